@@ -1,5 +1,9 @@
 package v1alpha1
 
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
 // NameCreated contains information about the status of a resource created in
 // the cluster (e.g. a namespace). Specifically, it contains the name of the
 // resource and a flag indicating whether the creation succeeded.
@@ -40,3 +44,17 @@ const WorkspaceLabelPrefix = "crownlabs.polito.it/workspace-"
 
 // TnOperatorFinalizerName is the name of the finalizer corresponding to the tenant operator.
 const TnOperatorFinalizerName = "crownlabs.polito.it/tenant-operator"
+
+// SnapshotRetentionPolicy defines for how long the completed snapshots of the
+// instances (i.e. InstanceSnapshots and the corresponding images) are retained.
+type SnapshotRetentionPolicy struct {
+	// +kubebuilder:validation:Minimum=1
+
+	// The maximum number of snapshots retained for each image name, after which
+	// the oldest ones are deleted. Unlimited if not specified.
+	KeepLast *int32 `json:"keepLast,omitempty"`
+
+	// The maximum age of the snapshots (e.g. 720h), after which they are
+	// deleted. Unlimited if not specified.
+	MaxAge *metav1.Duration `json:"maxAge,omitempty"`
+}
@@ -78,6 +78,10 @@ type TenantSpec struct {
 	// Whether a sandbox namespace should be created to allow the Tenant play
 	// with Kubernetes.
 	CreateSandbox bool `json:"createSandbox,omitempty"`
+
+	// The retention policy of the snapshots of the instances of the Tenant. If
+	// also the Workspace specifies one, the stricter of the two limits applies.
+	SnapshotRetention *SnapshotRetentionPolicy `json:"snapshotRetention,omitempty"`
 }
 
 // TenantStatus reflects the most recently observed status of the Tenant.
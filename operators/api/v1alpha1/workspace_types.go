@@ -27,6 +27,11 @@ import (
 type WorkspaceSpec struct {
 	// The human-readable name of the Workspace.
 	PrettyName string `json:"prettyName"`
+
+	// The retention policy of the snapshots of the instances of the Workspace.
+	// If also the Tenant owning them specifies one, the stricter of the two
+	// limits applies.
+	SnapshotRetention *SnapshotRetentionPolicy `json:"snapshotRetention,omitempty"`
 }
 
 // WorkspaceStatus reflects the most recently observed status of the Workspace.
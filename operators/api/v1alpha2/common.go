@@ -10,3 +10,18 @@ type GenericRef struct {
 	// empty in case of cluster-wide resources.
 	Namespace string `json:"namespace,omitempty"`
 }
+
+// InstanceSetNameLabel is the label assigned to the Instances created by an InstanceSet, indicating its name.
+const InstanceSetNameLabel = "crownlabs.polito.it/instance-set-name"
+
+// InstanceSetNamespaceLabel is the label assigned to the Instances created by an InstanceSet, indicating its namespace.
+const InstanceSetNamespaceLabel = "crownlabs.polito.it/instance-set-namespace"
+
+// InstOperatorFinalizerName is the name of the finalizer corresponding to the instance operator.
+const InstOperatorFinalizerName = "crownlabs.polito.it/instance-operator"
+
+// InstanceSnapshotNameLabel is the label assigned to the Templates published from an InstanceSnapshot, indicating its name.
+const InstanceSnapshotNameLabel = "crownlabs.polito.it/instance-snapshot-name"
+
+// InstanceSnapshotNamespaceLabel is the label assigned to the Templates published from an InstanceSnapshot, indicating its namespace.
+const InstanceSnapshotNamespaceLabel = "crownlabs.polito.it/instance-snapshot-namespace"
@@ -41,6 +41,16 @@ type InstanceSpec struct {
 	// is silently ignored in case of non-persistent environments, as the state
 	// cannot be preserved among reboots.
 	Running bool `json:"running"`
+
+	// +kubebuilder:validation:Optional
+
+	// The reference to the InstanceSnapshot the Instance is restored from. If
+	// specified, the environment is created from the image pushed by the snapshot
+	// (e.g. the disk of a persistent VM is imported from it), instead of the one
+	// of the Template. The InstanceSnapshot shall be completed, and belong to the
+	// same namespace, unless the Tenant is a manager of the Template workspace.
+	// The InstanceSnapshot is required to exist as long as the Instance.
+	SnapshotRef *GenericRef `json:"snapshotRef,omitempty"`
 }
 
 // InstanceStatus reflects the most recently observed status of the Instance.
@@ -59,6 +69,11 @@ type InstanceStatus struct {
 	// be used to access it through the SSH protocol (leveraging the SSH bastion
 	// in case it is not contacted from another CrownLabs Instance).
 	IP string `json:"ip,omitempty"`
+
+	// The revision of the Template the resources of the Instance have been
+	// generated from. It differs from the current revision of the Template in
+	// case the changes have not been applied yet, according to the rollout policy.
+	TemplateRevision string `json:"templateRevision,omitempty"`
 }
 
 // +kubebuilder:object:root=true
@@ -68,6 +83,7 @@ type InstanceStatus struct {
 // +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
 // +kubebuilder:printcolumn:name="URL",type=string,JSONPath=`.status.url`,priority=10
 // +kubebuilder:printcolumn:name="IP Address",type=string,JSONPath=`.status.ip`,priority=10
+// +kubebuilder:printcolumn:name="Revision",type=string,JSONPath=`.status.templateRevision`,priority=10
 // +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // Instance describes the instance of a CrownLabs environment Template.
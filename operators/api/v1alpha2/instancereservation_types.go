@@ -0,0 +1,118 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha2
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ReservationPhase is an enumeration representing the current state of the InstanceReservation.
+type ReservationPhase string
+
+const (
+	// ReservationScheduled -> The reservation has been accepted and its time slot has not begun yet.
+	ReservationScheduled ReservationPhase = "Scheduled"
+	// ReservationActive -> The time slot of the reservation is in progress, and the Instance has been started.
+	ReservationActive ReservationPhase = "Active"
+	// ReservationCompleted -> The time slot of the reservation is over, and the end action has been performed.
+	ReservationCompleted ReservationPhase = "Completed"
+	// ReservationFailed -> The reservation is invalid, or it was not possible to perform the requested actions.
+	ReservationFailed ReservationPhase = "Failed"
+)
+
+// ReservationEndAction is an enumeration of the actions performed on the Instance when the reservation ends.
+type ReservationEndAction string
+
+const (
+	// ReservationEndActionStop -> The Instance is stopped (i.e. running is set to false), preserving its disk.
+	ReservationEndActionStop ReservationEndAction = "Stop"
+	// ReservationEndActionDelete -> The Instance is deleted.
+	ReservationEndActionDelete ReservationEndAction = "Delete"
+)
+
+// InstanceReservationSpec is the specification of the desired state of the InstanceReservation.
+type InstanceReservationSpec struct {
+	// The reference to the Template to be instantiated.
+	Template GenericRef `json:"template.crownlabs.polito.it/TemplateRef"`
+
+	// The reference to the Tenant which owns the reserved Instance.
+	Tenant GenericRef `json:"tenant.crownlabs.polito.it/TenantRef"`
+
+	// The beginning of the time slot, when the Instance is created (or started, in case it already exists).
+	// It can be set slightly in advance with respect to the actual beginning of the lab, to allow the
+	// environment to be ready in time.
+	Start metav1.Time `json:"start"`
+
+	// The end of the time slot, when the end action is performed on the Instance.
+	End metav1.Time `json:"end"`
+
+	// +kubebuilder:validation:Enum="Stop";"Delete"
+	// +kubebuilder:default="Delete"
+	// +kubebuilder:validation:Optional
+
+	// The action performed on the Instance when the reservation ends. Stopping the
+	// Instance is meaningful only in case of persistent environments, as it allows
+	// to preserve the disk content for subsequent reservations.
+	EndAction ReservationEndAction `json:"endAction"`
+}
+
+// InstanceReservationStatus reflects the most recently observed status of the InstanceReservation.
+type InstanceReservationStatus struct {
+	// The current state of the reservation.
+	Phase ReservationPhase `json:"phase,omitempty"`
+
+	// The name of the Instance associated with the reservation, in the same namespace.
+	Instance string `json:"instance,omitempty"`
+
+	// The time of the next scheduled transition of the reservation (i.e. the start
+	// in case it is scheduled, the end in case it is active).
+	NextTransition *metav1.Time `json:"nextTransition,omitempty"`
+
+	// A human-readable message providing further details about the current phase.
+	Message string `json:"message,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName="ires"
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Start",type=string,JSONPath=`.spec.start`
+// +kubebuilder:printcolumn:name="End",type=string,JSONPath=`.spec.end`
+// +kubebuilder:printcolumn:name="Instance",type=string,JSONPath=`.status.instance`,priority=10
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// InstanceReservation describes the reservation of an Instance for a given time slot.
+type InstanceReservation struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   InstanceReservationSpec   `json:"spec,omitempty"`
+	Status InstanceReservationStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// InstanceReservationList contains a list of InstanceReservation objects.
+type InstanceReservationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []InstanceReservation `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&InstanceReservation{}, &InstanceReservationList{})
+}
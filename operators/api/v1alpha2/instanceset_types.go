@@ -0,0 +1,93 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha2
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// InstanceSetSpec is the specification of the desired state of the InstanceSet.
+type InstanceSetSpec struct {
+	// The reference to the Template to be instantiated for each Tenant
+	// subscribed to the Workspace the Template belongs to.
+	Template GenericRef `json:"template.crownlabs.polito.it/TemplateRef"`
+
+	// +kubebuilder:default=false
+	// +kubebuilder:validation:Optional
+
+	// Whether an Instance should be created also for the Tenants having the
+	// manager role in the Workspace, in addition to the ones with the user role.
+	IncludeManagers bool `json:"includeManagers"`
+
+	// +kubebuilder:default=true
+	// +kubebuilder:validation:Optional
+
+	// Whether the Instances of the set are running or not. The flag is propagated
+	// to all the Instances, and it is subject to the same constraints (i.e. it is
+	// meaningful only in case of persistent environments).
+	Running bool `json:"running"`
+}
+
+// InstanceSetStatus reflects the most recently observed status of the InstanceSet.
+type InstanceSetStatus struct {
+	// The name of the Workspace whose Tenants are targeted by the set.
+	Workspace string `json:"workspace,omitempty"`
+
+	// The number of Instances currently belonging to the set.
+	Instances int32 `json:"instances"`
+
+	// The number of Instances of the set which are ready to be accessed.
+	ReadyInstances int32 `json:"readyInstances"`
+
+	// The UIDs of the Instances created by the set. Only these Instances are
+	// managed by the set, since the labels referring to it can be set by anyone
+	// allowed to create Instances in the namespace of the Tenant.
+	InstanceUIDs []types.UID `json:"instanceUIDs,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName="iset"
+// +kubebuilder:printcolumn:name="Template",type=string,JSONPath=`.spec.template\.crownlabs\.polito\.it/TemplateRef.name`
+// +kubebuilder:printcolumn:name="Workspace",type=string,JSONPath=`.status.workspace`
+// +kubebuilder:printcolumn:name="Instances",type=integer,JSONPath=`.status.instances`
+// +kubebuilder:printcolumn:name="Ready",type=integer,JSONPath=`.status.readyInstances`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// InstanceSet describes the provisioning of the same CrownLabs environment
+// Template for all the Tenants subscribed to a given Workspace.
+type InstanceSet struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   InstanceSetSpec   `json:"spec,omitempty"`
+	Status InstanceSetStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// InstanceSetList contains a list of InstanceSet objects.
+type InstanceSetList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []InstanceSet `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&InstanceSet{}, &InstanceSetList{})
+}
@@ -30,6 +30,12 @@ const (
 	// Pending -> The snapshot resource has been observed and the
 	// process is waiting to be started.
 	Pending SnapshotStatus = "Pending"
+	// Stopping -> The running instance is being stopped, to release its
+	// volume before starting the creation of the snapshot.
+	Stopping SnapshotStatus = "Stopping"
+	// SnapshottingVolume -> The volume of the running instance is being
+	// snapshotted, before starting the creation of the snapshot from it.
+	SnapshottingVolume SnapshotStatus = "SnapshottingVolume"
 	// Processing -> The process of creation of the snapshot started.
 	Processing SnapshotStatus = "Processing"
 	// Completed -> The snapshot of the instance has been created.
@@ -38,14 +44,68 @@ const (
 	Failed SnapshotStatus = "Failed"
 )
 
+// SnapshotMode is an enumeration of the different modes a snapshot can be created.
+// +kubebuilder:validation:Enum="Full";"Incremental"
+type SnapshotMode string
+
+const (
+	// SnapshotModeFull -> the whole persistent disk is exported and pushed as a new image.
+	SnapshotModeFull SnapshotMode = "Full"
+	// SnapshotModeIncremental -> only the changes with respect to the image of the environment
+	// are exported (as a qcow2 overlay) and appended as a new layer on top of that image.
+	SnapshotModeIncremental SnapshotMode = "Incremental"
+)
+
+// ContainerSnapshotFormat is an enumeration of the formats the snapshot of a container environment can be created in.
+// +kubebuilder:validation:Enum="Image";"Archive"
+type ContainerSnapshotFormat string
+
+const (
+	// ContainerSnapshotImage -> the content of the persistent drive is added to the
+	// image of the environment, generating a new image usable in other templates.
+	ContainerSnapshotImage ContainerSnapshotFormat = "Image"
+	// ContainerSnapshotArchive -> the content of the persistent drive is packed in a
+	// compressed tarball, pushed to the registry as the only file of a new image.
+	ContainerSnapshotArchive ContainerSnapshotFormat = "Archive"
+)
+
+// SnapshotBackend is an enumeration of the backends the snapshots can be exported to.
+// +kubebuilder:validation:Enum="Registry";"S3";"PVC"
+type SnapshotBackend string
+
+const (
+	// SnapshotBackendRegistry -> the snapshot is pushed as a new image to the docker registry.
+	SnapshotBackendRegistry SnapshotBackend = "Registry"
+	// SnapshotBackendS3 -> the exported disk (or the archive of the persistent drive, in case
+	// of containers) is uploaded to an S3-compatible object storage.
+	SnapshotBackendS3 SnapshotBackend = "S3"
+	// SnapshotBackendPVC -> the content of the volume of the instance is cloned into a new
+	// PersistentVolumeClaim, in the same namespace of the InstanceSnapshot.
+	SnapshotBackendPVC SnapshotBackend = "PVC"
+)
+
+// PublishedTemplate contains the information about the Template to be published from an InstanceSnapshot.
+type PublishedTemplate struct {
+	// +kubebuilder:validation:MinLength=1
+
+	// The name of the Template to be created in the namespace of the workspace.
+	Name string `json:"name"`
+
+	// The human-readable name of the Template. If not specified, the name is used.
+	PrettyName string `json:"prettyName,omitempty"`
+
+	// A textual description of the Template.
+	Description string `json:"description,omitempty"`
+}
+
 // InstanceSnapshotSpec defines the desired state of InstanceSnapshot.
 type InstanceSnapshotSpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 
-	// Instance is the reference to the persistent VM instance to be snapshotted.
-	// The instance should not be running, otherwise it won't be possible to
-	// steal the volume and extract its content.
+	// Instance is the reference to the instance to be snapshotted, either a persistent
+	// VM or a container with a persistent drive. In case of VMs, the instance should
+	// not be running, unless live snapshots are enabled in the operator.
 	Instance GenericRef `json:"instanceRef"`
 
 	// Environment represents the reference to the environment to be snapshotted, in case more are
@@ -56,19 +116,117 @@ type InstanceSnapshotSpec struct {
 
 	// ImageName is the name of the image to pushed in the docker registry.
 	ImageName string `json:"imageName"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default="Full"
+
+	// Mode is the mode the snapshot is created with. In Incremental mode,
+	// only the changes with respect to the image of the environment are
+	// pushed to the registry, as a layer on top of that image. It is
+	// supported only by VMs.
+	Mode SnapshotMode `json:"mode,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default="Image"
+
+	// ContainerFormat is the format of the snapshot of container environments,
+	// while it is ignored in case of VMs. The content of the persistent drive is
+	// either added to the image of the environment, or packed in an archive.
+	ContainerFormat ContainerSnapshotFormat `json:"containerFormat,omitempty"`
+
+	// +kubebuilder:validation:Optional
+
+	// Backend is the backend the snapshot is exported to. If not specified, the
+	// default one configured in the operator is used. Only snapshots exported to
+	// the registry can be restored and published as templates.
+	Backend SnapshotBackend `json:"backend,omitempty"`
+
+	// +kubebuilder:validation:Optional
+
+	// PublishTemplate, if specified, requests to publish the snapshot as a new
+	// Template of the same workspace, once completed. The Template is a copy of
+	// the snapshotted environment, pointing to the pushed image. Publishing is
+	// allowed only if the Tenant owning the Instance is a workspace manager.
+	PublishTemplate *PublishedTemplate `json:"publishTemplate,omitempty"`
 }
 
+// InstanceSnapshotConditionType is an enumeration of the types of the conditions of an InstanceSnapshot.
+type InstanceSnapshotConditionType string
+
+const (
+	// InstanceSnapshotValidated -> the request has been validated, and the referenced
+	// instance and environment can be snapshotted.
+	InstanceSnapshotValidated InstanceSnapshotConditionType = "Validated"
+	// InstanceSnapshotImagePushed -> the job completed, and the image has been pushed to the registry.
+	InstanceSnapshotImagePushed InstanceSnapshotConditionType = "ImagePushed"
+	// InstanceSnapshotTemplatePublished -> the snapshot has been published as a new Template,
+	// present only in case the publication has been requested.
+	InstanceSnapshotTemplatePublished InstanceSnapshotConditionType = "TemplatePublished"
+)
+
 // InstanceSnapshotStatus defines the observed state of InstanceSnapshot.
 type InstanceSnapshotStatus struct {
 	// Phase represents the current state of the Instance Snapshot.
 	Phase SnapshotStatus `json:"phase"`
+
+	// QueuePosition is the position of the InstanceSnapshot in the queue of the
+	// ones waiting for the creation of their job, in case the concurrency limits
+	// of the snapshotting jobs are reached. It is not set once the job started.
+	QueuePosition int32 `json:"queuePosition,omitempty"`
+
+	// Image is the reference of the image pushed to the registry, once the
+	// creation of the snapshot started.
+	Image string `json:"image,omitempty"`
+
+	// Backend is the backend the snapshot is exported to.
+	Backend SnapshotBackend `json:"backend,omitempty"`
+
+	// Location is the reference of the exported snapshot in the backend, i.e. the image
+	// in the registry, the URL of the object (s3://<bucket>/<key>) or the name of the PVC.
+	Location string `json:"location,omitempty"`
+
+	// ImageDigest is the digest of the image pushed to the registry, once
+	// completed. It is available only if reported by the snapshotting job.
+	ImageDigest string `json:"imageDigest,omitempty"`
+
+	// JobName is the name of the job in charge of creating the snapshot.
+	JobName string `json:"jobName,omitempty"`
+
+	// StartTime is the time the job in charge of creating the snapshot started.
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// CompletionTime is the time the job in charge of creating the snapshot
+	// completed, either successfully or not.
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+
+	// FailureReason is the reason why the creation of the snapshot failed,
+	// as reported by the validation of the request, the job or its pods.
+	FailureReason string `json:"failureReason,omitempty"`
+
+	// +listType=map
+	// +listMapKey=type
+	// +patchStrategy=merge
+	// +patchMergeKey=type
+
+	// Conditions are the latest available observations of the state of the InstanceSnapshot.
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+
+	// InstanceStopped is true in case the instance has been stopped to
+	// create the snapshot, and it has to be restarted once completed.
+	InstanceStopped bool `json:"instanceStopped,omitempty"`
+
+	// VirtualMachineSnapshot is the name of the VirtualMachineSnapshot the
+	// snapshot is created from, in case the instance was running.
+	VirtualMachineSnapshot string `json:"virtualMachineSnapshot,omitempty"`
 }
 
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
 // +kubebuilder:resource:shortName="isnap"
 // +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Queue",type=integer,JSONPath=`.status.queuePosition`,priority=10
 // +kubebuilder:printcolumn:name="ImageName",type=string,JSONPath=`.spec.imageName`
+// +kubebuilder:printcolumn:name="Image",type=string,JSONPath=`.status.image`,priority=10
 // +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // InstanceSnapshot is the Schema for the instancesnapshots API.
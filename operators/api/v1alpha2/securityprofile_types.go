@@ -0,0 +1,100 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha2
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// SecurityProfileSpec defines the security settings applied to the
+// environments selecting the SecurityProfile.
+type SecurityProfileSpec struct {
+	// A textual description of the SecurityProfile (e.g. its intended usage).
+	Description string `json:"description,omitempty"`
+
+	// The list of Workspaces whose Templates are allowed to select the
+	// SecurityProfile. It is not available to any Workspace if empty.
+	AllowedWorkspaces []string `json:"allowedWorkspaces,omitempty"`
+
+	// +kubebuilder:validation:Minimum=0
+
+	// The UID the container is run as. If not specified, the default one
+	// (1010) is used.
+	RunAsUser *int64 `json:"runAsUser,omitempty"`
+
+	// +kubebuilder:validation:Minimum=0
+
+	// The GID the container is run as. If not specified, the default one
+	// (1010) is used.
+	RunAsGroup *int64 `json:"runAsGroup,omitempty"`
+
+	// The capabilities added to the container (e.g. NET_ADMIN or NET_RAW).
+	// All the other ones are dropped.
+	Capabilities []corev1.Capability `json:"capabilities,omitempty"`
+
+	// +kubebuilder:default=false
+
+	// Whether the processes of the container can gain more privileges than
+	// their parent (e.g. through setuid binaries).
+	AllowPrivilegeEscalation bool `json:"allowPrivilegeEscalation,omitempty"`
+
+	// The seccomp profile applied to the container. If not specified, the
+	// default one of the container runtime is used.
+	SeccompProfile *corev1.SeccompProfile `json:"seccompProfile,omitempty"`
+
+	// +kubebuilder:default=false
+
+	// Whether the VM environments selecting the SecurityProfile are allowed
+	// to pin their CPUs to dedicated physical CPUs.
+	AllowDedicatedCPUPlacement bool `json:"allowDedicatedCPUPlacement,omitempty"`
+
+	// +kubebuilder:default=false
+
+	// Whether the VM environments selecting the SecurityProfile are allowed
+	// to back their memory with hugepages.
+	AllowHugepages bool `json:"allowHugepages,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope="Cluster"
+// +kubebuilder:printcolumn:name="User",type=string,JSONPath=`.spec.runAsUser`
+// +kubebuilder:printcolumn:name="Capabilities",type=string,JSONPath=`.spec.capabilities`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// SecurityProfile describes an admin-approved set of security settings,
+// which can be selected by the environments of the Templates belonging to
+// the allowed Workspaces.
+type SecurityProfile struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec SecurityProfileSpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// SecurityProfileList contains a list of SecurityProfile objects.
+type SecurityProfileList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []SecurityProfile `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&SecurityProfile{}, &SecurityProfileList{})
+}
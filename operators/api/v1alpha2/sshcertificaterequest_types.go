@@ -0,0 +1,112 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha2
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
+// NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
+
+// SSHCertificateRequestPhase is an enumeration representing the current state of the SSHCertificateRequest.
+type SSHCertificateRequestPhase string
+
+const (
+	// SSHCertificateIssued -> The certificate has been issued, and it is valid until the expiration time.
+	SSHCertificateIssued SSHCertificateRequestPhase = "Issued"
+	// SSHCertificateExpired -> The certificate has been issued, but it is no longer valid.
+	SSHCertificateExpired SSHCertificateRequestPhase = "Expired"
+	// SSHCertificateFailed -> The request has been rejected, and no certificate has been issued.
+	SSHCertificateFailed SSHCertificateRequestPhase = "Failed"
+)
+
+// SSHCertificateRequestSpec defines the desired state of SSHCertificateRequest.
+type SSHCertificateRequestSpec struct {
+	// The reference to the Tenant the certificate is requested for. The request
+	// is required to be created in the personal namespace of the Tenant.
+	Tenant GenericRef `json:"tenant.crownlabs.polito.it/TenantRef"`
+
+	// +kubebuilder:validation:MinLength=1
+
+	// The public key to be certified, in the authorized_keys format (e.g.
+	// ssh-ed25519 AAAA... comment). Certificates are not accepted.
+	PublicKey string `json:"publicKey"`
+
+	// +kubebuilder:validation:Optional
+
+	// The requested validity of the certificate. If not specified, the default
+	// one configured in the operator is used. In any case, it is limited to the
+	// maximum validity configured in the operator.
+	Validity *metav1.Duration `json:"validity,omitempty"`
+}
+
+// SSHCertificateRequestStatus reflects the most recently observed status of the SSHCertificateRequest.
+type SSHCertificateRequestStatus struct {
+	// The current state of the SSHCertificateRequest.
+	Phase SSHCertificateRequestPhase `json:"phase,omitempty"`
+
+	// The issued certificate, in the authorized_keys format. It is meant to
+	// be saved beside the private key (e.g. id_ed25519-cert.pub), so that it
+	// is automatically presented by the SSH client.
+	Certificate string `json:"certificate,omitempty"`
+
+	// The identifier of the certificate, as logged by sshd upon authentication.
+	KeyID string `json:"keyID,omitempty"`
+
+	// The principals the certificate is valid for, i.e. the Tenant and the
+	// workspaces it is manager of, granting access to the corresponding Instances.
+	Principals []string `json:"principals,omitempty"`
+
+	// The time the certificate is valid from.
+	ValidAfter *metav1.Time `json:"validAfter,omitempty"`
+
+	// The time the certificate expires.
+	ValidBefore *metav1.Time `json:"validBefore,omitempty"`
+
+	// The reason why the request has been rejected, in case of failure.
+	FailureReason string `json:"failureReason,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName="sshcr"
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Expiration",type=string,JSONPath=`.status.validBefore`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// SSHCertificateRequest is the Schema for the sshcertificaterequests API.
+type SSHCertificateRequest struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   SSHCertificateRequestSpec   `json:"spec,omitempty"`
+	Status SSHCertificateRequestStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// SSHCertificateRequestList contains a list of SSHCertificateRequest.
+type SSHCertificateRequestList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []SSHCertificateRequest `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&SSHCertificateRequest{}, &SSHCertificateRequestList{})
+}
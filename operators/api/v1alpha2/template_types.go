@@ -33,6 +33,82 @@ const (
 	ClassVM EnvironmentType = "VirtualMachine"
 )
 
+// +kubebuilder:validation:Enum="Never";"OnRestart";"Immediate"
+
+// RolloutPolicy is an enumeration of the different policies to apply the changes
+// of a Template to the Instances already created from a previous revision.
+type RolloutPolicy string
+
+const (
+	// RolloutNever -> the existing Instances are left untouched, and only the new ones reflect the changes.
+	RolloutNever RolloutPolicy = "Never"
+	// RolloutOnRestart -> the changes are applied to the existing Instances the next time they are stopped.
+	RolloutOnRestart RolloutPolicy = "OnRestart"
+	// RolloutImmediate -> the changes are applied immediately, restarting the existing Instances if necessary.
+	RolloutImmediate RolloutPolicy = "Immediate"
+)
+
+// +kubebuilder:validation:Enum="ConfigMap";"Secret"
+
+// ConfigSourceKind is an enumeration of the different kinds of resources
+// providing the configuration of a container environment.
+type ConfigSourceKind string
+
+const (
+	// ConfigSourceConfigMap -> the configuration is provided by a ConfigMap.
+	ConfigSourceConfigMap ConfigSourceKind = "ConfigMap"
+	// ConfigSourceSecret -> the configuration is provided by a Secret.
+	ConfigSourceSecret ConfigSourceKind = "Secret"
+)
+
+// +kubebuilder:validation:Enum="BIOS";"UEFI"
+
+// FirmwareType is an enumeration of the firmwares of VM environments.
+type FirmwareType string
+
+const (
+	// FirmwareBIOS -> the VM boots through the legacy BIOS.
+	FirmwareBIOS FirmwareType = "BIOS"
+	// FirmwareUEFI -> the VM boots through UEFI.
+	FirmwareUEFI FirmwareType = "UEFI"
+)
+
+// +kubebuilder:validation:Enum="2Mi";"1Gi"
+
+// HugepagesSize is an enumeration of the sizes of the hugepages backing the
+// memory of VM environments.
+type HugepagesSize string
+
+const (
+	// Hugepages2Mi -> the memory is backed by 2Mi hugepages.
+	Hugepages2Mi HugepagesSize = "2Mi"
+	// Hugepages1Gi -> the memory is backed by 1Gi hugepages.
+	Hugepages1Gi HugepagesSize = "1Gi"
+)
+
+// +kubebuilder:validation:Enum="virtio";"e1000";"e1000e";"ne2k_pci";"pcnet";"rtl8139"
+
+// NICModel is an enumeration of the models of the network interface
+// emulated for VM environments.
+type NICModel string
+
+// +kubebuilder:validation:Enum="Scratch";"Persistent";"Dataset"
+
+// VolumeType is an enumeration of the different types of additional volumes
+// attached to the environments.
+type VolumeType string
+
+const (
+	// VolumeScratch -> an empty volume, discarded when the instance is stopped.
+	VolumeScratch VolumeType = "Scratch"
+	// VolumePersistent -> a volume owned by the tenant, preserved across the
+	// instances of the template.
+	VolumePersistent VolumeType = "Persistent"
+	// VolumeDataset -> a read-only volume shared by all the instances of the
+	// template, backed by a PVC in the namespace of the template.
+	VolumeDataset VolumeType = "Dataset"
+)
+
 // TemplateSpec is the specification of the desired state of the Template.
 type TemplateSpec struct {
 	// The human-readable name of the Template.
@@ -54,6 +130,16 @@ type TemplateSpec struct {
 	// Once this period is expired, the Instance may be automatically deleted
 	// or stopped to save resources.
 	DeleteAfter string `json:"deleteAfter,omitempty"`
+
+	// +kubebuilder:default="OnRestart"
+	// +kubebuilder:validation:Optional
+
+	// The policy to apply the changes of the environments to the Instances created
+	// from a previous revision of the Template. With the OnRestart policy, the changes
+	// are applied when the Instance is stopped (i.e. running is set to false), hence
+	// they are never applied to the environments which cannot be stopped. The changes
+	// of the image of persistent VMs are never applied, since the disk would be lost.
+	RolloutPolicy RolloutPolicy `json:"rolloutPolicy,omitempty"`
 }
 
 // TemplateStatus reflects the most recently observed status of the Template.
@@ -68,6 +154,14 @@ type Environment struct {
 	// The VM or container to be started when instantiating the environment.
 	Image string `json:"image"`
 
+	// +kubebuilder:default=false
+
+	// Whether the image contains a chain of qcow2 overlays (i.e. it has been pushed
+	// by an incremental InstanceSnapshot) rather than a standalone disk. In this
+	// case, the disk is flattened when imported, hence it is supported only by
+	// persistent VMs.
+	IncrementalImage bool `json:"incrementalImage,omitempty"`
+
 	// The type of environment to be instantiated, among VirtualMachine and
 	// Container.
 	EnvironmentType EnvironmentType `json:"environmentType"`
@@ -77,6 +171,16 @@ type Environment struct {
 	// Whether the environment is characterized by a graphical desktop or not.
 	GuiEnabled bool `json:"guiEnabled,omitempty"`
 
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+
+	// The port the web interface of the environment listens on, exposed at the
+	// URL of the Instance in place of the graphical desktop. If not specified,
+	// environments without graphical interface are reachable only through SSH.
+	// This field is meaningful only in case of container-based environments
+	// without graphical interface.
+	WebPort int32 `json:"webPort,omitempty"`
+
 	// +kubebuilder:default=false
 
 	// Whether the environment should be persistent (i.e. preserved when the
@@ -85,6 +189,236 @@ type Environment struct {
 
 	// The amount of computational resources associated with the environment.
 	Resources EnvironmentResources `json:"resources"`
+
+	// The name of the SecurityProfile applied to the environment, which shall
+	// be allowed for the Workspace the Template belongs to. If not specified,
+	// the container is run with the default (unprivileged) settings. In case
+	// of VM-based environments, the profile is required to grant the dedicated
+	// CPU placement and the hugepages.
+	SecurityProfile string `json:"securityProfile,omitempty"`
+
+	// The environment variables set in the container, in addition to the ones
+	// configured by CrownLabs. This field is meaningful only in case of
+	// container-based environments.
+	Env []EnvVar `json:"env,omitempty"`
+
+	// The entrypoint of the container, overriding the one of the image. This
+	// field is meaningful only in case of container-based environments.
+	Command []string `json:"command,omitempty"`
+
+	// The arguments of the entrypoint of the container, overriding the ones of
+	// the image. This field is meaningful only in case of container-based
+	// environments.
+	Args []string `json:"args,omitempty"`
+
+	// The ConfigMaps and Secrets, in the namespace of the Template, providing
+	// the configuration of the container. They are copied in the namespace of
+	// each Instance, and either mounted or exposed as environment variables.
+	// This field is meaningful only in case of container-based environments.
+	ConfigSources []ConfigSource `json:"configSources,omitempty"`
+
+	// The check determining whether the environment is ready to be used. If
+	// not specified, VMs are ready once the remote desktop (or SSH, in case of
+	// environments without graphical interface) accepts connections, while
+	// containers once they are running.
+	ReadinessCheck *ReadinessCheck `json:"readinessCheck,omitempty"`
+
+	// The firmware, CPU and device options of the VM. If not specified, the
+	// default ones are used. This field is meaningful only in case of VM-based
+	// environments.
+	Hardware *VMHardware `json:"hardware,omitempty"`
+
+	// The additional volumes attached to each instance of the environment,
+	// either mounted in the container or attached as disks to the VM.
+	Volumes []EnvironmentVolume `json:"volumes,omitempty"`
+}
+
+// EnvironmentVolume describes an additional volume attached to the instances
+// of an environment.
+type EnvironmentVolume struct {
+	// +kubebuilder:validation:Pattern="^[a-z0-9]([-a-z0-9]*[a-z0-9])?$"
+	// +kubebuilder:validation:MaxLength=32
+
+	// The name identifying the volume. In case of VMs, it is also the serial
+	// number of the corresponding disk (i.e. /dev/disk/by-id/virtio-<name>).
+	Name string `json:"name"`
+
+	// The type of the volume, among Scratch, Persistent and Dataset.
+	Type VolumeType `json:"type"`
+
+	// The size of the volume, required in case of Scratch and Persistent
+	// volumes, and ignored otherwise.
+	Size resource.Quantity `json:"size,omitempty"`
+
+	// The name of the PVC, in the namespace of the Template, containing the
+	// dataset. It is required in case of Dataset volumes, and ignored otherwise.
+	// In case of VMs, the PVC shall contain a disk image.
+	ClaimName string `json:"claimName,omitempty"`
+
+	// The path the volume is mounted at. It is required in case of
+	// container-based environments, and ignored otherwise.
+	MountPath string `json:"mountPath,omitempty"`
+}
+
+// VMHardware describes the firmware, CPU and device options of a VM environment.
+type VMHardware struct {
+	// +kubebuilder:default="BIOS"
+
+	// The firmware the VM boots through, among BIOS and UEFI.
+	Firmware FirmwareType `json:"firmware,omitempty"`
+
+	// +kubebuilder:default=false
+
+	// Whether secure boot is enabled. It requires the UEFI firmware.
+	SecureBoot bool `json:"secureBoot,omitempty"`
+
+	// The CPU model exposed to the VM (e.g. host-passthrough or Skylake-Client).
+	// If not specified, the default one of KubeVirt (host-model) is used.
+	CPUModel string `json:"cpuModel,omitempty"`
+
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=1
+
+	// The number of CPU sockets of the VM. The number of CPU cores of the
+	// environment shall be a multiple of the number of sockets and threads.
+	Sockets uint32 `json:"sockets,omitempty"`
+
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=1
+
+	// The number of threads per CPU core of the VM.
+	Threads uint32 `json:"threads,omitempty"`
+
+	// +kubebuilder:default=false
+
+	// Whether the CPUs of the VM are pinned to dedicated physical CPUs. In this
+	// case, the whole CPU cores of the environment are reserved, regardless of
+	// the configured percentage. It requires the SecurityProfile of the
+	// environment to allow it.
+	DedicatedCPUPlacement bool `json:"dedicatedCPUPlacement,omitempty"`
+
+	// The size of the hugepages backing the memory of the VM, among 2Mi and
+	// 1Gi. The memory of the environment shall be a multiple of this size. If
+	// not specified, hugepages are not used. It requires the SecurityProfile
+	// of the environment to allow it.
+	HugepagesSize HugepagesSize `json:"hugepagesSize,omitempty"`
+
+	// The model of the network interface of the VM (e.g. e1000 for guests
+	// lacking the virtio drivers). If not specified, virtio is used.
+	NICModel NICModel `json:"nicModel,omitempty"`
+
+	// +kubebuilder:default=false
+
+	// Whether the VM is provided with a tablet input device, improving the
+	// tracking of the cursor through the remote desktop.
+	TabletInput bool `json:"tabletInput,omitempty"`
+}
+
+// EnvVar represents an environment variable set in a container environment.
+type EnvVar struct {
+	// +kubebuilder:validation:MinLength=1
+
+	// The name of the environment variable.
+	Name string `json:"name"`
+
+	// The value of the environment variable.
+	Value string `json:"value,omitempty"`
+}
+
+// ReadinessCheck describes how to check whether an environment is ready to be
+// used. Only one among httpGet, tcpSocket, exec and guestAgent shall be set.
+type ReadinessCheck struct {
+	// The HTTP endpoint of the environment expected to return a successful
+	// status code.
+	HTTPGet *HTTPGetCheck `json:"httpGet,omitempty"`
+
+	// The TCP port of the environment expected to accept connections.
+	TCPSocket *TCPSocketCheck `json:"tcpSocket,omitempty"`
+
+	// The command expected to exit with a zero status code. This check is
+	// supported only in case of container-based environments, and templates
+	// of VMs specifying it are rejected.
+	Exec *ExecCheck `json:"exec,omitempty"`
+
+	// Whether the environment is ready once the guest agent is connected. This
+	// check is supported only in case of VM-based environments, and requires
+	// the qemu-guest-agent to be installed in the image.
+	GuestAgent bool `json:"guestAgent,omitempty"`
+
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:default=0
+
+	// The number of seconds after the start of the environment before the
+	// first check is performed.
+	InitialDelaySeconds int32 `json:"initialDelaySeconds,omitempty"`
+
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=5
+
+	// The number of seconds between two consecutive checks.
+	PeriodSeconds int32 `json:"periodSeconds,omitempty"`
+
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=1
+
+	// The number of seconds after which a single check times out.
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty"`
+
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=120
+
+	// The maximum number of seconds, after the VM is running, to wait for the
+	// environment to become ready. Once expired, the checks are interrupted and
+	// the Instance is not marked as ready. In case of containers, the checks
+	// are performed indefinitely, as the readiness probe of the container.
+	StartupTimeoutSeconds int32 `json:"startupTimeoutSeconds,omitempty"`
+}
+
+// HTTPGetCheck describes a check performed through an HTTP GET request.
+type HTTPGetCheck struct {
+	// +kubebuilder:default="/"
+
+	// The path of the HTTP request.
+	Path string `json:"path,omitempty"`
+
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+
+	// The port of the HTTP request.
+	Port int32 `json:"port"`
+}
+
+// TCPSocketCheck describes a check performed by opening a TCP connection.
+type TCPSocketCheck struct {
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+
+	// The port to be connected to.
+	Port int32 `json:"port"`
+}
+
+// ExecCheck describes a check performed by executing a command in the environment.
+type ExecCheck struct {
+	// +kubebuilder:validation:MinItems=1
+
+	// The command to be executed, along with its arguments.
+	Command []string `json:"command"`
+}
+
+// ConfigSource is the reference to a ConfigMap or a Secret providing the
+// configuration of a container environment.
+type ConfigSource struct {
+	// The kind of the referenced resource, among ConfigMap and Secret.
+	Kind ConfigSourceKind `json:"kind"`
+
+	// +kubebuilder:validation:MinLength=1
+
+	// The name of the referenced resource, in the namespace of the Template.
+	Name string `json:"name"`
+
+	// The path where the keys of the resource are mounted as files. If not
+	// specified, they are exposed as environment variables.
+	MountPath string `json:"mountPath,omitempty"`
 }
 
 // EnvironmentResources is the specification of the amount of resources
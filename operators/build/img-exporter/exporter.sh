@@ -3,6 +3,13 @@ IMG_DIR=/data
 OUT_DIR=/img-tmp
 IMG_NAME=disk.img
 OUT_IMAGE=vm-snapshot.qcow2
+OUT_LAYER=overlay.tar
+MODE=full
+BASE_IMAGE=
+CACHE_DIR=
+CACHE_RETENTION_DAYS=7
+DRIVE_PATH=/mydrive
+OUT_ARCHIVE=mydrive.tar.gz
 PROG_NAME=$0
 
 usage(){
@@ -10,6 +17,9 @@ usage(){
 	echo "  -d, --img-dir        Specify the working directory [DEFAULT=$IMG_DIR]"
 	echo "  -o, --out-dir        Specify the output directory  [DEFAULT=$OUT_DIR]"
 	echo "  -n, --img-name       Specify the name of the image [DEFAULT=$OUT_IMAGE]"
+	echo "  -m, --mode           Specify the export mode (full|incremental|files|archive|clone|flatten) [DEFAULT=$MODE]"
+	echo "  -b, --base-image     Specify the image the snapshot is based on (incremental, files and flatten modes)"
+	echo "  -c, --cache-dir      Specify the directory the disks of the base images are cached in (incremental and flatten modes)"
 	exit 1
 }
 
@@ -28,6 +38,18 @@ parse_args(){
 				shift
 				IMG_NAME=$1
 				;;
+			"-m" | "--mode")
+				shift
+				MODE=$1
+				;;
+			"-b" | "--base-image")
+				shift
+				BASE_IMAGE=$1
+				;;
+			"-c" | "--cache-dir")
+				shift
+				CACHE_DIR=$1
+				;;
 			*)
 				usage
 				;;
@@ -52,11 +74,156 @@ ADD ${OUT_IMAGE} /disk/
 EOF
 }
 
+# top_disk prints the name of the most recent disk of the given directory, i.e. the top of the chain of overlays
+# possibly created by incremental snapshots: the only disk which is not the backing file of any other one.
+top_disk(){
+	BACKING_FILES=$(for DISK in "$1"/*; do
+		qemu-img info "$DISK" | awk '/^backing file:/{sub(/^backing file: /, ""); sub(/ \(actual path:.*$/, ""); print}'
+	done)
+	for DISK in "$1"/*; do
+		NAME=$(basename "$DISK")
+		echo "$BACKING_FILES" | grep -q -x -F "$NAME" || { echo "$NAME"; return 0; }
+	done
+	return 1
+}
+
+# fetch_base_disks retrieves the disks of the base image, and sets BASE_DISKS to the directory containing them.
+# If a cache directory is configured, the disks are extracted only once per digest of the image, and reused by
+# the following snapshots and flattening jobs (possibly executed at the same time). Otherwise, a fresh copy
+# is extracted in the output directory.
+fetch_base_disks(){
+	if [ -z "$CACHE_DIR" ]; then
+		BASE_DISKS="${OUT_DIR}/base/disk"
+		mkdir -p "${OUT_DIR}/base"
+		crane export "$BASE_IMAGE" - | tar -x -C "${OUT_DIR}/base" disk/ || return 1
+		return 0
+	fi
+
+	DIGEST=$(crane digest "$BASE_IMAGE") || return 1
+	BASE_DISKS="${CACHE_DIR}/${DIGEST#*:}/disk"
+	if [ -d "$BASE_DISKS" ]; then
+		echo "Using the cached disks of ${BASE_IMAGE} (${DIGEST})"
+		touch "${BASE_DISKS%/disk}"
+	else
+		# The disks are extracted in a temporary directory, and atomically moved once complete.
+		mkdir -p "$CACHE_DIR"
+		TMP_CACHE=$(mktemp -d "${CACHE_DIR}/.tmp-XXXXXX") || return 1
+		crane export "${BASE_IMAGE%@*}@${DIGEST}" - | tar -x -C "$TMP_CACHE" disk/ || { rm -rf "$TMP_CACHE"; return 1; }
+		mv -T "$TMP_CACHE" "${BASE_DISKS%/disk}" 2>/dev/null || rm -rf "$TMP_CACHE"
+	fi
+
+	# The disks of the images no longer used are removed, to bound the size of the cache.
+	find "$CACHE_DIR" -mindepth 1 -maxdepth 1 -type d -mtime +"$CACHE_RETENTION_DAYS" -exec rm -rf {} + 2>/dev/null
+	return 0
+}
+
+export_img_incremental(){
+	WORK_DIR="${OUT_DIR}/work"
+	LAYER_DIR="${OUT_DIR}/layer"
+
+	echo "Retrieving the disk of ${BASE_IMAGE}..."
+	fetch_base_disks || return 1
+	BASE_DISK=$(top_disk "$BASE_DISKS")
+	[ -n "$BASE_DISK" ] || { echo "No disk found in ${BASE_IMAGE}"; return 1; }
+
+	echo "Generating the overlay with respect to ${BASE_DISK}..."
+	# The overlay is generated next to (links to) the disks of the base image, and the backing file is referenced
+	# with a relative path: once the layer is applied on top of the base image, the files are in the same directory.
+	# The timestamp prevents the overlay from shadowing the disk of a base image which is itself a snapshot.
+	OVERLAY="$(date +%Y%m%d%H%M%S)-${OUT_IMAGE}"
+	mkdir -p "$WORK_DIR" "${LAYER_DIR}/disk"
+	for DISK in "$BASE_DISKS"/*; do
+		ln -s "$DISK" "${WORK_DIR}/$(basename "$DISK")"
+	done
+	BASE_FORMAT=$(qemu-img info "${WORK_DIR}/${BASE_DISK}" | awk '/^file format/{print $3}')
+
+	# The overlay is initially empty and backed by the disk of the VM. Then, it is rebased (in safe mode) on top of
+	# the base disk: qemu-img copies into the overlay all and only the clusters differing between the two disks.
+	(cd "$WORK_DIR" && qemu-img create -f qcow2 -b "${IMG_DIR}/${IMG_NAME}" -F raw "$OVERLAY" && \
+		qemu-img rebase -f qcow2 -b "$BASE_DISK" -F "$BASE_FORMAT" "$OVERLAY") || return 1
+	mv "${WORK_DIR}/${OVERLAY}" "${LAYER_DIR}/disk/"
+
+	echo "Creating the layer..."
+	tar -c -f "${OUT_DIR}/${OUT_LAYER}" -C "$LAYER_DIR" disk/ || return 1
+	rm -rf "${OUT_DIR}/base" "$WORK_DIR" "$LAYER_DIR"
+}
+
+flatten_img(){
+	echo "Retrieving the disks of ${BASE_IMAGE}..."
+	fetch_base_disks || return 1
+	TOP_DISK=$(top_disk "$BASE_DISKS")
+	[ -n "$TOP_DISK" ] || { echo "No disk found in ${BASE_IMAGE}"; return 1; }
+
+	echo "Flattening the chain of ${TOP_DISK}..."
+	# The backing files are resolved relatively to the directory of the overlay, and the
+	# resulting raw disk overwrites the (blank) one of the volume, preserving its size.
+	(cd "$BASE_DISKS" && qemu-img convert -n -O raw "$TOP_DISK" "${IMG_DIR}/${IMG_NAME}") || return 1
+	rm -rf "${OUT_DIR}/base"
+}
+
+export_files(){
+	echo "Copying the content of the drive..."
+	mkdir -p "${OUT_DIR}/mydrive"
+	cp -a "${IMG_DIR}/." "${OUT_DIR}/mydrive/" || return 1
+
+	echo "Creating Dockerfile..."
+	# The files are owned by the same user of the container environments.
+	cat <<EOF > "${OUT_DIR}/Dockerfile"
+FROM ${BASE_IMAGE}
+COPY --chown=1010:1010 mydrive ${DRIVE_PATH}
+EOF
+}
+
+export_archive(){
+	echo "Archiving the content of the drive..."
+	mkdir -p "$OUT_DIR"
+	tar -c -z -f "${OUT_DIR}/${OUT_ARCHIVE}" -C "$IMG_DIR" . || return 1
+
+	echo "Creating Dockerfile..."
+	cat <<EOF > "${OUT_DIR}/Dockerfile"
+FROM scratch
+COPY ${OUT_ARCHIVE} /
+EOF
+}
+
+export_clone(){
+	echo "Cloning the content of the volume..."
+	mkdir -p "$OUT_DIR"
+	cp -a "${IMG_DIR}/." "${OUT_DIR}/" || return 1
+}
+
 parse_args "$@"
 
-if export_img;
+case "$MODE" in
+	"full")
+		EXPORT=export_img
+		;;
+	"incremental")
+		[ -n "$BASE_IMAGE" ] || usage
+		EXPORT=export_img_incremental
+		;;
+	"files")
+		[ -n "$BASE_IMAGE" ] || usage
+		EXPORT=export_files
+		;;
+	"archive")
+		EXPORT=export_archive
+		;;
+	"clone")
+		EXPORT=export_clone
+		;;
+	"flatten")
+		[ -n "$BASE_IMAGE" ] || usage
+		EXPORT=flatten_img
+		;;
+	*)
+		usage
+		;;
+esac
+
+if $EXPORT;
 then
-	echo "${IMG_DIR}/${IMG_NAME} successully converted"
+	echo "${IMG_DIR} successfully exported (${MODE} mode)"
 else
 	echo "Conversion unsuccessfully completed"
 	exit 1
@@ -19,6 +19,7 @@ package main
 import (
 	"flag"
 	"os"
+	"time"
 
 	"k8s.io/apimachinery/pkg/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
@@ -28,6 +29,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 
 	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	crownlabsv1alpha2 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha2"
 	bastion_controller "github.com/netgroup-polito/CrownLabs/operators/pkg/bastion-controller"
 )
 
@@ -39,16 +41,19 @@ func init() {
 	_ = clientgoscheme.AddToScheme(scheme)
 
 	_ = crownlabsv1alpha1.AddToScheme(scheme)
+	_ = crownlabsv1alpha2.AddToScheme(scheme)
 	// +kubebuilder:scaffold:scheme
 }
 
 func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
+	var resyncPeriod time.Duration
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.DurationVar(&resyncPeriod, "resync-period", 10*time.Minute, "The interval between the periodic regenerations of the authorized_keys file (0 to regenerate it only at startup)")
 	klog.InitFlags(nil)
 	flag.Parse()
 
@@ -76,7 +81,9 @@ func main() {
 	if err = (&bastion_controller.BastionReconciler{
 		Client:             mgr.GetClient(),
 		Scheme:             mgr.GetScheme(),
+		EventsRecorder:     mgr.GetEventRecorderFor("bastion-operator"),
 		AuthorizedKeysPath: authorizedKeysPath,
+		ResyncPeriod:       resyncPeriod,
 	}).SetupWithManager(mgr); err != nil {
 		klog.Fatal("unable to create controller", "controller", "Bastion", err)
 	}
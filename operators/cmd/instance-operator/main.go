@@ -18,14 +18,14 @@ package main
 import (
 	"flag"
 	"os"
-	"strings"
+	"time"
 
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	"k8s.io/klog/v2"
 	virtv1 "kubevirt.io/client-go/api/v1"
+	snapshotv1alpha1 "kubevirt.io/client-go/apis/snapshot/v1alpha1"
 	cdiv1 "kubevirt.io/containerized-data-importer/pkg/apis/core/v1alpha1"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
@@ -34,6 +34,8 @@ import (
 	crownlabsv1alpha2 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha2"
 	instance_controller "github.com/netgroup-polito/CrownLabs/operators/pkg/instance-controller"
 	instancesnapshot_controller "github.com/netgroup-polito/CrownLabs/operators/pkg/instancesnapshot-controller"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/selectors"
+	sshcertificate_controller "github.com/netgroup-polito/CrownLabs/operators/pkg/sshcertificate-controller"
 )
 
 var (
@@ -48,6 +50,7 @@ func init() {
 
 	_ = virtv1.AddToScheme(scheme)
 	_ = cdiv1.AddToScheme(scheme)
+	_ = snapshotv1alpha1.AddToScheme(scheme)
 }
 
 func main() {
@@ -65,17 +68,29 @@ func main() {
 	var vmRegistry string
 	var vmRegistrySecret string
 	var containerImgExport string
+	var imgExporterCacheDir string
 	var containerKaniko string
+	var containerCrane string
+	var liveSnapshots string
+	var snapshotBackend string
+	var s3Opts instancesnapshot_controller.S3Opts
+	var containerS3Uploader string
+	var cloneStorageClass string
+	var snapshotJobLimits instancesnapshot_controller.JobConcurrencyLimits
 	var containerEnvFileBrowserImg string
 	var containerEnvFileBrowserImgTag string
+	var sshCAKeyPath string
+	var sshCertificateValidity time.Duration
+	var sshCertificateMaxValidity time.Duration
+	var webTerminalOpts instance_controller.WebTerminalOpts
 	var maxConcurrentReconciles int
 
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
 		"Enable leader election for controller manager. Enabling this will ensure there is only one active controller manager.")
-	flag.StringVar(&namespaceWhiteList, "namespace-whitelist", "production=true", "The whitelist of the namespaces on "+
-		"which the controller will work. Different labels (key=value) can be specified, by separating them with a &"+
-		"( e.g. key1=value1&key2=value2")
+	flag.StringVar(&namespaceWhiteList, "namespace-whitelist", "production=true", "The label selector identifying the namespaces on "+
+		"which the controller will work. Both equality and set-based requirements are supported, separated by a comma or a &"+
+		" (e.g. key1=value1&key2 in (value2,value3),!key4)")
 	flag.StringVar(&websiteBaseURL, "website-base-url", "crownlabs.polito.it", "Base URL of crownlabs website instance")
 	flag.StringVar(&nextcloudBaseURL, "nextcloud-base-url", "", "Base URL of NextCloud website to use")
 	flag.StringVar(&instancesAuthURL, "instances-auth-url", "", "The base URL for user instances authentication (i.e., oauth2-proxy)")
@@ -90,10 +105,32 @@ func main() {
 	flag.StringVar(&vmRegistrySecret, "vm-registry-secret", "", "The name of the secret for the VM registry")
 
 	flag.StringVar(&containerImgExport, "container-export-img", "crownlabs/img-exporter", "The image for the img-exporter (container in charge of exporting the disk of a persistent vm)")
+	flag.StringVar(&imgExporterCacheDir, "img-exporter-cache-dir", "", "The directory of the nodes the img-exporter caches the disks of the base images of incremental snapshots in (disabled if empty)")
 	flag.StringVar(&containerKaniko, "container-kaniko-img", "gcr.io/kaniko-project/executor", "The image for the Kaniko container to be deployed")
+	flag.StringVar(&containerCrane, "container-crane-img", "gcr.io/go-containerregistry/crane", "The image for the Crane container (in charge of pushing incremental snapshots)")
+	flag.StringVar(&liveSnapshots, "live-snapshot-strategy", string(instancesnapshot_controller.LiveSnapshotStopAndRestart),
+		"The strategy to create the snapshot of running VMs (Disabled, StopAndRestart or VolumeSnapshot, which requires CSI volume snapshots)")
+	flag.StringVar(&snapshotBackend, "snapshot-backend", string(crownlabsv1alpha2.SnapshotBackendRegistry),
+		"The default backend the snapshots are exported to, if not specified in the InstanceSnapshot (Registry, S3 or PVC)")
+	flag.StringVar(&s3Opts.Endpoint, "s3-endpoint", "", "The URL of the S3-compatible object storage used by the S3 snapshot backend (AWS if empty)")
+	flag.StringVar(&s3Opts.Bucket, "s3-bucket", "", "The bucket the snapshots are uploaded to by the S3 snapshot backend")
+	flag.StringVar(&s3Opts.Region, "s3-region", "", "The region of the bucket used by the S3 snapshot backend")
+	flag.StringVar(&s3Opts.SecretName, "s3-secret", "", "The name of the secret containing the credentials of the S3 snapshot backend")
+	flag.StringVar(&containerS3Uploader, "container-s3-uploader-img", "amazon/aws-cli", "The image for the aws-cli container (in charge of uploading snapshots to S3)")
+	flag.StringVar(&cloneStorageClass, "snapshot-clone-storage-class", "", "The storage class of the PVCs created by the PVC snapshot backend (the default one if empty)")
+	flag.IntVar(&snapshotJobLimits.Global, "max-concurrent-snapshot-jobs", 0, "The maximum number of snapshotting jobs running at the same time in the cluster (0 means unlimited)")
+	flag.IntVar(&snapshotJobLimits.PerNamespace, "max-concurrent-snapshot-jobs-per-namespace", 0,
+		"The maximum number of snapshotting jobs running at the same time in each namespace (0 means unlimited)")
 	flag.StringVar(&containerEnvFileBrowserImg, "container-env-filebrowser-img", "filebrowser/filebrowser", "The image name for the filebrowser image (sidecar for gui-based file manager)")
 	flag.StringVar(&containerEnvFileBrowserImgTag, "container-env-filebrowser-img-tag", "latest", "The tag for the FileBrowser container (the gui-based file manager)")
 
+	flag.StringVar(&sshCAKeyPath, "ssh-ca-key", "", "The path of the private key of the certificate authority issuing the SSH certificates of the tenants (disabled if empty)")
+	flag.DurationVar(&sshCertificateValidity, "ssh-certificate-validity", 8*time.Hour, "The validity of the SSH certificates, if not specified in the request")
+	flag.DurationVar(&sshCertificateMaxValidity, "ssh-certificate-max-validity", 24*time.Hour, "The maximum validity of the SSH certificates")
+
+	flag.StringVar(&webTerminalOpts.Image, "web-terminal-img", "", "The image of the web terminal exposing the VMs without graphical interface (disabled if empty)")
+	flag.StringVar(&webTerminalOpts.SSHUser, "web-terminal-ssh-user", "crownlabs", "The user the web terminal logs in the VMs as")
+
 	flag.IntVar(&maxConcurrentReconciles, "max-concurrent-reconciles", 1, "The maximum number of concurrent Reconciles which can be run")
 
 	klog.InitFlags(nil)
@@ -111,13 +148,33 @@ func main() {
 	if err != nil {
 		klog.Fatal(err, "unable to start manager")
 	}
-	whiteListMap := parseMap(namespaceWhiteList)
-	klog.Info("Reconciling only namespaces with the following labels: ")
+	namespaceSelector, err := selectors.ParseLabelSelector(namespaceWhiteList)
+	if err != nil {
+		klog.Fatal(err, "invalid namespace whitelist")
+	}
+	klog.Infof("Reconciling only namespaces matching the following selector: %s", namespaceWhiteList)
+	liveSnapshotStrategy, err := instancesnapshot_controller.ParseLiveSnapshotStrategy(liveSnapshots)
+	if err != nil {
+		klog.Fatal(err, "invalid live snapshot strategy")
+	}
+	defaultSnapshotBackend, err := instancesnapshot_controller.ParseSnapshotBackend(snapshotBackend)
+	if err != nil {
+		klog.Fatal(err, "invalid snapshot backend")
+	}
+	var sshCA *sshcertificate_controller.CertificateAuthority
+	var sshCAPublicKey string
+	if sshCAKeyPath != "" {
+		if sshCA, err = sshcertificate_controller.LoadCertificateAuthority(sshCAKeyPath); err != nil {
+			klog.Fatal(err, "invalid ssh certificate authority")
+		}
+		sshCAPublicKey = sshCA.PublicKey()
+		klog.Infof("Issuing SSH certificates with the following certificate authority: %s", sshCAPublicKey)
+	}
 	if err = (&instance_controller.InstanceReconciler{
 		Client:             mgr.GetClient(),
 		Scheme:             mgr.GetScheme(),
 		EventsRecorder:     mgr.GetEventRecorderFor("InstanceOperator"),
-		NamespaceWhitelist: metav1.LabelSelector{MatchLabels: whiteListMap, MatchExpressions: []metav1.LabelSelectorRequirement{}},
+		NamespaceWhitelist: *namespaceSelector,
 		NextcloudBaseURL:   nextcloudBaseURL,
 		WebsiteBaseURL:     websiteBaseURL,
 		WebdavSecretName:   webdavSecret,
@@ -130,25 +187,79 @@ func main() {
 			FileBrowserImg:    containerEnvFileBrowserImg,
 			FileBrowserImgTag: containerEnvFileBrowserImgTag,
 		},
+		SSHCAPublicKey:      sshCAPublicKey,
+		WebTerminal:         webTerminalOpts,
+		ImgExporterImg:      containerImgExport,
+		ImgExporterCacheDir: imgExporterCacheDir,
+		RegistrySecretName:  vmRegistrySecret,
 	}).SetupWithManager(mgr); err != nil {
 		klog.Fatal(err, "unable to create controller", "controller", "Instance")
 	}
 
+	if err = (&instance_controller.InstanceReservationReconciler{
+		Client:             mgr.GetClient(),
+		Scheme:             mgr.GetScheme(),
+		EventsRecorder:     mgr.GetEventRecorderFor("InstanceReservation"),
+		NamespaceWhitelist: *namespaceSelector,
+	}).SetupWithManager(mgr); err != nil {
+		klog.Fatal(err, "unable to create controller", "controller", "InstanceReservation")
+	}
+
+	if err = (&instance_controller.InstanceSetReconciler{
+		Client:             mgr.GetClient(),
+		Scheme:             mgr.GetScheme(),
+		EventsRecorder:     mgr.GetEventRecorderFor("InstanceSet"),
+		NamespaceWhitelist: *namespaceSelector,
+	}).SetupWithManager(mgr); err != nil {
+		klog.Fatal(err, "unable to create controller", "controller", "InstanceSet")
+	}
+
+	if err = (&instance_controller.DatasetVolumeReconciler{
+		Client:    mgr.GetClient(),
+		APIReader: mgr.GetAPIReader(),
+	}).SetupWithManager(mgr); err != nil {
+		klog.Fatal(err, "unable to create controller", "controller", "DatasetVolume")
+	}
+
 	if err = (&instancesnapshot_controller.InstanceSnapshotReconciler{
 		Client:             mgr.GetClient(),
+		APIReader:          mgr.GetAPIReader(),
 		Scheme:             mgr.GetScheme(),
 		EventsRecorder:     mgr.GetEventRecorderFor("instance-snapshot"),
-		NamespaceWhitelist: metav1.LabelSelector{MatchLabels: whiteListMap, MatchExpressions: []metav1.LabelSelectorRequirement{}},
+		NamespaceWhitelist: *namespaceSelector,
 		VMRegistry:         vmRegistry,
 		RegistrySecretName: vmRegistrySecret,
 		ContainersSnapshot: instancesnapshot_controller.ContainersSnapshotOpts{
-			ContainerKaniko:    containerKaniko,
-			ContainerImgExport: containerImgExport,
+			ContainerKaniko:     containerKaniko,
+			ContainerImgExport:  containerImgExport,
+			ContainerCrane:      containerCrane,
+			ContainerS3Uploader: containerS3Uploader,
 		},
+		LiveSnapshots:       liveSnapshotStrategy,
+		Registry:            instancesnapshot_controller.NewRegistryClient("https://" + vmRegistry),
+		DefaultBackend:      defaultSnapshotBackend,
+		S3:                  s3Opts,
+		CloneStorageClass:   cloneStorageClass,
+		JobLimits:           snapshotJobLimits,
+		ImgExporterCacheDir: imgExporterCacheDir,
 	}).SetupWithManager(mgr); err != nil {
 		klog.Fatal(err, "unable to create controller", "controller", "InstanceSnapshot")
 	}
 
+	if sshCA != nil {
+		if err = (&sshcertificate_controller.SSHCertificateRequestReconciler{
+			Client:             mgr.GetClient(),
+			Scheme:             mgr.GetScheme(),
+			EventsRecorder:     mgr.GetEventRecorderFor("ssh-certificate-request"),
+			NamespaceWhitelist: *namespaceSelector,
+			CA:                 sshCA,
+			DefaultValidity:    sshCertificateValidity,
+			MaxValidity:        sshCertificateMaxValidity,
+		}).SetupWithManager(mgr); err != nil {
+			klog.Fatal(err, "unable to create controller", "controller", "SSHCertificateRequest")
+		}
+	}
+
 	// +kubebuilder:scaffold:builder
 	// Add readiness probe
 	err = mgr.AddReadyzCheck("ready-ping", healthz.Ping)
@@ -167,14 +278,3 @@ func main() {
 		klog.Fatal("Unable to start manager")
 	}
 }
-
-// This method parses a string to get a map. The different labels should divided by a &.
-func parseMap(raw string) map[string]string {
-	ss := strings.Split(raw, "&")
-	m := make(map[string]string)
-	for _, pair := range ss {
-		z := strings.Split(pair, "=")
-		m[z[0]] = z[1]
-	}
-	return m
-}
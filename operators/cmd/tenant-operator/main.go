@@ -19,7 +19,6 @@ package main
 import (
 	"context"
 	"flag"
-	"strings"
 	"time"
 
 	"github.com/go-resty/resty/v2"
@@ -31,6 +30,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 
 	tenantv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/selectors"
 	controllers "github.com/netgroup-polito/CrownLabs/operators/pkg/tenant-controller"
 )
 
@@ -59,12 +59,14 @@ func main() {
 	var ncTnOpUser string
 	var ncTnOpPsw string
 	var maxConcurrentReconciles int
+	var orphansPolicy string
+	var orphansInterval time.Duration
 
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
-	flag.StringVar(&targetLabel, "target-label", "", "The key=value pair label that needs to be in the resource to be reconciled. A single pair in the format key=value")
+	flag.StringVar(&targetLabel, "target-label", "", "The label selector the resources need to satisfy to be reconciled (e.g. key1=value1,key2 in (value2,value3))")
 	flag.StringVar(&kcURL, "kc-url", "", "The URL of the keycloak server.")
 	flag.StringVar(&kcTnOpUser, "kc-tenant-operator-user", "", "The username of the acting account for keycloak.")
 	flag.StringVar(&kcTnOpPsw, "kc-tenant-operator-psw", "", "The password of the acting account for keycloak.")
@@ -75,6 +77,9 @@ func main() {
 	flag.StringVar(&ncTnOpUser, "nc-tenant-operator-user", "", "The username of the acting account for nextcloud.")
 	flag.StringVar(&ncTnOpPsw, "nc-tenant-operator-psw", "", "The password of the acting account for nextcloud.")
 	flag.IntVar(&maxConcurrentReconciles, "max-concurrent-reconciles", 1, "The maximum number of concurrent Reconciles which can be run")
+	flag.StringVar(&orphansPolicy, "orphans-policy", string(controllers.OrphansPolicyDisabled),
+		"The policy applied to the keycloak and nextcloud resources no longer associated with a Tenant or Workspace (disabled, report or delete)")
+	flag.DurationVar(&orphansInterval, "orphans-interval", time.Hour, "The interval between two consecutive collections of orphan resources")
 	klog.InitFlags(nil)
 	flag.Parse()
 
@@ -85,12 +90,15 @@ func main() {
 		klog.Fatal("Some flag parameters are not defined!")
 	}
 
-	targetLabelKeyValue := strings.Split(targetLabel, "=")
-	if len(targetLabelKeyValue) != 2 {
-		klog.Fatal("Error with target label format")
+	targetLabelSelector, err := selectors.ParseLabelSelector(targetLabel)
+	if err != nil {
+		klog.Fatal("Error with target label format", err)
+	}
+
+	parsedOrphansPolicy, err := controllers.ParseOrphansPolicy(orphansPolicy)
+	if err != nil {
+		klog.Fatal("Error with orphans policy", err)
 	}
-	targetLabelKey := targetLabelKeyValue[0]
-	targetLabelValue := targetLabelKeyValue[1]
 
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:                 scheme,
@@ -116,25 +124,34 @@ func main() {
 	httpClient := resty.New().SetCookieJar(nil)
 	NcA := controllers.NcActor{TnOpUser: ncTnOpUser, TnOpPsw: ncTnOpPsw, Client: httpClient, BaseURL: ncURL}
 	if err = (&controllers.TenantReconciler{
-		Client:           mgr.GetClient(),
-		Scheme:           mgr.GetScheme(),
-		KcA:              kcA,
-		NcA:              &NcA,
-		TargetLabelKey:   targetLabelKey,
-		TargetLabelValue: targetLabelValue,
-		Concurrency:      maxConcurrentReconciles,
+		Client:              mgr.GetClient(),
+		Scheme:              mgr.GetScheme(),
+		KcA:                 kcA,
+		NcA:                 &NcA,
+		TargetLabelSelector: *targetLabelSelector,
+		Concurrency:         maxConcurrentReconciles,
 	}).SetupWithManager(mgr); err != nil {
 		klog.Fatal("Unable to create controller for Tenant", err)
 	}
 	if err = (&controllers.WorkspaceReconciler{
-		Client:           mgr.GetClient(),
-		Scheme:           mgr.GetScheme(),
-		KcA:              kcA,
-		TargetLabelKey:   targetLabelKey,
-		TargetLabelValue: targetLabelValue,
+		Client:              mgr.GetClient(),
+		Scheme:              mgr.GetScheme(),
+		KcA:                 kcA,
+		TargetLabelSelector: *targetLabelSelector,
 	}).SetupWithManager(mgr); err != nil {
 		klog.Fatal("Unable to create controller for Workspace", err)
 	}
+	if parsedOrphansPolicy != controllers.OrphansPolicyDisabled {
+		if err = mgr.Add(&controllers.OrphansCollector{
+			Reader:   mgr.GetAPIReader(),
+			KcA:      kcA,
+			NcA:      &NcA,
+			Policy:   parsedOrphansPolicy,
+			Interval: orphansInterval,
+		}); err != nil {
+			klog.Fatal("Unable to add the orphans collector", err)
+		}
+	}
 	// +kubebuilder:scaffold:builder
 	// Add readiness probe
 	err = mgr.AddReadyzCheck("ready-ping", healthz.Ping)
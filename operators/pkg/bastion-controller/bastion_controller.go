@@ -19,32 +19,56 @@ package bastion_controller
 
 import (
 	"context"
-	"io/ioutil"
-	"os"
+	"fmt"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
-	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	crownlabsalpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	crownlabsalpha2 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha2"
 )
 
+// authorizedKeysRequest is the request all the Tenant events are mapped to, since the
+// authorized_keys file is always regenerated as a whole (hence, bursts of events are coalesced).
+var authorizedKeysRequest = reconcile.Request{NamespacedName: types.NamespacedName{Name: "authorized-keys"}}
+
 // BastionReconciler reconciles a Bastion object.
 type BastionReconciler struct {
 	client.Client
 	Scheme             *runtime.Scheme
+	EventsRecorder     record.EventRecorder
 	AuthorizedKeysPath string
+	// ResyncPeriod is the interval between the periodic regenerations of the authorized_keys file. It is regenerated only at startup if zero.
+	ResyncPeriod time.Duration
 
 	// This function, if configured, is deferred at the beginning of the Reconcile.
 	// Specifically, it is meant to be set to GinkgoRecover during the tests,
 	// in order to lead to a controlled failure in case the Reconcile panics.
 	ReconcileDeferHook func()
+
+	// mutex serializes the regenerations of the authorized_keys file.
+	mutex sync.Mutex
+	// reported contains the invalid keys of each tenant already reported through events.
+	reported map[string]string
 }
 
-// Reconcile reconciles the SSH keys of a Tenant resource.
+// Reconcile regenerates the authorized_keys file with the SSH keys of all the Tenant resources,
+// restricted to forward connections only to the instances of the corresponding Tenant.
 func (r *BastionReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	if r.ReconcileDeferHook != nil {
 		defer r.ReconcileDeferHook()
@@ -52,57 +76,180 @@ func (r *BastionReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 
 	klog.Info("reconciling bastion")
 
-	tenant := &crownlabsalpha1.Tenant{}
-	deleted := false
-
-	if err := r.Get(ctx, req.NamespacedName, tenant); apierrors.IsNotFound(err) {
-		deleted = true
-	} else if err != nil {
+	if err := r.SyncAuthorizedKeys(ctx); err != nil {
+		klog.Error(err)
 		return ctrl.Result{}, err
 	}
+	return ctrl.Result{}, nil
+}
+
+// SyncAuthorizedKeys builds the authorized_keys file from the cached list of all the Tenants, and replaces the current one atomically.
+func (r *BastionReconciler) SyncAuthorizedKeys(ctx context.Context) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var tenants crownlabsalpha1.TenantList
+	if err := r.List(ctx, &tenants); err != nil {
+		return fmt.Errorf("error when listing the tenants -> %w", err)
+	}
+	// The tenants are sorted to generate the same file, unless their keys change.
+	sort.Slice(tenants.Items, func(i, j int) bool { return tenants.Items[i].Name < tenants.Items[j].Name })
+
+	allowedHosts, err := r.tenantInstanceHosts(ctx, tenants.Items)
+	if err != nil {
+		return err
+	}
+
+	var keys, invalid []string
+	for i := range tenants.Items {
+		tenant := &tenants.Items[i]
+		keys, invalid = composeAndMarkEntries(keys, tenant.Spec.PublicKeys, tenant.Name, allowedHosts[tenant.Name])
+		r.reportInvalidKeys(tenant, invalid)
+	}
 
-	var keys []string
+	if err := writeFileAtomically(r.AuthorizedKeysPath, []byte(strings.Join(keys, "\n"))); err != nil {
+		return fmt.Errorf("unable to write the file authorized_keys -> %w", err)
+	}
+
+	authorizedKeys.Set(float64(len(keys)))
+	lastSyncTimestamp.SetToCurrentTime()
+	klog.Infof("authorized_keys file synchronized with %d keys of %d tenants", len(keys), len(tenants.Items))
+	return nil
+}
 
-	if _, err := os.Stat(r.AuthorizedKeysPath); err == nil {
-		// if the file exists, read the whole file in a []byte
-		data, err := ioutil.ReadFile(r.AuthorizedKeysPath)
-		if err != nil {
-			klog.Errorf("unable to read the file authorized_keys: %v", err)
-			return ctrl.Result{}, err
+// tenantInstanceHosts returns, for each tenant, the sorted list of hosts (i.e. cluster IPs and service names)
+// of its running instances, which are the only targets the tenant is allowed to jump to through the bastion.
+// The hosts are derived from the services created by the instance operator, and assigned to the tenant owning
+// their namespace, since both the status of the instances and the tenant they reference are set by the tenants.
+func (r *BastionReconciler) tenantInstanceHosts(ctx context.Context, tenants []crownlabsalpha1.Tenant) (map[string][]string, error) {
+	owners, err := r.namespaceTenants(ctx, tenants)
+	if err != nil {
+		return nil, err
+	}
+
+	var instances crownlabsalpha2.InstanceList
+	if err := r.List(ctx, &instances); err != nil {
+		return nil, fmt.Errorf("error when listing the instances -> %w", err)
+	}
+	running := map[types.UID]bool{}
+	for i := range instances.Items {
+		running[instances.Items[i].UID] = instances.Items[i].Spec.Running
+	}
+
+	var services corev1.ServiceList
+	if err := r.List(ctx, &services); err != nil {
+		return nil, fmt.Errorf("error when listing the services -> %w", err)
+	}
+
+	hosts := map[string][]string{}
+	for i := range services.Items {
+		service := &services.Items[i]
+		owner := metav1.GetControllerOf(service)
+		if owner == nil || owner.Kind != "Instance" || owner.APIVersion != crownlabsalpha2.GroupVersion.String() || !running[owner.UID] {
+			continue
+		}
+		tenant, ok := owners[service.Namespace]
+		if !ok {
+			continue
 		}
 
-		if len(data) > 0 {
-			keys = decomposeAndPurgeEntries(strings.Split(string(data), string("\n")), req.NamespacedName.Name)
+		if service.Spec.ClusterIP != "" && service.Spec.ClusterIP != corev1.ClusterIPNone {
+			hosts[tenant] = append(hosts[tenant], service.Spec.ClusterIP)
 		}
+		hosts[tenant] = append(hosts[tenant], service.Name+"."+service.Namespace)
+	}
+	for tenant := range hosts {
+		sort.Strings(hosts[tenant])
+	}
+	return hosts, nil
+}
+
+// namespaceTenants returns the name of the tenant owning each namespace (i.e. the personal namespaces
+// created by the tenant operator). The namespaces not owned by any of the given tenants are omitted.
+func (r *BastionReconciler) namespaceTenants(ctx context.Context, tenants []crownlabsalpha1.Tenant) (map[string]string, error) {
+	uids := make(map[string]types.UID, len(tenants))
+	for i := range tenants {
+		uids[tenants[i].Name] = tenants[i].UID
 	}
 
-	if !deleted {
-		// if the event was NOT a deletion, add the tenant's keys. Otherwise nothing to do.
-		keys = composeAndMarkEntries(keys, tenant.Spec.PublicKeys, req.NamespacedName.Name)
+	var namespaces corev1.NamespaceList
+	if err := r.List(ctx, &namespaces); err != nil {
+		return nil, fmt.Errorf("error when listing the namespaces -> %w", err)
 	}
 
-	f, err := os.Create(r.AuthorizedKeysPath)
+	owners := map[string]string{}
+	for i := range namespaces.Items {
+		owner := metav1.GetControllerOf(&namespaces.Items[i])
+		if owner == nil || owner.Kind != "Tenant" || owner.APIVersion != crownlabsalpha1.GroupVersion.String() {
+			continue
+		}
+		if uid, ok := uids[owner.Name]; ok && uid == owner.UID {
+			owners[namespaces.Items[i].Name] = owner.Name
+		}
+	}
+	return owners, nil
+}
+
+// reportInvalidKeys generates a warning event on the tenant for each invalid public key, in case they changed since the last report.
+func (r *BastionReconciler) reportInvalidKeys(tenant *crownlabsalpha1.Tenant, invalid []string) {
+	if r.reported == nil {
+		r.reported = map[string]string{}
+	}
+
+	summary := strings.Join(invalid, "\n")
+	if r.reported[tenant.Name] == summary {
+		return
+	}
+	r.reported[tenant.Name] = summary
+
+	for _, message := range invalid {
+		klog.Warningf("tenant %s: %s", tenant.Name, message)
+		if r.EventsRecorder != nil {
+			r.EventsRecorder.Event(tenant, "Warning", "InvalidPublicKey", message)
+		}
+	}
+}
+
+// SetupWithManager registers a new controller for Tenant resources, and the periodic resync of the authorized_keys file.
+func (r *BastionReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	c, err := controller.New("bastion", mgr, controller.Options{Reconciler: r})
 	if err != nil {
-		klog.Errorf("unable to create the file authorized_keys: %v", err)
-		return ctrl.Result{}, nil
+		return err
+	}
+
+	// The keys are restricted to the addresses of the instances of the tenant, hence they are watched as well,
+	// together with the services exposing them and the namespaces determining the tenant they belong to.
+	toAuthorizedKeys := handler.EnqueueRequestsFromMapFunc(func(client.Object) []reconcile.Request { return []reconcile.Request{authorizedKeysRequest} })
+	for _, object := range []client.Object{&crownlabsalpha1.Tenant{}, &crownlabsalpha2.Instance{}, &corev1.Service{}, &corev1.Namespace{}} {
+		if err := c.Watch(&source.Kind{Type: object}, toAuthorizedKeys); err != nil {
+			return err
+		}
 	}
+	return mgr.Add(&authorizedKeysResync{reconciler: r})
+}
 
-	defer closeFile(f)
+// authorizedKeysResync regenerates the authorized_keys file at startup (once the cache is synchronized), and then periodically.
+type authorizedKeysResync struct {
+	reconciler *BastionReconciler
+}
 
-	if len(keys) > 0 {
-		_, err = f.Write([]byte(strings.Join(keys, string("\n"))))
-		if err != nil {
-			klog.Errorf("unable to write to authorized_keys: %v", err)
-			return ctrl.Result{}, nil
+// Start starts the resync, until the given context is canceled.
+func (s *authorizedKeysResync) Start(ctx context.Context) error {
+	resync := func(ctx context.Context) {
+		if err := s.reconciler.SyncAuthorizedKeys(ctx); err != nil {
+			klog.Errorf("periodic resync failed: %v", err)
 		}
 	}
 
-	return ctrl.Result{}, nil
+	if s.reconciler.ResyncPeriod <= 0 {
+		resync(ctx)
+		return nil
+	}
+	wait.UntilWithContext(ctx, resync, s.reconciler.ResyncPeriod)
+	return nil
 }
 
-// SetupWithManager registers a new controller for Tenant resources.
-func (r *BastionReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&crownlabsalpha1.Tenant{}).
-		Complete(r)
+// NeedLeaderElection returns false, since each replica of the bastion owns its authorized_keys file.
+func (s *authorizedKeysResync) NeedLeaderElection() bool {
+	return false
 }
@@ -3,17 +3,26 @@ package bastion_controller
 import (
 	"bytes"
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"golang.org/x/crypto/ssh"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/util/retry"
+	ctrl "sigs.k8s.io/controller-runtime"
 
 	crownlabsalpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	crownlabsalpha2 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha2"
 )
 
 var _ = Describe("Bastion controller - creating two tenants", func() {
@@ -29,6 +38,7 @@ var _ = Describe("Bastion controller - creating two tenants", func() {
 
 	var (
 		PubKeysToBeChecked = map[string][]string{}
+		InstanceHosts      = map[string][]string{}
 		PublicKeysTenant1  []string
 		PublicKeysTenant2  []string
 		tenant1LookupKey   = types.NamespacedName{Name: NameTenant1}
@@ -37,6 +47,15 @@ var _ = Describe("Bastion controller - creating two tenants", func() {
 
 	ctx := context.Background()
 
+	// this function generates a new public key in the authorized_keys format, with the given options and comment.
+	newPublicKey := func(options, comment string) string {
+		pub, _, err := ed25519.GenerateKey(rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+		sshPub, err := ssh.NewPublicKey(pub)
+		Expect(err).ToNot(HaveOccurred())
+		return strings.TrimSpace(options + " " + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPub))) + " " + comment)
+	}
+
 	// this function checks if the keys are properly placed in the file.
 	checkFile := func() (bool, error) {
 
@@ -52,6 +71,7 @@ var _ = Describe("Bastion controller - creating two tenants", func() {
 				if err != nil {
 					continue
 				}
+				entry.RestrictForwarding(InstanceHosts[id])
 
 				if !bytes.Contains(data, []byte(entry.Compose())) {
 					return false, nil
@@ -65,14 +85,17 @@ var _ = Describe("Bastion controller - creating two tenants", func() {
 
 	BeforeEach(func() {
 		PubKeysToBeChecked = make(map[string][]string)
+		InstanceHosts = make(map[string][]string)
 
 		PublicKeysTenant1 = []string{
-			"ssh-ed25519 publicKeyString_1 comment_1",
-			"ssh-rsa publicKeyString_2",
+			newPublicKey("", "comment_1"),
+			newPublicKey("restrict,no-pty", ""),
 			"invalid_entry",
+			"ssh-rsa publicKeyString_2",
 		}
 		PublicKeysTenant2 = []string{
-			"ssh-rsa abcdefghi comment",
+			newPublicKey("", "comment with spaces"),
+			newPublicKey(`command="/bin/sh"`, "comment"),
 		}
 
 		tenant1 := &crownlabsalpha1.Tenant{}
@@ -185,7 +208,7 @@ var _ = Describe("Bastion controller - creating two tenants", func() {
 				if err != nil {
 					return err
 				}
-				PublicKeysTenant1[0] = "ecdsa-sha2-nistp256 yet_another_public_key comment_3"
+				PublicKeysTenant1[0] = newPublicKey("", "comment_3")
 
 				createdTenant.Spec.PublicKeys = PublicKeysTenant1
 				return k8sClient.Update(ctx, createdTenant)
@@ -211,6 +234,93 @@ var _ = Describe("Bastion controller - creating two tenants", func() {
 		})
 	})
 
+	Context("When the file is modified externally", func() {
+		BeforeEach(func() {
+			PubKeysToBeChecked[NameTenant1] = PublicKeysTenant1
+			PubKeysToBeChecked[NameTenant2] = PublicKeysTenant2
+			Eventually(checkFile, timeout, interval).Should(BeTrue())
+
+			Expect(ioutil.WriteFile(testFile, []byte("ssh-rsa corrupted"), 0644)).Should(Succeed())
+		})
+
+		It("Should regenerate the file with the keys of all the tenants", func() {
+			By("Checking the file after the periodic resync")
+			Eventually(checkFile, timeout, interval).Should(BeTrue())
+
+			By("Checking that no temporary files are left")
+			tmpFiles, err := filepath.Glob(".authorized_keys_test.tmp-*")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tmpFiles).Should(BeEmpty())
+		})
+	})
+
+	Context("When one tenant has a running instance", func() {
+		const (
+			instanceName      = "instance-s11111"
+			instanceNamespace = "tenant-s11111"
+		)
+
+		var service *corev1.Service
+
+		BeforeEach(func() {
+			tenant := &crownlabsalpha1.Tenant{}
+			Expect(k8sClient.Get(ctx, tenant1LookupKey, tenant)).Should(Succeed())
+
+			// The namespaces cannot be deleted in the test environment, hence it is created only once.
+			namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: instanceNamespace}}
+			Expect(ctrl.SetControllerReference(tenant, namespace, k8sClient.Scheme())).Should(Succeed())
+			if err := k8sClient.Create(ctx, namespace); !errors.IsAlreadyExists(err) {
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			instance := &crownlabsalpha2.Instance{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      instanceName,
+					Namespace: instanceNamespace,
+				},
+				Spec: crownlabsalpha2.InstanceSpec{
+					Running:  true,
+					Template: crownlabsalpha2.GenericRef{Name: "template", Namespace: "default"},
+					// The referenced tenant is ignored, since it is set by the tenants themselves.
+					Tenant: crownlabsalpha2.GenericRef{Name: NameTenant2},
+				},
+			}
+			Expect(k8sClient.Create(ctx, instance)).Should(Succeed())
+
+			// The status of the instance is ignored as well, in favor of the service created by the instance operator.
+			instance.Status.IP = "10.0.0.1"
+			Expect(k8sClient.Status().Update(ctx, instance)).Should(Succeed())
+
+			service = &corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: instanceName, Namespace: instanceNamespace},
+				Spec: corev1.ServiceSpec{
+					Ports:    []corev1.ServicePort{{Name: "ssh", Protocol: corev1.ProtocolTCP, Port: 22}},
+					Selector: map[string]string{"name": instanceName},
+				},
+			}
+			Expect(ctrl.SetControllerReference(instance, service, k8sClient.Scheme())).Should(Succeed())
+			Expect(k8sClient.Create(ctx, service)).Should(Succeed())
+		})
+
+		AfterEach(func() {
+			Expect(k8sClient.Delete(ctx, service)).Should(Succeed())
+			Expect(k8sClient.Delete(ctx, &crownlabsalpha2.Instance{
+				ObjectMeta: metav1.ObjectMeta{Name: instanceName, Namespace: instanceNamespace},
+			})).Should(Succeed())
+		})
+
+		It("Should allow the tenant owning the namespace to jump only to its instance", func() {
+			By("Checking the file for the permitopen options of the first tenant")
+			PubKeysToBeChecked[NameTenant1] = PublicKeysTenant1
+			InstanceHosts[NameTenant1] = []string{service.Spec.ClusterIP, instanceName + "." + instanceNamespace}
+			Eventually(checkFile, timeout, interval).Should(BeTrue())
+
+			By("Checking the file for the keys of the second tenant, without port forwarding")
+			PubKeysToBeChecked[NameTenant2] = PublicKeysTenant2
+			Eventually(checkFile, timeout, interval).Should(BeTrue())
+		})
+	})
+
 	Context("When deleting a Tenant", func() {
 		BeforeEach(func() {
 			Expect(k8sClient.Delete(ctx, &crownlabsalpha1.Tenant{
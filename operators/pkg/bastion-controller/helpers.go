@@ -1,94 +1,176 @@
 package bastion_controller
 
 import (
+	"bytes"
+	"encoding/base64"
 	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
 	"os"
+	"path/filepath"
 	"strings"
 
-	"k8s.io/klog/v2"
+	"golang.org/x/crypto/ssh"
 )
 
-func closeFile(f *os.File) {
-	err := f.Close()
+// writeFileAtomically writes the data to a temporary file in the same directory of the given one, and then
+// renames it, so that readers never observe a partially written file, even in case of crashes.
+func writeFileAtomically(path string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp-")
 	if err != nil {
-		klog.Errorf("unable to close the file authorized_keys: %v", err)
+		return err
 	}
-}
+	// The temporary file is removed, unless it has already been renamed.
+	defer os.Remove(f.Name())
 
-func removeKey(s []string, i int) []string {
-	if i >= len(s) {
-		return s
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	// Same permissions of the files created by os.Create, with the default umask.
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return err
 	}
-	s[i] = s[len(s)-1]
+	return os.Rename(f.Name(), path)
+}
+
+// instanceSSHPort is the port the tenants are allowed to jump to on their instances.
+const instanceSSHPort = "22"
 
-	return s[:len(s)-1]
+// allowedKeyOptions are the options (see sshd(8)) the tenants are allowed to specify for their keys.
+// The permitopen option is additionally restricted to the targets the tenant is allowed to reach.
+var allowedKeyOptions = map[string]bool{
+	"restrict":            true,
+	"port-forwarding":     true,
+	"permitopen":          true,
+	"no-pty":              true,
+	"no-agent-forwarding": true,
+	"no-x11-forwarding":   true,
+	"no-user-rc":          true,
+	"cert-authority":      true,
+	"principals":          true,
+	"from":                true,
+	"expiry-time":         true,
 }
 
-// AuthorizedKeysEntry is a structure containing the three different fields
+// AuthorizedKeysEntry is a structure containing the different fields
 // of an entry of the .ssh/authorized_keys file.
 type AuthorizedKeysEntry struct {
-	Algo, Key, ID string
+	Options []string
+	Key     ssh.PublicKey
+	ID      string
 }
 
-// Decompose converts a string into an AuthorizedKeysEntry object.
-func Decompose(entry string) (AuthorizedKeysEntry, error) {
-	entryComponents := strings.Split(entry, string(" "))
-	if len(entryComponents) == 3 {
-		return AuthorizedKeysEntry{
-			Algo: entryComponents[0],
-			Key:  entryComponents[1],
-			ID:   entryComponents[2],
-		}, nil
+// Create parses a public key in the authorized_keys format (i.e. [options] keytype base64-key [comment])
+// and an id into an AuthorizedKeysEntry object. The comment is replaced by the id, while certificates
+// are replaced by the certified key, since they are not accepted by sshd as authorized keys.
+func Create(entry, id string) (AuthorizedKeysEntry, error) {
+	// ParseAuthorizedKey skips the malformed lines, hence entries spanning multiple lines are rejected upfront.
+	if strings.ContainsAny(entry, "\r\n") {
+		return AuthorizedKeysEntry{}, errors.New("invalid public key: multiple lines in the same entry")
+	}
+	key, _, options, rest, err := ssh.ParseAuthorizedKey([]byte(entry))
+	if err != nil {
+		return AuthorizedKeysEntry{}, fmt.Errorf("invalid public key: %w", err)
+	}
+	if len(bytes.TrimSpace(rest)) > 0 {
+		return AuthorizedKeysEntry{}, errors.New("invalid public key: multiple keys in the same entry")
+	}
+	if cert, ok := key.(*ssh.Certificate); ok {
+		key = cert.Key
 	}
 
-	return AuthorizedKeysEntry{}, errors.New("invalid entry")
-}
-
-// Create converts a string and an id into an AuthorizedKeysEntry object.
-func Create(entry, id string) (AuthorizedKeysEntry, error) {
-	entryComponents := strings.Split(entry, string(" "))
-	if len(entryComponents) == 3 || len(entryComponents) == 2 {
-		return AuthorizedKeysEntry{
-			Algo: entryComponents[0],
-			Key:  entryComponents[1],
-			ID:   id,
-		}, nil
+	for _, option := range options {
+		if name, _ := splitKeyOption(option); !allowedKeyOptions[name] {
+			return AuthorizedKeysEntry{}, fmt.Errorf("invalid public key: option %s is not allowed", name)
+		}
 	}
 
-	return AuthorizedKeysEntry{}, errors.New("invalid entry")
+	return AuthorizedKeysEntry{Options: options, Key: key, ID: id}, nil
 }
 
 // Compose an AuthorizedKeysEntry object into a string.
 func (e *AuthorizedKeysEntry) Compose() string {
-	return e.Algo + " " + e.Key + " " + e.ID
+	entry := e.Key.Type() + " " + base64.StdEncoding.EncodeToString(e.Key.Marshal()) + " " + e.ID
+	if len(e.Options) > 0 {
+		entry = strings.Join(e.Options, ",") + " " + entry
+	}
+	return entry
 }
 
-func decomposeAndPurgeEntries(keys []string, tenantID string) []string {
-	indexesList := []int{}
-	for i, key := range keys {
-		entry, err := Decompose(key)
-		if err != nil {
+// ValidatePermitOpen checks that the targets of the permitopen options of the entry are among the allowed hosts.
+func (e *AuthorizedKeysEntry) ValidatePermitOpen(allowedHosts map[string]bool) error {
+	for _, option := range e.Options {
+		name, value := splitKeyOption(option)
+		if name != "permitopen" {
 			continue
 		}
-		if entry.ID == tenantID {
-			indexesList = append(indexesList, i)
+		host, _, err := net.SplitHostPort(value)
+		if err != nil {
+			return fmt.Errorf("invalid permitopen option %s: %w", value, err)
+		}
+		if !allowedHosts[host] {
+			return fmt.Errorf("invalid permitopen option %s: %s is not an instance of the tenant", value, host)
+		}
+	}
+	return nil
+}
+
+// RestrictForwarding limits the targets the entry is allowed to forward connections to (i.e. jump to) to the
+// given hosts, unless already restricted through permitopen options. Port forwarding is disabled if no host is given.
+func (e *AuthorizedKeysEntry) RestrictForwarding(hosts []string) {
+	for _, option := range e.Options {
+		if name, _ := splitKeyOption(option); name == "permitopen" {
+			return
 		}
 	}
 
-	// we have to iterate in reverse order, otherwise the last indexes could become out of range
-	for k := len(indexesList) - 1; k >= 0; k-- {
-		keys = removeKey(keys, indexesList[k])
+	if len(hosts) == 0 {
+		e.Options = append(e.Options, "no-port-forwarding")
+		return
 	}
-	return keys
+	for _, host := range hosts {
+		e.Options = append(e.Options, fmt.Sprintf("permitopen=%q", net.JoinHostPort(host, instanceSSHPort)))
+	}
+}
+
+// splitKeyOption splits an option of an authorized_keys entry into its (lowercase) name and its unquoted value.
+func splitKeyOption(option string) (name, value string) {
+	parts := strings.SplitN(option, "=", 2)
+	if len(parts) == 2 {
+		value = strings.Trim(parts[1], `"`)
+	}
+	return strings.ToLower(parts[0]), value
 }
 
-func composeAndMarkEntries(keys, tenantKeys []string, tenantID string) []string {
+// composeAndMarkEntries adds to keys the valid public keys of the tenant, restricted to forward connections
+// only to the given hosts (i.e. the instances of the tenant), and returns the errors of the invalid ones.
+func composeAndMarkEntries(keys, tenantKeys []string, tenantID string, hosts []string) ([]string, []string) {
+	allowedHosts := make(map[string]bool, len(hosts))
+	for _, host := range hosts {
+		allowedHosts[host] = true
+	}
+
+	var invalid []string
 	for i := range tenantKeys {
 		entry, err := Create(tenantKeys[i], tenantID)
+		if err == nil {
+			err = entry.ValidatePermitOpen(allowedHosts)
+		}
 		if err != nil {
+			invalid = append(invalid, fmt.Sprintf("public key %d: %v", i, err))
 			continue
 		}
+		entry.RestrictForwarding(hosts)
 		keys = append(keys, entry.Compose())
 	}
-	return keys
+	return keys, invalid
 }
@@ -0,0 +1,22 @@
+package bastion_controller
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	authorizedKeys = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "bastion_authorized_keys",
+		Help: "The number of keys written in the authorized_keys file during the last synchronization",
+	})
+	lastSyncTimestamp = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "bastion_authorized_keys_last_sync_timestamp_seconds",
+		Help: "The timestamp of the last successful synchronization of the authorized_keys file",
+	})
+)
+
+func init() {
+	// Register custom metrics with the global prometheus registry
+	metrics.Registry.MustRegister(authorizedKeys, lastSyncTimestamp)
+}
@@ -6,8 +6,11 @@ import (
 	"github.com/google/uuid"
 	v1 "k8s.io/api/core/v1"
 	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/klog/v2"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	crownlabsv1alpha2 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha2"
 	instance_creation "github.com/netgroup-polito/CrownLabs/operators/pkg/instance-creation"
@@ -15,13 +18,30 @@ import (
 
 // CreateInstanceExpositionEnvironment creates the components necessary to access the environment (service, ingress and oauth2-proxy related resources).
 // Additionally, it makes the service expose another port and creates an ingress for FileBrowser sidecar container (only for container environments).
+// The ingress exposes at the URL of the instance the given web port, that is either the one of the remote desktop or the one of the web interface of
+// headless containers, and it is not created (or deleted, once the changes are rolled out) in case the port is zero. In case of web terminal,
+// the ingress exposes the service of the web terminal, instead of the remote desktop.
 func (r *InstanceReconciler) CreateInstanceExpositionEnvironment(
 	ctx context.Context,
 	instance *crownlabsv1alpha2.Instance,
-	name string, hasFileBrowser bool,
+	name string, hasFileBrowser, hasWebTerminal bool, webPort int32,
+	rollout rolloutAction,
 ) (v1.Service, networkingv1.Ingress, string, error) {
 	// create Service to expose the pod
 	service := instance_creation.ForgeService(name, instance.Namespace)
+	if webPort != instance_creation.RemoteDesktopPort {
+		// The port of the remote desktop is replaced by the web one, if any, since no component listens on it.
+		ports := []v1.ServicePort{}
+		if webPort != 0 {
+			ports = append(ports, instance_creation.ForgeWebServicePort(webPort))
+		}
+		for _, port := range service.Spec.Ports {
+			if port.Name != "vnc" {
+				ports = append(ports, port)
+			}
+		}
+		service.Spec.Ports = ports
+	}
 
 	fileBrowserPortName := "filebrowser"
 	if hasFileBrowser {
@@ -32,7 +52,13 @@ func (r *InstanceReconciler) CreateInstanceExpositionEnvironment(
 		})
 	}
 
+	// A copy is required, since the slice of the service is overwritten when retrieving the existing one.
+	ports := append([]v1.ServicePort{}, service.Spec.Ports...)
 	op, err := ctrl.CreateOrUpdate(ctx, r.Client, &service, func() error {
+		// The exposed ports follow the environment, unless the rollout policy prevents the changes from being applied.
+		if service.ObjectMeta.CreationTimestamp.IsZero() || rollout != rolloutSkip {
+			service.Spec.Ports = ports
+		}
 		return ctrl.SetControllerReference(instance, &service, r.Scheme)
 	})
 
@@ -42,22 +68,24 @@ func (r *InstanceReconciler) CreateInstanceExpositionEnvironment(
 	}
 	klog.Infof("Service for instance %s/%s %s", instance.GetNamespace(), instance.GetName(), op)
 
-	urlUUID := uuid.New().String()
-
-	// create Ingress to manage the service
-	ingress := instance_creation.ForgeIngress(name, instance.Namespace, &service, r.WebsiteBaseURL, urlUUID, r.InstancesAuthURL)
-	op, err = ctrl.CreateOrUpdate(ctx, r.Client, &ingress, func() error {
-		return ctrl.SetControllerReference(instance, &ingress, r.Scheme)
-	})
-
+	urlUUID, err := r.instanceURLUUID(ctx, instance.Namespace, name)
 	if err != nil {
-		r.setInstanceStatus(ctx, "Could not create ingress "+ingress.Name+" in namespace "+ingress.Namespace+": "+err.Error(), "Error", "IngressNotCreated", instance, "", "")
+		r.setInstanceStatus(ctx, "Could not retrieve the ingresses of instance "+instance.Name+" in namespace "+instance.Namespace+": "+err.Error(), "Error", "IngressNotCreated", instance, "", "")
 		return service, networkingv1.Ingress{}, "", err
 	}
-	klog.Infof("Ingress (gui) for instance %s/%s %s", instance.GetNamespace(), instance.GetName(), op)
 
-	// Overwrite using the actual ingress' url UUID
-	urlUUID = ingress.Annotations["crownlabs.polito.it/url-uuid"]
+	ingress := networkingv1.Ingress{}
+	switch {
+	case webPort != 0 || hasWebTerminal:
+		if ingress, err = r.createInstanceIngress(ctx, instance, name, &service, urlUUID, hasWebTerminal, webPort, rollout); err != nil {
+			return service, networkingv1.Ingress{}, "", err
+		}
+	case rollout != rolloutSkip:
+		if err := r.deleteInstanceIngress(ctx, instance, name); err != nil {
+			r.setInstanceStatus(ctx, "Could not delete ingress "+name+" in namespace "+instance.Namespace+": "+err.Error(), "Error", "IngressNotDeleted", instance, "", "")
+			return service, networkingv1.Ingress{}, "", err
+		}
+	}
 
 	if hasFileBrowser {
 		// create separate Ingress for FileBrowser to manage the same service
@@ -75,3 +103,75 @@ func (r *InstanceReconciler) CreateInstanceExpositionEnvironment(
 
 	return service, ingress, urlUUID, nil
 }
+
+// instanceURLUUID returns the UUID identifying the URL of the given instance, as recorded by its existing ingresses (either the
+// main one or the one of FileBrowser, which may outlive the former), or a new one in case none has been created yet.
+func (r *InstanceReconciler) instanceURLUUID(ctx context.Context, namespace, name string) (string, error) {
+	for _, ingressName := range []string{name, name + "-filebrowser"} {
+		var ingress networkingv1.Ingress
+		if err := r.Get(ctx, types.NamespacedName{Namespace: namespace, Name: ingressName}, &ingress); client.IgnoreNotFound(err) != nil {
+			return "", err
+		} else if err == nil && ingress.Annotations["crownlabs.polito.it/url-uuid"] != "" {
+			return ingress.Annotations["crownlabs.polito.it/url-uuid"], nil
+		}
+	}
+	return uuid.New().String(), nil
+}
+
+// createInstanceIngress creates the ingress exposing either the remote desktop (or the web interface) or the web terminal of the instance.
+// The existing ingress is updated as well, according to the rollout action, to keep exposing the right service and port.
+func (r *InstanceReconciler) createInstanceIngress(ctx context.Context, instance *crownlabsv1alpha2.Instance, name string,
+	service *v1.Service, urlUUID string, hasWebTerminal bool, webPort int32, rollout rolloutAction) (networkingv1.Ingress, error) {
+	desired := instance_creation.ForgeIngress(name, instance.Namespace, service, r.WebsiteBaseURL, urlUUID, r.InstancesAuthURL)
+	if webPort != instance_creation.RemoteDesktopPort {
+		desired = instance_creation.ForgeWebIngress(name, instance.Namespace, service, r.WebsiteBaseURL, urlUUID, r.InstancesAuthURL)
+	}
+	if hasWebTerminal {
+		webTerminalService := instance_creation.ForgeWebTerminalService(name, instance.Namespace)
+		op, err := ctrl.CreateOrUpdate(ctx, r.Client, &webTerminalService, func() error {
+			return ctrl.SetControllerReference(instance, &webTerminalService, r.Scheme)
+		})
+
+		if err != nil {
+			r.setInstanceStatus(ctx, "Could not create service "+webTerminalService.Name+" in namespace "+webTerminalService.Namespace+": "+err.Error(), "Error", "ServiceNotCreated", instance, "", "")
+			return networkingv1.Ingress{}, err
+		}
+		klog.Infof("Service (web terminal) for instance %s/%s %s", instance.GetNamespace(), instance.GetName(), op)
+
+		desired = instance_creation.ForgeWebTerminalIngress(name, instance.Namespace, &webTerminalService, r.WebsiteBaseURL, urlUUID, r.InstancesAuthURL)
+	}
+
+	ingress := networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: desired.Name, Namespace: desired.Namespace}}
+	op, err := ctrl.CreateOrUpdate(ctx, r.Client, &ingress, func() error {
+		if ingress.ObjectMeta.CreationTimestamp.IsZero() || rollout != rolloutSkip {
+			ingress.Annotations = desired.Annotations
+			ingress.Spec = desired.Spec
+		}
+		return ctrl.SetControllerReference(instance, &ingress, r.Scheme)
+	})
+
+	if err != nil {
+		r.setInstanceStatus(ctx, "Could not create ingress "+ingress.Name+" in namespace "+ingress.Namespace+": "+err.Error(), "Error", "IngressNotCreated", instance, "", "")
+		return networkingv1.Ingress{}, err
+	}
+	klog.Infof("Ingress (gui) for instance %s/%s %s", instance.GetNamespace(), instance.GetName(), op)
+	return ingress, nil
+}
+
+// deleteInstanceIngress deletes the main ingress of the instance, if any, in case the environment no longer exposes
+// neither the remote desktop nor a web interface (e.g. once the graphical interface of a container is disabled).
+func (r *InstanceReconciler) deleteInstanceIngress(ctx context.Context, instance *crownlabsv1alpha2.Instance, name string) error {
+	var ingress networkingv1.Ingress
+	if err := r.Get(ctx, types.NamespacedName{Namespace: instance.Namespace, Name: name}, &ingress); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if !metav1.IsControlledBy(&ingress, instance) {
+		return nil
+	}
+
+	if err := r.Delete(ctx, &ingress); client.IgnoreNotFound(err) != nil {
+		return err
+	}
+	klog.Infof("Ingress (gui) for instance %s/%s deleted", instance.GetNamespace(), instance.GetName())
+	return nil
+}
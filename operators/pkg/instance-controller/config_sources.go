@@ -0,0 +1,177 @@
+package instance_controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	crownlabsv1alpha2 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha2"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/utils"
+)
+
+// configSourceName returns the name of the copy of the given configuration source in the namespace of the instance.
+func configSourceName(name string, source *crownlabsv1alpha2.ConfigSource) string {
+	if source.Kind == crownlabsv1alpha2.ConfigSourceSecret {
+		return name + "-secret-" + source.Name
+	}
+	return name + "-configmap-" + source.Name
+}
+
+// enforceConfigSources copies the ConfigMaps and Secrets referenced by the environment from the namespace
+// of the template to the one of the instance, keeping the copies up-to-date with the originals, and deletes
+// the copies of the sources no longer referenced. The sources are copied only if the tenant owning the
+// namespace of the instance belongs to the workspace of the template.
+func (r *InstanceReconciler) enforceConfigSources(ctx context.Context, instance *crownlabsv1alpha2.Instance,
+	environment *crownlabsv1alpha2.Environment, name string) error {
+	if len(environment.ConfigSources) > 0 {
+		if err := r.checkTemplateResourcesAllowed(ctx, instance, "configuration sources"); err != nil {
+			return err
+		}
+	}
+
+	copies := map[string]bool{}
+	for i := range environment.ConfigSources {
+		source := &environment.ConfigSources[i]
+		originalName := types.NamespacedName{Namespace: instance.Spec.Template.Namespace, Name: source.Name}
+		meta := metav1.ObjectMeta{Name: configSourceName(name, source), Namespace: instance.Namespace}
+		copies[meta.Name] = true
+
+		switch source.Kind {
+		case crownlabsv1alpha2.ConfigSourceSecret:
+			original := v1.Secret{}
+			if err := r.Get(ctx, originalName, &original); err != nil {
+				return fmt.Errorf("error when retrieving secret %s -> %w", originalName, err)
+			}
+
+			secret := v1.Secret{ObjectMeta: meta}
+			op, err := ctrl.CreateOrUpdate(ctx, r.Client, &secret, func() error {
+				// The type of the secret is immutable, and the copy is never meant to be interpreted by other components.
+				if secret.ObjectMeta.CreationTimestamp.IsZero() {
+					secret.Type = v1.SecretTypeOpaque
+				}
+				secret.Data = original.Data
+				return ctrl.SetControllerReference(instance, &secret, r.Scheme)
+			})
+			if err != nil {
+				return fmt.Errorf("error when copying secret %s -> %w", originalName, err)
+			}
+			klog.Infof("Secret %s for instance %s/%s %s", source.Name, instance.GetNamespace(), instance.GetName(), op)
+
+		default:
+			original := v1.ConfigMap{}
+			if err := r.Get(ctx, originalName, &original); err != nil {
+				return fmt.Errorf("error when retrieving configmap %s -> %w", originalName, err)
+			}
+
+			configMap := v1.ConfigMap{ObjectMeta: meta}
+			op, err := ctrl.CreateOrUpdate(ctx, r.Client, &configMap, func() error {
+				configMap.Data = original.Data
+				configMap.BinaryData = original.BinaryData
+				return ctrl.SetControllerReference(instance, &configMap, r.Scheme)
+			})
+			if err != nil {
+				return fmt.Errorf("error when copying configmap %s -> %w", originalName, err)
+			}
+			klog.Infof("ConfigMap %s for instance %s/%s %s", source.Name, instance.GetNamespace(), instance.GetName(), op)
+		}
+	}
+	return r.deleteStaleConfigSources(ctx, instance, name, copies)
+}
+
+// checkTemplateResourcesAllowed checks whether the resources of the template (e.g. the configuration sources or the datasets) can be
+// exposed in the namespace of the Instance, which requires the tenant owning it to belong to the workspace of the template (i.e. the
+// one owning its namespace). The given description of the resources is included in the returned error.
+func (r *InstanceReconciler) checkTemplateResourcesAllowed(ctx context.Context, instance *crownlabsv1alpha2.Instance, resources string) error {
+	// The tenant is derived from the namespace of the instance, since the one in the spec is set by the tenant itself.
+	tenant, err := utils.GetNamespaceTenant(ctx, r.Client, instance.Namespace)
+	if err != nil {
+		return err
+	}
+	workspace := utils.NamespaceWorkspaceName(instance.Spec.Template.Namespace)
+	if _, ok := tenant.Labels[crownlabsv1alpha1.WorkspaceLabelPrefix+workspace]; workspace == "" || !ok {
+		return fmt.Errorf("tenant %s does not belong to the workspace of template %s/%s, and it cannot access its %s",
+			tenant.Name, instance.Spec.Template.Namespace, instance.Spec.Template.Name, resources)
+	}
+	return nil
+}
+
+// deleteStaleConfigSources deletes the copies of the configuration sources owned by the instance which are not among the given ones,
+// i.e. the ones of the sources removed from the environment.
+func (r *InstanceReconciler) deleteStaleConfigSources(ctx context.Context, instance *crownlabsv1alpha2.Instance, name string, copies map[string]bool) error {
+	isStale := func(object client.Object, kind crownlabsv1alpha2.ConfigSourceKind) bool {
+		prefix := configSourceName(name, &crownlabsv1alpha2.ConfigSource{Kind: kind})
+		return metav1.IsControlledBy(object, instance) && strings.HasPrefix(object.GetName(), prefix) && !copies[object.GetName()]
+	}
+
+	var secrets v1.SecretList
+	if err := r.List(ctx, &secrets, client.InNamespace(instance.Namespace)); err != nil {
+		return fmt.Errorf("error when listing the secrets in namespace %s -> %w", instance.Namespace, err)
+	}
+	var stale []client.Object
+	for i := range secrets.Items {
+		if isStale(&secrets.Items[i], crownlabsv1alpha2.ConfigSourceSecret) {
+			stale = append(stale, &secrets.Items[i])
+		}
+	}
+
+	var configMaps v1.ConfigMapList
+	if err := r.List(ctx, &configMaps, client.InNamespace(instance.Namespace)); err != nil {
+		return fmt.Errorf("error when listing the configmaps in namespace %s -> %w", instance.Namespace, err)
+	}
+	for i := range configMaps.Items {
+		if isStale(&configMaps.Items[i], crownlabsv1alpha2.ConfigSourceConfigMap) {
+			stale = append(stale, &configMaps.Items[i])
+		}
+	}
+
+	for _, object := range stale {
+		if err := r.Delete(ctx, object); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("error when deleting the stale configuration source %s/%s -> %w", object.GetNamespace(), object.GetName(), err)
+		}
+		klog.Infof("Stale configuration source %s for instance %s/%s deleted", object.GetName(), instance.GetNamespace(), instance.GetName())
+	}
+	return nil
+}
+
+// buildConfigSources returns the volumes, the volume mounts and the environment variable sources exposing the
+// copies of the configuration sources of the environment to its container, depending on whether a mount path is set.
+func buildConfigSources(name string, environment *crownlabsv1alpha2.Environment) ([]v1.Volume, []v1.VolumeMount, []v1.EnvFromSource) {
+	var volumes []v1.Volume
+	var mounts []v1.VolumeMount
+	var envFrom []v1.EnvFromSource
+
+	for i := range environment.ConfigSources {
+		source := &environment.ConfigSources[i]
+		copyName := configSourceName(name, source)
+
+		if source.MountPath == "" {
+			if source.Kind == crownlabsv1alpha2.ConfigSourceSecret {
+				envFrom = append(envFrom, v1.EnvFromSource{SecretRef: &v1.SecretEnvSource{
+					LocalObjectReference: v1.LocalObjectReference{Name: copyName}}})
+			} else {
+				envFrom = append(envFrom, v1.EnvFromSource{ConfigMapRef: &v1.ConfigMapEnvSource{
+					LocalObjectReference: v1.LocalObjectReference{Name: copyName}}})
+			}
+			continue
+		}
+
+		volume := v1.Volume{Name: fmt.Sprintf("config-%d", i)}
+		if source.Kind == crownlabsv1alpha2.ConfigSourceSecret {
+			volume.VolumeSource.Secret = &v1.SecretVolumeSource{SecretName: copyName}
+		} else {
+			volume.VolumeSource.ConfigMap = &v1.ConfigMapVolumeSource{LocalObjectReference: v1.LocalObjectReference{Name: copyName}}
+		}
+		volumes = append(volumes, volume)
+		mounts = append(mounts, v1.VolumeMount{Name: volume.Name, MountPath: source.MountPath, ReadOnly: true})
+	}
+
+	return volumes, mounts, envFrom
+}
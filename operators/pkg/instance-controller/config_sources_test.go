@@ -0,0 +1,173 @@
+package instance_controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/pointer"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	crownlabsv1alpha2 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha2"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/utils"
+)
+
+var _ = Describe("Configuration of container environments", func() {
+	const name = "instance-name"
+
+	var environment crownlabsv1alpha2.Environment
+
+	BeforeEach(func() {
+		environment = crownlabsv1alpha2.Environment{
+			Name:            "app",
+			Image:           "crownlabs/generic",
+			EnvironmentType: crownlabsv1alpha2.ClassContainer,
+			Resources: crownlabsv1alpha2.EnvironmentResources{
+				CPU:                   1,
+				ReservedCPUPercentage: 50,
+				Memory:                resource.MustParse("1Gi"),
+			},
+			Env:     []crownlabsv1alpha2.EnvVar{{Name: "COURSE", Value: "networks"}},
+			Command: []string{"/bin/start"},
+			Args:    []string{"--verbose"},
+			ConfigSources: []crownlabsv1alpha2.ConfigSource{
+				{Kind: crownlabsv1alpha2.ConfigSourceConfigMap, Name: "settings", MountPath: "/etc/app"},
+				{Kind: crownlabsv1alpha2.ConfigSourceSecret, Name: "credentials"},
+			},
+		}
+	})
+
+	It("Should name the copies depending on the kind of the source", func() {
+		Expect(configSourceName(name, &environment.ConfigSources[0])).To(Equal(name + "-configmap-settings"))
+		Expect(configSourceName(name, &environment.ConfigSources[1])).To(Equal(name + "-secret-credentials"))
+	})
+
+	It("Should either mount the sources or expose them as environment variables", func() {
+		volumes, mounts, envFrom := buildConfigSources(name, &environment)
+
+		Expect(volumes).To(HaveLen(1))
+		Expect(volumes[0].ConfigMap.Name).To(Equal(name + "-configmap-settings"))
+		Expect(mounts).To(HaveLen(1))
+		Expect(mounts[0].Name).To(Equal(volumes[0].Name))
+		Expect(mounts[0].MountPath).To(Equal("/etc/app"))
+		Expect(mounts[0].ReadOnly).To(BeTrue())
+		Expect(envFrom).To(HaveLen(1))
+		Expect(envFrom[0].SecretRef.Name).To(Equal(name + "-secret-credentials"))
+	})
+
+	It("Should configure the container of the environment", func() {
+		instance := crownlabsv1alpha2.Instance{}
+		spec := buildContainerInstanceDeploymentSpec(name, &instance, &environment, &ContainerEnvOpts{}, 6080, 8080, "/mydrive", "uuid", nil)
+		containers := spec.Template.Spec.Containers
+		container := containers[len(containers)-1]
+
+		Expect(container.Command).To(Equal(environment.Command))
+		Expect(container.Args).To(Equal(environment.Args))
+		Expect(container.Env[0].Name).To(Equal("COURSE"))
+		Expect(container.Env[0].Value).To(Equal("networks"))
+		Expect(container.Env[len(container.Env)-1].Name).To(Equal("CROWNLABS_CPU_LIMITS"))
+		Expect(container.EnvFrom).To(HaveLen(1))
+		Expect(container.VolumeMounts).To(HaveLen(2))
+		Expect(spec.Template.Spec.Volumes).To(HaveLen(2))
+	})
+})
+
+var _ = Describe("Copy of the configuration sources", func() {
+	const (
+		name        = "instance-name"
+		tenantName  = "config.student"
+		workspaceNs = "workspace-config-ws"
+	)
+
+	var (
+		reconciler  InstanceReconciler
+		instance    crownlabsv1alpha2.Instance
+		environment crownlabsv1alpha2.Environment
+		tenant      crownlabsv1alpha1.Tenant
+		objects     []client.Object
+		ctx         = context.Background()
+	)
+
+	BeforeEach(func() {
+		tenant = crownlabsv1alpha1.Tenant{ObjectMeta: metav1.ObjectMeta{
+			Name: tenantName, UID: "tenant-uid",
+			Labels: map[string]string{crownlabsv1alpha1.WorkspaceLabelPrefix + "config-ws": string(crownlabsv1alpha1.User)},
+		}}
+		instance = crownlabsv1alpha2.Instance{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: utils.TenantNamespaceName(tenantName), UID: "instance-uid"},
+			Spec:       crownlabsv1alpha2.InstanceSpec{Template: crownlabsv1alpha2.GenericRef{Name: "template", Namespace: workspaceNs}},
+		}
+		environment = crownlabsv1alpha2.Environment{ConfigSources: []crownlabsv1alpha2.ConfigSource{
+			{Kind: crownlabsv1alpha2.ConfigSourceConfigMap, Name: "settings"},
+		}}
+		objects = []client.Object{
+			&v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "settings", Namespace: workspaceNs}, Data: map[string]string{"key": "value"}},
+		}
+	})
+
+	JustBeforeEach(func() {
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(crownlabsv1alpha1.AddToScheme(scheme)).To(Succeed())
+		Expect(crownlabsv1alpha2.AddToScheme(scheme)).To(Succeed())
+
+		namespace := v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: instance.Namespace}}
+		Expect(ctrl.SetControllerReference(&tenant, &namespace, scheme)).To(Succeed())
+		objects = append(objects, &tenant, &namespace, &instance)
+		reconciler = InstanceReconciler{Scheme: scheme, Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()}
+	})
+
+	It("Should copy the sources if the tenant belongs to the workspace of the template", func() {
+		Expect(reconciler.enforceConfigSources(ctx, &instance, &environment, name)).To(Succeed())
+
+		configMap := v1.ConfigMap{}
+		Expect(reconciler.Get(ctx, types.NamespacedName{Name: name + "-configmap-settings", Namespace: instance.Namespace}, &configMap)).To(Succeed())
+		Expect(configMap.Data).To(HaveKeyWithValue("key", "value"))
+	})
+
+	When("the tenant does not belong to the workspace of the template", func() {
+		BeforeEach(func() { tenant.Labels = nil })
+
+		It("Should not copy the sources", func() {
+			Expect(reconciler.enforceConfigSources(ctx, &instance, &environment, name)).ToNot(Succeed())
+
+			configMap := v1.ConfigMap{}
+			err := reconciler.Get(ctx, types.NamespacedName{Name: name + "-configmap-settings", Namespace: instance.Namespace}, &configMap)
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+		})
+	})
+
+	When("a source has been removed from the template", func() {
+		var stale, unrelated v1.Secret
+
+		BeforeEach(func() {
+			controller := []metav1.OwnerReference{{
+				APIVersion: crownlabsv1alpha2.GroupVersion.String(), Kind: "Instance",
+				Name: instance.Name, UID: instance.UID, Controller: pointer.BoolPtr(true),
+			}}
+			stale = v1.Secret{ObjectMeta: metav1.ObjectMeta{
+				Name: name + "-secret-credentials", Namespace: instance.Namespace, OwnerReferences: controller}}
+			unrelated = v1.Secret{ObjectMeta: metav1.ObjectMeta{
+				Name: name + "-webterminal", Namespace: instance.Namespace, OwnerReferences: controller}}
+			objects = append(objects, &stale, &unrelated)
+		})
+
+		It("Should delete only the stale copy", func() {
+			Expect(reconciler.enforceConfigSources(ctx, &instance, &environment, name)).To(Succeed())
+
+			err := reconciler.Get(ctx, types.NamespacedName{Name: stale.Name, Namespace: stale.Namespace}, &v1.Secret{})
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+			Expect(reconciler.Get(ctx, types.NamespacedName{Name: unrelated.Name, Namespace: unrelated.Namespace}, &v1.Secret{})).To(Succeed())
+		})
+	})
+})
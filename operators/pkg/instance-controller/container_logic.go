@@ -60,6 +60,7 @@ func buildContainerInstanceDeploymentSpec(
 	environment *crownlabsv1alpha2.Environment,
 	o *ContainerEnvOpts, httpPort int32,
 	fileBrowserPort int32, mountPath, urlUUID string,
+	profile *crownlabsv1alpha2.SecurityProfile,
 ) appsv1.DeploymentSpec {
 	userID := int64(1010)
 	yes := true
@@ -81,6 +82,19 @@ func buildContainerInstanceDeploymentSpec(
 		AllowPrivilegeEscalation: &no,
 	}
 
+	configVolumes, configMounts, configEnvFrom := buildConfigSources(name, environment)
+	additionalVolumes, additionalMounts := buildAdditionalVolumes(instance, environment)
+
+	// The variables configured in the environment precede the CrownLabs ones, which hence cannot be overridden.
+	env := make([]v1.EnvVar, 0, len(environment.Env)+2)
+	for i := range environment.Env {
+		env = append(env, v1.EnvVar{Name: environment.Env[i].Name, Value: environment.Env[i].Value})
+	}
+
+	// The settings of the security profile (if any) are applied only to the container of the environment.
+	envSecCtx := *contSecCtx.DeepCopy()
+	applySecurityProfile(&envSecCtx, profile)
+
 	examMode := false // template.ExamMode (?)
 
 	noVncPortName := "http-port"
@@ -131,7 +145,8 @@ func buildContainerInstanceDeploymentSpec(
 		PeriodSeconds:       5,
 	}
 
-	containers := []v1.Container{
+	// The containers providing the remote desktop, deployed only for graphical environments.
+	guiContainers := []v1.Container{
 		{
 			Name:      "novnc",
 			Image:     o.NovncImg + ":" + o.ImagesTag,
@@ -182,6 +197,9 @@ func buildContainerInstanceDeploymentSpec(
 			// LivenessProbe:   &tigerVncProbe,
 			ReadinessProbe: &tigerVncProbe,
 		},
+	}
+
+	containers := []v1.Container{
 		{
 			Name:  "filebrowser",
 			Image: o.FileBrowserImg + ":" + o.FileBrowserImgTag,
@@ -223,7 +241,9 @@ func buildContainerInstanceDeploymentSpec(
 				environment.Resources.ReservedCPUPercentage,
 				environment.Resources.Memory,
 			),
-			Env: []v1.EnvVar{{
+			Command: environment.Command,
+			Args:    environment.Args,
+			Env: append(env, v1.EnvVar{
 				ValueFrom: &v1.EnvVarSource{
 					ResourceFieldRef: &v1.ResourceFieldSelector{
 						ContainerName: name,
@@ -231,7 +251,7 @@ func buildContainerInstanceDeploymentSpec(
 					},
 				},
 				Name: "CROWNLABS_CPU_REQUESTS",
-			}, {
+			}, v1.EnvVar{
 				ValueFrom: &v1.EnvVarSource{
 					ResourceFieldRef: &v1.ResourceFieldSelector{
 						ContainerName: name,
@@ -239,15 +259,21 @@ func buildContainerInstanceDeploymentSpec(
 					},
 				},
 				Name: "CROWNLABS_CPU_LIMITS",
-			}},
-			SecurityContext: &contSecCtx,
-			VolumeMounts: []v1.VolumeMount{{
+			}),
+			EnvFrom:         configEnvFrom,
+			SecurityContext: &envSecCtx,
+			ReadinessProbe:  buildReadinessProbe(environment.ReadinessCheck),
+			VolumeMounts: append([]v1.VolumeMount{{
 				Name:      "shared",
 				MountPath: mountPath, // Same as filebrowser for simplicity
-			}},
+			}}, append(configMounts, additionalMounts...)...),
 		},
 	}
 
+	if environment.GuiEnabled {
+		containers = append(guiContainers, containers...)
+	}
+
 	template := &instance.Spec.Template
 	labels := map[string]string{
 		"name":                         name,
@@ -267,9 +293,9 @@ func buildContainerInstanceDeploymentSpec(
 				Containers:                   containers,
 				SecurityContext:              &podSecCtx,
 				AutomountServiceAccountToken: &no,
-				Volumes: []v1.Volume{
+				Volumes: append([]v1.Volume{
 					buildContainerVolume("shared", name, environment),
-				},
+				}, append(configVolumes, additionalVolumes...)...),
 			},
 		},
 	}
@@ -292,15 +318,35 @@ func buildContainerInstancePVCSpec(
 
 // CreateContainerEnvironment implements the logic to create all the different
 // Kubernetes resources required to start a containerized CrownLabs environment.
+// The rollout action specifies whether the already existing deployment should be updated.
 func (r *InstanceReconciler) CreateContainerEnvironment(
 	instance *crownlabsv1alpha2.Instance,
 	environment *crownlabsv1alpha2.Environment,
+	workspace string,
 	namespace string,
 	name string,
-	vmStart time.Time) error {
+	vmStart time.Time,
+	rollout rolloutAction) error {
 	ctx := context.TODO()
 
-	service, ingress, urlUUID, err := r.CreateInstanceExpositionEnvironment(ctx, instance, name, true)
+	profile, err := r.getSecurityProfile(ctx, environment, workspace)
+	if err != nil {
+		r.setInstanceStatus(ctx, "Could not apply the security profile of instance "+instance.Name+" in namespace "+namespace+": "+err.Error(), "Error", "SecurityProfileNotAllowed", instance, "", "")
+		return err
+	}
+
+	if err := instance_creation.ValidateVolumes(environment); err != nil {
+		klog.Error(err)
+		r.setInstanceStatus(ctx, "Invalid volumes of instance "+instance.Name+" in namespace "+namespace+": "+err.Error(), "Warning", "InvalidVolumes", instance, "", "")
+		return err
+	}
+
+	// Headless containers are exposed at the URL of the instance only in case they declare a web port.
+	webPort := environment.WebPort
+	if environment.GuiEnabled {
+		webPort = instance_creation.RemoteDesktopPort
+	}
+	service, ingress, urlUUID, err := r.CreateInstanceExpositionEnvironment(ctx, instance, name, true, false, webPort, rollout)
 	if err != nil {
 		return err
 	}
@@ -327,6 +373,18 @@ func (r *InstanceReconciler) CreateContainerEnvironment(
 		klog.Infof("PVC for instance %s/%s %s", instance.GetNamespace(), instance.GetName(), res)
 	}
 
+	if err := r.enforceConfigSources(ctx, instance, environment, name); err != nil {
+		klog.Error(err)
+		r.setInstanceStatus(ctx, "Could not copy the configuration of instance "+instance.Name+" in namespace "+namespace+": "+err.Error(), "Error", "ConfigNotCreated", instance, "", "")
+		return err
+	}
+
+	if err := r.enforceAdditionalVolumes(ctx, instance, environment); err != nil {
+		klog.Error(err)
+		r.setInstanceStatus(ctx, "Could not create the volumes of instance "+instance.Name+" in namespace "+namespace+": "+err.Error(), "Error", "VolumesNotCreated", instance, "", "")
+		return err
+	}
+
 	depl := appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      name,
@@ -335,7 +393,10 @@ func (r *InstanceReconciler) CreateContainerEnvironment(
 	}
 
 	if _, err := ctrl.CreateOrUpdate(ctx, r.Client, &depl, func() error {
-		depl.Spec = buildContainerInstanceDeploymentSpec(name, instance, environment, &r.ContainerEnvOpts, 6080, 8080, "/mydrive", urlUUID)
+		// The deployment spec is updated (causing a rolling restart) unless the rollout policy prevents it.
+		if depl.ObjectMeta.CreationTimestamp.IsZero() || rollout != rolloutSkip {
+			depl.Spec = buildContainerInstanceDeploymentSpec(name, instance, environment, &r.ContainerEnvOpts, instance_creation.RemoteDesktopPort, 8080, "/mydrive", urlUUID, profile)
+		}
 		depl.Labels = instance_creation.UpdateLabels(depl.Labels, environment, name)
 		return ctrl.SetControllerReference(instance, &depl, r.Scheme)
 	}); err != nil {
@@ -0,0 +1,143 @@
+package instance_controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	crownlabsv1alpha2 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha2"
+	instance_creation "github.com/netgroup-polito/CrownLabs/operators/pkg/instance-creation"
+)
+
+var _ = Describe("Container deployment", func() {
+	const name = "instance-name"
+
+	DescribeTable("Should deploy the remote desktop sidecars only for graphical environments",
+		func(guiEnabled bool, expected []string) {
+			environment := crownlabsv1alpha2.Environment{
+				Name:            "app",
+				Image:           "crownlabs/generic",
+				EnvironmentType: crownlabsv1alpha2.ClassContainer,
+				GuiEnabled:      guiEnabled,
+				Resources: crownlabsv1alpha2.EnvironmentResources{
+					CPU:                   1,
+					ReservedCPUPercentage: 50,
+					Memory:                resource.MustParse("1Gi"),
+				},
+			}
+
+			spec := buildContainerInstanceDeploymentSpec(name, &crownlabsv1alpha2.Instance{}, &environment,
+				&ContainerEnvOpts{}, 6080, 8080, "/mydrive", "uuid", nil)
+
+			var names []string
+			for i := range spec.Template.Spec.Containers {
+				names = append(names, spec.Template.Spec.Containers[i].Name)
+			}
+			Expect(names).To(Equal(expected))
+		},
+		Entry("Graphical environment", true, []string{"novnc", "websockify", "tigervnc", "filebrowser", name}),
+		Entry("Headless environment", false, []string{"filebrowser", name}),
+	)
+})
+
+var _ = Describe("Container exposition", func() {
+	const (
+		name      = "instance-name"
+		namespace = "tenant-namespace"
+	)
+
+	var (
+		reconciler InstanceReconciler
+		instance   crownlabsv1alpha2.Instance
+		ctx        = context.Background()
+	)
+
+	portNames := func(service *v1.Service) []string {
+		var names []string
+		for i := range service.Spec.Ports {
+			names = append(names, service.Spec.Ports[i].Name)
+		}
+		return names
+	}
+
+	BeforeEach(func() {
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(crownlabsv1alpha2.AddToScheme(scheme)).To(Succeed())
+
+		instance = crownlabsv1alpha2.Instance{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, UID: "instance-uid"}}
+		reconciler = InstanceReconciler{Scheme: scheme, Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(&instance).Build()}
+	})
+
+	It("Should expose the remote desktop of graphical environments", func() {
+		service, ingress, urlUUID, err := reconciler.CreateInstanceExpositionEnvironment(ctx, &instance, name, true, false, instance_creation.RemoteDesktopPort, rolloutApply)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(portNames(&service)).To(ContainElement("vnc"))
+		Expect(ingress.Name).To(Equal(name))
+		Expect(ingress.GetAnnotations()["crownlabs.polito.it/probe-url"]).ToNot(BeEmpty())
+		Expect(urlUUID).To(Equal(ingress.GetAnnotations()["crownlabs.polito.it/url-uuid"]))
+	})
+
+	It("Should expose only SSH and FileBrowser for headless environments", func() {
+		service, ingress, urlUUID, err := reconciler.CreateInstanceExpositionEnvironment(ctx, &instance, name, true, false, 0, rolloutApply)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(portNames(&service)).To(ConsistOf("ssh", "filebrowser"))
+		Expect(ingress.GetAnnotations()["crownlabs.polito.it/probe-url"]).To(BeEmpty())
+		err = reconciler.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, &networkingv1.Ingress{})
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+
+		By("Keeping the url UUID of the FileBrowser ingress across reconciliations")
+		_, _, secondUUID, err := reconciler.CreateInstanceExpositionEnvironment(ctx, &instance, name, true, false, 0, rolloutApply)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(secondUUID).To(Equal(urlUUID))
+	})
+
+	It("Should expose the web port of headless environments, if declared", func() {
+		service, ingress, _, err := reconciler.CreateInstanceExpositionEnvironment(ctx, &instance, name, true, false, 8888, rolloutApply)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(portNames(&service)).To(ConsistOf("web", "ssh", "filebrowser"))
+		Expect(ingress.Spec.Rules[0].HTTP.Paths[0].Backend.Service.Port.Number).To(BeNumerically("==", 8888))
+		Expect(ingress.GetAnnotations()).ToNot(HaveKey("nginx.ingress.kubernetes.io/configuration-snippet"))
+		Expect(ingress.GetAnnotations()["crownlabs.polito.it/probe-url"]).ToNot(BeEmpty())
+	})
+
+	It("Should delete the ingress of the remote desktop once the graphical interface is disabled", func() {
+		_, _, urlUUID, err := reconciler.CreateInstanceExpositionEnvironment(ctx, &instance, name, true, false, instance_creation.RemoteDesktopPort, rolloutApply)
+		Expect(err).ToNot(HaveOccurred())
+		ingressKey := types.NamespacedName{Name: name, Namespace: namespace}
+
+		// The fake client does not set the creation timestamp, which marks the existing resources.
+		var existing v1.Service
+		Expect(reconciler.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, &existing)).To(Succeed())
+		existing.CreationTimestamp = metav1.Now()
+		Expect(reconciler.Update(ctx, &existing)).To(Succeed())
+
+		By("Preserving the ingress until the changes are rolled out")
+		service, _, _, err := reconciler.CreateInstanceExpositionEnvironment(ctx, &instance, name, true, false, 0, rolloutSkip)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(portNames(&service)).To(ContainElement("vnc"))
+		Expect(reconciler.Get(ctx, ingressKey, &networkingv1.Ingress{})).To(Succeed())
+
+		By("Deleting the ingress and the port of the remote desktop")
+		service, _, secondUUID, err := reconciler.CreateInstanceExpositionEnvironment(ctx, &instance, name, true, false, 0, rolloutApply)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(portNames(&service)).To(ConsistOf("ssh", "filebrowser"))
+		err = reconciler.Get(ctx, ingressKey, &networkingv1.Ingress{})
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+		Expect(secondUUID).To(Equal(urlUUID))
+	})
+})
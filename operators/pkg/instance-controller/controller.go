@@ -22,19 +22,25 @@ import (
 	"time"
 
 	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2"
+	virtv1 "kubevirt.io/client-go/api/v1"
 	cdiv1 "kubevirt.io/containerized-data-importer/pkg/apis/core/v1alpha1"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	crownlabsv1alpha2 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha2"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/selectors"
 	"github.com/netgroup-polito/CrownLabs/operators/pkg/utils"
 )
 
@@ -60,11 +66,25 @@ type InstanceReconciler struct {
 	InstancesAuthURL   string
 	Concurrency        int
 	ContainerEnvOpts   ContainerEnvOpts
+	// SSHCAPublicKey is the public key (in the authorized_keys format) of the certificate authority
+	// issuing the SSH certificates of the tenants, trusted by the VMs. It is not configured if empty.
+	SSHCAPublicKey string
+	// WebTerminal is the configuration of the web terminals exposing the VMs without graphical interface.
+	WebTerminal WebTerminalOpts
+	// ImgExporterImg is the image of the img-exporter, flattening the disks of the VMs created from incremental images.
+	ImgExporterImg string
+	// ImgExporterCacheDir is the directory of the nodes the disks of the base images are cached in by the img-exporter (disabled if empty).
+	ImgExporterCacheDir string
+	// RegistrySecretName is the name of the secret granting access to the registry the incremental images are pulled from.
+	RegistrySecretName string
 
 	// This function, if configured, is deferred at the beginning of the Reconcile.
 	// Specifically, it is meant to be set to GinkgoRecover during the tests,
 	// in order to lead to a controlled failure in case the Reconcile panics.
 	ReconcileDeferHook func()
+
+	// nsSelector filters the namespaces whose objects are reconciled, built from NamespaceWhitelist when setting up the controller.
+	nsSelector *selectors.NamespaceSelector
 }
 
 // Reconcile reconciles the state of an Instance resource.
@@ -83,7 +103,7 @@ func (r *InstanceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 	}
 
 	// Check the selector label, in order to know whether to perform or not reconciliation.
-	if proceed, err := utils.CheckSelectorLabel(ctx, r.Client, instance.Namespace, r.NamespaceWhitelist.MatchLabels); !proceed {
+	if proceed, err := r.nsSelector.Matches(ctx, instance.Namespace); !proceed {
 		// If there was an error while checking, show the error and try again.
 		if err != nil {
 			klog.Error(err)
@@ -108,21 +128,58 @@ func (r *InstanceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 	r.EventsRecorder.Event(&instance, "Normal", "TemplateFound", "Template "+templateName.Name+" found in namespace "+template.Namespace)
 
 	labeledInstance := *instance.DeepCopy()
-	labeledInstance.Labels = map[string]string{
-		"crownlabs.polito.it/workspace":  strings.ReplaceAll(template.Spec.WorkspaceRef.Name, ".", "-"),
-		"crownlabs.polito.it/template":   template.Name,
-		"crownlabs.polito.it/managed-by": "instance",
+	// The existing labels are preserved (e.g. the ones identifying the InstanceSet the Instance belongs to).
+	if labeledInstance.Labels == nil {
+		labeledInstance.Labels = make(map[string]string)
 	}
+	labeledInstance.Labels["crownlabs.polito.it/workspace"] = strings.ReplaceAll(template.Spec.WorkspaceRef.Name, ".", "-")
+	labeledInstance.Labels["crownlabs.polito.it/template"] = template.Name
+	labeledInstance.Labels["crownlabs.polito.it/managed-by"] = "instance"
 	if err := r.Patch(ctx, &labeledInstance, client.MergeFrom(&instance)); err != nil {
 		klog.Error("Unable to update Instance labels")
 		klog.Error(err)
 	}
 
-	if _, err := r.generateEnvironments(&template, &instance, VMstart); err != nil {
+	// Check whether the current revision of the template should be applied to the instance
+	revision := templateRevision(&template)
+	rollout := templateRolloutAction(&instance, &template, revision)
+	if rollout == rolloutSkip {
+		klog.Infof("Instance %s/%s not updated to template revision %s, according to the rollout policy", instance.Namespace, instance.Name, revision)
+	}
+
+	// The instances restored from a snapshot are created from the image it pushed, rather than the one of the template.
+	if instance.Spec.SnapshotRef != nil {
+		if err := r.applyInstanceSnapshot(ctx, &instance, &template); err != nil {
+			klog.Error(err)
+			r.EventsRecorder.Event(&instance, "Warning", "SnapshotNotRestored", err.Error())
+			return ctrl.Result{}, err
+		}
+	}
+
+	// The changes of the image of persistent VMs are rejected, and the instance is kept at the previous revision.
+	if rollout != rolloutSkip && instance.Status.TemplateRevision != revision {
+		changed, err := r.persistentImageChanged(ctx, &instance, &template)
+		if err != nil {
+			klog.Error(err)
+			return ctrl.Result{}, err
+		}
+		if changed {
+			klog.Warningf("Instance %s/%s not updated to template revision %s, since the image of a persistent environment changed", instance.Namespace, instance.Name, revision)
+			r.EventsRecorder.Event(&instance, "Warning", "ImageChangeRejected",
+				"The disk of persistent environments is not imported again from the new image, to preserve its data: the instance shall be recreated to use it")
+			rollout = rolloutSkip
+		}
+	}
+
+	if _, err := r.generateEnvironments(&template, &instance, VMstart, rollout); err != nil {
 		klog.Error(err)
 		return ctrl.Result{}, err
 	}
 
+	if rollout != rolloutSkip && instance.Status.TemplateRevision != revision {
+		r.setInstanceTemplateRevision(ctx, &instance, revision)
+	}
+
 	// create secret referenced by VirtualMachineInstance (Cloudinit)
 	// To be extracted in a configuration flag
 	VMElaborationTimestamp := time.Now()
@@ -132,19 +189,22 @@ func (r *InstanceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 	return ctrl.Result{}, nil
 }
 
-func (r *InstanceReconciler) generateEnvironments(template *crownlabsv1alpha2.Template, instance *crownlabsv1alpha2.Instance, vmstart time.Time) (ctrl.Result, error) {
+func (r *InstanceReconciler) generateEnvironments(template *crownlabsv1alpha2.Template, instance *crownlabsv1alpha2.Instance,
+	vmstart time.Time, rollout rolloutAction) (ctrl.Result, error) {
 	namespace := instance.Namespace
 	name := strings.ReplaceAll(instance.Name, ".", "-")
+	// The workspace is derived from the namespace of the template, since the reference in the spec is set by its authors.
+	workspace := utils.NamespaceWorkspaceName(template.Namespace)
 	for i := range template.Spec.EnvironmentList {
 		// prepare variables common to all resources
 		switch template.Spec.EnvironmentList[i].EnvironmentType {
 		case crownlabsv1alpha2.ClassVM:
 
-			if err := r.CreateVMEnvironment(instance, &template.Spec.EnvironmentList[i], namespace, name, vmstart); err != nil {
+			if err := r.CreateVMEnvironment(instance, &template.Spec.EnvironmentList[i], workspace, namespace, name, vmstart, rollout); err != nil {
 				return ctrl.Result{}, err
 			}
 		case crownlabsv1alpha2.ClassContainer:
-			if err := r.CreateContainerEnvironment(instance, &template.Spec.EnvironmentList[i], namespace, name, vmstart); err != nil {
+			if err := r.CreateContainerEnvironment(instance, &template.Spec.EnvironmentList[i], workspace, namespace, name, vmstart, rollout); err != nil {
 				return ctrl.Result{}, err
 			}
 		}
@@ -155,14 +215,29 @@ func (r *InstanceReconciler) generateEnvironments(template *crownlabsv1alpha2.Te
 // SetupWithManager registers a new controller for Instance resources.
 func (r *InstanceReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	klog.Info("setup manager")
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&crownlabsv1alpha2.Instance{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+	// Only the instances in the namespaces satisfying the whitelist are reconciled.
+	nsSelector, err := selectors.NewNamespaceSelector(mgr.GetClient(), &r.NamespaceWhitelist)
+	if err != nil {
+		return err
+	}
+	r.nsSelector = nsSelector
+
+	blder := ctrl.NewControllerManagedBy(mgr).
+		For(&crownlabsv1alpha2.Instance{}, builder.WithPredicates(predicate.GenerationChangedPredicate{}, nsSelector.Predicate())).
 		// Also Deployments are watched in order to better handle container environment.
-		Owns(&appsv1.Deployment{}).
-		Owns(&cdiv1.DataVolume{}, builder.WithPredicates(dataVolumePredicate())).
+		Owns(&appsv1.Deployment{}, builder.WithPredicates(nsSelector.Predicate())).
+		Owns(&cdiv1.DataVolume{}, builder.WithPredicates(dataVolumePredicate(), nsSelector.Predicate())).
+		// Jobs are watched to start the VMs created from incremental images once their disk has been flattened.
+		Owns(&batchv1.Job{}, builder.WithPredicates(nsSelector.Predicate())).
+		// VMIs deletions are watched to recreate them when restarted to apply a new template revision.
+		Owns(&virtv1.VirtualMachineInstance{}, builder.WithPredicates(deletionPredicate(), nsSelector.Predicate())).
+		// Templates are watched to apply the changes immediately, if required by the rollout policy.
+		Watches(&source.Kind{Type: &crownlabsv1alpha2.Template{}}, handler.EnqueueRequestsFromMapFunc(r.templateToInstances),
+			builder.WithPredicates(predicate.GenerationChangedPredicate{})).
 		WithOptions(controller.Options{
 			MaxConcurrentReconciles: r.Concurrency,
-		}).
+		})
+	return nsSelector.Watch(blder, func() client.ObjectList { return &crownlabsv1alpha2.InstanceList{} }).
 		Complete(r)
 }
 
@@ -173,6 +248,14 @@ func dataVolumePredicate() predicate.Predicate {
 	})
 }
 
+func deletionPredicate() predicate.Predicate {
+	return predicate.Funcs{
+		CreateFunc:  func(event.CreateEvent) bool { return false },
+		UpdateFunc:  func(event.UpdateEvent) bool { return false },
+		GenericFunc: func(event.GenericEvent) bool { return false },
+	}
+}
+
 func (r *InstanceReconciler) setInstanceStatus(
 	ctx context.Context,
 	msg string, eventType string, eventReason string,
@@ -0,0 +1,77 @@
+package instance_controller
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	instance_creation "github.com/netgroup-polito/CrownLabs/operators/pkg/instance-creation"
+)
+
+// DatasetVolumeReconciler garbage collects the PVs mirroring the ones of the datasets in the namespaces of the instances,
+// once released (i.e. the PVC they were bound to has been deleted together with the last instance using it).
+// Since such PVs have the Retain reclaim policy, deleting them does not affect the storage of the dataset.
+type DatasetVolumeReconciler struct {
+	client.Client
+	// APIReader is used to check whether the claim of a PV still exists bypassing the cache,
+	// to avoid deleting the PVs just bound to a recreated claim not yet observed by the cache.
+	APIReader client.Reader
+
+	// This function, if configured, is deferred at the beginning of the Reconcile.
+	// Specifically, it is meant to be set to GinkgoRecover during the tests,
+	// in order to lead to a controlled failure in case the Reconcile panics.
+	ReconcileDeferHook func()
+}
+
+// Reconcile deletes the given mirrored PV, in case it has been released.
+func (r *DatasetVolumeReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	if r.ReconcileDeferHook != nil {
+		defer r.ReconcileDeferHook()
+	}
+
+	var pv v1.PersistentVolume
+	if err := r.Get(ctx, req.NamespacedName, &pv); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if pv.Labels[instance_creation.DatasetVolumeLabel] != "true" || pv.Status.Phase != v1.VolumeReleased || !pv.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+
+	// The claim may have been recreated in the meanwhile, and the PV bound to it again.
+	if claimRef := pv.Spec.ClaimRef; claimRef != nil {
+		var claim v1.PersistentVolumeClaim
+		err := r.APIReader.Get(ctx, types.NamespacedName{Namespace: claimRef.Namespace, Name: claimRef.Name}, &claim)
+		if client.IgnoreNotFound(err) != nil {
+			return ctrl.Result{}, fmt.Errorf("error when retrieving the claim of PV %s -> %w", pv.Name, err)
+		}
+		if err == nil && claim.UID == claimRef.UID {
+			return ctrl.Result{}, nil
+		}
+	}
+
+	if err := r.Delete(ctx, &pv); client.IgnoreNotFound(err) != nil {
+		klog.Errorf("Unable to delete the released dataset PV %s -> %v", pv.Name, err)
+		return ctrl.Result{}, err
+	}
+	klog.Infof("Released dataset PV %s deleted", pv.Name)
+	return ctrl.Result{}, nil
+}
+
+// SetupWithManager registers a new controller for the PVs mirroring the ones of the datasets.
+func (r *DatasetVolumeReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	isDatasetVolume := predicate.NewPredicateFuncs(func(object client.Object) bool {
+		return object.GetLabels()[instance_creation.DatasetVolumeLabel] == "true"
+	})
+
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("dataset-volume").
+		For(&v1.PersistentVolume{}, builder.WithPredicates(isDatasetVolume)).
+		Complete(r)
+}
@@ -0,0 +1,125 @@
+package instance_controller
+
+import (
+	"context"
+	"fmt"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	crownlabsv1alpha2 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha2"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/utils"
+)
+
+// enforceFlattenedDisk creates the job flattening the chain of overlays of an incremental image into the (blank)
+// volume of the persistent VM, and returns whether it completed successfully. The job is owned by the instance,
+// and it is not recreated once completed, since the volume is preserved for the whole lifetime of the instance.
+func (r *InstanceReconciler) enforceFlattenedDisk(ctx context.Context, instance *crownlabsv1alpha2.Instance,
+	environment *crownlabsv1alpha2.Environment, name string) (bool, error) {
+	job := batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: flattenJobName(name), Namespace: instance.Namespace}}
+
+	op, err := ctrl.CreateOrUpdate(ctx, r.Client, &job, func() error {
+		// Job's spec is immutable, it has to be set at creation
+		if job.ObjectMeta.CreationTimestamp.IsZero() {
+			job.Spec = r.flattenJobSpec(environment, name)
+		}
+		return ctrl.SetControllerReference(instance, &job, r.Scheme)
+	})
+	if err != nil {
+		return false, fmt.Errorf("error when creating the job flattening the disk of instance %s/%s -> %w", instance.Namespace, instance.Name, err)
+	}
+	klog.Infof("Flattening job for instance %s/%s %s", instance.GetNamespace(), instance.GetName(), op)
+
+	for _, c := range job.Status.Conditions {
+		if c.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch c.Type {
+		case batchv1.JobComplete:
+			return true, nil
+		case batchv1.JobFailed:
+			return false, fmt.Errorf("the job flattening the disk of instance %s/%s failed: %s", instance.Namespace, instance.Name, c.Message)
+		}
+	}
+	return false, nil
+}
+
+// flattenJobSpec returns the specification of the job flattening the chain of overlays of the image
+// of the environment into the disk of the given volume, through the img-exporter.
+func (r *InstanceReconciler) flattenJobSpec(environment *crownlabsv1alpha2.Environment, volumeName string) batchv1.JobSpec {
+	var backoff int32 = 2
+
+	flattener := corev1.Container{
+		Name:    "disk-flattener",
+		Image:   r.ImgExporterImg,
+		Command: []string{"/exporter.sh"},
+		Args:    []string{"--mode", "flatten", "--base-image", environment.Image},
+		VolumeMounts: []corev1.VolumeMount{
+			{Name: "disk-vol", MountPath: "/data"},
+			{Name: "tmp-vol", MountPath: "/img-tmp"},
+		},
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{
+				"cpu":    resource.MustParse("1"),
+				"memory": resource.MustParse("128Mi"),
+			},
+			Limits: corev1.ResourceList{
+				"cpu":    resource.MustParse("1"),
+				"memory": resource.MustParse("256Mi"),
+			},
+		},
+		TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+	}
+	volumes := []corev1.Volume{
+		{
+			Name: "disk-vol",
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: volumeName},
+			},
+		},
+		{
+			Name:         "tmp-vol",
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		},
+	}
+
+	// The credentials are the same used by the snapshotting jobs to push the images to the registry.
+	if r.RegistrySecretName != "" {
+		dockerConfig := corev1.EnvVar{Name: "DOCKER_CONFIG", Value: "/docker-config"}
+		flattener.Env = append(flattener.Env, dockerConfig)
+		flattener.VolumeMounts = append(flattener.VolumeMounts,
+			corev1.VolumeMount{Name: "registry-credentials", MountPath: dockerConfig.Value, ReadOnly: true})
+		volumes = append(volumes, corev1.Volume{
+			Name: "registry-credentials",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: r.RegistrySecretName,
+					Items:      []corev1.KeyToPath{{Key: corev1.DockerConfigJsonKey, Path: "config.json"}},
+				},
+			},
+		})
+	}
+	if cache := utils.ConfigureImgExporterCache(&flattener, r.ImgExporterCacheDir); cache != nil {
+		volumes = append(volumes, *cache)
+	}
+
+	return batchv1.JobSpec{
+		BackoffLimit: &backoff,
+		Template: corev1.PodTemplateSpec{
+			Spec: corev1.PodSpec{
+				Containers:    []corev1.Container{flattener},
+				Volumes:       volumes,
+				RestartPolicy: corev1.RestartPolicyOnFailure,
+			},
+		},
+	}
+}
+
+// flattenJobName returns the name of the job flattening the disk of the given persistent VM.
+func flattenJobName(name string) string {
+	return name + "-flatten"
+}
@@ -0,0 +1,117 @@
+package instance_controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	crownlabsv1alpha2 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha2"
+)
+
+var _ = Describe("Flattening the disks of incremental images", func() {
+	const (
+		namespace     = "tenant-john-doe"
+		name          = "instance"
+		image         = "registry.example.com/john-doe/snapshot:20210101t000000"
+		exporterImage = "crownlabs/img-exporter"
+		secretName    = "registry-secret"
+	)
+
+	var (
+		ctx         context.Context
+		reconciler  InstanceReconciler
+		instance    crownlabsv1alpha2.Instance
+		environment crownlabsv1alpha2.Environment
+		jobKey      = types.NamespacedName{Name: flattenJobName(name), Namespace: namespace}
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(crownlabsv1alpha2.AddToScheme(scheme)).To(Succeed())
+
+		instance = crownlabsv1alpha2.Instance{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, UID: "instance-uid"}}
+		environment = crownlabsv1alpha2.Environment{
+			Name: "vm", Image: image, EnvironmentType: crownlabsv1alpha2.ClassVM, Persistent: true, IncrementalImage: true,
+		}
+		reconciler = InstanceReconciler{
+			Client:             fake.NewClientBuilder().WithScheme(scheme).Build(),
+			Scheme:             scheme,
+			ImgExporterImg:     exporterImage,
+			RegistrySecretName: secretName,
+		}
+	})
+
+	It("Should flatten the image into the volume of the VM", func() {
+		flattened, err := reconciler.enforceFlattenedDisk(ctx, &instance, &environment, name)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(flattened).To(BeFalse())
+
+		var job batchv1.Job
+		Expect(reconciler.Get(ctx, jobKey, &job)).To(Succeed())
+		Expect(job.OwnerReferences).To(HaveLen(1))
+		Expect(job.OwnerReferences[0].UID).To(Equal(instance.UID))
+
+		pod := job.Spec.Template.Spec
+		Expect(pod.Containers).To(HaveLen(1))
+		Expect(pod.Containers[0].Image).To(Equal(exporterImage))
+		Expect(pod.Containers[0].Args).To(Equal([]string{"--mode", "flatten", "--base-image", image}))
+		Expect(pod.Containers[0].VolumeMounts).To(ContainElement(corev1.VolumeMount{Name: "disk-vol", MountPath: "/data"}))
+		Expect(pod.Volumes[0].PersistentVolumeClaim.ClaimName).To(Equal(name))
+		Expect(pod.Volumes).To(HaveLen(3))
+		Expect(pod.Volumes[2].Secret.SecretName).To(Equal(secretName))
+	})
+
+	It("Should cache the disks of the base image, if configured", func() {
+		reconciler.ImgExporterCacheDir = "/var/cache/img-exporter"
+		spec := reconciler.flattenJobSpec(&environment, name)
+
+		pod := spec.Template.Spec
+		Expect(pod.Containers[0].Args).To(Equal([]string{"--mode", "flatten", "--base-image", image, "--cache-dir", "/img-cache"}))
+		Expect(pod.Containers[0].VolumeMounts).To(ContainElement(corev1.VolumeMount{Name: "img-cache", MountPath: "/img-cache"}))
+		Expect(pod.Volumes).To(HaveLen(4))
+		Expect(pod.Volumes[3].HostPath.Path).To(Equal(reconciler.ImgExporterCacheDir))
+	})
+
+	It("Should not mount the registry credentials, if not configured", func() {
+		reconciler.RegistrySecretName = ""
+		spec := reconciler.flattenJobSpec(&environment, name)
+
+		Expect(spec.Template.Spec.Volumes).To(HaveLen(2))
+		Expect(spec.Template.Spec.Containers[0].Env).To(BeEmpty())
+	})
+
+	It("Should report the completion of the job", func() {
+		_, err := reconciler.enforceFlattenedDisk(ctx, &instance, &environment, name)
+		Expect(err).ToNot(HaveOccurred())
+
+		var job batchv1.Job
+		Expect(reconciler.Get(ctx, jobKey, &job)).To(Succeed())
+		job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+		Expect(reconciler.Status().Update(ctx, &job)).To(Succeed())
+
+		Expect(reconciler.enforceFlattenedDisk(ctx, &instance, &environment, name)).To(BeTrue())
+	})
+
+	It("Should fail if the job failed", func() {
+		_, err := reconciler.enforceFlattenedDisk(ctx, &instance, &environment, name)
+		Expect(err).ToNot(HaveOccurred())
+
+		var job batchv1.Job
+		Expect(reconciler.Get(ctx, jobKey, &job)).To(Succeed())
+		job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue}}
+		Expect(reconciler.Status().Update(ctx, &job)).To(Succeed())
+
+		_, err = reconciler.enforceFlattenedDisk(ctx, &instance, &environment, name)
+		Expect(err).To(HaveOccurred())
+	})
+})
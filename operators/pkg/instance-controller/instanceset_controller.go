@@ -0,0 +1,361 @@
+package instance_controller
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrlUtil "sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	crownlabsv1alpha2 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha2"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/selectors"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/utils"
+)
+
+// InstanceSetReconciler reconciles an InstanceSet object, creating an Instance of the referenced
+// Template for each Tenant subscribed to the Workspace the Template belongs to.
+type InstanceSetReconciler struct {
+	client.Client
+	Scheme             *runtime.Scheme
+	EventsRecorder     record.EventRecorder
+	NamespaceWhitelist metav1.LabelSelector
+
+	// This function, if configured, is deferred at the beginning of the Reconcile.
+	// Specifically, it is meant to be set to GinkgoRecover during the tests,
+	// in order to lead to a controlled failure in case the Reconcile panics.
+	ReconcileDeferHook func()
+
+	// nsSelector matches the namespaces whose instance sets are reconciled (see NamespaceWhitelist).
+	nsSelector *selectors.NamespaceSelector
+}
+
+// Reconcile reconciles the state of an InstanceSet resource.
+func (r *InstanceSetReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	if r.ReconcileDeferHook != nil {
+		defer r.ReconcileDeferHook()
+	}
+
+	var set crownlabsv1alpha2.InstanceSet
+	if err := r.Get(ctx, req.NamespacedName, &set); err != nil {
+		// reconcile was triggered by a delete request
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if !set.DeletionTimestamp.IsZero() {
+		klog.Infof("Processing deletion of instance set %s/%s", set.Namespace, set.Name)
+		if ctrlUtil.ContainsFinalizer(&set, crownlabsv1alpha2.InstOperatorFinalizerName) {
+			// the instances cannot be owned by the set, as they belong to different namespaces
+			if err := r.deleteSetInstances(ctx, &set, nil); err != nil {
+				return ctrl.Result{}, err
+			}
+			ctrlUtil.RemoveFinalizer(&set, crownlabsv1alpha2.InstOperatorFinalizerName)
+			if err := r.Update(ctx, &set); err != nil {
+				klog.Errorf("Error when removing finalizer from instance set %s/%s -> %s", set.Namespace, set.Name, err)
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// Check the selector label, in order to know whether to perform or not reconciliation.
+	if proceed, err := r.nsSelector.Matches(ctx, set.Namespace); !proceed {
+		// If there was an error while checking, show the error and try again.
+		if err != nil {
+			klog.Error(err)
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	if !ctrlUtil.ContainsFinalizer(&set, crownlabsv1alpha2.InstOperatorFinalizerName) {
+		ctrlUtil.AddFinalizer(&set, crownlabsv1alpha2.InstOperatorFinalizerName)
+		if err := r.Update(ctx, &set); err != nil {
+			klog.Errorf("Error when adding finalizer to instance set %s/%s -> %s", set.Namespace, set.Name, err)
+			return ctrl.Result{}, err
+		}
+	}
+
+	// check if the Template exists
+	templateName := types.NamespacedName{Namespace: set.Spec.Template.Namespace, Name: set.Spec.Template.Name}
+	if templateName.Namespace == "" {
+		templateName.Namespace = set.Namespace
+	}
+	var template crownlabsv1alpha2.Template
+	if err := r.Get(ctx, templateName, &template); err != nil {
+		klog.Errorf("Error when retrieving template %s for instance set %s/%s -> %s", templateName, set.Namespace, set.Name, err)
+		r.EventsRecorder.Event(&set, "Warning", "TemplateNotFound", "Template "+templateName.Name+" not found in namespace "+templateName.Namespace)
+		return ctrl.Result{}, err
+	}
+	wsName := template.Spec.WorkspaceRef.Name
+
+	// InstanceSets can be created only by the managers of the workspace (i.e. in the namespace of the workspace),
+	// hence sets living elsewhere are rejected, to prevent tenants from provisioning instances for other tenants.
+	if !isInstanceSetAllowed(&set, &template) {
+		klog.Infof("Instance set %s/%s rejected: it does not belong to the namespace of workspace %s", set.Namespace, set.Name, wsName)
+		r.EventsRecorder.Event(&set, "Warning", "InstanceSetRejected", "The set must belong to the namespace of the workspace of the template")
+		return ctrl.Result{}, r.deleteSetInstances(ctx, &set, nil)
+	}
+
+	var tenants crownlabsv1alpha1.TenantList
+	if err := r.List(ctx, &tenants, client.HasLabels{crownlabsv1alpha1.WorkspaceLabelPrefix + wsName}); err != nil {
+		klog.Errorf("Error when listing tenants of workspace %s -> %s", wsName, err)
+		return ctrl.Result{}, err
+	}
+
+	// create or update the instances of the target tenants using a fail-fast:false strategy
+	var retErr error
+	created := make(map[types.UID]bool)
+	targetNamespaces := make(map[string]bool)
+	for i := range tenants.Items {
+		tenant := &tenants.Items[i]
+		if !isInstanceSetTarget(&set, tenant, wsName) {
+			continue
+		}
+		targetNamespaces[utils.TenantNamespaceName(tenant.Name)] = true
+		if err := r.enforceSetInstance(ctx, &set, &template, tenant, created); err != nil {
+			klog.Errorf("Error when enforcing instance of set %s/%s for tenant %s -> %s", set.Namespace, set.Name, tenant.Name, err)
+			r.EventsRecorder.Event(&set, "Warning", "InstanceNotCreated", "Failed to create the instance for tenant "+tenant.Name)
+			retErr = err
+		}
+	}
+
+	// delete the instances of the tenants no longer targeted (e.g. unsubscribed from the workspace)
+	if err := r.deleteSetInstances(ctx, &set, targetNamespaces); err != nil {
+		retErr = err
+	}
+
+	if err := r.updateSetStatus(ctx, &set, wsName, created); err != nil {
+		retErr = err
+	}
+	return ctrl.Result{}, retErr
+}
+
+// enforceSetInstance creates or updates the Instance of the set associated with the given Tenant.
+// The UID of the Instance, if created, is immediately recorded in the status of the set and added to created.
+func (r *InstanceSetReconciler) enforceSetInstance(ctx context.Context, set *crownlabsv1alpha2.InstanceSet,
+	template *crownlabsv1alpha2.Template, tenant *crownlabsv1alpha1.Tenant, created map[types.UID]bool) error {
+	instance := crownlabsv1alpha2.Instance{ObjectMeta: metav1.ObjectMeta{Name: set.Name, Namespace: utils.TenantNamespaceName(tenant.Name)}}
+
+	op, err := ctrl.CreateOrUpdate(ctx, r.Client, &instance, func() error {
+		if !instance.CreationTimestamp.IsZero() && !belongsToInstanceSet(&instance, set) {
+			return fmt.Errorf("instance %s/%s already exists and does not belong to the set", instance.Namespace, instance.Name)
+		}
+		if instance.Labels == nil {
+			instance.Labels = make(map[string]string)
+		}
+		instance.Labels[crownlabsv1alpha2.InstanceSetNameLabel] = set.Name
+		instance.Labels[crownlabsv1alpha2.InstanceSetNamespaceLabel] = set.Namespace
+
+		instance.Spec.Template = crownlabsv1alpha2.GenericRef{Name: template.Name, Namespace: template.Namespace}
+		instance.Spec.Tenant = crownlabsv1alpha2.GenericRef{Name: tenant.Name}
+		instance.Spec.Running = set.Spec.Running
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	klog.Infof("Instance %s/%s of set %s/%s %s", instance.Namespace, instance.Name, set.Namespace, set.Name, op)
+
+	if op == ctrlUtil.OperationResultCreated {
+		// Otherwise, the instance would no longer be recognized as belonging to the set.
+		created[instance.UID] = true
+		set.Status.InstanceUIDs = append(set.Status.InstanceUIDs, instance.UID)
+		if err := r.Status().Update(ctx, set); err != nil {
+			klog.Errorf("Error when recording instance %s/%s in the status of set %s/%s -> %s", instance.Namespace, instance.Name, set.Namespace, set.Name, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteSetInstances deletes the Instances of the set not belonging to the given namespaces (all of them, if nil),
+// using a fail-fast:false strategy.
+func (r *InstanceSetReconciler) deleteSetInstances(ctx context.Context, set *crownlabsv1alpha2.InstanceSet, keepNamespaces map[string]bool) error {
+	var instances crownlabsv1alpha2.InstanceList
+	if err := r.List(ctx, &instances, instanceSetLabels(set)); err != nil {
+		klog.Errorf("Error when listing instances of set %s/%s -> %s", set.Namespace, set.Name, err)
+		return err
+	}
+
+	var retErr error
+	for i := range instances.Items {
+		instance := &instances.Items[i]
+		if keepNamespaces[instance.Namespace] {
+			continue
+		}
+		if !belongsToInstanceSet(instance, set) {
+			klog.Warningf("Instance %s/%s refers to set %s/%s, but it was not created by it", instance.Namespace, instance.Name, set.Namespace, set.Name)
+			continue
+		}
+		if err := r.Delete(ctx, instance); client.IgnoreNotFound(err) != nil {
+			klog.Errorf("Error when deleting instance %s/%s of set %s/%s -> %s", instance.Namespace, instance.Name, set.Namespace, set.Name, err)
+			retErr = err
+			continue
+		}
+		klog.Infof("Instance %s/%s of set %s/%s deleted", instance.Namespace, instance.Name, set.Namespace, set.Name)
+	}
+	return retErr
+}
+
+// updateSetStatus updates the status of the set, aggregating the readiness of the associated Instances. The UIDs of the
+// Instances no longer existing are removed, except for the ones just created, which might not be yet in the cache.
+func (r *InstanceSetReconciler) updateSetStatus(ctx context.Context, set *crownlabsv1alpha2.InstanceSet, wsName string, created map[types.UID]bool) error {
+	var instances crownlabsv1alpha2.InstanceList
+	if err := r.List(ctx, &instances, instanceSetLabels(set)); err != nil {
+		klog.Errorf("Error when listing instances of set %s/%s -> %s", set.Namespace, set.Name, err)
+		return err
+	}
+
+	status := crownlabsv1alpha2.InstanceSetStatus{Workspace: wsName}
+	existing := make(map[types.UID]bool)
+	for i := range instances.Items {
+		if !belongsToInstanceSet(&instances.Items[i], set) {
+			continue
+		}
+		existing[instances.Items[i].UID] = true
+		if !instances.Items[i].DeletionTimestamp.IsZero() {
+			continue
+		}
+		status.Instances++
+		if instances.Items[i].Status.Phase == "VmiReady" {
+			status.ReadyInstances++
+		}
+	}
+	for _, uid := range set.Status.InstanceUIDs {
+		if existing[uid] || created[uid] {
+			status.InstanceUIDs = append(status.InstanceUIDs, uid)
+		}
+	}
+
+	if equality.Semantic.DeepEqual(status, set.Status) {
+		return nil
+	}
+	set.Status = status
+	if err := r.Status().Update(ctx, set); err != nil {
+		klog.Errorf("Error when updating the status of instance set %s/%s -> %s", set.Namespace, set.Name, err)
+		return err
+	}
+	return nil
+}
+
+// tenantToInstanceSets returns the requests for the InstanceSets targeting the Workspaces the given Tenant is subscribed to,
+// as well as for the ones the Instances in the namespace of the Tenant belong to. The latter are required to delete the
+// Instances when the Tenant unsubscribes from the Workspace, since the new labels no longer refer to it.
+func (r *InstanceSetReconciler) tenantToInstanceSets(object client.Object) []reconcile.Request {
+	ctx := context.Background()
+
+	var sets crownlabsv1alpha2.InstanceSetList
+	if err := r.List(ctx, &sets); err != nil {
+		klog.Errorf("Error when listing instance sets -> %s", err)
+		return nil
+	}
+
+	var instances crownlabsv1alpha2.InstanceList
+	if err := r.List(ctx, &instances, client.InNamespace(utils.TenantNamespaceName(object.GetName())),
+		client.HasLabels{crownlabsv1alpha2.InstanceSetNameLabel, crownlabsv1alpha2.InstanceSetNamespaceLabel}); err != nil {
+		klog.Errorf("Error when listing the instance set instances of tenant %s -> %s", object.GetName(), err)
+		return nil
+	}
+
+	requested := make(map[types.NamespacedName]bool)
+	for i := range sets.Items {
+		if _, ok := object.GetLabels()[crownlabsv1alpha1.WorkspaceLabelPrefix+sets.Items[i].Status.Workspace]; ok {
+			requested[types.NamespacedName{Name: sets.Items[i].Name, Namespace: sets.Items[i].Namespace}] = true
+		}
+	}
+	for i := range instances.Items {
+		for _, request := range instanceToInstanceSet(&instances.Items[i]) {
+			requested[request.NamespacedName] = true
+		}
+	}
+
+	requests := make([]reconcile.Request, 0, len(requested))
+	for name := range requested {
+		requests = append(requests, reconcile.Request{NamespacedName: name})
+	}
+	return requests
+}
+
+// instanceToInstanceSet returns the request for the InstanceSet the given Instance belongs to, if any.
+func instanceToInstanceSet(object client.Object) []reconcile.Request {
+	name, nameOk := object.GetLabels()[crownlabsv1alpha2.InstanceSetNameLabel]
+	namespace, namespaceOk := object.GetLabels()[crownlabsv1alpha2.InstanceSetNamespaceLabel]
+	if !nameOk || !namespaceOk {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: name, Namespace: namespace}}}
+}
+
+// isInstanceSetTarget returns whether the given Tenant, subscribed to the given Workspace, should be assigned an Instance of the set.
+func isInstanceSetTarget(set *crownlabsv1alpha2.InstanceSet, tenant *crownlabsv1alpha1.Tenant, wsName string) bool {
+	switch crownlabsv1alpha1.WorkspaceUserRole(tenant.Labels[crownlabsv1alpha1.WorkspaceLabelPrefix+wsName]) {
+	case crownlabsv1alpha1.User:
+		return true
+	case crownlabsv1alpha1.Manager:
+		return set.Spec.IncludeManagers
+	default:
+		return false
+	}
+}
+
+// isInstanceSetAllowed returns whether the given InstanceSet belongs to the namespace of the Workspace of the given Template,
+// which can be written only by the managers of that Workspace.
+func isInstanceSetAllowed(set *crownlabsv1alpha2.InstanceSet, template *crownlabsv1alpha2.Template) bool {
+	return set.Namespace == template.Namespace && set.Namespace == utils.WorkspaceNamespaceName(template.Spec.WorkspaceRef.Name)
+}
+
+// belongsToInstanceSet returns whether the given Instance has been created by the given InstanceSet. The labels are not
+// sufficient, as they can be forged by the Tenants, hence the UID shall also be recorded in the status of the set.
+func belongsToInstanceSet(instance *crownlabsv1alpha2.Instance, set *crownlabsv1alpha2.InstanceSet) bool {
+	if instance.Labels[crownlabsv1alpha2.InstanceSetNameLabel] != set.Name ||
+		instance.Labels[crownlabsv1alpha2.InstanceSetNamespaceLabel] != set.Namespace {
+		return false
+	}
+	for _, uid := range set.Status.InstanceUIDs {
+		if uid == instance.UID {
+			return true
+		}
+	}
+	return false
+}
+
+// instanceSetLabels returns the labels identifying the Instances belonging to the given InstanceSet.
+func instanceSetLabels(set *crownlabsv1alpha2.InstanceSet) client.MatchingLabels {
+	return client.MatchingLabels{
+		crownlabsv1alpha2.InstanceSetNameLabel:      set.Name,
+		crownlabsv1alpha2.InstanceSetNamespaceLabel: set.Namespace,
+	}
+}
+
+// SetupWithManager registers a new controller for InstanceSet resources.
+func (r *InstanceSetReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	// Only the sets in the namespaces satisfying the whitelist are reconciled.
+	nsSelector, err := selectors.NewNamespaceSelector(mgr.GetClient(), &r.NamespaceWhitelist)
+	if err != nil {
+		return err
+	}
+	r.nsSelector = nsSelector
+
+	blder := ctrl.NewControllerManagedBy(mgr).
+		For(&crownlabsv1alpha2.InstanceSet{}, builder.WithPredicates(predicate.GenerationChangedPredicate{}, nsSelector.Predicate())).
+		// Tenants are watched to create (or delete) the instances when they subscribe to (or unsubscribe from) the workspace.
+		Watches(&source.Kind{Type: &crownlabsv1alpha1.Tenant{}}, handler.EnqueueRequestsFromMapFunc(r.tenantToInstanceSets),
+			builder.WithPredicates(predicate.LabelChangedPredicate{})).
+		// Instances are watched to keep track of their readiness.
+		Watches(&source.Kind{Type: &crownlabsv1alpha2.Instance{}}, handler.EnqueueRequestsFromMapFunc(instanceToInstanceSet))
+	return nsSelector.Watch(blder, func() client.ObjectList { return &crownlabsv1alpha2.InstanceSetList{} }).
+		Complete(r)
+}
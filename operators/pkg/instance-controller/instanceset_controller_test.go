@@ -0,0 +1,231 @@
+package instance_controller
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	crownlabsv1alpha2 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha2"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/utils"
+)
+
+var _ = Describe("Instance sets", func() {
+
+	const (
+		SetName           = "lab-set"
+		WorkspaceName     = "set-ws"
+		WorkspaceNs       = "workspace-set-ws"
+		TemplateName      = "set-template"
+		StudentName       = "set.student"
+		LateStudentName   = "set.late-student"
+		TeacherName       = "set.teacher"
+		OtherStudentName  = "set.other-student"
+		WorkspaceLabelKey = crownlabsv1alpha1.WorkspaceLabelPrefix + WorkspaceName
+
+		timeout  = time.Second * 20
+		interval = time.Millisecond * 500
+	)
+
+	var ctx context.Context
+
+	forgeNamespace := func(name string) *v1.Namespace {
+		return &v1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   name,
+				Labels: map[string]string{"production": "true", "test-suite": "true"},
+			},
+		}
+	}
+
+	forgeTenant := func(name string, labels map[string]string) *crownlabsv1alpha1.Tenant {
+		return &crownlabsv1alpha1.Tenant{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+			Spec: crownlabsv1alpha1.TenantSpec{
+				FirstName: "Mario",
+				LastName:  "Rossi",
+				Email:     "mario@rossi.com",
+			},
+		}
+	}
+
+	setInstanceKey := func(tenantName string) types.NamespacedName {
+		return types.NamespacedName{Name: SetName, Namespace: utils.TenantNamespaceName(tenantName)}
+	}
+
+	It("Setting up the workspace, the tenants and their namespaces", func() {
+		ctx = context.Background()
+		for _, ns := range []string{WorkspaceNs, utils.TenantNamespaceName(StudentName), utils.TenantNamespaceName(LateStudentName),
+			utils.TenantNamespaceName(TeacherName), utils.TenantNamespaceName(OtherStudentName)} {
+			Expect(k8sClient.Create(ctx, forgeNamespace(ns))).Should(Succeed())
+		}
+
+		Expect(k8sClient.Create(ctx, &crownlabsv1alpha2.Template{
+			ObjectMeta: metav1.ObjectMeta{Name: TemplateName, Namespace: WorkspaceNs},
+			Spec: crownlabsv1alpha2.TemplateSpec{
+				WorkspaceRef:    crownlabsv1alpha2.GenericRef{Name: WorkspaceName},
+				PrettyName:      "Lab Template",
+				Description:     "A description",
+				EnvironmentList: []crownlabsv1alpha2.Environment{},
+			},
+		})).Should(Succeed())
+
+		Expect(k8sClient.Create(ctx, forgeTenant(StudentName, map[string]string{WorkspaceLabelKey: string(crownlabsv1alpha1.User)}))).Should(Succeed())
+		Expect(k8sClient.Create(ctx, forgeTenant(TeacherName, map[string]string{WorkspaceLabelKey: string(crownlabsv1alpha1.Manager)}))).Should(Succeed())
+		Expect(k8sClient.Create(ctx, forgeTenant(OtherStudentName, map[string]string{
+			crownlabsv1alpha1.WorkspaceLabelPrefix + "other": string(crownlabsv1alpha1.User)}))).Should(Succeed())
+	})
+
+	It("Should create an instance for each user of the workspace", func() {
+		Expect(k8sClient.Create(ctx, &crownlabsv1alpha2.InstanceSet{
+			ObjectMeta: metav1.ObjectMeta{Name: SetName, Namespace: WorkspaceNs},
+			Spec: crownlabsv1alpha2.InstanceSetSpec{
+				Template: crownlabsv1alpha2.GenericRef{Name: TemplateName, Namespace: WorkspaceNs},
+				Running:  true,
+			},
+		})).Should(Succeed())
+
+		var instance crownlabsv1alpha2.Instance
+		doesEventuallyExist(ctx, setInstanceKey(StudentName), &instance, BeTrue(), timeout, interval)
+		Expect(instance.Spec.Template).Should(Equal(crownlabsv1alpha2.GenericRef{Name: TemplateName, Namespace: WorkspaceNs}))
+		Expect(instance.Spec.Tenant.Name).Should(Equal(StudentName))
+		Expect(instance.Labels).Should(HaveKeyWithValue(crownlabsv1alpha2.InstanceSetNameLabel, SetName))
+		Expect(instance.Labels).Should(HaveKeyWithValue(crownlabsv1alpha2.InstanceSetNamespaceLabel, WorkspaceNs))
+
+		By("Not creating the instances of the managers and of the tenants of other workspaces")
+		Consistently(func() bool {
+			errTeacher := k8sClient.Get(ctx, setInstanceKey(TeacherName), &crownlabsv1alpha2.Instance{})
+			errOther := k8sClient.Get(ctx, setInstanceKey(OtherStudentName), &crownlabsv1alpha2.Instance{})
+			return errTeacher == nil || errOther == nil
+		}, time.Second*2, interval).Should(BeFalse())
+
+		By("Tracking the instances in the status")
+		Eventually(func() crownlabsv1alpha2.InstanceSetStatus {
+			var set crownlabsv1alpha2.InstanceSet
+			_ = k8sClient.Get(ctx, types.NamespacedName{Name: SetName, Namespace: WorkspaceNs}, &set)
+			return set.Status
+		}, timeout, interval).Should(Equal(crownlabsv1alpha2.InstanceSetStatus{
+			Workspace: WorkspaceName, Instances: 1, InstanceUIDs: []types.UID{instance.UID}}))
+	})
+
+	It("Should create the instance of the tenants subscribing later to the workspace", func() {
+		Expect(k8sClient.Create(ctx, forgeTenant(LateStudentName, map[string]string{WorkspaceLabelKey: string(crownlabsv1alpha1.User)}))).Should(Succeed())
+		doesEventuallyExist(ctx, setInstanceKey(LateStudentName), &crownlabsv1alpha2.Instance{}, BeTrue(), timeout, interval)
+	})
+
+	It("Should delete the instance of the tenants unsubscribing from the workspace", func() {
+		var tenant crownlabsv1alpha1.Tenant
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: LateStudentName}, &tenant)).Should(Succeed())
+		delete(tenant.Labels, WorkspaceLabelKey)
+		Expect(k8sClient.Update(ctx, &tenant)).Should(Succeed())
+		doesEventuallyExist(ctx, setInstanceKey(LateStudentName), &crownlabsv1alpha2.Instance{}, BeFalse(), timeout, interval)
+	})
+
+	It("Should delete all the instances when the set is deleted", func() {
+		Expect(k8sClient.Delete(ctx, &crownlabsv1alpha2.InstanceSet{
+			ObjectMeta: metav1.ObjectMeta{Name: SetName, Namespace: WorkspaceNs},
+		})).Should(Succeed())
+		doesEventuallyExist(ctx, setInstanceKey(StudentName), &crownlabsv1alpha2.Instance{}, BeFalse(), timeout, interval)
+		doesEventuallyExist(ctx, types.NamespacedName{Name: SetName, Namespace: WorkspaceNs},
+			&crownlabsv1alpha2.InstanceSet{}, BeFalse(), timeout, interval)
+	})
+})
+
+var _ = Describe("Instance sets mapping", func() {
+	const (
+		workspaceName = "mapping-ws"
+		workspaceNs   = "workspace-mapping-ws"
+		tenantName    = "mapping.student"
+	)
+
+	var (
+		reconciler InstanceSetReconciler
+		tenant     crownlabsv1alpha1.Tenant
+		set        crownlabsv1alpha2.InstanceSet
+		instance   crownlabsv1alpha2.Instance
+		setKey     = types.NamespacedName{Name: "lab-set", Namespace: workspaceNs}
+	)
+
+	BeforeEach(func() {
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(crownlabsv1alpha1.AddToScheme(scheme)).To(Succeed())
+		Expect(crownlabsv1alpha2.AddToScheme(scheme)).To(Succeed())
+
+		set = crownlabsv1alpha2.InstanceSet{
+			ObjectMeta: metav1.ObjectMeta{Name: setKey.Name, Namespace: setKey.Namespace},
+			Status:     crownlabsv1alpha2.InstanceSetStatus{Workspace: workspaceName},
+		}
+		instance = crownlabsv1alpha2.Instance{ObjectMeta: metav1.ObjectMeta{
+			Name: setKey.Name, Namespace: utils.TenantNamespaceName(tenantName), UID: "instance-uid",
+			Labels: map[string]string{
+				crownlabsv1alpha2.InstanceSetNameLabel:      setKey.Name,
+				crownlabsv1alpha2.InstanceSetNamespaceLabel: setKey.Namespace,
+			},
+		}}
+		reconciler = InstanceSetReconciler{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(&set, &instance).Build()}
+		tenant = crownlabsv1alpha1.Tenant{ObjectMeta: metav1.ObjectMeta{
+			Name:   tenantName,
+			Labels: map[string]string{crownlabsv1alpha1.WorkspaceLabelPrefix + workspaceName: string(crownlabsv1alpha1.User)},
+		}}
+	})
+
+	It("Should enqueue the sets of the workspaces the tenant is subscribed to", func() {
+		Expect(reconciler.tenantToInstanceSets(&tenant)).To(ConsistOf(reconcile.Request{NamespacedName: setKey}))
+	})
+
+	It("Should enqueue the sets of the instances of the tenant, once unsubscribed from the workspace", func() {
+		delete(tenant.Labels, crownlabsv1alpha1.WorkspaceLabelPrefix+workspaceName)
+		Expect(reconciler.tenantToInstanceSets(&tenant)).To(ConsistOf(reconcile.Request{NamespacedName: setKey}))
+	})
+
+	It("Should not enqueue any set for tenants unrelated to them", func() {
+		tenant.Name = "mapping.other"
+		tenant.Labels = nil
+		Expect(reconciler.tenantToInstanceSets(&tenant)).To(BeEmpty())
+	})
+
+	It("Should manage only the instances whose UID is recorded in the status of the set", func() {
+		Expect(belongsToInstanceSet(&instance, &set)).To(BeFalse())
+		set.Status.InstanceUIDs = []types.UID{instance.UID}
+		Expect(belongsToInstanceSet(&instance, &set)).To(BeTrue())
+
+		instance.Labels[crownlabsv1alpha2.InstanceSetNameLabel] = "other-set"
+		Expect(belongsToInstanceSet(&instance, &set)).To(BeFalse())
+	})
+
+	It("Should not delete the instances carrying the labels of the set without having been created by it", func() {
+		Expect(reconciler.deleteSetInstances(context.Background(), &set, nil)).To(Succeed())
+		Expect(reconciler.Get(context.Background(), types.NamespacedName{Name: instance.Name, Namespace: instance.Namespace},
+			&crownlabsv1alpha2.Instance{})).To(Succeed())
+
+		set.Status.InstanceUIDs = []types.UID{instance.UID}
+		Expect(reconciler.deleteSetInstances(context.Background(), &set, nil)).To(Succeed())
+		Expect(reconciler.Get(context.Background(), types.NamespacedName{Name: instance.Name, Namespace: instance.Namespace},
+			&crownlabsv1alpha2.Instance{})).ToNot(Succeed())
+	})
+
+	It("Should allow only the sets in the namespace of the workspace of the template", func() {
+		template := crownlabsv1alpha2.Template{
+			ObjectMeta: metav1.ObjectMeta{Name: "template", Namespace: workspaceNs},
+			Spec:       crownlabsv1alpha2.TemplateSpec{WorkspaceRef: crownlabsv1alpha2.GenericRef{Name: workspaceName}},
+		}
+		set := crownlabsv1alpha2.InstanceSet{ObjectMeta: metav1.ObjectMeta{Name: setKey.Name, Namespace: workspaceNs}}
+		Expect(isInstanceSetAllowed(&set, &template)).To(BeTrue())
+
+		set.Namespace = utils.TenantNamespaceName(tenantName)
+		Expect(isInstanceSetAllowed(&set, &template)).To(BeFalse())
+
+		template.Namespace = set.Namespace
+		Expect(isInstanceSetAllowed(&set, &template)).To(BeFalse())
+	})
+})
@@ -2,6 +2,7 @@ package instance_controller
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -9,6 +10,7 @@ import (
 	virtv1 "kubevirt.io/client-go/api/v1"
 	cdiv1 "kubevirt.io/containerized-data-importer/pkg/apis/core/v1alpha1"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	crownlabsv1alpha2 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha2"
 	instance_creation "github.com/netgroup-polito/CrownLabs/operators/pkg/instance-creation"
@@ -16,11 +18,39 @@ import (
 
 // CreateVMEnvironment implements the logic to create all the different
 // Kubernetes resources required to start a CrownLabs environment.
-func (r *InstanceReconciler) CreateVMEnvironment(instance *crownlabsv1alpha2.Instance, environment *crownlabsv1alpha2.Environment, namespace, name string, vmStart time.Time) error {
+// The rollout action specifies whether the already existing VMs should be updated (and restarted).
+func (r *InstanceReconciler) CreateVMEnvironment(instance *crownlabsv1alpha2.Instance, environment *crownlabsv1alpha2.Environment,
+	workspace, namespace, name string, vmStart time.Time, rollout rolloutAction) error {
 	var user, password string
 	var vmi *virtv1.VirtualMachineInstance
 	ctx := context.TODO()
-	err := instance_creation.GetWebdavCredentials(ctx, r.Client, r.WebdavSecretName, instance.Namespace, &user, &password)
+	if err := instance_creation.ValidateVMHardware(environment); err != nil {
+		klog.Error(err)
+		r.setInstanceStatus(ctx, "Invalid hardware options of instance "+instance.Name+" in namespace "+instance.Namespace+": "+err.Error(), "Warning", "InvalidHardware", instance, "", "")
+		return err
+	}
+	// The hardware options reserving resources of the nodes are granted only by the security profiles defined by the administrators.
+	profile, err := r.getSecurityProfile(ctx, environment, workspace)
+	if err == nil {
+		err = checkVMHardwareAllowed(environment, profile)
+	}
+	if err != nil {
+		klog.Error(err)
+		r.setInstanceStatus(ctx, "Could not apply the security profile of instance "+instance.Name+" in namespace "+instance.Namespace+": "+err.Error(), "Error", "SecurityProfileNotAllowed", instance, "", "")
+		return err
+	}
+	if err := instance_creation.ValidateVolumes(environment); err != nil {
+		klog.Error(err)
+		r.setInstanceStatus(ctx, "Invalid volumes of instance "+instance.Name+" in namespace "+instance.Namespace+": "+err.Error(), "Warning", "InvalidVolumes", instance, "", "")
+		return err
+	}
+	if environment.IncrementalImage && !environment.Persistent {
+		err := fmt.Errorf("the image %s of instance %s/%s is incremental, and it can be used only by persistent VMs", environment.Image, instance.Namespace, instance.Name)
+		klog.Error(err)
+		r.setInstanceStatus(ctx, "Invalid image of instance "+instance.Name+" in namespace "+instance.Namespace+": "+err.Error(), "Warning", "InvalidImage", instance, "", "")
+		return err
+	}
+	err = instance_creation.GetWebdavCredentials(ctx, r.Client, r.WebdavSecretName, instance.Namespace, &user, &password)
 	if err != nil {
 		klog.Error("unable to get Webdav Credentials")
 		klog.Error(err)
@@ -35,6 +65,29 @@ func (r *InstanceReconciler) CreateVMEnvironment(instance *crownlabsv1alpha2.Ins
 		klog.Info("Public keys obtained. Building cloud-init script. " + name)
 	}
 
+	hasWebTerminal := r.hasWebTerminal(environment)
+	if hasWebTerminal {
+		// The web terminal connects to the VM through SSH, hence its key needs to be authorized.
+		publicKey, err := r.enforceWebTerminalKeys(ctx, instance, name)
+		if err != nil {
+			klog.Error(err)
+			r.setInstanceStatus(ctx, "Could not create the web terminal keys of instance "+instance.Name+" in namespace "+instance.Namespace, "Warning", "SecretNotCreated", instance, "", "")
+			return err
+		}
+		publicKeys = append(publicKeys, publicKey)
+	}
+
+	var sshCA *instance_creation.SSHCertificateAuthority
+	if r.SSHCAPublicKey != "" {
+		principals, err := instance_creation.GetPrincipals(ctx, r.Client, instance.Spec.Tenant, instance.Spec.Template)
+		if err != nil {
+			klog.Error("unable to get the ssh principals")
+			klog.Error(err)
+		} else {
+			sshCA = &instance_creation.SSHCertificateAuthority{PublicKey: r.SSHCAPublicKey, Principals: principals}
+		}
+	}
+
 	// persistent feature
 	if environment.Persistent {
 		if cancontinue, err1 := r.createPersistentlogic(instance, environment, name); err1 != nil {
@@ -46,7 +99,7 @@ func (r *InstanceReconciler) CreateVMEnvironment(instance *crownlabsv1alpha2.Ins
 	}
 
 	// create secret
-	secret := instance_creation.CreateCloudInitSecret(name, namespace, user, password, r.NextcloudBaseURL, publicKeys)
+	secret := instance_creation.CreateCloudInitSecret(name, namespace, user, password, r.NextcloudBaseURL, publicKeys, sshCA)
 	op, err := ctrl.CreateOrUpdate(ctx, r.Client, &secret, func() error {
 		return ctrl.SetControllerReference(instance, &secret, r.Scheme)
 	})
@@ -56,28 +109,55 @@ func (r *InstanceReconciler) CreateVMEnvironment(instance *crownlabsv1alpha2.Ins
 		klog.Infof("Secret for instance %s/%s %s", instance.GetNamespace(), instance.GetName(), op)
 	}
 
-	service, ingress, _, err := r.CreateInstanceExpositionEnvironment(ctx, instance, name, false)
+	service, ingress, urlUUID, err := r.CreateInstanceExpositionEnvironment(ctx, instance, name, false, hasWebTerminal, instance_creation.RemoteDesktopPort, rollout)
 	if err != nil {
 		return err
 	}
 
+	if hasWebTerminal {
+		if err = r.enforceWebTerminal(ctx, instance, name, urlUUID, &service); err != nil {
+			return err
+		}
+	}
+
+	if err = r.enforceAdditionalVolumes(ctx, instance, environment); err != nil {
+		klog.Error(err)
+		r.setInstanceStatus(ctx, "Could not create the volumes of instance "+instance.Name+" in namespace "+instance.Namespace+": "+err.Error(), "Warning", "VolumesNotCreated", instance, "", "")
+		return err
+	}
+
 	// create vm
 	vmi = &virtv1.VirtualMachineInstance{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
 	vmStatus := "VmiCreated"
 	if environment.Persistent {
 		vm := virtv1.VirtualMachine{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
 		_, err = ctrl.CreateOrUpdate(ctx, r.Client, &vm, func() error {
-			instance_creation.UpdateVirtualMachineSpec(&vm, environment, instance.Spec.Running)
+			if vm.ObjectMeta.CreationTimestamp.IsZero() || rollout != rolloutSkip {
+				instance_creation.UpdateVirtualMachineSpec(&vm, environment, instance.Spec.Running)
+				instance_creation.UpdateVMVolumes(&vm.Spec.Template.Spec, environment, &instance.Spec.Template)
+			} else {
+				vm.Spec.Running = &instance.Spec.Running
+			}
 			vm.Spec.Template.ObjectMeta.Labels = instance_creation.UpdateLabels(vm.Spec.Template.ObjectMeta.Labels, environment, name)
 			return ctrl.SetControllerReference(instance, &vm, r.Scheme)
 		})
 		if !instance.Spec.Running {
 			vmStatus = "VmiOff"
+		} else if err == nil && rollout == rolloutRestart {
+			// The VMI is deleted, and then recreated by KubeVirt according to the updated VM spec.
+			err = r.restartVMI(ctx, vmi)
 		}
 	} else {
+		if rollout == rolloutRestart {
+			// The VMI spec is immutable, hence it is deleted and recreated once the deletion completes.
+			if err = r.restartVMI(ctx, vmi); err != nil {
+				return err
+			}
+		}
 		_, err = ctrl.CreateOrUpdate(ctx, r.Client, vmi, func() error {
 			if vmi.ObjectMeta.CreationTimestamp.IsZero() {
 				instance_creation.UpdateVirtualMachineInstanceSpec(vmi, environment)
+				instance_creation.UpdateVMVolumes(&vmi.Spec, environment, &instance.Spec.Template)
 			}
 			vmi.Labels = instance_creation.UpdateLabels(vmi.Labels, environment, name)
 			return ctrl.SetControllerReference(instance, vmi, r.Scheme)
@@ -90,7 +170,7 @@ func (r *InstanceReconciler) CreateVMEnvironment(instance *crownlabsv1alpha2.Ins
 	r.setInstanceStatus(ctx, "VirtualMachineInstance "+vmi.Name+" correctly created in namespace "+namespace, "Normal", vmStatus, instance, "", "")
 
 	if vmStatus != "VmiOff" {
-		go r.getVmiStatus(ctx, environment.GuiEnabled, &service, &ingress, instance, vmi, vmStart)
+		go r.getVmiStatus(ctx, environment, &service, &ingress, instance, vmi, vmStart)
 	}
 
 	return nil
@@ -101,7 +181,10 @@ func (r *InstanceReconciler) createPersistentlogic(instance *crownlabsv1alpha2.I
 	// create datavolume
 	dv := cdiv1.DataVolume{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: instance.Namespace}}
 	op, err := ctrl.CreateOrUpdate(ctx, r.Client, &dv, func() error {
-		instance_creation.UpdateDataVolumeSpec(&dv, environment)
+		// The spec of the DataVolume is immutable, and the disk is never imported again (i.e. the image changes are not rolled out).
+		if dv.CreationTimestamp.IsZero() {
+			instance_creation.UpdateDataVolumeSpec(&dv, environment)
+		}
 		return ctrl.SetControllerReference(instance, &dv, r.Scheme)
 	})
 	if err != nil {
@@ -117,5 +200,28 @@ func (r *InstanceReconciler) createPersistentlogic(instance *crownlabsv1alpha2.I
 	}
 
 	klog.Infof("DataVolume import for instance %s/%s completed", instance.GetNamespace(), instance.GetName())
+
+	if environment.IncrementalImage {
+		flattened, err := r.enforceFlattenedDisk(ctx, instance, environment, name)
+		if err != nil {
+			klog.Error(err)
+			r.setInstanceStatus(ctx, "Could not flatten the disk of instance "+instance.Name+" in namespace "+instance.Namespace, "Warning", "DiskNotFlattened", instance, "", "")
+			return false, err
+		}
+		if !flattened {
+			r.setInstanceStatus(ctx, "PVC "+dv.Name+" flattening", "Normal", "Importing", instance, "", "")
+			return false, nil
+		}
+	}
 	return true, nil
 }
+
+// restartVMI deletes the given VMI (if it exists), to restart it with an updated spec.
+func (r *InstanceReconciler) restartVMI(ctx context.Context, vmi *virtv1.VirtualMachineInstance) error {
+	if err := r.Delete(ctx, vmi); client.IgnoreNotFound(err) != nil {
+		klog.Errorf("Error when restarting VMI %s/%s -> %s", vmi.Namespace, vmi.Name, err)
+		return err
+	}
+	klog.Infof("VMI %s/%s restarted to apply the new template revision", vmi.Namespace, vmi.Name)
+	return nil
+}
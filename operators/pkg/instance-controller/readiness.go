@@ -0,0 +1,207 @@
+package instance_controller
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/klog/v2"
+	virtv1 "kubevirt.io/client-go/api/v1"
+
+	crownlabsv1alpha2 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha2"
+)
+
+const (
+	defaultReadinessPeriod         = 5 * time.Second
+	defaultReadinessTimeout        = 1 * time.Second
+	defaultReadinessStartupTimeout = 120 * time.Second
+	// defaultVMReadinessPeriod is the period of the checks in case the environment does not specify any.
+	defaultVMReadinessPeriod = 1 * time.Second
+)
+
+// readinessTimings contains the timings of the readiness checks.
+type readinessTimings struct {
+	InitialDelay   time.Duration
+	Period         time.Duration
+	Timeout        time.Duration
+	StartupTimeout time.Duration
+}
+
+// readinessProbe checks once whether an environment is ready, returning an error otherwise.
+type readinessProbe func(ctx context.Context, timeout time.Duration) error
+
+// getReadinessTimings returns the timings of the given readiness check, applying the defaults to the unset fields.
+func getReadinessTimings(check *crownlabsv1alpha2.ReadinessCheck) readinessTimings {
+	if check == nil {
+		return readinessTimings{Period: defaultVMReadinessPeriod, Timeout: defaultReadinessTimeout, StartupTimeout: defaultReadinessStartupTimeout}
+	}
+
+	secondsOrDefault := func(seconds int32, def time.Duration) time.Duration {
+		if seconds > 0 {
+			return time.Duration(seconds) * time.Second
+		}
+		return def
+	}
+
+	return readinessTimings{
+		InitialDelay:   secondsOrDefault(check.InitialDelaySeconds, 0),
+		Period:         secondsOrDefault(check.PeriodSeconds, defaultReadinessPeriod),
+		Timeout:        secondsOrDefault(check.TimeoutSeconds, defaultReadinessTimeout),
+		StartupTimeout: secondsOrDefault(check.StartupTimeoutSeconds, defaultReadinessStartupTimeout),
+	}
+}
+
+// buildReadinessProbe returns the readiness probe of the container of an environment, according
+// to its readiness check (nil if not specified, or if not supported by containers).
+func buildReadinessProbe(check *crownlabsv1alpha2.ReadinessCheck) *v1.Probe {
+	if check == nil {
+		return nil
+	}
+
+	var handler v1.Handler
+	switch {
+	case check.HTTPGet != nil:
+		path := check.HTTPGet.Path
+		if path == "" {
+			path = "/"
+		}
+		handler.HTTPGet = &v1.HTTPGetAction{Path: path, Port: intstr.FromInt(int(check.HTTPGet.Port))}
+	case check.TCPSocket != nil:
+		handler.TCPSocket = &v1.TCPSocketAction{Port: intstr.FromInt(int(check.TCPSocket.Port))}
+	case check.Exec != nil:
+		handler.Exec = &v1.ExecAction{Command: check.Exec.Command}
+	default:
+		return nil
+	}
+
+	timings := getReadinessTimings(check)
+	return &v1.Probe{
+		Handler:             handler,
+		InitialDelaySeconds: int32(timings.InitialDelay.Seconds()),
+		PeriodSeconds:       int32(timings.Period.Seconds()),
+		TimeoutSeconds:      int32(timings.Timeout.Seconds()),
+	}
+}
+
+// vmReadinessProbe returns the probe checking whether a VM is ready, according to the readiness check of the environment,
+// along with its description. If not specified, the VM is ready once the remote desktop (or SSH) accepts connections.
+func (r *InstanceReconciler) vmReadinessProbe(environment *crownlabsv1alpha2.Environment, service *v1.Service,
+	vmi *virtv1.VirtualMachineInstance) (readinessProbe, string) {
+	name := types.NamespacedName{Namespace: vmi.Namespace, Name: vmi.Name}
+	check := environment.ReadinessCheck
+	if check != nil {
+		switch {
+		case check.HTTPGet != nil:
+			return r.vmiAddressReadinessProbe(name, func(ip string) readinessProbe {
+				return httpReadinessProbe(fmt.Sprintf("http://%s%s", net.JoinHostPort(ip, strconv.Itoa(int(check.HTTPGet.Port))), check.HTTPGet.Path))
+			}), fmt.Sprintf("the path %s on port %d of %s", check.HTTPGet.Path, check.HTTPGet.Port, name)
+		case check.TCPSocket != nil:
+			return r.vmiAddressReadinessProbe(name, func(ip string) readinessProbe {
+				return tcpReadinessProbe(net.JoinHostPort(ip, strconv.Itoa(int(check.TCPSocket.Port))))
+			}), fmt.Sprintf("port %d of %s", check.TCPSocket.Port, name)
+		case check.GuestAgent:
+			return r.guestAgentReadinessProbe(name), "the guest agent of " + name.String()
+		default:
+			// Templates specifying checks not supported by VMs are rejected at admission time.
+			klog.Warningf("Readiness check of environment %s not supported by VMs, falling back to the default one", environment.Name)
+		}
+	}
+
+	port := "6080" // VNC
+	if !environment.GuiEnabled {
+		port = "22" // SSH
+	}
+	address := net.JoinHostPort(service.Name+"."+service.Namespace, port)
+	return tcpReadinessProbe(address), address
+}
+
+// tcpReadinessProbe returns the probe checking whether the given address accepts TCP connections.
+func tcpReadinessProbe(address string) readinessProbe {
+	return func(ctx context.Context, timeout time.Duration) error {
+		conn, err := net.DialTimeout("tcp", address, timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+}
+
+// httpReadinessProbe returns the probe checking whether the given URL returns a successful status code.
+func httpReadinessProbe(url string) readinessProbe {
+	return func(ctx context.Context, timeout time.Duration) error {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusBadRequest {
+			return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+		}
+		return nil
+	}
+}
+
+// vmiAddressReadinessProbe returns the probe retrieving the current IP address of the given VMI, which may be assigned (or change)
+// after the VMI is running, and then checking it through the probe returned by the given function. It fails if no address is set.
+func (r *InstanceReconciler) vmiAddressReadinessProbe(name types.NamespacedName, probe func(ip string) readinessProbe) readinessProbe {
+	return func(ctx context.Context, timeout time.Duration) error {
+		vmi := virtv1.VirtualMachineInstance{}
+		if err := r.Get(ctx, name, &vmi); err != nil {
+			return err
+		}
+		if len(vmi.Status.Interfaces) == 0 || vmi.Status.Interfaces[0].IP == "" {
+			return fmt.Errorf("no IP address assigned to %v", name)
+		}
+		return probe(vmi.Status.Interfaces[0].IP)(ctx, timeout)
+	}
+}
+
+// guestAgentReadinessProbe returns the probe checking whether the guest agent of the given VMI is connected.
+func (r *InstanceReconciler) guestAgentReadinessProbe(name types.NamespacedName) readinessProbe {
+	return func(ctx context.Context, timeout time.Duration) error {
+		vmi := virtv1.VirtualMachineInstance{}
+		if err := r.Get(ctx, name, &vmi); err != nil {
+			return err
+		}
+		for i := range vmi.Status.Conditions {
+			condition := &vmi.Status.Conditions[i]
+			if condition.Type == virtv1.VirtualMachineInstanceAgentConnected && condition.Status == v1.ConditionTrue {
+				return nil
+			}
+		}
+		return fmt.Errorf("guest agent not connected")
+	}
+}
+
+// waitForReadiness repeatedly executes the probe, according to the given timings, until it succeeds or the startup timeout expires.
+func waitForReadiness(ctx context.Context, probe readinessProbe, description string, timings readinessTimings) error {
+	time.Sleep(timings.InitialDelay)
+	deadline := time.Now().Add(timings.StartupTimeout)
+
+	for {
+		err := probe(ctx, timings.Timeout)
+		if err == nil {
+			return nil
+		}
+		klog.Info(fmt.Sprintf("Unable to check whether %v is ready: %v", description, err))
+
+		if time.Now().Add(timings.Period).After(deadline) {
+			return fmt.Errorf("timeout while checking whether %v is ready -> %w", description, err)
+		}
+		time.Sleep(timings.Period)
+	}
+}
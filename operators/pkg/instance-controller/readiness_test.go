@@ -0,0 +1,124 @@
+package instance_controller
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	virtv1 "kubevirt.io/client-go/api/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	crownlabsv1alpha2 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha2"
+)
+
+var _ = Describe("Readiness checks", func() {
+	Context("Building the probe of the container", func() {
+		It("Should not set any probe if no check is specified", func() {
+			Expect(buildReadinessProbe(nil)).To(BeNil())
+		})
+
+		It("Should not set any probe if only the guest agent is checked", func() {
+			Expect(buildReadinessProbe(&crownlabsv1alpha2.ReadinessCheck{GuestAgent: true})).To(BeNil())
+		})
+
+		It("Should configure an HTTP probe with the default timings", func() {
+			probe := buildReadinessProbe(&crownlabsv1alpha2.ReadinessCheck{HTTPGet: &crownlabsv1alpha2.HTTPGetCheck{Port: 8888}})
+			Expect(probe).ToNot(BeNil())
+			Expect(probe.HTTPGet).To(Equal(&v1.HTTPGetAction{Path: "/", Port: intstr.FromInt(8888)}))
+			Expect(probe.InitialDelaySeconds).To(BeNumerically("==", 0))
+			Expect(probe.PeriodSeconds).To(BeNumerically("==", 5))
+			Expect(probe.TimeoutSeconds).To(BeNumerically("==", 1))
+		})
+
+		It("Should configure an exec probe with the given timings", func() {
+			probe := buildReadinessProbe(&crownlabsv1alpha2.ReadinessCheck{
+				Exec:                &crownlabsv1alpha2.ExecCheck{Command: []string{"cat", "/tmp/ready"}},
+				InitialDelaySeconds: 10, PeriodSeconds: 2, TimeoutSeconds: 3,
+			})
+			Expect(probe).ToNot(BeNil())
+			Expect(probe.Exec.Command).To(Equal([]string{"cat", "/tmp/ready"}))
+			Expect(probe.InitialDelaySeconds).To(BeNumerically("==", 10))
+			Expect(probe.PeriodSeconds).To(BeNumerically("==", 2))
+			Expect(probe.TimeoutSeconds).To(BeNumerically("==", 3))
+		})
+	})
+
+	Context("Waiting for the readiness of a VM", func() {
+		timings := readinessTimings{Period: 10 * time.Millisecond, Timeout: 10 * time.Millisecond, StartupTimeout: 100 * time.Millisecond}
+
+		It("Should succeed once the probe succeeds", func() {
+			attempts := 0
+			probe := func(ctx context.Context, timeout time.Duration) error {
+				attempts++
+				if attempts < 3 {
+					return errors.New("not ready")
+				}
+				return nil
+			}
+			Expect(waitForReadiness(context.Background(), probe, "test", timings)).To(Succeed())
+			Expect(attempts).To(Equal(3))
+		})
+
+		It("Should fail once the startup timeout expires", func() {
+			probe := func(ctx context.Context, timeout time.Duration) error { return errors.New("not ready") }
+			Expect(waitForReadiness(context.Background(), probe, "test", timings)).ToNot(Succeed())
+		})
+
+		It("Should check whether the HTTP endpoint returns a successful status code", func() {
+			status := http.StatusServiceUnavailable
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(status) }))
+			defer server.Close()
+
+			probe := httpReadinessProbe(server.URL + "/healthz")
+			Expect(probe(context.Background(), time.Second)).ToNot(Succeed())
+			status = http.StatusOK
+			Expect(probe(context.Background(), time.Second)).To(Succeed())
+		})
+
+		It("Should check whether the TCP port accepts connections", func() {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).ToNot(HaveOccurred())
+			address := listener.Addr().String()
+
+			probe := tcpReadinessProbe(address)
+			Expect(probe(context.Background(), time.Second)).To(Succeed())
+			Expect(listener.Close()).To(Succeed())
+			Expect(probe(context.Background(), time.Second)).ToNot(Succeed())
+		})
+
+		It("Should check the current IP address of the VMI", func() {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).ToNot(HaveOccurred())
+			defer listener.Close()
+			port := listener.Addr().(*net.TCPAddr).Port
+
+			// Only the latest version is registered, since the fake client refuses types associated with multiple ones.
+			scheme := runtime.NewScheme()
+			scheme.AddKnownTypes(virtv1.GroupVersion, &virtv1.VirtualMachineInstance{}, &virtv1.VirtualMachineInstanceList{})
+			metav1.AddToGroupVersion(scheme, virtv1.GroupVersion)
+			vmi := virtv1.VirtualMachineInstance{ObjectMeta: metav1.ObjectMeta{Name: "vmi-name", Namespace: "vmi-namespace"}}
+			reconciler := InstanceReconciler{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(&vmi).Build()}
+			environment := crownlabsv1alpha2.Environment{ReadinessCheck: &crownlabsv1alpha2.ReadinessCheck{
+				TCPSocket: &crownlabsv1alpha2.TCPSocketCheck{Port: int32(port)},
+			}}
+
+			probe, _ := reconciler.vmReadinessProbe(&environment, &v1.Service{}, &vmi)
+			By("Failing as long as no IP address is assigned")
+			Expect(probe(context.Background(), time.Second)).To(MatchError(ContainSubstring("no IP address")))
+
+			By("Succeeding once the IP address is assigned")
+			vmi.Status.Interfaces = []virtv1.VirtualMachineInstanceNetworkInterface{{IP: "127.0.0.1"}}
+			Expect(reconciler.Update(context.Background(), &vmi)).To(Succeed())
+			Expect(probe(context.Background(), time.Second)).To(Succeed())
+		})
+	})
+})
@@ -0,0 +1,207 @@
+package instance_controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	crownlabsv1alpha2 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha2"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/selectors"
+)
+
+// errInstanceNotOwned is returned in case an Instance with the name of the reservation exists, but it is not owned by it.
+var errInstanceNotOwned = errors.New("instance not owned by the reservation")
+
+// InstanceReservationReconciler reconciles an InstanceReservation object, creating (or starting) the associated
+// Instance at the beginning of the reserved time slot, and stopping or deleting it when the time slot is over.
+type InstanceReservationReconciler struct {
+	client.Client
+	Scheme             *runtime.Scheme
+	EventsRecorder     record.EventRecorder
+	NamespaceWhitelist metav1.LabelSelector
+
+	// This function, if configured, is deferred at the beginning of the Reconcile.
+	// Specifically, it is meant to be set to GinkgoRecover during the tests,
+	// in order to lead to a controlled failure in case the Reconcile panics.
+	ReconcileDeferHook func()
+
+	// nsSelector filters the namespaces whose objects are reconciled, built from NamespaceWhitelist when setting up the controller.
+	nsSelector *selectors.NamespaceSelector
+}
+
+// Reconcile reconciles the state of an InstanceReservation resource.
+func (r *InstanceReservationReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	if r.ReconcileDeferHook != nil {
+		defer r.ReconcileDeferHook()
+	}
+
+	var reservation crownlabsv1alpha2.InstanceReservation
+	if err := r.Get(ctx, req.NamespacedName, &reservation); err != nil {
+		// reconcile was triggered by a delete request
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	// Check the selector label, in order to know whether to perform or not reconciliation.
+	if proceed, err := r.nsSelector.Matches(ctx, reservation.Namespace); !proceed {
+		// If there was an error while checking, show the error and try again.
+		if err != nil {
+			klog.Error(err)
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	now := time.Now()
+	start, end := reservation.Spec.Start.Time, reservation.Spec.End.Time
+
+	switch {
+	case !end.After(start):
+		klog.Infof("Reservation %s/%s is invalid: the end does not follow the start", reservation.Namespace, reservation.Name)
+		r.EventsRecorder.Event(&reservation, "Warning", "InvalidReservation", "The end of the reservation does not follow its start")
+		return ctrl.Result{}, r.setReservationStatus(ctx, &reservation, crownlabsv1alpha2.ReservationFailed, nil,
+			"The end of the reservation must follow its start")
+
+	case now.Before(start):
+		klog.Infof("Reservation %s/%s scheduled at %s", reservation.Namespace, reservation.Name, start)
+		err := r.setReservationStatus(ctx, &reservation, crownlabsv1alpha2.ReservationScheduled, &reservation.Spec.Start,
+			fmt.Sprintf("The instance will be started at %s", start.Format(time.RFC3339)))
+		return ctrl.Result{RequeueAfter: start.Sub(now)}, err
+
+	case now.Before(end):
+		if err := r.enforceReservedInstance(ctx, &reservation); errors.Is(err, errInstanceNotOwned) {
+			klog.Infof("Reservation %s/%s conflicts with an already existing instance", reservation.Namespace, reservation.Name)
+			r.EventsRecorder.Event(&reservation, "Warning", "InstanceConflict", "An instance with the same name, not created by the reservation, already exists")
+			return ctrl.Result{}, r.setReservationStatus(ctx, &reservation, crownlabsv1alpha2.ReservationFailed, nil,
+				fmt.Sprintf("The instance %s already exists, and it is not managed by the reservation", reservation.Name))
+		} else if err != nil {
+			klog.Errorf("Error when starting the instance of reservation %s/%s -> %s", reservation.Namespace, reservation.Name, err)
+			r.EventsRecorder.Event(&reservation, "Warning", "InstanceStartFailed", "Failed to start the reserved instance")
+			return ctrl.Result{}, err
+		}
+		reservation.Status.Instance = reservation.Name
+		err := r.setReservationStatus(ctx, &reservation, crownlabsv1alpha2.ReservationActive, &reservation.Spec.End,
+			fmt.Sprintf("The instance will be terminated at %s", end.Format(time.RFC3339)))
+		return ctrl.Result{RequeueAfter: end.Sub(now)}, err
+
+	default:
+		// The end action is performed only once, to avoid interfering with instances subsequently created with the same name.
+		if reservation.Status.Phase == crownlabsv1alpha2.ReservationCompleted {
+			return ctrl.Result{}, nil
+		}
+		if err := r.terminateReservedInstance(ctx, &reservation); err != nil {
+			klog.Errorf("Error when terminating the instance of reservation %s/%s -> %s", reservation.Namespace, reservation.Name, err)
+			r.EventsRecorder.Event(&reservation, "Warning", "InstanceTerminationFailed", "Failed to terminate the reserved instance")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, r.setReservationStatus(ctx, &reservation, crownlabsv1alpha2.ReservationCompleted, nil,
+			fmt.Sprintf("The reservation ended at %s", end.Format(time.RFC3339)))
+	}
+}
+
+// enforceReservedInstance creates the Instance associated with the reservation, or starts it in case it already exists.
+// Instances created by the reservation are owned by it, while already existing ones are not adopted (nor modified):
+// in that case, errInstanceNotOwned is returned.
+func (r *InstanceReservationReconciler) enforceReservedInstance(ctx context.Context, reservation *crownlabsv1alpha2.InstanceReservation) error {
+	instance := crownlabsv1alpha2.Instance{ObjectMeta: metav1.ObjectMeta{Name: reservation.Name, Namespace: reservation.Namespace}}
+
+	op, err := ctrl.CreateOrUpdate(ctx, r.Client, &instance, func() error {
+		if instance.CreationTimestamp.IsZero() {
+			instance.Spec.Template = reservation.Spec.Template
+			instance.Spec.Tenant = reservation.Spec.Tenant
+			if err := ctrl.SetControllerReference(reservation, &instance, r.Scheme); err != nil {
+				return err
+			}
+		} else if !metav1.IsControlledBy(&instance, reservation) {
+			return errInstanceNotOwned
+		}
+		instance.Spec.Running = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if op != controllerutil.OperationResultNone {
+		klog.Infof("Instance %s/%s of reservation %s", instance.Namespace, instance.Name, op)
+		r.EventsRecorder.Event(reservation, "Normal", "InstanceStarted", fmt.Sprintf("Instance %s %s", instance.Name, op))
+	}
+	return nil
+}
+
+// terminateReservedInstance performs the end action of the reservation on the associated Instance,
+// if it exists and it is owned by the reservation (i.e. it has been created by it).
+func (r *InstanceReservationReconciler) terminateReservedInstance(ctx context.Context, reservation *crownlabsv1alpha2.InstanceReservation) error {
+	var instance crownlabsv1alpha2.Instance
+	if err := r.Get(ctx, types.NamespacedName{Name: reservation.Name, Namespace: reservation.Namespace}, &instance); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+
+	if !metav1.IsControlledBy(&instance, reservation) {
+		klog.Infof("Instance %s/%s is not owned by reservation %s, skipping the end action", instance.Namespace, instance.Name, reservation.Name)
+		return nil
+	}
+
+	if reservation.Spec.EndAction == crownlabsv1alpha2.ReservationEndActionStop {
+		original := instance.DeepCopy()
+		instance.Spec.Running = false
+		if err := r.Patch(ctx, &instance, client.MergeFrom(original)); err != nil {
+			return err
+		}
+		r.EventsRecorder.Event(reservation, "Normal", "InstanceStopped", fmt.Sprintf("Instance %s stopped", instance.Name))
+		return nil
+	}
+
+	if err := r.Delete(ctx, &instance); client.IgnoreNotFound(err) != nil {
+		return err
+	}
+	r.EventsRecorder.Event(reservation, "Normal", "InstanceDeleted", fmt.Sprintf("Instance %s deleted", instance.Name))
+	return nil
+}
+
+// setReservationStatus updates the status of the reservation, if it changed.
+func (r *InstanceReservationReconciler) setReservationStatus(ctx context.Context, reservation *crownlabsv1alpha2.InstanceReservation,
+	phase crownlabsv1alpha2.ReservationPhase, nextTransition *metav1.Time, msg string) error {
+	original := reservation.Status.DeepCopy()
+	reservation.Status.Phase = phase
+	reservation.Status.NextTransition = nextTransition.DeepCopy()
+	reservation.Status.Message = msg
+
+	if original.Phase == reservation.Status.Phase && original.Instance == reservation.Status.Instance &&
+		original.Message == reservation.Status.Message {
+		return nil
+	}
+	if err := r.Status().Update(ctx, reservation); err != nil {
+		klog.Errorf("Error when updating the status of reservation %s/%s -> %s", reservation.Namespace, reservation.Name, err)
+		return err
+	}
+	return nil
+}
+
+// SetupWithManager registers a new controller for InstanceReservation resources.
+func (r *InstanceReservationReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	// Only the reservations in the namespaces satisfying the whitelist are reconciled.
+	nsSelector, err := selectors.NewNamespaceSelector(mgr.GetClient(), &r.NamespaceWhitelist)
+	if err != nil {
+		return err
+	}
+	r.nsSelector = nsSelector
+
+	blder := ctrl.NewControllerManagedBy(mgr).
+		For(&crownlabsv1alpha2.InstanceReservation{}, builder.WithPredicates(predicate.GenerationChangedPredicate{}, nsSelector.Predicate())).
+		// The owned instances are watched to recreate them in case they are deleted during the reserved time slot.
+		Owns(&crownlabsv1alpha2.Instance{}, builder.WithPredicates(predicate.GenerationChangedPredicate{}, nsSelector.Predicate()))
+	return nsSelector.Watch(blder, func() client.ObjectList { return &crownlabsv1alpha2.InstanceReservationList{} }).
+		Complete(r)
+}
@@ -0,0 +1,152 @@
+package instance_controller
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	crownlabsv1alpha2 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha2"
+)
+
+var _ = Describe("Instance reservations", func() {
+
+	const (
+		ReservationNamespace = "reservation-namespace"
+		TemplateName         = "reservation-template"
+		TenantName           = "reservation-tenant"
+
+		timeout  = time.Second * 20
+		interval = time.Millisecond * 500
+	)
+
+	var (
+		ctx           context.Context
+		reservationNs = v1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: ReservationNamespace,
+				Labels: map[string]string{
+					"production": "true",
+					"test-suite": "true",
+				},
+			},
+		}
+	)
+
+	forgeReservation := func(name string, start, end time.Time, endAction crownlabsv1alpha2.ReservationEndAction) *crownlabsv1alpha2.InstanceReservation {
+		return &crownlabsv1alpha2.InstanceReservation{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ReservationNamespace},
+			Spec: crownlabsv1alpha2.InstanceReservationSpec{
+				Template:  crownlabsv1alpha2.GenericRef{Name: TemplateName, Namespace: ReservationNamespace},
+				Tenant:    crownlabsv1alpha2.GenericRef{Name: TenantName},
+				Start:     metav1.NewTime(start),
+				End:       metav1.NewTime(end),
+				EndAction: endAction,
+			},
+		}
+	}
+
+	reservationPhase := func(name string) func() crownlabsv1alpha2.ReservationPhase {
+		return func() crownlabsv1alpha2.ReservationPhase {
+			var reservation crownlabsv1alpha2.InstanceReservation
+			if err := k8sClient.Get(ctx, types.NamespacedName{Name: name, Namespace: ReservationNamespace}, &reservation); err != nil {
+				return ""
+			}
+			return reservation.Status.Phase
+		}
+	}
+
+	It("Setting up the reservation namespace", func() {
+		ctx = context.Background()
+		Expect(k8sClient.Create(ctx, &reservationNs)).Should(Succeed())
+		doesEventuallyExist(ctx, types.NamespacedName{Name: ReservationNamespace}, &v1.Namespace{}, BeTrue(), timeout, interval)
+	})
+
+	It("Should mark as failed a reservation ending before its start", func() {
+		now := time.Now()
+		reservation := forgeReservation("reservation-invalid", now.Add(time.Hour), now, crownlabsv1alpha2.ReservationEndActionDelete)
+		Expect(k8sClient.Create(ctx, reservation)).Should(Succeed())
+
+		Eventually(reservationPhase(reservation.Name), timeout, interval).Should(Equal(crownlabsv1alpha2.ReservationFailed))
+		doesEventuallyExist(ctx, types.NamespacedName{Name: reservation.Name, Namespace: ReservationNamespace},
+			&crownlabsv1alpha2.Instance{}, BeFalse(), timeout, interval)
+	})
+
+	It("Should not create the instance before the start of the reservation", func() {
+		now := time.Now()
+		reservation := forgeReservation("reservation-future", now.Add(time.Hour), now.Add(2*time.Hour), crownlabsv1alpha2.ReservationEndActionDelete)
+		Expect(k8sClient.Create(ctx, reservation)).Should(Succeed())
+
+		Eventually(reservationPhase(reservation.Name), timeout, interval).Should(Equal(crownlabsv1alpha2.ReservationScheduled))
+		Consistently(func() bool {
+			err := k8sClient.Get(ctx, types.NamespacedName{Name: reservation.Name, Namespace: ReservationNamespace}, &crownlabsv1alpha2.Instance{})
+			return err == nil
+		}, time.Second*2, interval).Should(BeFalse())
+	})
+
+	It("Should create the instance during the reservation, and delete it at the end", func() {
+		now := time.Now()
+		reservation := forgeReservation("reservation-active", now.Add(-time.Minute), now.Add(5*time.Second), crownlabsv1alpha2.ReservationEndActionDelete)
+		Expect(k8sClient.Create(ctx, reservation)).Should(Succeed())
+
+		By("Creating the reserved instance")
+		var instance crownlabsv1alpha2.Instance
+		instanceLookupKey := types.NamespacedName{Name: reservation.Name, Namespace: ReservationNamespace}
+		doesEventuallyExist(ctx, instanceLookupKey, &instance, BeTrue(), timeout, interval)
+		Expect(instance.Spec.Running).Should(BeTrue())
+		Expect(instance.Spec.Template.Name).Should(Equal(TemplateName))
+		Expect(instance.Spec.Tenant.Name).Should(Equal(TenantName))
+		Expect(instance.OwnerReferences).Should(HaveLen(1))
+		Expect(instance.OwnerReferences[0].Kind).Should(Equal("InstanceReservation"))
+		Expect(instance.OwnerReferences[0].Name).Should(Equal(reservation.Name))
+
+		By("Deleting the reserved instance at the end")
+		Eventually(reservationPhase(reservation.Name), timeout, interval).Should(Equal(crownlabsv1alpha2.ReservationCompleted))
+		Eventually(func() bool {
+			err := k8sClient.Get(ctx, instanceLookupKey, &instance)
+			return err != nil || !instance.DeletionTimestamp.IsZero()
+		}, timeout, interval).Should(BeTrue())
+	})
+
+	It("Should stop the instance at the end of the reservation if requested", func() {
+		now := time.Now()
+		reservation := forgeReservation("reservation-stop", now.Add(-time.Minute), now.Add(5*time.Second), crownlabsv1alpha2.ReservationEndActionStop)
+		Expect(k8sClient.Create(ctx, reservation)).Should(Succeed())
+
+		var instance crownlabsv1alpha2.Instance
+		instanceLookupKey := types.NamespacedName{Name: reservation.Name, Namespace: ReservationNamespace}
+		doesEventuallyExist(ctx, instanceLookupKey, &instance, BeTrue(), timeout, interval)
+		Expect(instance.Spec.Running).Should(BeTrue())
+
+		Eventually(reservationPhase(reservation.Name), timeout, interval).Should(Equal(crownlabsv1alpha2.ReservationCompleted))
+		Expect(k8sClient.Get(ctx, instanceLookupKey, &instance)).Should(Succeed())
+		Expect(instance.Spec.Running).Should(BeFalse())
+	})
+
+	It("Should neither start nor delete an already existing instance not created by the reservation", func() {
+		now := time.Now()
+		instance := crownlabsv1alpha2.Instance{
+			ObjectMeta: metav1.ObjectMeta{Name: "reservation-conflict", Namespace: ReservationNamespace},
+			Spec: crownlabsv1alpha2.InstanceSpec{
+				Template: crownlabsv1alpha2.GenericRef{Name: TemplateName, Namespace: ReservationNamespace},
+				Tenant:   crownlabsv1alpha2.GenericRef{Name: TenantName},
+				Running:  false,
+			},
+		}
+		Expect(k8sClient.Create(ctx, &instance)).Should(Succeed())
+
+		reservation := forgeReservation(instance.Name, now.Add(-time.Minute), now.Add(time.Hour), crownlabsv1alpha2.ReservationEndActionDelete)
+		Expect(k8sClient.Create(ctx, reservation)).Should(Succeed())
+
+		Eventually(reservationPhase(reservation.Name), timeout, interval).Should(Equal(crownlabsv1alpha2.ReservationFailed))
+		instanceLookupKey := types.NamespacedName{Name: instance.Name, Namespace: ReservationNamespace}
+		Expect(k8sClient.Get(ctx, instanceLookupKey, &instance)).Should(Succeed())
+		Expect(instance.Spec.Running).Should(BeFalse())
+		Expect(instance.OwnerReferences).Should(BeEmpty())
+		Expect(instance.DeletionTimestamp.IsZero()).Should(BeTrue())
+	})
+})
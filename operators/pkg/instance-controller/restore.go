@@ -0,0 +1,85 @@
+package instance_controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	crownlabsv1alpha2 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha2"
+	instancesnapshot_controller "github.com/netgroup-polito/CrownLabs/operators/pkg/instancesnapshot-controller"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/utils"
+)
+
+// applyInstanceSnapshot replaces, in the given Template, the image of the snapshotted environment with the one
+// pushed by the InstanceSnapshot the Instance is restored from, after checking the Instance is allowed to access it.
+func (r *InstanceReconciler) applyInstanceSnapshot(ctx context.Context, instance *crownlabsv1alpha2.Instance, template *crownlabsv1alpha2.Template) error {
+	snapshotName := types.NamespacedName{Namespace: instance.Spec.SnapshotRef.Namespace, Name: instance.Spec.SnapshotRef.Name}
+	if snapshotName.Namespace == "" {
+		snapshotName.Namespace = instance.Namespace
+	}
+
+	var isnap crownlabsv1alpha2.InstanceSnapshot
+	if err := r.Get(ctx, snapshotName, &isnap); err != nil {
+		return fmt.Errorf("error in retrieving the InstanceSnapshot %s/%s -> %w", snapshotName.Namespace, snapshotName.Name, err)
+	}
+
+	if isnap.Status.Phase != crownlabsv1alpha2.Completed {
+		return fmt.Errorf("the InstanceSnapshot %s/%s is not completed", isnap.Namespace, isnap.Name)
+	}
+	// The snapshots created before the introduction of the backends do not report it, and they are all images.
+	if isnap.Status.Backend != "" && isnap.Status.Backend != crownlabsv1alpha2.SnapshotBackendRegistry {
+		return fmt.Errorf("the InstanceSnapshot %s/%s is stored in the %s backend, but restore is only supported for registry snapshots",
+			isnap.Namespace, isnap.Name, isnap.Status.Backend)
+	}
+	if isnap.Status.Image == "" {
+		return fmt.Errorf("the InstanceSnapshot %s/%s does not report the image it was pushed to", isnap.Namespace, isnap.Name)
+	}
+	if isnap.Spec.ContainerFormat == crownlabsv1alpha2.ContainerSnapshotArchive {
+		return fmt.Errorf("the InstanceSnapshot %s/%s is an archive, and it cannot be restored", isnap.Namespace, isnap.Name)
+	}
+
+	if snapshotName.Namespace != instance.Namespace {
+		if err := r.checkSnapshotCloneAllowed(ctx, instance, template, &isnap); err != nil {
+			return err
+		}
+	}
+
+	env, err := instancesnapshot_controller.GetSnapshotEnvironment(&isnap, template)
+	if err != nil {
+		return err
+	}
+	env.Image = isnap.Status.Image
+	// The images of incremental snapshots contain the chain of overlays, which needs to be flattened when imported.
+	env.IncrementalImage = isnap.Spec.Mode == crownlabsv1alpha2.SnapshotModeIncremental
+	return nil
+}
+
+// checkSnapshotCloneAllowed checks whether the Instance can be cloned from the InstanceSnapshot of another tenant,
+// which requires the tenant owning the namespace of the Instance to be a manager of the workspace both the Instances belong to.
+func (r *InstanceReconciler) checkSnapshotCloneAllowed(ctx context.Context, instance *crownlabsv1alpha2.Instance,
+	template *crownlabsv1alpha2.Template, isnap *crownlabsv1alpha2.InstanceSnapshot) error {
+	// The tenant is derived from the namespace of the instance, since the one in the spec is set by the tenant itself.
+	tenant, err := utils.GetNamespaceTenant(ctx, r.Client, instance.Namespace)
+	if err != nil {
+		return err
+	}
+	workspace := template.Spec.WorkspaceRef.Name
+	if tenant.Labels[crownlabsv1alpha1.WorkspaceLabelPrefix+workspace] != string(crownlabsv1alpha1.Manager) {
+		return fmt.Errorf("tenant %s is not a manager of workspace %s, and it cannot clone InstanceSnapshot %s/%s",
+			tenant.Name, workspace, isnap.Namespace, isnap.Name)
+	}
+
+	// The snapshotted instance is required to verify it belongs to the same workspace.
+	var source crownlabsv1alpha2.Instance
+	sourceName := types.NamespacedName{Namespace: isnap.Spec.Instance.Namespace, Name: isnap.Spec.Instance.Name}
+	if err := r.Get(ctx, sourceName, &source); err != nil {
+		return fmt.Errorf("error in retrieving the instance of InstanceSnapshot %s/%s -> %w", isnap.Namespace, isnap.Name, err)
+	}
+	if source.Labels["crownlabs.polito.it/workspace"] != strings.ReplaceAll(workspace, ".", "-") {
+		return fmt.Errorf("the InstanceSnapshot %s/%s does not belong to workspace %s", isnap.Namespace, isnap.Name, workspace)
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package instance_controller
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	cdiv1 "kubevirt.io/containerized-data-importer/pkg/apis/core/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	crownlabsv1alpha2 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha2"
+)
+
+var _ = Describe("Instances restored from snapshots", func() {
+
+	const (
+		RestoreNamespace = "restore-namespace"
+		OtherNamespace   = "restore-other-namespace"
+		TemplateName     = "restore-template"
+		TenantName       = "restore.tenant"
+		SnapshotName     = "restore-snapshot"
+		SnapshotImage    = "registry.example.com/restore-tenant/snapshot:20210101t000000"
+
+		timeout  = time.Second * 20
+		interval = time.Millisecond * 500
+	)
+
+	var ctx context.Context
+
+	forgeSnapshot := func(name, namespace string) *crownlabsv1alpha2.InstanceSnapshot {
+		return &crownlabsv1alpha2.InstanceSnapshot{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec: crownlabsv1alpha2.InstanceSnapshotSpec{
+				Instance:  crownlabsv1alpha2.GenericRef{Name: "source-instance", Namespace: namespace},
+				ImageName: "snapshot",
+			},
+		}
+	}
+
+	forgeInstance := func(name, namespace string, snapshot crownlabsv1alpha2.GenericRef) *crownlabsv1alpha2.Instance {
+		return &crownlabsv1alpha2.Instance{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec: crownlabsv1alpha2.InstanceSpec{
+				Template:    crownlabsv1alpha2.GenericRef{Name: TemplateName, Namespace: RestoreNamespace},
+				Tenant:      crownlabsv1alpha2.GenericRef{Name: TenantName},
+				SnapshotRef: &snapshot,
+			},
+		}
+	}
+
+	It("Setting up the namespaces, the template and the snapshots", func() {
+		ctx = context.Background()
+		for _, ns := range []string{RestoreNamespace, OtherNamespace} {
+			Expect(k8sClient.Create(ctx, &v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+				Name:   ns,
+				Labels: map[string]string{"production": "true", "test-suite": "true"},
+			}})).Should(Succeed())
+		}
+
+		Expect(k8sClient.Create(ctx, &crownlabsv1alpha1.Tenant{
+			ObjectMeta: metav1.ObjectMeta{Name: TenantName},
+			Spec:       crownlabsv1alpha1.TenantSpec{FirstName: "Mario", LastName: "Rossi", Email: "mario@rossi.com"},
+		})).Should(Succeed())
+
+		Expect(k8sClient.Create(ctx, &crownlabsv1alpha2.Template{
+			ObjectMeta: metav1.ObjectMeta{Name: TemplateName, Namespace: RestoreNamespace},
+			Spec: crownlabsv1alpha2.TemplateSpec{
+				WorkspaceRef: crownlabsv1alpha2.GenericRef{Name: "restore-ws"},
+				PrettyName:   "Restore Template",
+				Description:  "A description",
+				EnvironmentList: []crownlabsv1alpha2.Environment{{
+					Name:  "Test",
+					Image: "crownlabs/vm",
+					Resources: crownlabsv1alpha2.EnvironmentResources{
+						CPU:                   1,
+						ReservedCPUPercentage: 1,
+						Memory:                resource.MustParse("1024M"),
+						Disk:                  resource.MustParse("10Gi"),
+					},
+					EnvironmentType: crownlabsv1alpha2.ClassVM,
+					Persistent:      true,
+				}},
+			},
+		})).Should(Succeed())
+
+		By("Creating a completed snapshot in both namespaces, and a pending one")
+		for _, ns := range []string{RestoreNamespace, OtherNamespace} {
+			isnap := forgeSnapshot(SnapshotName, ns)
+			Expect(k8sClient.Create(ctx, isnap)).Should(Succeed())
+			isnap.Status = crownlabsv1alpha2.InstanceSnapshotStatus{Phase: crownlabsv1alpha2.Completed, Image: SnapshotImage}
+			Expect(k8sClient.Status().Update(ctx, isnap)).Should(Succeed())
+		}
+		Expect(k8sClient.Create(ctx, forgeSnapshot(SnapshotName+"-pending", RestoreNamespace))).Should(Succeed())
+	})
+
+	It("Should import the disk of the instance from the snapshot image", func() {
+		Expect(k8sClient.Create(ctx, forgeInstance("restored", RestoreNamespace,
+			crownlabsv1alpha2.GenericRef{Name: SnapshotName}))).Should(Succeed())
+
+		var datavol cdiv1.DataVolume
+		doesEventuallyExist(ctx, types.NamespacedName{Name: "restored", Namespace: RestoreNamespace}, &datavol, BeTrue(), timeout, interval)
+		Expect(datavol.Spec.Source.Registry).ShouldNot(BeNil())
+		Expect(datavol.Spec.Source.Registry.URL).Should(Equal("docker://" + SnapshotImage))
+	})
+
+	It("Should not restore an instance from a snapshot not yet completed", func() {
+		Expect(k8sClient.Create(ctx, forgeInstance("restored-pending", RestoreNamespace,
+			crownlabsv1alpha2.GenericRef{Name: SnapshotName + "-pending"}))).Should(Succeed())
+
+		Consistently(func() bool {
+			err := k8sClient.Get(ctx, types.NamespacedName{Name: "restored-pending", Namespace: RestoreNamespace}, &cdiv1.DataVolume{})
+			return err == nil
+		}, time.Second*2, interval).Should(BeFalse())
+	})
+
+	It("Should not clone the snapshot of another tenant if not a manager", func() {
+		Expect(k8sClient.Create(ctx, forgeInstance("cloned", RestoreNamespace,
+			crownlabsv1alpha2.GenericRef{Name: SnapshotName, Namespace: OtherNamespace}))).Should(Succeed())
+
+		Consistently(func() bool {
+			err := k8sClient.Get(ctx, types.NamespacedName{Name: "cloned", Namespace: RestoreNamespace}, &cdiv1.DataVolume{})
+			return err == nil
+		}, time.Second*2, interval).Should(BeFalse())
+	})
+})
+
+var _ = Describe("Instances restored from snapshots of other backends", func() {
+	var (
+		ctx        context.Context
+		reconciler InstanceReconciler
+		instance   crownlabsv1alpha2.Instance
+		template   crownlabsv1alpha2.Template
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(crownlabsv1alpha2.AddToScheme(scheme)).To(Succeed())
+
+		isnap := crownlabsv1alpha2.InstanceSnapshot{
+			ObjectMeta: metav1.ObjectMeta{Name: "snapshot", Namespace: "tenant-john-doe"},
+			Status: crownlabsv1alpha2.InstanceSnapshotStatus{
+				Phase:    crownlabsv1alpha2.Completed,
+				Backend:  crownlabsv1alpha2.SnapshotBackendS3,
+				Location: "s3://snapshots/johndoe/snapshot/20210101t000000.qcow2",
+			},
+		}
+		reconciler = InstanceReconciler{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(&isnap).Build(), Scheme: scheme}
+
+		instance = crownlabsv1alpha2.Instance{
+			ObjectMeta: metav1.ObjectMeta{Name: "instance", Namespace: "tenant-john-doe"},
+			Spec:       crownlabsv1alpha2.InstanceSpec{SnapshotRef: &crownlabsv1alpha2.GenericRef{Name: "snapshot"}},
+		}
+		template = crownlabsv1alpha2.Template{Spec: crownlabsv1alpha2.TemplateSpec{
+			EnvironmentList: []crownlabsv1alpha2.Environment{{Name: "vm", Image: "crownlabs/vm", EnvironmentType: crownlabsv1alpha2.ClassVM}},
+		}}
+	})
+
+	It("Should report that only the registry snapshots can be restored", func() {
+		err := reconciler.applyInstanceSnapshot(ctx, &instance, &template)
+		Expect(err).To(MatchError(ContainSubstring("restore is only supported for registry snapshots")))
+		Expect(template.Spec.EnvironmentList[0].Image).To(Equal("crownlabs/vm"))
+	})
+})
@@ -0,0 +1,124 @@
+package instance_controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	cdiv1 "kubevirt.io/containerized-data-importer/pkg/apis/core/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	crownlabsv1alpha2 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha2"
+	instance_creation "github.com/netgroup-polito/CrownLabs/operators/pkg/instance-creation"
+)
+
+// rolloutAction represents how the current revision of the Template is applied to the resources of an Instance.
+type rolloutAction int
+
+const (
+	// rolloutSkip -> the existing resources are left untouched (new ones are created from the current revision).
+	rolloutSkip rolloutAction = iota
+	// rolloutApply -> the existing resources are updated, and the changes take effect when they are restarted.
+	rolloutApply
+	// rolloutRestart -> the existing resources are updated and restarted, for the changes to take effect immediately.
+	rolloutRestart
+)
+
+// templateRevision returns the revision of the given Template, computed as the hash of its environments.
+// Hence, the changes not affecting the environments (e.g. the description) do not modify the revision.
+func templateRevision(template *crownlabsv1alpha2.Template) string {
+	// The marshaling of a slice of structs cannot fail.
+	data, _ := json.Marshal(template.Spec.EnvironmentList)
+	return fmt.Sprintf("%x", sha256.Sum256(data))[:10]
+}
+
+// templateRolloutAction returns the action to be performed on the resources of the given Instance,
+// depending on the revision it was generated from and on the rollout policy of the Template.
+func templateRolloutAction(instance *crownlabsv1alpha2.Instance, template *crownlabsv1alpha2.Template, revision string) rolloutAction {
+	// Instances never reconciled before, or already up-to-date.
+	if instance.Status.TemplateRevision == "" || instance.Status.TemplateRevision == revision {
+		return rolloutApply
+	}
+
+	switch template.Spec.RolloutPolicy {
+	case crownlabsv1alpha2.RolloutNever:
+		return rolloutSkip
+	case crownlabsv1alpha2.RolloutImmediate:
+		return rolloutRestart
+	default:
+		if instance.Spec.Running {
+			return rolloutSkip
+		}
+		return rolloutApply
+	}
+}
+
+// persistentImageChanged returns whether the image of any persistent VM environment of the given Template differs from the one
+// the existing DataVolume of the Instance has been imported from. Since the disk of the VM is never imported again, not to lose
+// the data of the Tenant, such changes cannot be rolled out to the existing Instances.
+func (r *InstanceReconciler) persistentImageChanged(ctx context.Context, instance *crownlabsv1alpha2.Instance, template *crownlabsv1alpha2.Template) (bool, error) {
+	name := strings.ReplaceAll(instance.Name, ".", "-")
+	for i := range template.Spec.EnvironmentList {
+		environment := &template.Spec.EnvironmentList[i]
+		if environment.EnvironmentType != crownlabsv1alpha2.ClassVM || !environment.Persistent {
+			continue
+		}
+
+		var dv cdiv1.DataVolume
+		if err := r.Get(ctx, types.NamespacedName{Namespace: instance.Namespace, Name: name}, &dv); err != nil {
+			if client.IgnoreNotFound(err) != nil {
+				return false, fmt.Errorf("error when retrieving the DataVolume of instance %s/%s -> %w", instance.Namespace, instance.Name, err)
+			}
+			continue
+		}
+
+		var desired cdiv1.DataVolume
+		instance_creation.UpdateDataVolumeSpec(&desired, environment)
+		if !equality.Semantic.DeepEqual(desired.Spec.Source, dv.Spec.Source) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// setInstanceTemplateRevision records in the status of the Instance the revision of the Template its resources have been generated from.
+func (r *InstanceReconciler) setInstanceTemplateRevision(ctx context.Context, instance *crownlabsv1alpha2.Instance, revision string) {
+	revisionInstance := instance.DeepCopy()
+	revisionInstance.Status.TemplateRevision = revision
+	if err := r.Status().Patch(ctx, revisionInstance, client.MergeFrom(instance)); err != nil {
+		klog.Errorf("Unable to update the template revision of instance %s/%s -> %s", instance.Namespace, instance.Name, err)
+		return
+	}
+	klog.Infof("Instance %s/%s updated to template revision %s", instance.Namespace, instance.Name, revision)
+}
+
+// templateToInstances returns the requests for the Instances referencing the given Template, in case
+// its rollout policy requires the changes to be applied immediately.
+func (r *InstanceReconciler) templateToInstances(object client.Object) []reconcile.Request {
+	template, ok := object.(*crownlabsv1alpha2.Template)
+	if !ok || template.Spec.RolloutPolicy != crownlabsv1alpha2.RolloutImmediate {
+		return nil
+	}
+
+	var instances crownlabsv1alpha2.InstanceList
+	if err := r.List(context.Background(), &instances, client.MatchingLabels{"crownlabs.polito.it/template": template.Name}); err != nil {
+		klog.Errorf("Error when listing the instances of template %s/%s -> %s", template.Namespace, template.Name, err)
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for i := range instances.Items {
+		if instances.Items[i].Spec.Template.Namespace == template.Namespace {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: instances.Items[i].Name, Namespace: instances.Items[i].Namespace},
+			})
+		}
+	}
+	return requests
+}
@@ -0,0 +1,97 @@
+package instance_controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	cdiv1 "kubevirt.io/containerized-data-importer/pkg/apis/core/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	crownlabsv1alpha2 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha2"
+	instance_creation "github.com/netgroup-polito/CrownLabs/operators/pkg/instance-creation"
+)
+
+var _ = Describe("Template rollout", func() {
+	var template crownlabsv1alpha2.Template
+
+	BeforeEach(func() {
+		template = crownlabsv1alpha2.Template{
+			Spec: crownlabsv1alpha2.TemplateSpec{
+				PrettyName:  "Rollout Template",
+				Description: "A description",
+				EnvironmentList: []crownlabsv1alpha2.Environment{{
+					Name:            "Test",
+					Image:           "crownlabs/vm:v1",
+					EnvironmentType: crownlabsv1alpha2.ClassVM,
+					Resources: crownlabsv1alpha2.EnvironmentResources{
+						CPU:                   1,
+						ReservedCPUPercentage: 1,
+						Memory:                resource.MustParse("1024M"),
+					},
+				}},
+			},
+		}
+	})
+
+	It("Should compute a revision depending only on the environments", func() {
+		revision := templateRevision(&template)
+		Expect(revision).ShouldNot(BeEmpty())
+
+		template.Spec.Description = "Another description"
+		Expect(templateRevision(&template)).Should(Equal(revision))
+
+		template.Spec.EnvironmentList[0].Image = "crownlabs/vm:v2"
+		Expect(templateRevision(&template)).ShouldNot(Equal(revision))
+	})
+
+	DescribeTable("Should select the rollout action according to the policy",
+		func(recorded string, running bool, policy crownlabsv1alpha2.RolloutPolicy, expected rolloutAction) {
+			template.Spec.RolloutPolicy = policy
+			instance := crownlabsv1alpha2.Instance{
+				Spec:   crownlabsv1alpha2.InstanceSpec{Running: running},
+				Status: crownlabsv1alpha2.InstanceStatus{TemplateRevision: recorded},
+			}
+			Expect(templateRolloutAction(&instance, &template, "current")).Should(Equal(expected))
+		},
+		Entry("New instance", "", true, crownlabsv1alpha2.RolloutNever, rolloutApply),
+		Entry("Up-to-date instance", "current", true, crownlabsv1alpha2.RolloutNever, rolloutApply),
+		Entry("Outdated instance, never policy", "old", false, crownlabsv1alpha2.RolloutNever, rolloutSkip),
+		Entry("Outdated running instance, on restart policy", "old", true, crownlabsv1alpha2.RolloutOnRestart, rolloutSkip),
+		Entry("Outdated stopped instance, on restart policy", "old", false, crownlabsv1alpha2.RolloutOnRestart, rolloutApply),
+		Entry("Outdated instance, immediate policy", "old", true, crownlabsv1alpha2.RolloutImmediate, rolloutRestart),
+	)
+
+	Context("Persistent environments", func() {
+		var (
+			instance   crownlabsv1alpha2.Instance
+			reconciler InstanceReconciler
+		)
+
+		BeforeEach(func() {
+			template.Spec.EnvironmentList[0].Persistent = true
+			instance = crownlabsv1alpha2.Instance{ObjectMeta: metav1.ObjectMeta{Name: "rollout.instance", Namespace: "tenant-john-doe"}}
+
+			dv := cdiv1.DataVolume{ObjectMeta: metav1.ObjectMeta{Name: "rollout-instance", Namespace: instance.Namespace}}
+			instance_creation.UpdateDataVolumeSpec(&dv, &template.Spec.EnvironmentList[0])
+
+			scheme := runtime.NewScheme()
+			Expect(cdiv1.AddToScheme(scheme)).To(Succeed())
+			reconciler = InstanceReconciler{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(&dv).Build()}
+		})
+
+		It("Should accept the changes not affecting the image of the disk", func() {
+			template.Spec.EnvironmentList[0].Resources.CPU = 2
+			Expect(reconciler.persistentImageChanged(context.Background(), &instance, &template)).To(BeFalse())
+		})
+
+		It("Should detect the changes of the image, which cannot be rolled out", func() {
+			template.Spec.EnvironmentList[0].Image = "crownlabs/vm:v2"
+			Expect(reconciler.persistentImageChanged(context.Background(), &instance, &template)).To(BeTrue())
+		})
+	})
+})
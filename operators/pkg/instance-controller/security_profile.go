@@ -0,0 +1,88 @@
+package instance_controller
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	crownlabsv1alpha2 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha2"
+)
+
+// getSecurityProfile retrieves the SecurityProfile selected by the given environment (nil if none),
+// verifying that it can be used by the Templates of the given workspace.
+func (r *InstanceReconciler) getSecurityProfile(ctx context.Context, environment *crownlabsv1alpha2.Environment,
+	workspace string) (*crownlabsv1alpha2.SecurityProfile, error) {
+	if environment.SecurityProfile == "" {
+		return nil, nil
+	}
+
+	profile := crownlabsv1alpha2.SecurityProfile{}
+	if err := r.Get(ctx, types.NamespacedName{Name: environment.SecurityProfile}, &profile); err != nil {
+		return nil, fmt.Errorf("error when retrieving the security profile %s -> %w", environment.SecurityProfile, err)
+	}
+
+	if !securityProfileAllowed(&profile, workspace) {
+		return nil, fmt.Errorf("the security profile %s is not allowed for workspace %s", profile.Name, workspace)
+	}
+	return &profile, nil
+}
+
+// securityProfileAllowed returns whether the SecurityProfile can be used by the Templates of the given workspace.
+func securityProfileAllowed(profile *crownlabsv1alpha2.SecurityProfile, workspace string) bool {
+	for _, allowed := range profile.Spec.AllowedWorkspaces {
+		if allowed == workspace {
+			return true
+		}
+	}
+	return false
+}
+
+// checkVMHardwareAllowed verifies that the privileged hardware options of the given VM environment (i.e. the
+// dedicated CPU placement and the hugepages, which reserve resources of the nodes) are allowed by its SecurityProfile.
+func checkVMHardwareAllowed(environment *crownlabsv1alpha2.Environment, profile *crownlabsv1alpha2.SecurityProfile) error {
+	hardware := environment.Hardware
+	if hardware == nil {
+		return nil
+	}
+
+	if hardware.DedicatedCPUPlacement && (profile == nil || !profile.Spec.AllowDedicatedCPUPlacement) {
+		return fmt.Errorf("the dedicated CPU placement is not allowed by the security profile of environment %s", environment.Name)
+	}
+	if hardware.HugepagesSize != "" && (profile == nil || !profile.Spec.AllowHugepages) {
+		return fmt.Errorf("the hugepages are not allowed by the security profile of environment %s", environment.Name)
+	}
+	return nil
+}
+
+// applySecurityProfile configures the security context of a container according to the given SecurityProfile.
+// The capabilities of the profile are added, while all the other ones remain dropped.
+func applySecurityProfile(secCtx *v1.SecurityContext, profile *crownlabsv1alpha2.SecurityProfile) {
+	if profile == nil {
+		return
+	}
+
+	if secCtx.Capabilities == nil {
+		secCtx.Capabilities = &v1.Capabilities{}
+	}
+	secCtx.Capabilities.Add = append([]v1.Capability(nil), profile.Spec.Capabilities...)
+
+	allowPrivilegeEscalation := profile.Spec.AllowPrivilegeEscalation
+	secCtx.AllowPrivilegeEscalation = &allowPrivilegeEscalation
+
+	if profile.Spec.RunAsUser != nil {
+		runAsUser := *profile.Spec.RunAsUser
+		// The pod-level constraint is overridden, since the profile may require to run as root.
+		runAsNonRoot := runAsUser != 0
+		secCtx.RunAsUser = &runAsUser
+		secCtx.RunAsNonRoot = &runAsNonRoot
+	}
+	if profile.Spec.RunAsGroup != nil {
+		runAsGroup := *profile.Spec.RunAsGroup
+		secCtx.RunAsGroup = &runAsGroup
+	}
+	if profile.Spec.SeccompProfile != nil {
+		secCtx.SeccompProfile = profile.Spec.SeccompProfile.DeepCopy()
+	}
+}
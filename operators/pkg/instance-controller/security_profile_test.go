@@ -0,0 +1,86 @@
+package instance_controller
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/utils/pointer"
+
+	crownlabsv1alpha2 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha2"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/utils"
+)
+
+var _ = Describe("Security profiles", func() {
+	var (
+		profile crownlabsv1alpha2.SecurityProfile
+		secCtx  v1.SecurityContext
+	)
+
+	BeforeEach(func() {
+		profile = crownlabsv1alpha2.SecurityProfile{
+			Spec: crownlabsv1alpha2.SecurityProfileSpec{
+				AllowedWorkspaces: []string{"networking"},
+				Capabilities:      []v1.Capability{"NET_ADMIN", "NET_RAW"},
+			},
+		}
+		secCtx = v1.SecurityContext{
+			Capabilities:             &v1.Capabilities{Drop: []v1.Capability{"ALL"}},
+			AllowPrivilegeEscalation: pointer.BoolPtr(false),
+		}
+	})
+
+	It("Should be allowed only for the listed workspaces", func() {
+		Expect(securityProfileAllowed(&profile, "networking")).To(BeTrue())
+		Expect(securityProfileAllowed(&profile, "other")).To(BeFalse())
+	})
+
+	It("Should derive the workspace from the namespace of the template", func() {
+		Expect(securityProfileAllowed(&profile, utils.NamespaceWorkspaceName("workspace-networking"))).To(BeTrue())
+		Expect(securityProfileAllowed(&profile, utils.NamespaceWorkspaceName("tenant-networking"))).To(BeFalse())
+		Expect(utils.NamespaceWorkspaceName("networking")).To(BeEmpty())
+	})
+
+	It("Should allow the dedicated CPUs and the hugepages only if granted by the profile", func() {
+		environment := crownlabsv1alpha2.Environment{Name: "vm", Hardware: &crownlabsv1alpha2.VMHardware{
+			DedicatedCPUPlacement: true,
+			HugepagesSize:         crownlabsv1alpha2.Hugepages2Mi,
+		}}
+		Expect(checkVMHardwareAllowed(&environment, nil)).ToNot(Succeed())
+		Expect(checkVMHardwareAllowed(&environment, &profile)).ToNot(Succeed())
+
+		profile.Spec.AllowDedicatedCPUPlacement = true
+		Expect(checkVMHardwareAllowed(&environment, &profile)).ToNot(Succeed())
+		profile.Spec.AllowHugepages = true
+		Expect(checkVMHardwareAllowed(&environment, &profile)).To(Succeed())
+
+		environment.Hardware = &crownlabsv1alpha2.VMHardware{TabletInput: true}
+		Expect(checkVMHardwareAllowed(&environment, nil)).To(Succeed())
+	})
+
+	It("Should leave the security context untouched if no profile is selected", func() {
+		expected := *secCtx.DeepCopy()
+		applySecurityProfile(&secCtx, nil)
+		Expect(secCtx).To(Equal(expected))
+	})
+
+	It("Should add the capabilities of the profile, keeping all the other ones dropped", func() {
+		applySecurityProfile(&secCtx, &profile)
+		Expect(secCtx.Capabilities.Drop).To(ConsistOf(v1.Capability("ALL")))
+		Expect(secCtx.Capabilities.Add).To(ConsistOf(v1.Capability("NET_ADMIN"), v1.Capability("NET_RAW")))
+		Expect(*secCtx.AllowPrivilegeEscalation).To(BeFalse())
+		Expect(secCtx.RunAsUser).To(BeNil())
+		Expect(secCtx.SeccompProfile).To(BeNil())
+	})
+
+	It("Should apply the user, group and seccomp profile", func() {
+		profile.Spec.RunAsUser = pointer.Int64Ptr(0)
+		profile.Spec.RunAsGroup = pointer.Int64Ptr(2000)
+		profile.Spec.SeccompProfile = &v1.SeccompProfile{Type: v1.SeccompProfileTypeRuntimeDefault}
+
+		applySecurityProfile(&secCtx, &profile)
+		Expect(*secCtx.RunAsUser).To(BeNumerically("==", 0))
+		Expect(*secCtx.RunAsNonRoot).To(BeFalse())
+		Expect(*secCtx.RunAsGroup).To(BeNumerically("==", 2000))
+		Expect(secCtx.SeccompProfile.Type).To(Equal(v1.SeccompProfileTypeRuntimeDefault))
+	})
+})
@@ -3,7 +3,6 @@ package instance_controller
 import (
 	"context"
 	"fmt"
-	"net"
 	"time"
 
 	v1 "k8s.io/api/core/v1"
@@ -16,7 +15,7 @@ import (
 )
 
 func (r *InstanceReconciler) getVmiStatus(ctx context.Context,
-	guiEnabled bool, service *v1.Service, ingress *networkingv1.Ingress,
+	environment *crownlabsv1alpha2.Environment, service *v1.Service, ingress *networkingv1.Ingress,
 	instance *crownlabsv1alpha2.Instance, vmi *virtv1.VirtualMachineInstance, startTimeVM time.Time) {
 	var vmStatus virtv1.VirtualMachineInstancePhase
 	var ip string
@@ -50,18 +49,17 @@ func (r *InstanceReconciler) getVmiStatus(ctx context.Context,
 	}
 
 	// when the vm status is Running, it is still not available for some seconds
-	// hence, wait until it starts responding
-	host := service.Name + "." + service.Namespace
-	port := "6080" // VNC
-	if !guiEnabled {
-		port = "22" // SSH
-	}
-
-	err := waitForConnection(host, port)
+	// hence, wait until the readiness check succeeds
+	probe, description := r.vmReadinessProbe(environment, service, vmi)
+	err := waitForReadiness(ctx, probe, description, getReadinessTimings(environment.ReadinessCheck))
 	if err != nil {
-		klog.Error(fmt.Sprintf("Unable to check whether %v:%v is reachable", host, port))
+		klog.Error(fmt.Sprintf("Unable to check whether %v is ready", description))
 		klog.Error(err)
 	} else {
+		// the IP address may have been assigned after the VMI started running, hence retrieve the current one
+		if err := r.Client.Get(ctx, types.NamespacedName{Namespace: vmi.Namespace, Name: vmi.Name}, vmi); err == nil && len(vmi.Status.Interfaces) > 0 {
+			ip = vmi.Status.Interfaces[0].IP
+		}
 		msg := "VirtualMachineInstance " + vmi.Name + " in namespace " + vmi.Namespace + " status update to VmiReady."
 		r.setInstanceStatus(ctx, msg, "Normal", "VmiReady", instance, ip, url)
 		readyTime := time.Now()
@@ -69,20 +67,3 @@ func (r *InstanceReconciler) getVmiStatus(ctx context.Context,
 		bootTimes.Observe(bootTime.Seconds())
 	}
 }
-
-func waitForConnection(host, port string) error {
-	for retries := 0; retries < 120; retries++ {
-		timeout := time.Second
-		conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, port), timeout)
-		if err != nil {
-			klog.Info(fmt.Sprintf("Unable to check whether %v:%v is reachable: %v", host, port, err))
-			time.Sleep(time.Second)
-		} else {
-			// The connection succeeded, hence the VM is ready
-			defer conn.Close()
-			return nil
-		}
-	}
-
-	return fmt.Errorf("timeout while checking whether %v:%v is reachable", host, port)
-}
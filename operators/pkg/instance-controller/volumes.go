@@ -0,0 +1,167 @@
+package instance_controller
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+	ctrlUtil "sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	crownlabsv1alpha2 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha2"
+	instance_creation "github.com/netgroup-polito/CrownLabs/operators/pkg/instance-creation"
+)
+
+// enforceAdditionalVolumes creates the PVCs backing the Persistent and Dataset volumes of the environment
+// in the namespace of the instance. Scratch volumes do not require any additional resource.
+func (r *InstanceReconciler) enforceAdditionalVolumes(ctx context.Context, instance *crownlabsv1alpha2.Instance,
+	environment *crownlabsv1alpha2.Environment) error {
+	for i := range environment.Volumes {
+		volume := &environment.Volumes[i]
+		switch volume.Type {
+		case crownlabsv1alpha2.VolumePersistent:
+			if err := r.enforcePersistentVolume(ctx, instance, volume); err != nil {
+				return err
+			}
+		case crownlabsv1alpha2.VolumeDataset:
+			if err := r.enforceDatasetVolume(ctx, instance, volume); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// enforcePersistentVolume creates the PVC backing a Persistent volume. The PVC is not owned by the
+// instance, hence it is preserved when the instance is deleted and reused by the following ones.
+func (r *InstanceReconciler) enforcePersistentVolume(ctx context.Context, instance *crownlabsv1alpha2.Instance,
+	volume *crownlabsv1alpha2.EnvironmentVolume) error {
+	pvc := v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{
+		Name:      instance_creation.AdditionalVolumeClaimName(&instance.Spec.Template, volume),
+		Namespace: instance.Namespace,
+	}}
+
+	op, err := ctrl.CreateOrUpdate(ctx, r.Client, &pvc, func() error {
+		// PVC's spec is immutable, it has to be set at creation
+		if pvc.ObjectMeta.CreationTimestamp.IsZero() {
+			pvc.Spec = v1.PersistentVolumeClaimSpec{
+				AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+				Resources: v1.ResourceRequirements{
+					Requests: v1.ResourceList{v1.ResourceStorage: volume.Size},
+				},
+			}
+		}
+		pvc.Labels = volumeLabels(pvc.Labels, instance)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error when creating the PVC of volume %s -> %w", volume.Name, err)
+	}
+	klog.Infof("PVC %s for instance %s/%s %s", pvc.Name, instance.GetNamespace(), instance.GetName(), op)
+	return nil
+}
+
+// enforceDatasetVolume exposes the dataset of a Dataset volume in the namespace of the instance. Since PVCs
+// cannot be mounted across namespaces, the PV bound to the original PVC (in the namespace of the template) is
+// mirrored by a read-only PV sharing the same storage, bound to a PVC in the namespace of the instance.
+// The latter is owned by all the instances using it, hence it is deleted together with the last one, while
+// the mirrored PV is then deleted by the DatasetVolumeReconciler. Only shared filesystems can be mirrored, and
+// only in case the tenant owning the namespace of the instance belongs to the workspace of the template.
+func (r *InstanceReconciler) enforceDatasetVolume(ctx context.Context, instance *crownlabsv1alpha2.Instance,
+	volume *crownlabsv1alpha2.EnvironmentVolume) error {
+	if err := r.checkTemplateResourcesAllowed(ctx, instance, "dataset "+volume.Name); err != nil {
+		return err
+	}
+
+	originalName := types.NamespacedName{Namespace: instance.Spec.Template.Namespace, Name: volume.ClaimName}
+	original := v1.PersistentVolumeClaim{}
+	if err := r.Get(ctx, originalName, &original); err != nil {
+		return fmt.Errorf("error when retrieving the PVC %s of dataset %s -> %w", originalName, volume.Name, err)
+	}
+	if original.Spec.VolumeName == "" {
+		return fmt.Errorf("the PVC %s of dataset %s is not bound", originalName, volume.Name)
+	}
+	originalPV := v1.PersistentVolume{}
+	if err := r.Get(ctx, types.NamespacedName{Name: original.Spec.VolumeName}, &originalPV); err != nil {
+		return fmt.Errorf("error when retrieving the PV of dataset %s -> %w", volume.Name, err)
+	}
+	if err := instance_creation.ValidateDatasetPersistentVolume(&originalPV); err != nil {
+		return fmt.Errorf("the storage of dataset %s cannot be shared -> %w", volume.Name, err)
+	}
+
+	pvc := v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{
+		Name:      instance_creation.AdditionalVolumeClaimName(&instance.Spec.Template, volume),
+		Namespace: instance.Namespace,
+	}}
+	pvName := instance_creation.DatasetPersistentVolumeName(pvc.Namespace, pvc.Name)
+
+	op, err := ctrl.CreateOrUpdate(ctx, r.Client, &pvc, func() error {
+		// PVC's spec is immutable, it has to be set at creation
+		if pvc.ObjectMeta.CreationTimestamp.IsZero() {
+			instance_creation.UpdateDatasetClaimSpec(&pvc, &originalPV, pvName)
+		}
+		pvc.Labels = volumeLabels(pvc.Labels, instance)
+		return ctrlUtil.SetOwnerReference(instance, &pvc, r.Scheme)
+	})
+	if err != nil {
+		return fmt.Errorf("error when creating the PVC of dataset %s -> %w", volume.Name, err)
+	}
+	klog.Infof("PVC %s for instance %s/%s %s", pvc.Name, instance.GetNamespace(), instance.GetName(), op)
+
+	pv := v1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: pvName}}
+	op, err = ctrl.CreateOrUpdate(ctx, r.Client, &pv, func() error {
+		// The volume source is immutable, hence it is set only at creation.
+		if pv.ObjectMeta.CreationTimestamp.IsZero() {
+			instance_creation.UpdateDatasetPersistentVolumeSpec(&pv, &originalPV)
+		}
+		instance_creation.UpdateDatasetClaimRef(&pv, &pvc)
+		pv.Labels = volumeLabels(pv.Labels, instance)
+		pv.Labels[instance_creation.DatasetVolumeLabel] = "true"
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error when creating the PV of dataset %s -> %w", volume.Name, err)
+	}
+	klog.Infof("PV %s for instance %s/%s %s", pv.Name, instance.GetNamespace(), instance.GetName(), op)
+	return nil
+}
+
+// buildAdditionalVolumes returns the volumes and the volume mounts corresponding to
+// the additional volumes of the environment, to be configured in the container.
+func buildAdditionalVolumes(instance *crownlabsv1alpha2.Instance, environment *crownlabsv1alpha2.Environment) ([]v1.Volume, []v1.VolumeMount) {
+	var volumes []v1.Volume
+	var mounts []v1.VolumeMount
+
+	for i := range environment.Volumes {
+		volume := &environment.Volumes[i]
+		readOnly := volume.Type == crownlabsv1alpha2.VolumeDataset
+
+		podVolume := v1.Volume{Name: instance_creation.AdditionalVolumeName(volume)}
+		if volume.Type == crownlabsv1alpha2.VolumeScratch {
+			size := volume.Size
+			podVolume.VolumeSource.EmptyDir = &v1.EmptyDirVolumeSource{SizeLimit: &size}
+		} else {
+			podVolume.VolumeSource.PersistentVolumeClaim = &v1.PersistentVolumeClaimVolumeSource{
+				ClaimName: instance_creation.AdditionalVolumeClaimName(&instance.Spec.Template, volume),
+				ReadOnly:  readOnly,
+			}
+		}
+		volumes = append(volumes, podVolume)
+		mounts = append(mounts, v1.VolumeMount{Name: podVolume.Name, MountPath: volume.MountPath, ReadOnly: readOnly})
+	}
+
+	return volumes, mounts
+}
+
+// volumeLabels returns the labels identifying the resources backing the additional volumes.
+func volumeLabels(labels map[string]string, instance *crownlabsv1alpha2.Instance) map[string]string {
+	if labels == nil {
+		labels = make(map[string]string, 2)
+	}
+	labels["crownlabs.polito.it/template"] = instance.Spec.Template.Namespace + "_" + instance.Spec.Template.Name
+	labels["crownlabs.polito.it/managed-by"] = "instance"
+	return labels
+}
@@ -0,0 +1,243 @@
+package instance_controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	crownlabsv1alpha2 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha2"
+	instance_creation "github.com/netgroup-polito/CrownLabs/operators/pkg/instance-creation"
+)
+
+var _ = Describe("Additional volumes", func() {
+	const (
+		templateNamespace = "workspace-netlab"
+		instanceNamespace = "tenant-john-doe"
+	)
+
+	var (
+		instance    crownlabsv1alpha2.Instance
+		environment crownlabsv1alpha2.Environment
+	)
+
+	BeforeEach(func() {
+		instance = crownlabsv1alpha2.Instance{
+			ObjectMeta: metav1.ObjectMeta{Name: "instance", Namespace: instanceNamespace, UID: "instance-uid"},
+			Spec: crownlabsv1alpha2.InstanceSpec{
+				Template: crownlabsv1alpha2.GenericRef{Name: "course", Namespace: templateNamespace},
+			},
+		}
+		environment = crownlabsv1alpha2.Environment{
+			Name:            "app",
+			EnvironmentType: crownlabsv1alpha2.ClassContainer,
+			Volumes: []crownlabsv1alpha2.EnvironmentVolume{
+				{Name: "scratch", Type: crownlabsv1alpha2.VolumeScratch, Size: resource.MustParse("10Gi"), MountPath: "/scratch"},
+				{Name: "data", Type: crownlabsv1alpha2.VolumePersistent, Size: resource.MustParse("5Gi"), MountPath: "/data"},
+				{Name: "imagenet", Type: crownlabsv1alpha2.VolumeDataset, ClaimName: "imagenet", MountPath: "/datasets/imagenet"},
+			},
+		}
+	})
+
+	It("Should mount the volumes in the container", func() {
+		volumes, mounts := buildAdditionalVolumes(&instance, &environment)
+		Expect(volumes).To(HaveLen(3))
+		Expect(mounts).To(HaveLen(3))
+
+		Expect(*volumes[0].EmptyDir.SizeLimit).To(Equal(resource.MustParse("10Gi")))
+		Expect(volumes[1].PersistentVolumeClaim.ClaimName).To(Equal(templateNamespace + "-course-data"))
+		Expect(volumes[1].PersistentVolumeClaim.ReadOnly).To(BeFalse())
+		Expect(volumes[2].PersistentVolumeClaim.ClaimName).To(Equal(templateNamespace + "-course-dataset-imagenet"))
+		Expect(volumes[2].PersistentVolumeClaim.ReadOnly).To(BeTrue())
+
+		for i := range mounts {
+			Expect(mounts[i].Name).To(Equal(volumes[i].Name))
+			Expect(mounts[i].MountPath).To(Equal(environment.Volumes[i].MountPath))
+		}
+		Expect(mounts[2].ReadOnly).To(BeTrue())
+	})
+
+	Context("Enforcing the volumes", func() {
+		var (
+			ctx        context.Context
+			reconciler InstanceReconciler
+		)
+
+		BeforeEach(func() {
+			ctx = context.Background()
+			scheme := runtime.NewScheme()
+			Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+			Expect(crownlabsv1alpha1.AddToScheme(scheme)).To(Succeed())
+			Expect(crownlabsv1alpha2.AddToScheme(scheme)).To(Succeed())
+
+			tenant := crownlabsv1alpha1.Tenant{ObjectMeta: metav1.ObjectMeta{
+				Name: "john.doe", UID: "tenant-uid",
+				Labels: map[string]string{crownlabsv1alpha1.WorkspaceLabelPrefix + "netlab": string(crownlabsv1alpha1.User)},
+			}}
+			namespace := v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: instanceNamespace}}
+			Expect(ctrl.SetControllerReference(&tenant, &namespace, scheme)).To(Succeed())
+
+			storageClass := "nfs"
+			originalPV := v1.PersistentVolume{
+				ObjectMeta: metav1.ObjectMeta{Name: "pv-imagenet"},
+				Spec: v1.PersistentVolumeSpec{
+					Capacity:                      v1.ResourceList{v1.ResourceStorage: resource.MustParse("100Gi")},
+					AccessModes:                   []v1.PersistentVolumeAccessMode{v1.ReadWriteMany},
+					PersistentVolumeReclaimPolicy: v1.PersistentVolumeReclaimDelete,
+					StorageClassName:              storageClass,
+					PersistentVolumeSource: v1.PersistentVolumeSource{
+						CSI: &v1.CSIPersistentVolumeSource{Driver: "nfs.csi.k8s.io", VolumeHandle: "imagenet"},
+					},
+					ClaimRef: &v1.ObjectReference{Namespace: templateNamespace, Name: "imagenet"},
+				},
+			}
+			originalPVC := v1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{Name: "imagenet", Namespace: templateNamespace},
+				Spec:       v1.PersistentVolumeClaimSpec{StorageClassName: &storageClass, VolumeName: originalPV.Name},
+			}
+
+			reconciler = InstanceReconciler{
+				Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(&tenant, &namespace, &originalPV, &originalPVC).Build(),
+				Scheme: scheme,
+			}
+		})
+
+		It("Should create the PVC of the persistent volumes, not owned by the instance", func() {
+			Expect(reconciler.enforceAdditionalVolumes(ctx, &instance, &environment)).To(Succeed())
+
+			pvc := v1.PersistentVolumeClaim{}
+			Expect(reconciler.Get(ctx, types.NamespacedName{Namespace: instanceNamespace, Name: templateNamespace + "-course-data"}, &pvc)).To(Succeed())
+			Expect(pvc.Spec.Resources.Requests[v1.ResourceStorage]).To(Equal(resource.MustParse("5Gi")))
+			Expect(pvc.OwnerReferences).To(BeEmpty())
+		})
+
+		It("Should expose the dataset through a read-only mirror of the original PV", func() {
+			Expect(reconciler.enforceAdditionalVolumes(ctx, &instance, &environment)).To(Succeed())
+
+			pvc := v1.PersistentVolumeClaim{}
+			pvcName := templateNamespace + "-course-dataset-imagenet"
+			Expect(reconciler.Get(ctx, types.NamespacedName{Namespace: instanceNamespace, Name: pvcName}, &pvc)).To(Succeed())
+			Expect(pvc.Spec.AccessModes).To(ConsistOf(v1.ReadOnlyMany))
+			Expect(*pvc.Spec.StorageClassName).To(Equal("nfs"))
+			Expect(pvc.Spec.VolumeName).To(Equal(instanceNamespace + "-" + pvcName))
+			Expect(pvc.OwnerReferences).To(HaveLen(1))
+			Expect(pvc.OwnerReferences[0].Controller).To(BeNil())
+
+			pv := v1.PersistentVolume{}
+			Expect(reconciler.Get(ctx, types.NamespacedName{Name: pvc.Spec.VolumeName}, &pv)).To(Succeed())
+			Expect(pv.Spec.PersistentVolumeReclaimPolicy).To(Equal(v1.PersistentVolumeReclaimRetain))
+			Expect(pv.Spec.AccessModes).To(ConsistOf(v1.ReadOnlyMany))
+			Expect(pv.Spec.CSI.VolumeHandle).To(Equal("imagenet"))
+			Expect(pv.Spec.CSI.ReadOnly).To(BeTrue())
+			Expect(pv.Spec.ClaimRef.Namespace).To(Equal(instanceNamespace))
+			Expect(pv.Spec.ClaimRef.Name).To(Equal(pvcName))
+			Expect(pv.Spec.ClaimRef.UID).To(Equal(pvc.UID))
+			Expect(pv.Labels).To(HaveKeyWithValue(instance_creation.DatasetVolumeLabel, "true"))
+		})
+
+		It("Should fail if the storage of the dataset cannot be shared among multiple nodes", func() {
+			originalPV := v1.PersistentVolume{}
+			Expect(reconciler.Get(ctx, types.NamespacedName{Name: "pv-imagenet"}, &originalPV)).To(Succeed())
+			originalPV.Spec.AccessModes = []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce}
+			Expect(reconciler.Update(ctx, &originalPV)).To(Succeed())
+
+			Expect(reconciler.enforceAdditionalVolumes(ctx, &instance, &environment)).ToNot(Succeed())
+			pv := v1.PersistentVolume{}
+			pvName := instanceNamespace + "-" + templateNamespace + "-course-dataset-imagenet"
+			Expect(reconciler.Get(ctx, types.NamespacedName{Name: pvName}, &pv)).ToNot(Succeed())
+		})
+
+		It("Should not expose the dataset if the tenant does not belong to the workspace of the template", func() {
+			tenant := crownlabsv1alpha1.Tenant{}
+			Expect(reconciler.Get(ctx, types.NamespacedName{Name: "john.doe"}, &tenant)).To(Succeed())
+			tenant.Labels = nil
+			Expect(reconciler.Update(ctx, &tenant)).To(Succeed())
+
+			Expect(reconciler.enforceAdditionalVolumes(ctx, &instance, &environment)).ToNot(Succeed())
+			pvcName := templateNamespace + "-course-dataset-imagenet"
+			Expect(reconciler.Get(ctx, types.NamespacedName{Namespace: instanceNamespace, Name: pvcName}, &v1.PersistentVolumeClaim{})).ToNot(Succeed())
+			Expect(reconciler.Get(ctx, types.NamespacedName{Name: instanceNamespace + "-" + pvcName}, &v1.PersistentVolume{})).ToNot(Succeed())
+		})
+
+		It("Should fail if the PVC of the dataset does not exist", func() {
+			environment.Volumes[2].ClaimName = "missing"
+			Expect(reconciler.enforceAdditionalVolumes(ctx, &instance, &environment)).ToNot(Succeed())
+		})
+	})
+
+	Context("Garbage collecting the dataset volumes", func() {
+		const (
+			pvName    = "tenant-john-doe-dataset"
+			claimName = "dataset"
+		)
+
+		var (
+			ctx        context.Context
+			reconciler DatasetVolumeReconciler
+			claim      v1.PersistentVolumeClaim
+			pv         v1.PersistentVolume
+		)
+
+		BeforeEach(func() {
+			ctx = context.Background()
+			claim = v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: claimName, Namespace: instanceNamespace, UID: "claim-uid"}}
+			pv = v1.PersistentVolume{
+				ObjectMeta: metav1.ObjectMeta{Name: pvName, Labels: map[string]string{instance_creation.DatasetVolumeLabel: "true"}},
+				Spec: v1.PersistentVolumeSpec{
+					PersistentVolumeReclaimPolicy: v1.PersistentVolumeReclaimRetain,
+					ClaimRef:                      &v1.ObjectReference{Namespace: instanceNamespace, Name: claimName, UID: claim.UID},
+				},
+				Status: v1.PersistentVolumeStatus{Phase: v1.VolumeReleased},
+			}
+		})
+
+		JustBeforeEach(func() {
+			scheme := runtime.NewScheme()
+			Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+			fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&pv, &claim).Build()
+			reconciler = DatasetVolumeReconciler{Client: fakeClient, APIReader: fakeClient}
+		})
+
+		reconcile := func() {
+			_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Name: pvName}})
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		It("Should keep the PV if the claim still exists", func() {
+			reconcile()
+			Expect(reconciler.Get(ctx, types.NamespacedName{Name: pvName}, &v1.PersistentVolume{})).To(Succeed())
+		})
+
+		Context("The claim has been deleted", func() {
+			BeforeEach(func() { claim.Name = "other" })
+
+			It("Should delete the released PV", func() {
+				reconcile()
+				Expect(reconciler.Get(ctx, types.NamespacedName{Name: pvName}, &v1.PersistentVolume{})).ToNot(Succeed())
+			})
+
+			It("Should keep the PV if it is still bound", func() {
+				pv.Status.Phase = v1.VolumeBound
+				Expect(reconciler.Update(ctx, &pv)).To(Succeed())
+				reconcile()
+				Expect(reconciler.Get(ctx, types.NamespacedName{Name: pvName}, &v1.PersistentVolume{})).To(Succeed())
+			})
+
+			It("Should keep the PV if it does not mirror a dataset", func() {
+				pv.Labels = nil
+				Expect(reconciler.Update(ctx, &pv)).To(Succeed())
+				reconcile()
+				Expect(reconciler.Get(ctx, types.NamespacedName{Name: pvName}, &v1.PersistentVolume{})).To(Succeed())
+			})
+		})
+	})
+})
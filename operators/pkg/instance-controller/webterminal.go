@@ -0,0 +1,77 @@
+package instance_controller
+
+import (
+	"context"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	crownlabsv1alpha2 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha2"
+	instance_creation "github.com/netgroup-polito/CrownLabs/operators/pkg/instance-creation"
+)
+
+// WebTerminalOpts contains the configuration of the web terminals exposing the VMs without graphical interface.
+type WebTerminalOpts struct {
+	// Image is the image of the web terminal (i.e. wetty). The web terminals are disabled if empty.
+	Image string
+	// SSHUser is the user the web terminal logs in the VMs as.
+	SSHUser string
+}
+
+// Enabled returns whether the web terminals are enabled.
+func (o *WebTerminalOpts) Enabled() bool {
+	return o.Image != ""
+}
+
+// hasWebTerminal returns whether the given environment is exposed through a web terminal.
+func (r *InstanceReconciler) hasWebTerminal(environment *crownlabsv1alpha2.Environment) bool {
+	return r.WebTerminal.Enabled() && environment.EnvironmentType == crownlabsv1alpha2.ClassVM && !environment.GuiEnabled
+}
+
+// enforceWebTerminalKeys returns the public key used by the web terminal to connect to the environment,
+// generating the key pair (stored in a secret owned by the instance) in case it does not exist yet.
+func (r *InstanceReconciler) enforceWebTerminalKeys(ctx context.Context, instance *crownlabsv1alpha2.Instance, name string) (string, error) {
+	secret := v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: instance_creation.WebTerminalName(name), Namespace: instance.Namespace}}
+	op, err := ctrl.CreateOrUpdate(ctx, r.Client, &secret, func() error {
+		// The keys are generated only once, since they are already authorized in the environment.
+		if len(secret.Data[instance_creation.WebTerminalPrivateKey]) == 0 || len(secret.Data[instance_creation.WebTerminalPublicKey]) == 0 {
+			privateKey, publicKey, err := instance_creation.GenerateWebTerminalKeys()
+			if err != nil {
+				return err
+			}
+			secret.Type = v1.SecretTypeOpaque
+			secret.Data = map[string][]byte{
+				instance_creation.WebTerminalPrivateKey: privateKey,
+				instance_creation.WebTerminalPublicKey:  publicKey,
+			}
+		}
+		return ctrl.SetControllerReference(instance, &secret, r.Scheme)
+	})
+	if err != nil {
+		return "", fmt.Errorf("error when enforcing the web terminal keys of instance %s/%s -> %w", instance.Namespace, instance.Name, err)
+	}
+	klog.Infof("Web terminal keys for instance %s/%s %s", instance.GetNamespace(), instance.GetName(), op)
+
+	return string(secret.Data[instance_creation.WebTerminalPublicKey]), nil
+}
+
+// enforceWebTerminal creates the deployment running the web terminal, which connects to the environment through the given service.
+func (r *InstanceReconciler) enforceWebTerminal(ctx context.Context, instance *crownlabsv1alpha2.Instance, name, urlUUID string, service *v1.Service) error {
+	deployment := appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: instance_creation.WebTerminalName(name), Namespace: instance.Namespace}}
+	op, err := ctrl.CreateOrUpdate(ctx, r.Client, &deployment, func() error {
+		deployment.Spec = instance_creation.ForgeWebTerminalDeploymentSpec(name, r.WebTerminal.Image,
+			instance_creation.WebTerminalName(name), service.Name+"."+service.Namespace, r.WebTerminal.SSHUser, urlUUID)
+		return ctrl.SetControllerReference(instance, &deployment, r.Scheme)
+	})
+	if err != nil {
+		r.setInstanceStatus(ctx, "Could not create deployment "+deployment.Name+" in namespace "+deployment.Namespace+": "+err.Error(), "Error", "DeploymentNotCreated", instance, "", "")
+		return err
+	}
+	klog.Infof("Web terminal for instance %s/%s %s", instance.GetNamespace(), instance.GetName(), op)
+
+	return nil
+}
@@ -1,6 +1,8 @@
 package instance_creation
 
 import (
+	"strings"
+
 	"gopkg.in/yaml.v3"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -22,9 +24,26 @@ type cloudInitConfig struct {
 	Mounts            [][]string  `yaml:"mounts"`
 	WriteFiles        []writeFile `yaml:"write_files"`
 	SSHAuthorizedKeys []string    `yaml:"ssh_authorized_keys,omitempty"`
+	RunCmd            [][]string  `yaml:"runcmd,omitempty"`
 }
 
-func createUserdata(nextUsername, nextPassword, nextCloudBaseURL string, publicKeys []string) map[string]string {
+const (
+	// sshCAPublicKeyPath is the path where the public key of the SSH certificate authority is written in the VMs.
+	sshCAPublicKeyPath = "/etc/ssh/crownlabs_user_ca.pub"
+	// sshPrincipalsPath is the path where the principals allowed to access the VMs are written.
+	sshPrincipalsPath = "/etc/ssh/crownlabs_principals"
+)
+
+// SSHCertificateAuthority contains the information to configure the VMs to accept the SSH
+// certificates issued by the CrownLabs certificate authority.
+type SSHCertificateAuthority struct {
+	// PublicKey is the public key of the certificate authority, in the authorized_keys format.
+	PublicKey string
+	// Principals are the principals allowed to access the VM (any user).
+	Principals []string
+}
+
+func createUserdata(nextUsername, nextPassword, nextCloudBaseURL string, publicKeys []string, sshCA *SSHCertificateAuthority) map[string]string {
 	var Userdata cloudInitConfig
 
 	Userdata.Network.Version = 2
@@ -46,6 +65,24 @@ func createUserdata(nextUsername, nextPassword, nextCloudBaseURL string, publicK
 	}
 	Userdata.SSHAuthorizedKeys = publicKeys
 
+	if sshCA != nil {
+		// The certificate authority is trusted in addition to the authorized keys, for the given principals only.
+		Userdata.WriteFiles = append(Userdata.WriteFiles, writeFile{
+			Content:     sshCA.PublicKey + "\n",
+			Path:        sshCAPublicKeyPath,
+			Permissions: "0644",
+		}, writeFile{
+			Content:     strings.Join(sshCA.Principals, "\n") + "\n",
+			Path:        sshPrincipalsPath,
+			Permissions: "0644",
+		})
+		Userdata.RunCmd = [][]string{
+			// The options are prepended, since the first occurrence prevails (and they shall not end up in a Match block).
+			{"sed", "-i", "-e", "1i TrustedUserCAKeys " + sshCAPublicKeyPath, "-e", "1i AuthorizedPrincipalsFile " + sshPrincipalsPath, "/etc/ssh/sshd_config"},
+			{"sh", "-c", "systemctl reload ssh || systemctl reload sshd"},
+		}
+	}
+
 	out, _ := yaml.Marshal(Userdata)
 
 	headerComment := "#cloud-config\n"
@@ -55,7 +92,8 @@ func createUserdata(nextUsername, nextPassword, nextCloudBaseURL string, publicK
 
 // CreateCloudInitSecret creates and returns a Kubernetes Secret object which
 // contains the cloud-init configuration required to correctly start the VMs.
-func CreateCloudInitSecret(name, namespace, nextUsername, nextPassword, nextCloudBaseURL string, publicKeys []string) v1.Secret {
+// The SSH certificate authority is optional, and it is not configured if nil.
+func CreateCloudInitSecret(name, namespace, nextUsername, nextPassword, nextCloudBaseURL string, publicKeys []string, sshCA *SSHCertificateAuthority) v1.Secret {
 	secret := v1.Secret{
 		TypeMeta: metav1.TypeMeta{
 			Kind:       "v1",
@@ -70,7 +108,8 @@ func CreateCloudInitSecret(name, namespace, nextUsername, nextPassword, nextClou
 			nextUsername,
 			nextPassword,
 			nextCloudBaseURL,
-			publicKeys),
+			publicKeys,
+			sshCA),
 		Type: v1.SecretTypeOpaque,
 	}
 
@@ -16,7 +16,7 @@ func TestCreateUserData(t *testing.T) {
 	)
 	publicKeys := []string{"key1", "key2", "key3"}
 
-	rawConfig := createUserdata(nextUsername, nextPassword, nextCloudBaseURL, publicKeys)
+	rawConfig := createUserdata(nextUsername, nextPassword, nextCloudBaseURL, publicKeys, nil)
 
 	var config cloudInitConfig
 
@@ -43,6 +43,31 @@ func TestCreateUserData(t *testing.T) {
 	assert.Equal(t, config.SSHAuthorizedKeys[0], publicKeys[0], "Public key should be set to"+publicKeys[0]+" .")
 	assert.Equal(t, config.SSHAuthorizedKeys[1], publicKeys[1], "Public key should be set to"+publicKeys[1]+" .")
 	assert.Equal(t, config.SSHAuthorizedKeys[2], publicKeys[2], "Public key should be set to"+publicKeys[2]+" .")
+	assert.Equal(t, len(config.WriteFiles), 1, "No SSH certificate authority files should be written.")
+	assert.Equal(t, len(config.RunCmd), 0, "No commands should be executed.")
+}
+
+func TestCreateUserDataWithSSHCertificateAuthority(t *testing.T) {
+	sshCA := SSHCertificateAuthority{
+		PublicKey:  "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIHjxN6Ya9zXzZMqWuWm4ILsOL8ki+XB/WjUn7hFPbq0a crownlabs-ca",
+		Principals: []string{"tenant-s11111", "manager-ws"},
+	}
+
+	rawConfig := createUserdata("usertest", "passtest", "nextcloud.url", []string{"key1"}, &sshCA)
+
+	var config cloudInitConfig
+	err := yaml.Unmarshal([]byte(rawConfig["userdata"]), &config)
+	assert.Equal(t, err, nil, "Yaml parser should return nil error.")
+
+	assert.Equal(t, len(config.WriteFiles), 3, "The SSH certificate authority files should be written.")
+	assert.Equal(t, config.WriteFiles[1].Path, sshCAPublicKeyPath)
+	assert.Equal(t, config.WriteFiles[1].Content, sshCA.PublicKey+"\n")
+	assert.Equal(t, config.WriteFiles[2].Path, sshPrincipalsPath)
+	assert.Equal(t, config.WriteFiles[2].Content, "tenant-s11111\nmanager-ws\n")
+	assert.Equal(t, config.SSHAuthorizedKeys, []string{"key1"}, "Public keys should be preserved.")
+	assert.Equal(t, len(config.RunCmd), 2, "The ssh daemon should be configured and reloaded.")
+	assert.Contains(t, config.RunCmd[0], "1i TrustedUserCAKeys "+sshCAPublicKeyPath)
+	assert.Contains(t, config.RunCmd[0], "1i AuthorizedPrincipalsFile "+sshPrincipalsPath)
 }
 
 func TestCreateCloudInitSecret(t *testing.T) {
@@ -54,7 +79,7 @@ func TestCreateCloudInitSecret(t *testing.T) {
 		nextCloudBaseURL = "nextcloud.url"
 	)
 	publicKeys := []string{"key1", "key2", "key3"}
-	secret := CreateCloudInitSecret(name, namespace, nextUsername, nextPassword, nextCloudBaseURL, publicKeys)
+	secret := CreateCloudInitSecret(name, namespace, nextUsername, nextPassword, nextCloudBaseURL, publicKeys, nil)
 
 	var (
 		expectedmount       = []string{nextCloudBaseURL + "/remote.php/dav/files/" + nextUsername, "/media/MyDrive", "davfs", "_netdev,auto,user,rw,uid=1000,gid=1000", "0", "0"}
@@ -34,6 +34,7 @@ func UpdateVirtualMachineInstanceSpec(vmi *virtv1.VirtualMachineInstance, templa
 	vmi.Spec = virtv1.VirtualMachineInstanceSpec{
 		TerminationGracePeriodSeconds: &terminationGracePeriod,
 		Domain:                        domain,
+		Networks:                      CreateVMNetworks(template),
 		Volumes: []virtv1.Volume{
 			containerdisk,
 			cloudinitdisk,
@@ -53,6 +54,7 @@ func UpdateVirtualMachineSpec(vm *virtv1.VirtualMachine, template *crownlabsv1al
 			Spec: virtv1.VirtualMachineInstanceSpec{
 				TerminationGracePeriodSeconds: &terminationGracePeriod,
 				Domain:                        domain,
+				Networks:                      CreateVMNetworks(template),
 				Volumes: []virtv1.Volume{
 					containerdisk,
 					cloudinitdisk,
@@ -149,6 +151,7 @@ func UpdateVMdomain(template *crownlabsv1alpha2.Environment) virtv1.DomainSpec {
 			},
 		},
 	}
+	applyVMHardware(&Domain, template.Hardware)
 	return Domain
 }
 
@@ -182,6 +185,10 @@ func UpdateDataVolumeSpec(dv *cdiv1.DataVolume, template *crownlabsv1alpha2.Envi
 			},
 		},
 	}
+	if template.IncrementalImage {
+		// CDI cannot import a chain of overlays, hence the disk is flattened into a blank volume by a dedicated job.
+		dv.Spec.Source = cdiv1.DataVolumeSource{Blank: &cdiv1.DataVolumeBlankImage{}}
+	}
 }
 
 func computeCPULimits(cpu uint32, hypervisorCoefficient float32) string {
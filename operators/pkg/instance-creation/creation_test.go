@@ -124,6 +124,11 @@ func TestCreatePersistentVirtualMachine(t *testing.T) {
 
 	UpdateDataVolumeSpec(&dv, tc1)
 	assert.Equal(t, "docker://"+tc1.Image, dv.Spec.Source.Registry.URL, "The datavolume has not the correct image")
+	tc1.IncrementalImage = true
+	UpdateDataVolumeSpec(&dv, tc1)
+	assert.Nil(t, dv.Spec.Source.Registry, "The incremental image should not be imported by CDI")
+	assert.NotNil(t, dv.Spec.Source.Blank, "The datavolume of an incremental image should be blank")
+	tc1.IncrementalImage = false
 	UpdateVirtualMachineSpec(&vm, tc1, instance.Spec.Running)
 	assert.Equal(t, len(vm.Spec.Template.Spec.Volumes), 2, "The VMI has a number of volume different from expected")
 	assert.Equal(t, len(vm.Spec.Template.Spec.Domain.Devices.Disks), 2, "The VMI has a number of devices different from the expected")
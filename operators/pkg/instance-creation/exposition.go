@@ -7,6 +7,9 @@ import (
 	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
+// RemoteDesktopPort is the port noVNC listens to, exposing the remote desktop of the environments.
+const RemoteDesktopPort = 6080
+
 // ForgeService creates and returns a Kubernetes Service resource providing
 // access to a CrownLabs environment.
 func ForgeService(name, namespace string) corev1.Service {
@@ -20,8 +23,8 @@ func ForgeService(name, namespace string) corev1.Service {
 				{
 					Name:       "vnc",
 					Protocol:   corev1.ProtocolTCP,
-					Port:       6080,
-					TargetPort: intstr.IntOrString{IntVal: 6080},
+					Port:       RemoteDesktopPort,
+					TargetPort: intstr.IntOrString{IntVal: RemoteDesktopPort},
 				},
 				{
 					Name:       "ssh",
@@ -97,6 +100,25 @@ func ForgeIngress(name, namespace string, svc *corev1.Service, websiteBaseURL, u
 	return ingress
 }
 
+// ForgeWebServicePort returns the port of the Service exposing the web interface of a CrownLabs environment.
+func ForgeWebServicePort(port int32) corev1.ServicePort {
+	return corev1.ServicePort{
+		Name:       "web",
+		Protocol:   corev1.ProtocolTCP,
+		Port:       port,
+		TargetPort: intstr.IntOrString{IntVal: port},
+	}
+}
+
+// ForgeWebIngress creates and returns a Kubernetes Ingress resource exposing
+// the web interface of a CrownLabs environment, in place of the remote desktop.
+// Differently from noVNC, the pages are served as they are, without rewriting their base URL.
+func ForgeWebIngress(name, namespace string, svc *corev1.Service, websiteBaseURL, urlUUID, instancesAuthURL string) networkingv1.Ingress {
+	ingress := ForgeIngress(name, namespace, svc, websiteBaseURL, urlUUID, instancesAuthURL)
+	delete(ingress.Annotations, "nginx.ingress.kubernetes.io/configuration-snippet")
+	return ingress
+}
+
 // ForgeFileBrowserIngress creates and returns a Kubernetes Ingress resource
 // exposing FileBrowser for a CrownLabs container environment.
 func ForgeFileBrowserIngress(
@@ -110,6 +132,7 @@ func ForgeFileBrowserIngress(
 		"nginx.ingress.kubernetes.io/proxy-read-timeout":       "600",
 		"nginx.ingress.kubernetes.io/proxy-send-timeout":       "600",
 		"nginx.ingress.kubernetes.io/proxy-max-temp-file-size": "0",
+		"crownlabs.polito.it/url-uuid":                         urlUUID,
 	}
 	annotations = appendInstancesAuthAnnotations(annotations, instancesAuthURL)
 
@@ -0,0 +1,116 @@
+package instance_creation
+
+import (
+	"fmt"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	virtv1 "kubevirt.io/client-go/api/v1"
+
+	crownlabsv1alpha2 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha2"
+)
+
+// tabletInputName is the name of the tablet input device of the VMs.
+const tabletInputName = "tablet"
+
+// ValidateVMHardware verifies that the hardware options of the given environment are consistent
+// with each other and with the resources of the environment.
+func ValidateVMHardware(template *crownlabsv1alpha2.Environment) error {
+	hardware := template.Hardware
+	if hardware == nil {
+		return nil
+	}
+
+	if hardware.SecureBoot && hardware.Firmware != crownlabsv1alpha2.FirmwareUEFI {
+		return fmt.Errorf("secure boot requires the %v firmware", crownlabsv1alpha2.FirmwareUEFI)
+	}
+
+	sockets, threads := topologyOrDefault(hardware.Sockets), topologyOrDefault(hardware.Threads)
+	if template.Resources.CPU%(sockets*threads) != 0 {
+		return fmt.Errorf("the number of CPU cores (%d) is not a multiple of the number of sockets (%d) and threads (%d)",
+			template.Resources.CPU, sockets, threads)
+	}
+
+	if hardware.HugepagesSize != "" {
+		pageSize, err := resource.ParseQuantity(string(hardware.HugepagesSize))
+		if err != nil {
+			return fmt.Errorf("invalid hugepages size %v -> %w", hardware.HugepagesSize, err)
+		}
+		if template.Resources.Memory.Value()%pageSize.Value() != 0 {
+			return fmt.Errorf("the memory (%v) is not a multiple of the hugepages size (%v)",
+				template.Resources.Memory.String(), hardware.HugepagesSize)
+		}
+	}
+	return nil
+}
+
+// applyVMHardware configures the domain of the VM according to the given hardware options.
+func applyVMHardware(domain *virtv1.DomainSpec, hardware *crownlabsv1alpha2.VMHardware) {
+	if hardware == nil {
+		return
+	}
+
+	if hardware.Firmware == crownlabsv1alpha2.FirmwareUEFI {
+		// KubeVirt enables secure boot by default with UEFI, hence it is always configured explicitly.
+		secureBoot := hardware.SecureBoot
+		domain.Firmware = &virtv1.Firmware{Bootloader: &virtv1.Bootloader{EFI: &virtv1.EFI{SecureBoot: &secureBoot}}}
+		if secureBoot {
+			smm := true
+			domain.Features = &virtv1.Features{SMM: &virtv1.FeatureState{Enabled: &smm}}
+		}
+	}
+
+	// The number of cores is split among the sockets and threads, to preserve the total number of vCPUs.
+	sockets, threads := topologyOrDefault(hardware.Sockets), topologyOrDefault(hardware.Threads)
+	if sockets*threads > 1 {
+		domain.CPU.Sockets = sockets
+		domain.CPU.Threads = threads
+		domain.CPU.Cores /= sockets * threads
+	}
+	domain.CPU.Model = hardware.CPUModel
+
+	if hardware.DedicatedCPUPlacement {
+		// Dedicated CPUs require the requests and the limits to be equal to the number of vCPUs.
+		vcpus := resource.MustParse(strconv.FormatUint(uint64(domain.CPU.Cores*domain.CPU.Sockets*domain.CPU.Threads), 10))
+		domain.CPU.DedicatedCPUPlacement = true
+		domain.Resources.Requests[corev1.ResourceCPU] = vcpus
+		domain.Resources.Limits[corev1.ResourceCPU] = vcpus
+	}
+
+	if hardware.HugepagesSize != "" {
+		// The requested memory is backed by hugepages, hence it shall be a multiple of their size:
+		// the memory reserved for the hypervisor is dropped, as the overhead is accounted for by KubeVirt.
+		domain.Memory.Hugepages = &virtv1.Hugepages{PageSize: string(hardware.HugepagesSize)}
+		domain.Resources.Requests[corev1.ResourceMemory] = *domain.Memory.Guest
+		domain.Resources.Limits[corev1.ResourceMemory] = *domain.Memory.Guest
+	}
+
+	if hardware.NICModel != "" {
+		// The interface keeps the binding KubeVirt attaches by default to the pod network, only changing its model.
+		nic := virtv1.DefaultMasqueradeNetworkInterface()
+		nic.Model = string(hardware.NICModel)
+		domain.Devices.Interfaces = []virtv1.Interface{*nic}
+	}
+
+	if hardware.TabletInput {
+		domain.Devices.Inputs = []virtv1.Input{{Name: tabletInputName, Type: "tablet", Bus: "usb"}}
+	}
+}
+
+// CreateVMNetworks returns the networks of the VM, which need to be explicitly configured only in case
+// a network interface is defined in the domain (i.e. a NIC model is selected), as KubeVirt does not
+// automatically attach the default one in that case.
+func CreateVMNetworks(template *crownlabsv1alpha2.Environment) []virtv1.Network {
+	if template.Hardware == nil || template.Hardware.NICModel == "" {
+		return nil
+	}
+	return []virtv1.Network{*virtv1.DefaultPodNetwork()}
+}
+
+func topologyOrDefault(value uint32) uint32 {
+	if value == 0 {
+		return 1
+	}
+	return value
+}
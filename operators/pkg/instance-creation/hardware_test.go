@@ -0,0 +1,93 @@
+package instance_creation
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/netgroup-polito/CrownLabs/operators/api/v1alpha2"
+)
+
+func hardwareEnvironment(hardware *v1alpha2.VMHardware) *v1alpha2.Environment {
+	return &v1alpha2.Environment{
+		Name: "Test1",
+		Resources: v1alpha2.EnvironmentResources{
+			CPU:                   4,
+			ReservedCPUPercentage: 25,
+			Memory:                resource.MustParse("2Gi"),
+		},
+		EnvironmentType: v1alpha2.ClassVM,
+		Image:           "test/image",
+		Hardware:        hardware,
+	}
+}
+
+func TestValidateVMHardware(t *testing.T) {
+	assert.Nil(t, ValidateVMHardware(hardwareEnvironment(nil)))
+	assert.Nil(t, ValidateVMHardware(hardwareEnvironment(&v1alpha2.VMHardware{
+		Firmware: v1alpha2.FirmwareUEFI, SecureBoot: true, Sockets: 2, Threads: 2, HugepagesSize: v1alpha2.Hugepages1Gi})))
+
+	assert.NotNil(t, ValidateVMHardware(hardwareEnvironment(&v1alpha2.VMHardware{Firmware: v1alpha2.FirmwareBIOS, SecureBoot: true})),
+		"Secure boot should require the UEFI firmware")
+	assert.NotNil(t, ValidateVMHardware(hardwareEnvironment(&v1alpha2.VMHardware{Sockets: 3})),
+		"The number of cores should be a multiple of the number of sockets")
+
+	env := hardwareEnvironment(&v1alpha2.VMHardware{HugepagesSize: v1alpha2.Hugepages1Gi})
+	env.Resources.Memory = resource.MustParse("1536Mi")
+	assert.NotNil(t, ValidateVMHardware(env), "The memory should be a multiple of the hugepages size")
+}
+
+func TestUpdateVMdomainDefaultHardware(t *testing.T) {
+	domain := UpdateVMdomain(hardwareEnvironment(nil))
+	assert.Nil(t, domain.Firmware)
+	assert.Nil(t, domain.Features)
+	assert.Nil(t, domain.Memory.Hugepages)
+	assert.Empty(t, domain.Devices.Interfaces)
+	assert.Empty(t, domain.Devices.Inputs)
+	assert.Equal(t, domain.CPU.Cores, uint32(4))
+	assert.Equal(t, domain.CPU.Sockets, uint32(0))
+	assert.False(t, domain.CPU.DedicatedCPUPlacement)
+	assert.Nil(t, CreateVMNetworks(hardwareEnvironment(nil)))
+}
+
+func TestUpdateVMdomainHardware(t *testing.T) {
+	env := hardwareEnvironment(&v1alpha2.VMHardware{
+		Firmware:              v1alpha2.FirmwareUEFI,
+		SecureBoot:            true,
+		CPUModel:              "host-passthrough",
+		Sockets:               2,
+		Threads:               1,
+		DedicatedCPUPlacement: true,
+		HugepagesSize:         v1alpha2.Hugepages2Mi,
+		NICModel:              "e1000",
+		TabletInput:           true,
+	})
+	domain := UpdateVMdomain(env)
+
+	assert.True(t, *domain.Firmware.Bootloader.EFI.SecureBoot)
+	assert.True(t, *domain.Features.SMM.Enabled)
+	assert.Equal(t, domain.CPU.Model, "host-passthrough")
+	assert.Equal(t, domain.CPU.Sockets, uint32(2))
+	assert.Equal(t, domain.CPU.Cores, uint32(2))
+	assert.Equal(t, domain.CPU.Threads, uint32(1))
+	assert.True(t, domain.CPU.DedicatedCPUPlacement)
+	assert.Equal(t, domain.Resources.Requests.Cpu().String(), "4")
+	assert.Equal(t, domain.Resources.Limits.Cpu().String(), "4")
+	assert.Equal(t, domain.Memory.Hugepages.PageSize, "2Mi")
+	assert.Equal(t, domain.Resources.Limits.Memory().String(), "2Gi")
+	assert.Equal(t, len(domain.Devices.Interfaces), 1)
+	assert.Equal(t, domain.Devices.Interfaces[0].Model, "e1000")
+	assert.NotNil(t, domain.Devices.Interfaces[0].Masquerade)
+	assert.Equal(t, domain.Devices.Inputs[0].Type, "tablet")
+
+	networks := CreateVMNetworks(env)
+	assert.Equal(t, len(networks), 1)
+	assert.Equal(t, networks[0].Name, domain.Devices.Interfaces[0].Name)
+}
+
+func TestUpdateVMdomainUEFIWithoutSecureBoot(t *testing.T) {
+	domain := UpdateVMdomain(hardwareEnvironment(&v1alpha2.VMHardware{Firmware: v1alpha2.FirmwareUEFI}))
+	assert.False(t, *domain.Firmware.Bootloader.EFI.SecureBoot)
+	assert.Nil(t, domain.Features)
+}
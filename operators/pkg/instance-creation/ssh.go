@@ -52,3 +52,27 @@ func GetPublicKeys(ctx context.Context, c client.Reader, tenantRef, templateRef
 
 	return nil
 }
+
+// TenantPrincipal returns the SSH certificate principal identifying the given tenant.
+func TenantPrincipal(tenantName string) string {
+	return "tenant-" + tenantName
+}
+
+// WorkspaceManagerPrincipal returns the SSH certificate principal identifying the managers of the given workspace.
+func WorkspaceManagerPrincipal(workspaceName string) string {
+	return "manager-" + workspaceName
+}
+
+// GetPrincipals returns the SSH certificate principals allowed to access the environments of a given
+// instance, i.e. the one of the tenant owning it, and the one of the managers of the corresponding workspace.
+func GetPrincipals(ctx context.Context, c client.Reader, tenantRef, templateRef crownlabsv1alpha2.GenericRef) ([]string, error) {
+	template := crownlabsv1alpha2.Template{}
+	if err := c.Get(ctx, types.NamespacedName{
+		Namespace: templateRef.Namespace,
+		Name:      templateRef.Name,
+	}, &template); err != nil {
+		return nil, err
+	}
+
+	return []string{TenantPrincipal(tenantRef.Name), WorkspaceManagerPrincipal(template.Spec.WorkspaceRef.Name)}, nil
+}
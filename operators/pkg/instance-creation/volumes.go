@@ -0,0 +1,156 @@
+package instance_creation
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	virtv1 "kubevirt.io/client-go/api/v1"
+
+	crownlabsv1alpha2 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha2"
+)
+
+// AdditionalVolumeName returns the name of the pod (or VM) volume corresponding to the given additional volume.
+func AdditionalVolumeName(volume *crownlabsv1alpha2.EnvironmentVolume) string {
+	return "volume-" + volume.Name
+}
+
+// AdditionalVolumeClaimName returns the name of the PVC backing the given (Persistent or Dataset) volume
+// in the namespace of the instance. The PVC is shared by all the instances of the same template in that namespace.
+func AdditionalVolumeClaimName(template *crownlabsv1alpha2.GenericRef, volume *crownlabsv1alpha2.EnvironmentVolume) string {
+	if volume.Type == crownlabsv1alpha2.VolumeDataset {
+		return template.Namespace + "-" + template.Name + "-dataset-" + volume.Name
+	}
+	return template.Namespace + "-" + template.Name + "-" + volume.Name
+}
+
+// DatasetVolumeLabel is the label identifying the PVs mirroring the ones of the datasets, which are garbage collected once released.
+const DatasetVolumeLabel = "crownlabs.polito.it/dataset"
+
+// DatasetPersistentVolumeName returns the name of the PV exposing a dataset through the given PVC in the given namespace.
+func DatasetPersistentVolumeName(namespace, claimName string) string {
+	return namespace + "-" + claimName
+}
+
+// ValidateDatasetPersistentVolume verifies that the given PV, backing a dataset, can be safely mirrored in the namespaces of
+// the instances, that is it is a shared filesystem (e.g. NFS or CephFS) supporting the concurrent access from multiple nodes.
+// Block devices (e.g. RBD volumes) are not supported, since the same volume would be attached to multiple nodes.
+func ValidateDatasetPersistentVolume(pv *corev1.PersistentVolume) error {
+	if pv.Spec.NFS == nil && pv.Spec.CephFS == nil && pv.Spec.CSI == nil {
+		return fmt.Errorf("the PV %s is not backed by a shared filesystem (NFS, CephFS or CSI)", pv.Name)
+	}
+	if pv.Spec.VolumeMode != nil && *pv.Spec.VolumeMode != corev1.PersistentVolumeFilesystem {
+		return fmt.Errorf("the PV %s is not a filesystem volume", pv.Name)
+	}
+	for _, mode := range pv.Spec.AccessModes {
+		if mode == corev1.ReadOnlyMany || mode == corev1.ReadWriteMany {
+			return nil
+		}
+	}
+	return fmt.Errorf("the PV %s does not support the concurrent access from multiple nodes (ReadOnlyMany or ReadWriteMany)", pv.Name)
+}
+
+// ValidateVolumes verifies that the additional volumes of the given environment are correctly configured.
+func ValidateVolumes(template *crownlabsv1alpha2.Environment) error {
+	names := make(map[string]bool, len(template.Volumes))
+	for i := range template.Volumes {
+		volume := &template.Volumes[i]
+		if names[volume.Name] {
+			return fmt.Errorf("duplicated volume %v", volume.Name)
+		}
+		names[volume.Name] = true
+
+		switch volume.Type {
+		case crownlabsv1alpha2.VolumeScratch, crownlabsv1alpha2.VolumePersistent:
+			if volume.Size.IsZero() {
+				return fmt.Errorf("the size of volume %v is not specified", volume.Name)
+			}
+		case crownlabsv1alpha2.VolumeDataset:
+			if volume.ClaimName == "" {
+				return fmt.Errorf("the claim of volume %v is not specified", volume.Name)
+			}
+		default:
+			return fmt.Errorf("unknown type %v of volume %v", volume.Type, volume.Name)
+		}
+
+		if template.EnvironmentType == crownlabsv1alpha2.ClassContainer && volume.MountPath == "" {
+			return fmt.Errorf("the mount path of volume %v is not specified", volume.Name)
+		}
+	}
+	return nil
+}
+
+// UpdateVMVolumes adds the disks and the volumes corresponding to the additional volumes of the environment
+// to the specification of the VM. Datasets are attached as read-only disks.
+func UpdateVMVolumes(spec *virtv1.VirtualMachineInstanceSpec, template *crownlabsv1alpha2.Environment, templateRef *crownlabsv1alpha2.GenericRef) {
+	for i := range template.Volumes {
+		volume := &template.Volumes[i]
+		name := AdditionalVolumeName(volume)
+		readOnly := volume.Type == crownlabsv1alpha2.VolumeDataset
+
+		spec.Domain.Devices.Disks = append(spec.Domain.Devices.Disks, virtv1.Disk{
+			Name:   name,
+			Serial: volume.Name,
+			DiskDevice: virtv1.DiskDevice{
+				Disk: &virtv1.DiskTarget{Bus: "virtio", ReadOnly: readOnly},
+			},
+		})
+
+		vmVolume := virtv1.Volume{Name: name}
+		if volume.Type == crownlabsv1alpha2.VolumeScratch {
+			vmVolume.VolumeSource.EmptyDisk = &virtv1.EmptyDiskSource{Capacity: volume.Size}
+		} else {
+			vmVolume.VolumeSource.PersistentVolumeClaim = &corev1.PersistentVolumeClaimVolumeSource{
+				ClaimName: AdditionalVolumeClaimName(templateRef, volume),
+				ReadOnly:  readOnly,
+			}
+		}
+		spec.Volumes = append(spec.Volumes, vmVolume)
+	}
+}
+
+// UpdateDatasetPersistentVolumeSpec configures the PV exposing the dataset to the given claim, sharing
+// the underlying storage of the original PV. The reclaim policy is set to Retain, to never delete the dataset,
+// and the storage is mounted read-only, regardless of the access mode of the PV, for all the supported sources.
+func UpdateDatasetPersistentVolumeSpec(pv *corev1.PersistentVolume, original *corev1.PersistentVolume) {
+	pv.Spec = *original.Spec.DeepCopy()
+	pv.Spec.AccessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadOnlyMany}
+	pv.Spec.PersistentVolumeReclaimPolicy = corev1.PersistentVolumeReclaimRetain
+	pv.Spec.ClaimRef = nil
+	switch {
+	case pv.Spec.NFS != nil:
+		pv.Spec.NFS.ReadOnly = true
+	case pv.Spec.CephFS != nil:
+		pv.Spec.CephFS.ReadOnly = true
+	case pv.Spec.CSI != nil:
+		pv.Spec.CSI.ReadOnly = true
+	}
+}
+
+// UpdateDatasetClaimRef binds the PV exposing the dataset to the given claim. The reference is updated
+// in case the claim is recreated (e.g. after all the instances have been deleted), to allow the binding again.
+func UpdateDatasetClaimRef(pv *corev1.PersistentVolume, claim *corev1.PersistentVolumeClaim) {
+	pv.Spec.ClaimRef = &corev1.ObjectReference{
+		Kind:       "PersistentVolumeClaim",
+		APIVersion: "v1",
+		Namespace:  claim.Namespace,
+		Name:       claim.Name,
+		UID:        claim.UID,
+	}
+}
+
+// UpdateDatasetClaimSpec configures the PVC statically bound to the PV (named pvName) exposing the dataset of the original PV.
+func UpdateDatasetClaimSpec(claim *corev1.PersistentVolumeClaim, original *corev1.PersistentVolume, pvName string) {
+	// The storage class shall match the one of the PV, to allow the binding.
+	storageClassName := original.Spec.StorageClassName
+	claim.Spec = corev1.PersistentVolumeClaimSpec{
+		AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadOnlyMany},
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{
+				corev1.ResourceStorage: original.Spec.Capacity[corev1.ResourceStorage],
+			},
+		},
+		StorageClassName: &storageClassName,
+		VolumeMode:       original.Spec.VolumeMode,
+		VolumeName:       pvName,
+	}
+}
@@ -0,0 +1,136 @@
+package instance_creation
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	virtv1 "kubevirt.io/client-go/api/v1"
+
+	"github.com/netgroup-polito/CrownLabs/operators/api/v1alpha2"
+)
+
+func volumesEnvironment() *v1alpha2.Environment {
+	return &v1alpha2.Environment{
+		Name:       "Test1",
+		GuiEnabled: true,
+		Resources: v1alpha2.EnvironmentResources{
+			CPU:                   1,
+			ReservedCPUPercentage: 25,
+			Memory:                resource.MustParse("1024M"),
+		},
+		EnvironmentType: v1alpha2.ClassVM,
+		Image:           "test/image",
+		Volumes: []v1alpha2.EnvironmentVolume{
+			{Name: "scratch", Type: v1alpha2.VolumeScratch, Size: resource.MustParse("10Gi")},
+			{Name: "data", Type: v1alpha2.VolumePersistent, Size: resource.MustParse("5Gi")},
+			{Name: "imagenet", Type: v1alpha2.VolumeDataset, ClaimName: "imagenet"},
+		},
+	}
+}
+
+func TestValidateVolumes(t *testing.T) {
+	env := volumesEnvironment()
+	assert.Nil(t, ValidateVolumes(env))
+
+	env.EnvironmentType = v1alpha2.ClassContainer
+	assert.NotNil(t, ValidateVolumes(env), "The mount path should be required for containers")
+
+	env = volumesEnvironment()
+	env.Volumes[0].Size = resource.Quantity{}
+	assert.NotNil(t, ValidateVolumes(env), "The size should be required for scratch volumes")
+
+	env = volumesEnvironment()
+	env.Volumes[2].ClaimName = ""
+	assert.NotNil(t, ValidateVolumes(env), "The claim should be required for datasets")
+
+	env = volumesEnvironment()
+	env.Volumes[1].Name = "scratch"
+	assert.NotNil(t, ValidateVolumes(env), "The names of the volumes should be unique")
+}
+
+func TestValidateDatasetPersistentVolume(t *testing.T) {
+	pv := corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pv-imagenet"},
+		Spec: corev1.PersistentVolumeSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadOnlyMany},
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				NFS: &corev1.NFSVolumeSource{Server: "nfs.example.com", Path: "/imagenet"},
+			},
+		},
+	}
+	assert.Nil(t, ValidateDatasetPersistentVolume(&pv))
+
+	pv.Spec.AccessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce}
+	assert.NotNil(t, ValidateDatasetPersistentVolume(&pv), "The storage should be accessible from multiple nodes")
+
+	pv.Spec.AccessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany}
+	block := corev1.PersistentVolumeBlock
+	pv.Spec.VolumeMode = &block
+	assert.NotNil(t, ValidateDatasetPersistentVolume(&pv), "Block volumes should not be supported")
+
+	pv.Spec.VolumeMode = nil
+	pv.Spec.PersistentVolumeSource = corev1.PersistentVolumeSource{RBD: &corev1.RBDPersistentVolumeSource{RBDImage: "imagenet"}}
+	assert.NotNil(t, ValidateDatasetPersistentVolume(&pv), "Only shared filesystems should be supported")
+}
+
+func TestUpdateDatasetPersistentVolumeSpec(t *testing.T) {
+	sources := map[string]corev1.PersistentVolumeSource{
+		"NFS":    {NFS: &corev1.NFSVolumeSource{Server: "nfs.example.com", Path: "/imagenet"}},
+		"CephFS": {CephFS: &corev1.CephFSPersistentVolumeSource{Monitors: []string{"ceph.example.com"}, Path: "/imagenet"}},
+		"CSI":    {CSI: &corev1.CSIPersistentVolumeSource{Driver: "nfs.csi.k8s.io", VolumeHandle: "imagenet"}},
+	}
+
+	for name, source := range sources {
+		original := corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: "pv-imagenet"},
+			Spec: corev1.PersistentVolumeSpec{
+				AccessModes:                   []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany},
+				PersistentVolumeReclaimPolicy: corev1.PersistentVolumeReclaimDelete,
+				PersistentVolumeSource:        source,
+				ClaimRef:                      &corev1.ObjectReference{Namespace: "workspace-netlab", Name: "imagenet"},
+			},
+		}
+
+		pv := corev1.PersistentVolume{}
+		UpdateDatasetPersistentVolumeSpec(&pv, &original)
+		assert.Equal(t, []corev1.PersistentVolumeAccessMode{corev1.ReadOnlyMany}, pv.Spec.AccessModes, name)
+		assert.Equal(t, corev1.PersistentVolumeReclaimRetain, pv.Spec.PersistentVolumeReclaimPolicy, name)
+		assert.Nil(t, pv.Spec.ClaimRef, name)
+
+		switch {
+		case pv.Spec.NFS != nil:
+			assert.True(t, pv.Spec.NFS.ReadOnly, "The NFS share should be mounted read-only")
+			assert.False(t, original.Spec.NFS.ReadOnly, "The original PV should not be modified")
+		case pv.Spec.CephFS != nil:
+			assert.True(t, pv.Spec.CephFS.ReadOnly, "The CephFS filesystem should be mounted read-only")
+			assert.False(t, original.Spec.CephFS.ReadOnly, "The original PV should not be modified")
+		default:
+			assert.True(t, pv.Spec.CSI.ReadOnly, "The CSI volume should be mounted read-only")
+			assert.False(t, original.Spec.CSI.ReadOnly, "The original PV should not be modified")
+		}
+	}
+}
+
+func TestUpdateVMVolumes(t *testing.T) {
+	env := volumesEnvironment()
+	ref := v1alpha2.GenericRef{Name: "course", Namespace: "workspace-netlab"}
+	vmi := virtv1.VirtualMachineInstance{ObjectMeta: metav1.ObjectMeta{Name: "name", Namespace: "namespace"}}
+
+	UpdateVirtualMachineInstanceSpec(&vmi, env)
+	UpdateVMVolumes(&vmi.Spec, env, &ref)
+
+	disks := vmi.Spec.Domain.Devices.Disks
+	assert.Equal(t, len(disks), 5, "The VMI has a number of devices different from the expected")
+	assert.Equal(t, len(vmi.Spec.Volumes), 5, "The VMI has a number of volume different from expected")
+	assert.Equal(t, disks[2].Name, "volume-scratch")
+	assert.Equal(t, disks[2].Serial, "scratch")
+	assert.Equal(t, vmi.Spec.Volumes[2].EmptyDisk.Capacity.String(), "10Gi")
+	assert.Equal(t, vmi.Spec.Volumes[3].PersistentVolumeClaim.ClaimName, "workspace-netlab-course-data")
+	assert.False(t, disks[3].Disk.ReadOnly)
+	assert.Equal(t, vmi.Spec.Volumes[4].PersistentVolumeClaim.ClaimName, "workspace-netlab-course-dataset-imagenet")
+	assert.True(t, vmi.Spec.Volumes[4].PersistentVolumeClaim.ReadOnly)
+	assert.True(t, disks[4].Disk.ReadOnly)
+}
@@ -0,0 +1,225 @@
+package instance_creation
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/pointer"
+)
+
+const (
+	// WebTerminalPort is the port the web terminal listens to.
+	WebTerminalPort = 3000
+	// WebTerminalPrivateKey is the key of the secret containing the private key used by the web terminal.
+	WebTerminalPrivateKey = "id_ecdsa"
+	// WebTerminalPublicKey is the key of the secret containing the public key of the web terminal.
+	WebTerminalPublicKey = "id_ecdsa.pub"
+
+	webTerminalKeysPath   = "/etc/crownlabs/ssh"
+	webTerminalKeyComment = "crownlabs-webterminal"
+)
+
+// WebTerminalName returns the name of the resources (i.e. secret, deployment and service)
+// composing the web terminal of the environment with the given name.
+func WebTerminalName(name string) string {
+	return name + "-webterminal"
+}
+
+// GenerateWebTerminalKeys generates a new key pair used by the web terminal to connect to the
+// environment. It returns the private key (PEM encoded) and the public key (authorized_keys format).
+func GenerateWebTerminalKeys() (privateKey, publicKey []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error when generating the web terminal key -> %w", err)
+	}
+
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error when marshaling the web terminal private key -> %w", err)
+	}
+	privateKey = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+
+	sshKey, err := ssh.NewPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error when marshaling the web terminal public key -> %w", err)
+	}
+	publicKey = []byte(strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshKey))) + " " + webTerminalKeyComment)
+
+	return privateKey, publicKey, nil
+}
+
+// ForgeWebTerminalDeploymentSpec creates and returns the spec of the deployment running the web terminal
+// of a CrownLabs environment, which connects through SSH to the given host with the key stored in the given secret.
+func ForgeWebTerminalDeploymentSpec(name, image, secretName, sshHost, sshUser, urlUUID string) appsv1.DeploymentSpec {
+	no := false
+	labels := map[string]string{"name": WebTerminalName(name)}
+
+	probe := corev1.Probe{
+		Handler: corev1.Handler{
+			TCPSocket: &corev1.TCPSocketAction{
+				Port: intstr.FromInt(WebTerminalPort),
+			},
+		},
+		InitialDelaySeconds: 3,
+		PeriodSeconds:       5,
+	}
+
+	container := corev1.Container{
+		Name:  "webterminal",
+		Image: image,
+		Args: []string{
+			fmt.Sprintf("--port=%d", WebTerminalPort),
+			"--base=/" + urlUUID + "/",
+			"--ssh-host=" + sshHost,
+			"--ssh-port=22",
+			"--ssh-user=" + sshUser,
+			"--ssh-auth=publickey",
+			"--ssh-key=" + webTerminalKeysPath + "/" + WebTerminalPrivateKey,
+			"--known-hosts=/dev/null",
+			"--force-ssh",
+		},
+		Ports: []corev1.ContainerPort{{
+			Name:          "http",
+			ContainerPort: WebTerminalPort,
+		}},
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{
+				"cpu":    resource.MustParse("10m"),
+				"memory": resource.MustParse("50Mi"),
+			},
+			Limits: corev1.ResourceList{
+				"cpu":    resource.MustParse("250m"),
+				"memory": resource.MustParse("250Mi"),
+			},
+		},
+		VolumeMounts: []corev1.VolumeMount{{
+			Name:      "ssh-keys",
+			MountPath: webTerminalKeysPath,
+			ReadOnly:  true,
+		}},
+		SecurityContext: &corev1.SecurityContext{
+			Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+			Privileged:               &no,
+			AllowPrivilegeEscalation: &no,
+		},
+		ReadinessProbe: &probe,
+	}
+
+	return appsv1.DeploymentSpec{
+		Replicas: pointer.Int32Ptr(1),
+		Selector: &metav1.LabelSelector{
+			MatchLabels: labels,
+		},
+		Template: corev1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{
+				Labels: labels,
+			},
+			Spec: corev1.PodSpec{
+				Containers:                   []corev1.Container{container},
+				AutomountServiceAccountToken: &no,
+				Volumes: []corev1.Volume{{
+					Name: "ssh-keys",
+					VolumeSource: corev1.VolumeSource{
+						Secret: &corev1.SecretVolumeSource{
+							SecretName:  secretName,
+							Items:       []corev1.KeyToPath{{Key: WebTerminalPrivateKey, Path: WebTerminalPrivateKey}},
+							DefaultMode: pointer.Int32Ptr(0400),
+						},
+					},
+				}},
+			},
+		},
+	}
+}
+
+// ForgeWebTerminalService creates and returns a Kubernetes Service resource
+// exposing the web terminal of a CrownLabs environment.
+func ForgeWebTerminalService(name, namespace string) corev1.Service {
+	return corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      WebTerminalName(name),
+			Namespace: namespace,
+		},
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{
+				{
+					Name:       "http",
+					Protocol:   corev1.ProtocolTCP,
+					Port:       WebTerminalPort,
+					TargetPort: intstr.FromInt(WebTerminalPort),
+				},
+			},
+			Selector: map[string]string{"name": WebTerminalName(name)},
+			Type:     corev1.ServiceTypeClusterIP,
+		},
+	}
+}
+
+// ForgeWebTerminalIngress creates and returns a Kubernetes Ingress resource
+// exposing the web terminal of a CrownLabs environment, in place of the remote desktop.
+func ForgeWebTerminalIngress(name, namespace string, svc *corev1.Service, websiteBaseURL, urlUUID, instancesAuthURL string) networkingv1.Ingress {
+	pathType := networkingv1.PathTypePrefix
+	url := websiteBaseURL + "/" + urlUUID
+
+	annotations := map[string]string{
+		"nginx.ingress.kubernetes.io/proxy-read-timeout": "3600",
+		"nginx.ingress.kubernetes.io/proxy-send-timeout": "3600",
+		"crownlabs.polito.it/probe-url":                  "https://" + url + "/",
+		"crownlabs.polito.it/url-uuid":                   urlUUID,
+	}
+	annotations = appendInstancesAuthAnnotations(annotations, instancesAuthURL)
+
+	ingress := networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   namespace,
+			Annotations: annotations,
+		},
+		Spec: networkingv1.IngressSpec{
+			TLS: []networkingv1.IngressTLS{
+				{
+					Hosts:      []string{websiteBaseURL},
+					SecretName: "crownlabs-ingress-secret",
+				},
+			},
+			Rules: []networkingv1.IngressRule{
+				{
+					Host: websiteBaseURL,
+					IngressRuleValue: networkingv1.IngressRuleValue{
+						HTTP: &networkingv1.HTTPIngressRuleValue{
+							Paths: []networkingv1.HTTPIngressPath{
+								{
+									// The web terminal is configured with the same base path, hence no rewrite is needed.
+									Path:     "/" + urlUUID,
+									PathType: &pathType,
+									Backend: networkingv1.IngressBackend{
+										Service: &networkingv1.IngressServiceBackend{
+											Name: svc.Name,
+											Port: networkingv1.ServiceBackendPort{
+												Number: svc.Spec.Ports[0].Port,
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	return ingress
+}
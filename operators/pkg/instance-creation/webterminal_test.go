@@ -0,0 +1,102 @@
+package instance_creation
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestGenerateWebTerminalKeys(t *testing.T) {
+	privateKey, publicKey, err := GenerateWebTerminalKeys()
+	assert.NoError(t, err)
+
+	block, _ := pem.Decode(privateKey)
+	assert.NotNil(t, block)
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	assert.NoError(t, err)
+
+	parsed, comment, _, rest, err := ssh.ParseAuthorizedKey(publicKey)
+	assert.NoError(t, err)
+	assert.Empty(t, rest)
+	assert.Equal(t, comment, webTerminalKeyComment)
+
+	expected, err := ssh.NewPublicKey(&key.PublicKey)
+	assert.NoError(t, err)
+	assert.Equal(t, parsed.Marshal(), expected.Marshal())
+}
+
+func TestForgeWebTerminalDeploymentSpec(t *testing.T) {
+	var (
+		name       = "usertest"
+		image      = "wettyoss/wetty"
+		secretName = "secrettest"
+		sshHost    = "usertest.namespacetest"
+		sshUser    = "crownlabs"
+		urlUUID    = "urlUUIDtest"
+	)
+
+	spec := ForgeWebTerminalDeploymentSpec(name, image, secretName, sshHost, sshUser, urlUUID)
+
+	assert.Equal(t, spec.Selector.MatchLabels["name"], WebTerminalName(name))
+	assert.Equal(t, spec.Template.ObjectMeta.Labels, spec.Selector.MatchLabels)
+
+	container := spec.Template.Spec.Containers[0]
+	assert.Equal(t, container.Image, image)
+	assert.Contains(t, container.Args, "--base=/"+urlUUID+"/")
+	assert.Contains(t, container.Args, "--ssh-host="+sshHost)
+	assert.Contains(t, container.Args, "--ssh-user="+sshUser)
+	assert.Contains(t, container.Args, "--ssh-key="+webTerminalKeysPath+"/"+WebTerminalPrivateKey)
+	assert.Equal(t, container.Ports[0].ContainerPort, int32(WebTerminalPort))
+	assert.Equal(t, container.VolumeMounts[0].MountPath, webTerminalKeysPath)
+
+	volume := spec.Template.Spec.Volumes[0]
+	assert.Equal(t, volume.Name, container.VolumeMounts[0].Name)
+	assert.Equal(t, volume.Secret.SecretName, secretName)
+	assert.Equal(t, volume.Secret.Items[0].Key, WebTerminalPrivateKey)
+	assert.Equal(t, *volume.Secret.DefaultMode, int32(0400))
+}
+
+func TestForgeWebTerminalService(t *testing.T) {
+	var (
+		name      = "usertest"
+		namespace = "namespacetest"
+	)
+
+	service := ForgeWebTerminalService(name, namespace)
+
+	assert.Equal(t, service.ObjectMeta.Name, WebTerminalName(name))
+	assert.Equal(t, service.ObjectMeta.Namespace, namespace)
+	assert.Equal(t, service.Spec.Ports[0].Port, int32(WebTerminalPort))
+	assert.Equal(t, service.Spec.Selector["name"], WebTerminalName(name))
+}
+
+func TestForgeWebTerminalIngress(t *testing.T) {
+	var (
+		name             = "usertest"
+		namespace        = "namespacetest"
+		urlUUID          = "urlUUIDtest"
+		websiteBaseURL   = "websiteBaseUrlTest"
+		instancesAuthURL = "fake.com/auth"
+		svc              = ForgeWebTerminalService(name, namespace)
+	)
+
+	instancesAuthAnnotations := appendInstancesAuthAnnotations(map[string]string{}, instancesAuthURL)
+	ingress := ForgeWebTerminalIngress(name, namespace, &svc, websiteBaseURL, urlUUID, instancesAuthURL)
+
+	assert.Equal(t, ingress.ObjectMeta.Name, name)
+	assert.Equal(t, ingress.ObjectMeta.Namespace, namespace)
+	assert.Equal(t, ingress.Spec.Rules[0].IngressRuleValue.HTTP.Paths[0].Backend.Service.Name, svc.Name)
+	assert.Equal(t, ingress.Spec.Rules[0].IngressRuleValue.HTTP.Paths[0].Backend.Service.Port.Number, int32(WebTerminalPort))
+	assert.Equal(t, ingress.Spec.Rules[0].IngressRuleValue.HTTP.Paths[0].Path, "/"+urlUUID)
+	assert.NotContains(t, ingress.GetAnnotations(), "nginx.ingress.kubernetes.io/rewrite-target")
+	assert.Equal(t, ingress.ObjectMeta.Annotations["crownlabs.polito.it/url-uuid"], urlUUID)
+	assert.Equal(t, ingress.ObjectMeta.Annotations["crownlabs.polito.it/probe-url"], "https://"+websiteBaseURL+"/"+urlUUID+"/")
+
+	for key, value := range instancesAuthAnnotations {
+		assert.Contains(t, ingress.GetAnnotations(), key)
+		assert.Equal(t, ingress.GetAnnotations()[key], value)
+	}
+}
@@ -0,0 +1,89 @@
+package instancesnapshot_controller_test
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+)
+
+// fakeRegistry is a minimal implementation of the docker registry API, used to check the deleted images.
+// If a token is configured, the requests are required to authenticate with it, as obtained from the /token endpoint.
+type fakeRegistry struct {
+	*httptest.Server
+
+	Token    string
+	mutex    sync.Mutex
+	manifest map[string]string
+	digests  map[string]string
+	deleted  []string
+}
+
+// newFakeRegistry starts a new fakeRegistry, which shall be closed once no longer needed.
+func newFakeRegistry() *fakeRegistry {
+	fr := &fakeRegistry{manifest: map[string]string{}, digests: map[string]string{}}
+	fr.Server = httptest.NewServer(http.HandlerFunc(fr.handle))
+	return fr
+}
+
+// SetDigest configures the digest of the given image (i.e. <repository>:<tag>), to simulate multiple tags of the same manifest.
+func (fr *fakeRegistry) SetDigest(image, digest string) {
+	fr.mutex.Lock()
+	defer fr.mutex.Unlock()
+	fr.digests[image] = digest
+}
+
+// Deleted returns the images (i.e. <repository>:<tag>) deleted from the registry.
+func (fr *fakeRegistry) Deleted() []string {
+	fr.mutex.Lock()
+	defer fr.mutex.Unlock()
+	return append([]string{}, fr.deleted...)
+}
+
+func (fr *fakeRegistry) handle(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/token" {
+		fmt.Fprintf(w, `{"token": %q}`, fr.Token)
+		return
+	}
+	if fr.Token != "" && r.Header.Get("Authorization") != "Bearer "+fr.Token {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="fake-registry"`, fr.URL))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/v2/")
+	separator := strings.LastIndex(path, "/manifests/")
+	if separator < 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	repository, reference := path[:separator], path[separator+len("/manifests/"):]
+
+	fr.mutex.Lock()
+	defer fr.mutex.Unlock()
+	switch r.Method {
+	case http.MethodHead:
+		// Every tag is assumed to exist, with a digest depending on the image reference unless configured.
+		image := repository + ":" + reference
+		digest, found := fr.digests[image]
+		if !found {
+			digest = fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(image)))
+		}
+		fr.manifest[repository+"@"+digest] = image
+		w.Header().Set("Docker-Content-Digest", digest)
+		w.WriteHeader(http.StatusOK)
+	case http.MethodDelete:
+		image, found := fr.manifest[repository+"@"+reference]
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(fr.manifest, repository+"@"+reference)
+		fr.deleted = append(fr.deleted, image)
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,58 @@
+package instancesnapshot_controller_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+)
+
+// fakeS3 is a minimal stand-in of an S3-compatible object storage (e.g. MinIO), used to check the deleted objects.
+// Requests are required to be signed with the configured access key ID, while the signature itself is not verified.
+type fakeS3 struct {
+	*httptest.Server
+
+	AccessKeyID string
+	mutex       sync.Mutex
+	objects     map[string]bool
+	deleted     []string
+}
+
+// newFakeS3 starts a new fakeS3 containing the given objects (i.e. <bucket>/<key>), which shall be closed once no longer needed.
+func newFakeS3(objects ...string) *fakeS3 {
+	fs := &fakeS3{objects: map[string]bool{}}
+	for _, object := range objects {
+		fs.objects[object] = true
+	}
+	fs.Server = httptest.NewServer(http.HandlerFunc(fs.handle))
+	return fs
+}
+
+// Deleted returns the objects (i.e. <bucket>/<key>) deleted from the object storage.
+func (fs *fakeS3) Deleted() []string {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	return append([]string{}, fs.deleted...)
+}
+
+func (fs *fakeS3) handle(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential="+fs.AccessKeyID+"/") ||
+		r.Header.Get("X-Amz-Date") == "" || r.Header.Get("X-Amz-Content-Sha256") == "" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodDelete {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	// Consistently with S3, deleting a missing object is not an error.
+	object := strings.TrimPrefix(r.URL.Path, "/")
+	if fs.objects[object] {
+		delete(fs.objects, object)
+		fs.deleted = append(fs.deleted, object)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,396 @@
+package instancesnapshot_controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	crownlabsv1alpha2 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha2"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/utils"
+)
+
+// S3Opts contains the configuration of the object storage the snapshots are uploaded to by the S3 backend.
+type S3Opts struct {
+	// Endpoint is the URL of the S3-compatible object storage. If empty, the AWS endpoint of the region is used.
+	Endpoint string
+	// Bucket is the bucket the snapshots are uploaded to.
+	Bucket string
+	// Region is the region of the bucket.
+	Region string
+	// SecretName is the name of the secret containing the credentials (AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY).
+	SecretName string
+}
+
+// snapshotJob contains the elements of the snapshotting job, configured by the exporter of the selected backend.
+type snapshotJob struct {
+	// Environment is the environment being snapshotted.
+	Environment *crownlabsv1alpha2.Environment
+	// Directory is the directory (i.e. the parsed name of the tenant) the snapshot is stored in.
+	Directory string
+	// Tag identifies the snapshot among the ones with the same image name.
+	Tag string
+	// Source is the name of the PVC the snapshot is created from.
+	Source string
+	// Exporter is the container exporting the volume of the instance.
+	Exporter corev1.Container
+	// Pusher, if set, is the container storing the exported volume in the backend, executed after the exporter.
+	Pusher *corev1.Container
+	// Volumes are the volumes of the job.
+	Volumes []corev1.Volume
+}
+
+// snapshotExporter is the interface implemented by the backends the snapshots are exported to.
+type snapshotExporter interface {
+	// Validate checks whether the snapshot of the given environment can be exported to the backend.
+	Validate(isnap *crownlabsv1alpha2.InstanceSnapshot, env *crownlabsv1alpha2.Environment) error
+	// Configure adapts the job to export the snapshot to the backend, and returns the location of the snapshot.
+	Configure(ctx context.Context, isnap *crownlabsv1alpha2.InstanceSnapshot, job *snapshotJob) (string, error)
+	// Deletable returns whether the snapshots can be deleted from the backend, when no longer retained.
+	Deletable() bool
+	// Delete deletes the given snapshot from the backend.
+	Delete(ctx context.Context, isnap *crownlabsv1alpha2.InstanceSnapshot) error
+}
+
+// ParseSnapshotBackend parses the given snapshot backend, returning an error if it is not valid.
+func ParseSnapshotBackend(backend string) (crownlabsv1alpha2.SnapshotBackend, error) {
+	switch crownlabsv1alpha2.SnapshotBackend(backend) {
+	case crownlabsv1alpha2.SnapshotBackendRegistry, crownlabsv1alpha2.SnapshotBackendS3, crownlabsv1alpha2.SnapshotBackendPVC:
+		return crownlabsv1alpha2.SnapshotBackend(backend), nil
+	default:
+		return "", fmt.Errorf("invalid snapshot backend %q", backend)
+	}
+}
+
+// snapshotBackend returns the backend the given InstanceSnapshot is exported to: the one recorded in the status,
+// if already started, otherwise the one requested in the spec or, if not specified, the default one.
+func (r *InstanceSnapshotReconciler) snapshotBackend(isnap *crownlabsv1alpha2.InstanceSnapshot) crownlabsv1alpha2.SnapshotBackend {
+	switch {
+	case isnap.Status.Backend != "":
+		return isnap.Status.Backend
+	case isnap.Status.Image != "":
+		// The snapshot was created before the introduction of the backends.
+		return crownlabsv1alpha2.SnapshotBackendRegistry
+	case isnap.Spec.Backend != "":
+		return isnap.Spec.Backend
+	case r.DefaultBackend != "":
+		return r.DefaultBackend
+	default:
+		return crownlabsv1alpha2.SnapshotBackendRegistry
+	}
+}
+
+// snapshotExporter returns the exporter of the given backend.
+func (r *InstanceSnapshotReconciler) snapshotExporter(backend crownlabsv1alpha2.SnapshotBackend) snapshotExporter {
+	switch backend {
+	case crownlabsv1alpha2.SnapshotBackendS3:
+		return &s3Exporter{r}
+	case crownlabsv1alpha2.SnapshotBackendPVC:
+		return &pvcExporter{r}
+	default:
+		return &registryExporter{r}
+	}
+}
+
+// validateNonRegistrySnapshot checks the requirements common to the backends other than the registry,
+// which can neither store incremental snapshots nor be used to publish templates.
+func validateNonRegistrySnapshot(isnap *crownlabsv1alpha2.InstanceSnapshot, backend crownlabsv1alpha2.SnapshotBackend) error {
+	if isnap.Spec.Mode == crownlabsv1alpha2.SnapshotModeIncremental {
+		return fmt.Errorf("incremental snapshots are not supported by the %s backend. It is not possible to complete the InstanceSnapshot %s",
+			backend, isnap.Name)
+	}
+	if isnap.Spec.PublishTemplate != nil {
+		return fmt.Errorf("snapshots exported to the %s backend cannot be published as templates. It is not possible to complete the InstanceSnapshot %s",
+			backend, isnap.Name)
+	}
+	return nil
+}
+
+// registryExporter builds the snapshot as a new image, and pushes it to the docker registry.
+type registryExporter struct {
+	r *InstanceSnapshotReconciler
+}
+
+// Validate checks whether the snapshot can be pushed to the registry.
+func (e *registryExporter) Validate(isnap *crownlabsv1alpha2.InstanceSnapshot, _ *crownlabsv1alpha2.Environment) error {
+	if e.r.VMRegistry == "" {
+		return fmt.Errorf("the registry backend is not configured. It is not possible to complete the InstanceSnapshot %s", isnap.Name)
+	}
+	return nil
+}
+
+// Configure adds to the job the kaniko (or crane, for incremental snapshots) container pushing the image.
+func (e *registryExporter) Configure(_ context.Context, isnap *crownlabsv1alpha2.InstanceSnapshot, job *snapshotJob) (string, error) {
+	destination := fmt.Sprintf("%s/%s/%s:%s", e.r.VMRegistry, job.Directory, isnap.Spec.ImageName, job.Tag)
+	isnap.Status.Image = destination
+
+	// Define secret VolumeSource.
+	job.Volumes = append(job.Volumes, corev1.Volume{
+		Name: "kaniko-secret",
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName: e.r.RegistrySecretName,
+				Items: []corev1.KeyToPath{
+					{
+						Key:  ".dockerconfigjson",
+						Path: "config.json",
+					},
+				},
+			},
+		},
+	})
+
+	// Define Docker pusher container.
+	job.Pusher = &corev1.Container{
+		Name:  "docker-pusher",
+		Image: e.r.ContainersSnapshot.ContainerKaniko,
+		// The digest of the pushed image is written in the termination message, to be reported in the status.
+		Args: []string{"--dockerfile=/workspace/Dockerfile", "--destination=" + destination,
+			"--digest-file=" + corev1.TerminationMessagePathDefault},
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      "tmp-vol",
+				MountPath: "/workspace",
+			},
+			{
+				Name:      "kaniko-secret",
+				MountPath: "/kaniko/.docker/",
+			},
+		},
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{
+				"cpu":    resource.MustParse("1"),
+				"memory": resource.MustParse("8Gi"),
+			},
+			Limits: corev1.ResourceList{
+				"cpu":    resource.MustParse("1"),
+				"memory": resource.MustParse("32Gi"),
+			},
+		},
+	}
+
+	switch {
+	case job.Environment.EnvironmentType == crownlabsv1alpha2.ClassContainer:
+		configureContainerSnapshot(&job.Exporter, isnap.Spec.ContainerFormat, job.Environment.Image)
+	case isnap.Spec.Mode == crownlabsv1alpha2.SnapshotModeIncremental:
+		configureIncrementalSnapshot(&job.Exporter, job.Pusher, job.Environment.Image, destination, e.r.ContainersSnapshot.ContainerCrane)
+		if cache := utils.ConfigureImgExporterCache(&job.Exporter, e.r.ImgExporterCacheDir); cache != nil {
+			job.Volumes = append(job.Volumes, *cache)
+		}
+	}
+	return destination, nil
+}
+
+// Deletable returns whether the client of the registry is configured.
+func (e *registryExporter) Deletable() bool {
+	return e.r.Registry != nil
+}
+
+// Delete deletes the image pushed by the given InstanceSnapshot from the registry.
+func (e *registryExporter) Delete(ctx context.Context, isnap *crownlabsv1alpha2.InstanceSnapshot) error {
+	if isnap.Status.Image == "" {
+		return nil
+	}
+
+	creds, err := e.credentials(ctx, isnap)
+	if err != nil {
+		return err
+	}
+	if err := e.r.Registry.DeleteImage(ctx, isnap.Status.Image, isnap.Status.ImageDigest, creds); err != nil {
+		return fmt.Errorf("error when deleting the image of InstanceSnapshot %s -> %w", isnap.Name, err)
+	}
+	return nil
+}
+
+// Digest returns the digest of the image pushed by the given InstanceSnapshot, resolving it from the registry in case
+// it was not recorded in the status. An empty digest is returned if the image does not exist.
+func (e *registryExporter) Digest(ctx context.Context, isnap *crownlabsv1alpha2.InstanceSnapshot) (string, error) {
+	if isnap.Status.ImageDigest != "" || isnap.Status.Image == "" {
+		return isnap.Status.ImageDigest, nil
+	}
+
+	creds, err := e.credentials(ctx, isnap)
+	if err != nil {
+		return "", err
+	}
+	digest, err := e.r.Registry.ImageDigest(ctx, isnap.Status.Image, creds)
+	if err != nil {
+		return "", fmt.Errorf("error when resolving the digest of the image of InstanceSnapshot %s -> %w", isnap.Name, err)
+	}
+	return digest, nil
+}
+
+// credentials returns the credentials to access the registry, which are the same used by the job to push the image
+// (secrets are not cached by the manager). Nil is returned if the secret does not exist in the namespace of the snapshot.
+func (e *registryExporter) credentials(ctx context.Context, isnap *crownlabsv1alpha2.InstanceSnapshot) (*RegistryCredentials, error) {
+	secret := &corev1.Secret{}
+	if err := e.r.APIReader.Get(ctx, types.NamespacedName{Namespace: isnap.Namespace, Name: e.r.RegistrySecretName}, secret); client.IgnoreNotFound(err) != nil {
+		return nil, fmt.Errorf("error in retrieving the registry secret for InstanceSnapshot %s -> %w", isnap.Name, err)
+	} else if err != nil {
+		return nil, nil
+	}
+	return registryCredentialsFromDockerConfig(secret.Data[corev1.DockerConfigJsonKey], e.r.VMRegistry)
+}
+
+// s3Exporter uploads the exported disk (or the archive of the persistent drive) to an S3-compatible object storage.
+type s3Exporter struct {
+	r *InstanceSnapshotReconciler
+}
+
+// Validate checks whether the snapshot can be uploaded to the object storage.
+func (e *s3Exporter) Validate(isnap *crownlabsv1alpha2.InstanceSnapshot, _ *crownlabsv1alpha2.Environment) error {
+	if e.r.S3.Bucket == "" {
+		return fmt.Errorf("the S3 backend is not configured. It is not possible to complete the InstanceSnapshot %s", isnap.Name)
+	}
+	return validateNonRegistrySnapshot(isnap, crownlabsv1alpha2.SnapshotBackendS3)
+}
+
+// Configure adds to the job the aws-cli container uploading the exported file to the bucket.
+func (e *s3Exporter) Configure(_ context.Context, isnap *crownlabsv1alpha2.InstanceSnapshot, job *snapshotJob) (string, error) {
+	file := "vm-snapshot.qcow2"
+	if job.Environment.EnvironmentType == crownlabsv1alpha2.ClassContainer {
+		// The content of the persistent drive is always packed in an archive.
+		file = "mydrive.tar.gz"
+		configureContainerSnapshot(&job.Exporter, crownlabsv1alpha2.ContainerSnapshotArchive, job.Environment.Image)
+	}
+
+	key := fmt.Sprintf("%s/%s/%s%s", job.Directory, isnap.Spec.ImageName, job.Tag, file[strings.Index(file, "."):])
+	location := fmt.Sprintf("s3://%s/%s", e.r.S3.Bucket, key)
+
+	args := []string{"s3", "cp", "/workspace/" + file, location}
+	if e.r.S3.Endpoint != "" {
+		args = append(args, "--endpoint-url", e.r.S3.Endpoint)
+	}
+	if e.r.S3.Region != "" {
+		args = append(args, "--region", e.r.S3.Region)
+	}
+
+	job.Pusher = &corev1.Container{
+		Name:  "s3-uploader",
+		Image: e.r.ContainersSnapshot.ContainerS3Uploader,
+		Args:  args,
+		EnvFrom: []corev1.EnvFromSource{{
+			SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: e.r.S3.SecretName}},
+		}},
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      "tmp-vol",
+				MountPath: "/workspace",
+				ReadOnly:  true,
+			},
+		},
+		// The file is streamed to the object storage, without loading it in memory.
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{
+				"cpu":    resource.MustParse("100m"),
+				"memory": resource.MustParse("128Mi"),
+			},
+			Limits: corev1.ResourceList{
+				"cpu":    resource.MustParse("1"),
+				"memory": resource.MustParse("512Mi"),
+			},
+		},
+	}
+	return location, nil
+}
+
+// Deletable returns true, since the objects can always be deleted from the bucket.
+func (e *s3Exporter) Deletable() bool {
+	return true
+}
+
+// Delete deletes the object uploaded by the given InstanceSnapshot from the bucket.
+func (e *s3Exporter) Delete(ctx context.Context, isnap *crownlabsv1alpha2.InstanceSnapshot) error {
+	path := strings.SplitN(strings.TrimPrefix(isnap.Status.Location, "s3://"), "/", 2)
+	if len(path) != 2 {
+		return nil
+	}
+
+	secret := &corev1.Secret{}
+	if err := e.r.APIReader.Get(ctx, types.NamespacedName{Namespace: isnap.Namespace, Name: e.r.S3.SecretName}, secret); err != nil {
+		return fmt.Errorf("error in retrieving the S3 secret for InstanceSnapshot %s -> %w", isnap.Name, err)
+	}
+	creds := S3Credentials{
+		AccessKeyID:     string(secret.Data[s3AccessKeyIDKey]),
+		SecretAccessKey: string(secret.Data[s3SecretAccessKeyKey]),
+	}
+
+	if err := NewS3Client(e.r.S3.Endpoint, e.r.S3.Region).DeleteObject(ctx, path[0], path[1], creds); err != nil {
+		return fmt.Errorf("error when deleting the object of InstanceSnapshot %s -> %w", isnap.Name, err)
+	}
+	return nil
+}
+
+// pvcExporter clones the content of the volume of the instance into a new PVC, owned by the InstanceSnapshot.
+type pvcExporter struct {
+	r *InstanceSnapshotReconciler
+}
+
+// Validate checks whether the volume can be cloned.
+func (e *pvcExporter) Validate(isnap *crownlabsv1alpha2.InstanceSnapshot, _ *crownlabsv1alpha2.Environment) error {
+	return validateNonRegistrySnapshot(isnap, crownlabsv1alpha2.SnapshotBackendPVC)
+}
+
+// Configure creates the destination PVC, sized as the source one, and mounts it in the exporter container.
+func (e *pvcExporter) Configure(ctx context.Context, isnap *crownlabsv1alpha2.InstanceSnapshot, job *snapshotJob) (string, error) {
+	source := &corev1.PersistentVolumeClaim{}
+	if err := e.r.Get(ctx, types.NamespacedName{Namespace: isnap.Namespace, Name: job.Source}, source); err != nil {
+		return "", fmt.Errorf("error in retrieving the volume of the instance for InstanceSnapshot %s -> %w", isnap.Name, err)
+	}
+	size, found := source.Status.Capacity[corev1.ResourceStorage]
+	if !found {
+		size = source.Spec.Resources.Requests[corev1.ResourceStorage]
+	}
+
+	pvc := corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: clonedClaimName(isnap), Namespace: isnap.Namespace},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			Resources:   corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceStorage: size}},
+		},
+	}
+	if e.r.CloneStorageClass != "" {
+		pvc.Spec.StorageClassName = &e.r.CloneStorageClass
+	}
+	// The PVC is deleted together with the InstanceSnapshot.
+	if err := ctrl.SetControllerReference(isnap, &pvc, e.r.Scheme); err != nil {
+		return "", err
+	}
+	if err := e.r.Create(ctx, &pvc); err != nil && !errors.IsAlreadyExists(err) {
+		return "", fmt.Errorf("error when creating the PVC for InstanceSnapshot %s -> %w", isnap.Name, err)
+	}
+
+	// The content of the volume is copied as is, hence no further containers are needed.
+	job.Volumes = append(job.Volumes, corev1.Volume{
+		Name: "export-vol",
+		VolumeSource: corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: pvc.Name},
+		},
+	})
+	job.Exporter.Command = []string{"/exporter.sh"}
+	job.Exporter.Args = []string{"--mode", "clone", "--out-dir", "/export"}
+	job.Exporter.VolumeMounts = append(job.Exporter.VolumeMounts, corev1.VolumeMount{Name: "export-vol", MountPath: "/export"})
+	return pvc.Name, nil
+}
+
+// Deletable returns true, since the PVC is garbage collected together with the InstanceSnapshot.
+func (e *pvcExporter) Deletable() bool {
+	return true
+}
+
+// Delete does nothing, since the PVC is owned by the InstanceSnapshot.
+func (e *pvcExporter) Delete(_ context.Context, _ *crownlabsv1alpha2.InstanceSnapshot) error {
+	return nil
+}
+
+// clonedClaimName returns the name of the PVC the volume is cloned to by the given InstanceSnapshot.
+func clonedClaimName(isnap *crownlabsv1alpha2.InstanceSnapshot) string {
+	return isnap.Name + "-export"
+}
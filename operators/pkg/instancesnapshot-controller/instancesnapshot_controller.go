@@ -34,29 +34,49 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 
 	crownlabsv1alpha2 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha2"
-	"github.com/netgroup-polito/CrownLabs/operators/pkg/utils"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/selectors"
 )
 
 // ContainersSnapshotOpts contains image names and tags of the containers needed for the VM snapshot.
 type ContainersSnapshotOpts struct {
-	ContainerKaniko    string
-	ContainerImgExport string
+	ContainerKaniko     string
+	ContainerImgExport  string
+	ContainerCrane      string
+	ContainerS3Uploader string
 }
 
 // InstanceSnapshotReconciler reconciles a InstanceSnapshot object.
 type InstanceSnapshotReconciler struct {
 	client.Client
+	// APIReader is used to retrieve the pods of the snapshotting jobs, which are not cached by the manager.
+	APIReader          client.Reader
 	EventsRecorder     record.EventRecorder
 	Scheme             *runtime.Scheme
 	NamespaceWhitelist metav1.LabelSelector
 	VMRegistry         string
 	RegistrySecretName string
 	ContainersSnapshot ContainersSnapshotOpts
+	LiveSnapshots      LiveSnapshotStrategy
+	// Registry is used to delete the images of the snapshots no longer retained. Retention is not enforced if nil.
+	Registry *RegistryClient
+	// DefaultBackend is the backend the snapshots are exported to, if not specified in the InstanceSnapshot.
+	DefaultBackend crownlabsv1alpha2.SnapshotBackend
+	// S3 is the configuration of the object storage used by the S3 backend.
+	S3 S3Opts
+	// CloneStorageClass is the storage class of the PVCs created by the PVC backend (the default one if empty).
+	CloneStorageClass string
+	// JobLimits are the limits to the number of snapshotting jobs running at the same time.
+	JobLimits JobConcurrencyLimits
+	// ImgExporterCacheDir is the host directory the img-exporter caches the disks of the base images of incremental snapshots in (disabled if empty).
+	ImgExporterCacheDir string
 
 	// This function, if configured, is deferred at the beginning of the Reconcile.
 	// Specifically, it is meant to be set to GinkgoRecover during the tests,
 	// in order to lead to a controlled failure in case the Reconcile panics.
 	ReconcileDeferHook func()
+
+	// nsSelector matches the namespaces whose snapshots are reconciled, built once from NamespaceWhitelist.
+	nsSelector *selectors.NamespaceSelector
 }
 
 // Reconcile reconciles the status of the InstanceSnapshot resource.
@@ -75,8 +95,20 @@ func (r *InstanceSnapshotReconciler) Reconcile(ctx context.Context, req ctrl.Req
 		return ctrl.Result{}, nil
 	}
 
+	if !isnap.DeletionTimestamp.IsZero() {
+		klog.Infof("Processing deletion of InstanceSnapshot %s in %s namespace", isnap.Name, isnap.Namespace)
+		if done, err := r.HandleSnapshotDeletion(ctx, isnap); err != nil {
+			klog.Error(err)
+			return ctrl.Result{}, err
+		} else if !done {
+			// The snapshotting job is being deleted, check again later.
+			return ctrl.Result{RequeueAfter: liveSnapshotPollInterval}, nil
+		}
+		return ctrl.Result{}, nil
+	}
+
 	// Check the selector label, in order to know whether to perform or not reconciliation.
-	if proceed, err := utils.CheckSelectorLabel(ctx, r.Client, isnap.Namespace, r.NamespaceWhitelist.MatchLabels); !proceed {
+	if proceed, err := r.nsSelector.Matches(ctx, isnap.Namespace); !proceed {
 		// If there was an error while checking, show the error and try again.
 		if err != nil {
 			klog.Error(err)
@@ -108,6 +140,14 @@ func (r *InstanceSnapshotReconciler) Reconcile(ctx context.Context, req ctrl.Req
 			// Add the event and stop reconciliation since the request is not valid.
 			r.EventsRecorder.Event(isnap, "Warning", "ValidationError", fmt.Sprintf("%s", err1))
 			return ctrl.Result{}, nil
+		} else if IsQueued(isnap) {
+			// The concurrency limits of the snapshotting jobs are reached, check again later.
+			klog.Infof("InstanceSnapshot %s queued in position %d", isnap.Name, isnap.Status.QueuePosition)
+			return ctrl.Result{RequeueAfter: snapshotQueuePollInterval}, nil
+		} else if IsWaitingForVolume(isnap) {
+			// The volume of the running instance is not yet available, check again later.
+			klog.Infof("InstanceSnapshot %s waiting for the volume of the instance", isnap.Name)
+			return ctrl.Result{RequeueAfter: liveSnapshotPollInterval}, nil
 		}
 		// Job successfully created
 		r.EventsRecorder.Event(isnap, "Normal", "Creation", fmt.Sprintf("Job %s for snapshot creation started", isnap.Name))
@@ -124,7 +164,7 @@ func (r *InstanceSnapshotReconciler) Reconcile(ctx context.Context, req ctrl.Req
 			return ctrl.Result{}, err1
 		case jstatus == batch.JobComplete:
 
-			successMessage := fmt.Sprintf("Image %s created and uploaded", isnap.Spec.ImageName)
+			successMessage := fmt.Sprintf("Snapshot %s created and exported", isnap.Status.Location)
 			// If we are able to retrieve the execution time, report it
 			if found.Status.StartTime != nil && found.Status.CompletionTime != nil {
 				extime := found.Status.CompletionTime.Sub(found.Status.StartTime.Time)
@@ -132,10 +172,39 @@ func (r *InstanceSnapshotReconciler) Reconcile(ctx context.Context, req ctrl.Req
 			}
 			klog.Info(successMessage)
 			r.EventsRecorder.Event(isnap, "Normal", "Created", successMessage)
+
+			// Publish the pushed image as a new template, if requested
+			if retry, err2 := r.PublishTemplate(ctx, isnap); err2 != nil {
+				klog.Error(err2)
+				r.EventsRecorder.Event(isnap, "Warning", "PublishFailed", fmt.Sprintf("%s", err2))
+				if err3 := r.updateSnapshotCondition(ctx, isnap, crownlabsv1alpha2.InstanceSnapshotTemplatePublished,
+					metav1.ConditionFalse, "PublishFailed", err2.Error()); err3 != nil {
+					klog.Error(err3)
+				}
+				if retry {
+					return ctrl.Result{}, err2
+				}
+			} else if isnap.Spec.PublishTemplate != nil {
+				message := fmt.Sprintf("Template %s published in the workspace", isnap.Spec.PublishTemplate.Name)
+				r.EventsRecorder.Event(isnap, "Normal", "TemplatePublished", message)
+				if err3 := r.updateSnapshotCondition(ctx, isnap, crownlabsv1alpha2.InstanceSnapshotTemplatePublished,
+					metav1.ConditionTrue, "TemplatePublished", message); err3 != nil {
+					klog.Error(err3)
+					return ctrl.Result{}, err3
+				}
+			}
+
+			// Delete the snapshots with the same image name no longer retained
+			requeue, err2 := r.EnforceRetention(ctx, isnap)
+			if err2 != nil {
+				klog.Error(err2)
+				return ctrl.Result{}, err2
+			}
+			return ctrl.Result{RequeueAfter: requeue}, nil
 		case jstatus == batch.JobFailed:
 
 			klog.Infof("Image %s could not be created", isnap.Spec.ImageName)
-			r.EventsRecorder.Event(isnap, "Warning", "CreationFailed", "The creation job failed")
+			r.EventsRecorder.Event(isnap, "Warning", "CreationFailed", fmt.Sprintf("The creation job failed: %s", isnap.Status.FailureReason))
 		}
 	}
 
@@ -144,9 +213,17 @@ func (r *InstanceSnapshotReconciler) Reconcile(ctx context.Context, req ctrl.Req
 
 // SetupWithManager registers a new controller for InstanceSnapshot resources.
 func (r *InstanceSnapshotReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
+	// Only the snapshots in the namespaces satisfying the whitelist are reconciled.
+	nsSelector, err := selectors.NewNamespaceSelector(mgr.GetClient(), &r.NamespaceWhitelist)
+	if err != nil {
+		return err
+	}
+	r.nsSelector = nsSelector
+
+	blder := ctrl.NewControllerManagedBy(mgr).
 		// The generation changed predicate allow to avoid updates on the status changes of the InstanceSnapshot
-		For(&crownlabsv1alpha2.InstanceSnapshot{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
-		Owns(&batch.Job{}).
+		For(&crownlabsv1alpha2.InstanceSnapshot{}, builder.WithPredicates(predicate.GenerationChangedPredicate{}, nsSelector.Predicate())).
+		Owns(&batch.Job{}, builder.WithPredicates(nsSelector.Predicate()))
+	return nsSelector.Watch(blder, func() client.ObjectList { return &crownlabsv1alpha2.InstanceSnapshotList{} }).
 		Complete(r)
 }
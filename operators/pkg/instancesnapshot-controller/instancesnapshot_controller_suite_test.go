@@ -13,11 +13,13 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
+	virtv1 "kubevirt.io/client-go/api/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/envtest"
 	"sigs.k8s.io/controller-runtime/pkg/envtest/printer"
 
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
 	crownlabsv1alpha2 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha2"
 	instancesnapshot_controller "github.com/netgroup-polito/CrownLabs/operators/pkg/instancesnapshot-controller"
 )
@@ -25,6 +27,7 @@ import (
 var cfg *rest.Config
 var k8sClient client.Client
 var testEnv *envtest.Environment
+var registry *fakeRegistry
 
 func TestAPIs(t *testing.T) {
 	RegisterFailHandler(Fail)
@@ -45,8 +48,12 @@ var _ = BeforeSuite(func(done Done) {
 	Expect(err).ToNot(HaveOccurred())
 	Expect(cfg).ToNot(BeNil())
 
+	err = crownlabsv1alpha1.AddToScheme(scheme.Scheme)
+	Expect(err).NotTo(HaveOccurred())
 	err = crownlabsv1alpha2.AddToScheme(scheme.Scheme)
 	Expect(err).NotTo(HaveOccurred())
+	err = virtv1.AddToScheme(scheme.Scheme)
+	Expect(err).NotTo(HaveOccurred())
 
 	// +kubebuilder:scaffold:scheme
 	k8sManager, err := ctrl.NewManager(cfg, ctrl.Options{
@@ -55,6 +62,9 @@ var _ = BeforeSuite(func(done Done) {
 	})
 	Expect(err).ToNot(HaveOccurred())
 
+	// Start the fake registry the images of the expired snapshots are deleted from
+	registry = newFakeRegistry()
+
 	// Generate whitelist map for InstanceSnapshot controller reconciliation
 	whiteListMap := map[string]string{
 		"test-suite": "true",
@@ -62,14 +72,24 @@ var _ = BeforeSuite(func(done Done) {
 
 	err = (&instancesnapshot_controller.InstanceSnapshotReconciler{
 		Client:             k8sManager.GetClient(),
+		APIReader:          k8sManager.GetAPIReader(),
 		Scheme:             k8sManager.GetScheme(),
 		EventsRecorder:     k8sManager.GetEventRecorderFor("instance-snapshot"),
 		NamespaceWhitelist: metav1.LabelSelector{MatchLabels: whiteListMap, MatchExpressions: []metav1.LabelSelectorRequirement{}},
 		VMRegistry:         "my-registry",
 		RegistrySecretName: "kaniko-secret",
 		ContainersSnapshot: instancesnapshot_controller.ContainersSnapshotOpts{
-			ContainerKaniko:    "kaniko",
-			ContainerImgExport: "crownlabs/img-export",
+			ContainerKaniko:     "kaniko",
+			ContainerImgExport:  "crownlabs/img-export",
+			ContainerCrane:      "crane",
+			ContainerS3Uploader: "aws-cli",
+		},
+		LiveSnapshots: instancesnapshot_controller.LiveSnapshotStopAndRestart,
+		Registry:      instancesnapshot_controller.NewRegistryClient(registry.URL),
+		S3: instancesnapshot_controller.S3Opts{
+			Endpoint:   "http://my-object-storage",
+			Bucket:     "snapshots",
+			SecretName: "s3-secret",
 		},
 	}).SetupWithManager(k8sManager)
 	Expect(err).ToNot(HaveOccurred())
@@ -87,6 +107,7 @@ var _ = BeforeSuite(func(done Done) {
 
 var _ = AfterSuite(func() {
 	By("tearing down the test environment")
+	registry.Close()
 	err := testEnv.Stop()
 	Expect(err).ToNot(HaveOccurred())
 })
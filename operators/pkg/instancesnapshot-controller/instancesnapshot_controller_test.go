@@ -3,6 +3,7 @@ package instancesnapshot_controller_test
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	. "github.com/onsi/ginkgo"
@@ -11,11 +12,15 @@ import (
 	batch "k8s.io/api/batch/v1"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/utils/pointer"
+	ctrl "sigs.k8s.io/controller-runtime"
 
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
 	crownlabsv1alpha2 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha2"
 )
 
@@ -171,6 +176,16 @@ var _ = Describe("InstancesnapshotController", func() {
 
 			By("Checking if the InstanceSnapshot status is Completed")
 			checkIsnapStatus(ctx, newInstanceSnapshot.Name, WorkingNamespace, crownlabsv1alpha2.Completed, timeout, interval)
+
+			By("Checking that the outcome of the job is reported in the InstanceSnapshot status")
+			isnapLookupKey := types.NamespacedName{Name: newInstanceSnapshot.Name, Namespace: WorkingNamespace}
+			Expect(k8sClient.Get(ctx, isnapLookupKey, newInstanceSnapshot)).Should(Succeed())
+			Expect(newInstanceSnapshot.Status.JobName).Should(Equal(snapjob.Name))
+			Expect(newInstanceSnapshot.Status.Image).Should(ContainSubstring("/" + newInstanceSnapshot.Spec.ImageName + ":"))
+			Expect(newInstanceSnapshot.Status.StartTime).ShouldNot(BeNil())
+			Expect(newInstanceSnapshot.Status.CompletionTime).ShouldNot(BeNil())
+			Expect(meta.IsStatusConditionTrue(newInstanceSnapshot.Status.Conditions, string(crownlabsv1alpha2.InstanceSnapshotValidated))).Should(BeTrue())
+			Expect(meta.IsStatusConditionTrue(newInstanceSnapshot.Status.Conditions, string(crownlabsv1alpha2.InstanceSnapshotImagePushed))).Should(BeTrue())
 		})
 
 		It("Should start snapshot creation given an environment name", func() {
@@ -193,22 +208,299 @@ var _ = Describe("InstancesnapshotController", func() {
 		})
 	})
 
-	Context("Testing incorrect environment configurations", func() {
-		It("Should fail: the VM is running", func() {
-			By("Getting current instance")
+	Context("Creating an incremental snapshot of a persistent VM", func() {
+		It("Should push only the overlay on top of the environment image", func() {
+			newInstanceSnapshot := instanceSnapshot.DeepCopy()
+			newInstanceSnapshot.Name = fmt.Sprintf("isnap-sample-%v", rand.Int())
+			newInstanceSnapshot.Spec.Mode = crownlabsv1alpha2.SnapshotModeIncremental
+			checkIsnapSuccessfulCreation(ctx, newInstanceSnapshot, WorkingNamespace, timeout, interval)
+
+			By("Checking the containers of the job")
+			jobLookupKey := types.NamespacedName{Name: newInstanceSnapshot.Name, Namespace: WorkingNamespace}
+			snapjob := &batch.Job{}
+			Expect(k8sClient.Get(ctx, jobLookupKey, snapjob)).Should(Succeed())
+
+			baseImage := templateEnvironment.EnvironmentList[0].Image
+			Expect(snapjob.Spec.Template.Spec.InitContainers).Should(HaveLen(1))
+			Expect(snapjob.Spec.Template.Spec.InitContainers[0].Args).Should(Equal([]string{"--mode", "incremental", "--base-image", baseImage}))
+			Expect(snapjob.Spec.Template.Spec.Containers).Should(HaveLen(1))
+			Expect(snapjob.Spec.Template.Spec.Containers[0].Image).Should(Equal("crane"))
+			Expect(snapjob.Spec.Template.Spec.Containers[0].Args).Should(ContainElements("append", baseImage))
+		})
+	})
+
+	Context("Creating a snapshot of a running persistent VM", func() {
+		It("Should stop the VM, and restart it once completed", func() {
+			By("Setting instance as powered on")
 			currentInstance := &crownlabsv1alpha2.Instance{}
 			instanceLookupKey := types.NamespacedName{Name: InstanceName, Namespace: WorkingNamespace}
 			Expect(k8sClient.Get(ctx, instanceLookupKey, currentInstance)).Should(Succeed())
+			currentInstance.Spec.Running = true
+			Expect(k8sClient.Update(ctx, currentInstance)).Should(Succeed())
+
+			newInstanceSnapshot := instanceSnapshot.DeepCopy()
+			newInstanceSnapshot.Name = fmt.Sprintf("isnap-sample-%v", rand.Int())
+			checkIsnapSuccessfulCreation(ctx, newInstanceSnapshot, WorkingNamespace, timeout, interval)
+
+			By("Checking that the instance has been stopped")
+			Expect(k8sClient.Get(ctx, instanceLookupKey, currentInstance)).Should(Succeed())
+			Expect(currentInstance.Spec.Running).Should(BeFalse())
+			isnapLookupKey := types.NamespacedName{Name: newInstanceSnapshot.Name, Namespace: WorkingNamespace}
+			Expect(k8sClient.Get(ctx, isnapLookupKey, newInstanceSnapshot)).Should(Succeed())
+			Expect(newInstanceSnapshot.Status.InstanceStopped).Should(BeTrue())
 
+			By("Changing the job status to completed")
+			jobLookupKey := types.NamespacedName{Name: newInstanceSnapshot.Name, Namespace: WorkingNamespace}
+			snapjob := &batch.Job{}
+			Expect(k8sClient.Get(ctx, jobLookupKey, snapjob)).Should(Succeed())
+			snapjob.Status.Conditions = []batch.JobCondition{
+				{Type: batch.JobComplete, Status: v1.ConditionTrue},
+			}
+			Expect(k8sClient.Status().Update(ctx, snapjob)).Should(Succeed())
+
+			By("Checking that the instance has been restarted")
+			checkIsnapStatus(ctx, newInstanceSnapshot.Name, WorkingNamespace, crownlabsv1alpha2.Completed, timeout, interval)
+			Expect(k8sClient.Get(ctx, instanceLookupKey, currentInstance)).Should(Succeed())
+			Expect(currentInstance.Spec.Running).Should(BeTrue())
+		})
+
+		It("Should restart the VM if the snapshot is deleted before completing", func() {
 			By("Setting instance as powered on")
+			currentInstance := &crownlabsv1alpha2.Instance{}
+			instanceLookupKey := types.NamespacedName{Name: InstanceName, Namespace: WorkingNamespace}
+			Expect(k8sClient.Get(ctx, instanceLookupKey, currentInstance)).Should(Succeed())
 			currentInstance.Spec.Running = true
 			Expect(k8sClient.Update(ctx, currentInstance)).Should(Succeed())
 
 			newInstanceSnapshot := instanceSnapshot.DeepCopy()
 			newInstanceSnapshot.Name = fmt.Sprintf("isnap-sample-%v", rand.Int())
+			checkIsnapSuccessfulCreation(ctx, newInstanceSnapshot, WorkingNamespace, timeout, interval)
+
+			By("Checking that the instance has been stopped, and the snapshot protected by the finalizer")
+			Expect(k8sClient.Get(ctx, instanceLookupKey, currentInstance)).Should(Succeed())
+			Expect(currentInstance.Spec.Running).Should(BeFalse())
+			isnapLookupKey := types.NamespacedName{Name: newInstanceSnapshot.Name, Namespace: WorkingNamespace}
+			Expect(k8sClient.Get(ctx, isnapLookupKey, newInstanceSnapshot)).Should(Succeed())
+			Expect(newInstanceSnapshot.Finalizers).Should(ContainElement(crownlabsv1alpha2.InstOperatorFinalizerName))
+
+			By("Deleting the snapshot before the job completes")
+			Expect(k8sClient.Delete(ctx, newInstanceSnapshot)).Should(Succeed())
+			doesEventuallyExists(ctx, isnapLookupKey, &crownlabsv1alpha2.InstanceSnapshot{}, BeFalse(), timeout, interval)
+
+			By("Checking that the instance has been restarted")
+			Expect(k8sClient.Get(ctx, instanceLookupKey, currentInstance)).Should(Succeed())
+			Expect(currentInstance.Spec.Running).Should(BeTrue())
+			doesEventuallyExists(ctx, isnapLookupKey, &batch.Job{}, BeFalse(), timeout, interval)
+		})
+	})
+
+	Context("Publishing a snapshot as a new template", func() {
+		It("Should create the template in the workspace namespace", func() {
+			By("Setting the tenant as manager of the workspace of the template")
+			Expect(k8sClient.Create(ctx, &crownlabsv1alpha1.Tenant{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   TenantName,
+					Labels: map[string]string{crownlabsv1alpha1.WorkspaceLabelPrefix + "test-ws": string(crownlabsv1alpha1.Manager)},
+				},
+				Spec: crownlabsv1alpha1.TenantSpec{FirstName: "Mario", LastName: "Rossi", Email: "mario@rossi.com"},
+			})).Should(Succeed())
+
+			By("Setting the tenant as owner of the namespace of the snapshot")
+			tenant := &crownlabsv1alpha1.Tenant{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: TenantName}, tenant)).Should(Succeed())
+			ns := &v1.Namespace{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: WorkingNamespace}, ns)).Should(Succeed())
+			Expect(ctrl.SetControllerReference(tenant, ns, k8sClient.Scheme())).Should(Succeed())
+			Expect(k8sClient.Update(ctx, ns)).Should(Succeed())
+
+			currentTemplate := &crownlabsv1alpha2.Template{}
+			templateLookupKey := types.NamespacedName{Name: TemplateName, Namespace: WorkingNamespace}
+			Expect(k8sClient.Get(ctx, templateLookupKey, currentTemplate)).Should(Succeed())
+			currentTemplate.Spec.WorkspaceRef = crownlabsv1alpha2.GenericRef{Name: "test-ws"}
+			Expect(k8sClient.Update(ctx, currentTemplate)).Should(Succeed())
+
+			newInstanceSnapshot := instanceSnapshot.DeepCopy()
+			newInstanceSnapshot.Name = fmt.Sprintf("isnap-sample-%v", rand.Int())
+			newInstanceSnapshot.Spec.PublishTemplate = &crownlabsv1alpha2.PublishedTemplate{Name: "published-template"}
+			checkIsnapSuccessfulCreation(ctx, newInstanceSnapshot, WorkingNamespace, timeout, interval)
+
+			By("Changing the job status to completed")
+			jobLookupKey := types.NamespacedName{Name: newInstanceSnapshot.Name, Namespace: WorkingNamespace}
+			snapjob := &batch.Job{}
+			Expect(k8sClient.Get(ctx, jobLookupKey, snapjob)).Should(Succeed())
+			snapjob.Status.Conditions = []batch.JobCondition{
+				{Type: batch.JobComplete, Status: v1.ConditionTrue},
+			}
+			Expect(k8sClient.Status().Update(ctx, snapjob)).Should(Succeed())
+			checkIsnapStatus(ctx, newInstanceSnapshot.Name, WorkingNamespace, crownlabsv1alpha2.Completed, timeout, interval)
+
+			By("Checking that the template has been published")
+			isnapLookupKey := types.NamespacedName{Name: newInstanceSnapshot.Name, Namespace: WorkingNamespace}
+			Expect(k8sClient.Get(ctx, isnapLookupKey, newInstanceSnapshot)).Should(Succeed())
+			published := &crownlabsv1alpha2.Template{}
+			publishedLookupKey := types.NamespacedName{Name: "published-template", Namespace: WorkingNamespace}
+			doesEventuallyExists(ctx, publishedLookupKey, published, BeTrue(), timeout, interval)
+			Expect(published.Labels).Should(HaveKeyWithValue(crownlabsv1alpha2.InstanceSnapshotNameLabel, newInstanceSnapshot.Name))
+			Expect(published.Labels).Should(HaveKeyWithValue(crownlabsv1alpha2.InstanceSnapshotNamespaceLabel, WorkingNamespace))
+			Expect(published.Spec.WorkspaceRef.Name).Should(Equal("test-ws"))
+			Expect(published.Spec.EnvironmentList).Should(HaveLen(1))
+			Expect(published.Spec.EnvironmentList[0].Image).Should(Equal(newInstanceSnapshot.Status.Image))
+			Expect(published.Spec.EnvironmentList[0].EnvironmentType).Should(Equal(crownlabsv1alpha2.ClassVM))
+		})
+	})
+
+	Context("Enforcing the retention policy of the snapshots", func() {
+		It("Should delete the oldest snapshots exceeding the limit, and their images", func() {
+			By("Setting a retention policy in the workspace of the template")
+			Expect(k8sClient.Create(ctx, &crownlabsv1alpha1.Workspace{
+				ObjectMeta: metav1.ObjectMeta{Name: "retention-ws"},
+				Spec: crownlabsv1alpha1.WorkspaceSpec{
+					PrettyName:        "Retention Workspace",
+					SnapshotRetention: &crownlabsv1alpha1.SnapshotRetentionPolicy{KeepLast: pointer.Int32Ptr(1)},
+				},
+			})).Should(Succeed())
+			currentTemplate := &crownlabsv1alpha2.Template{}
+			templateLookupKey := types.NamespacedName{Name: TemplateName, Namespace: WorkingNamespace}
+			Expect(k8sClient.Get(ctx, templateLookupKey, currentTemplate)).Should(Succeed())
+			currentTemplate.Spec.WorkspaceRef = crownlabsv1alpha2.GenericRef{Name: "retention-ws"}
+			Expect(k8sClient.Update(ctx, currentTemplate)).Should(Succeed())
+
+			By("Creating two snapshots with the same image name, completed at different times")
+			var snapshots []*crownlabsv1alpha2.InstanceSnapshot
+			for _, completion := range []time.Time{time.Now().Add(-time.Hour), time.Now()} {
+				newInstanceSnapshot := instanceSnapshot.DeepCopy()
+				newInstanceSnapshot.Name = fmt.Sprintf("isnap-sample-%v", rand.Int())
+				newInstanceSnapshot.Spec.ImageName = "retention-image"
+				checkIsnapSuccessfulCreation(ctx, newInstanceSnapshot, WorkingNamespace, timeout, interval)
+
+				jobLookupKey := types.NamespacedName{Name: newInstanceSnapshot.Name, Namespace: WorkingNamespace}
+				snapjob := &batch.Job{}
+				Expect(k8sClient.Get(ctx, jobLookupKey, snapjob)).Should(Succeed())
+				snapjob.Status.Conditions = []batch.JobCondition{
+					{Type: batch.JobComplete, Status: v1.ConditionTrue},
+				}
+				snapjob.Status.StartTime = &metav1.Time{Time: completion.Add(-time.Minute)}
+				snapjob.Status.CompletionTime = &metav1.Time{Time: completion}
+				Expect(k8sClient.Status().Update(ctx, snapjob)).Should(Succeed())
+				checkIsnapStatus(ctx, newInstanceSnapshot.Name, WorkingNamespace, crownlabsv1alpha2.Completed, timeout, interval)
+
+				Expect(k8sClient.Get(ctx, jobLookupKey, newInstanceSnapshot)).Should(Succeed())
+				snapshots = append(snapshots, newInstanceSnapshot)
+			}
+
+			By("Checking that the oldest snapshot and its image have been deleted")
+			oldestLookupKey := types.NamespacedName{Name: snapshots[0].Name, Namespace: WorkingNamespace}
+			doesEventuallyExists(ctx, oldestLookupKey, &crownlabsv1alpha2.InstanceSnapshot{}, BeFalse(), timeout, interval)
+			Expect(registry.Deleted()).Should(ContainElement(strings.TrimPrefix(snapshots[0].Status.Image, "my-registry/")))
+
+			By("Checking that the most recent snapshot has been retained")
+			newestLookupKey := types.NamespacedName{Name: snapshots[1].Name, Namespace: WorkingNamespace}
+			Expect(k8sClient.Get(ctx, newestLookupKey, &crownlabsv1alpha2.InstanceSnapshot{})).Should(Succeed())
+		})
+	})
+
+	Context("Creating a snapshot of a container environment", func() {
+		It("Should archive the persistent drive of the running container", func() {
+			By("Setting environment as Container with a persistent drive")
+			currentTemplate := &crownlabsv1alpha2.Template{}
+			templateLookupKey := types.NamespacedName{Name: TemplateName, Namespace: WorkingNamespace}
+			Expect(k8sClient.Get(ctx, templateLookupKey, currentTemplate)).Should(Succeed())
+			currentTemplate.Spec.EnvironmentList[0].EnvironmentType = crownlabsv1alpha2.ClassContainer
+			currentTemplate.Spec.EnvironmentList[0].Persistent = false
+			currentTemplate.Spec.EnvironmentList[0].Resources.Disk = resource.MustParse("1Gi")
+			Expect(k8sClient.Update(ctx, currentTemplate)).Should(Succeed())
+
+			By("Setting instance as running")
+			currentInstance := &crownlabsv1alpha2.Instance{}
+			instanceLookupKey := types.NamespacedName{Name: InstanceName, Namespace: WorkingNamespace}
+			Expect(k8sClient.Get(ctx, instanceLookupKey, currentInstance)).Should(Succeed())
+			currentInstance.Spec.Running = true
+			Expect(k8sClient.Update(ctx, currentInstance)).Should(Succeed())
+
+			newInstanceSnapshot := instanceSnapshot.DeepCopy()
+			newInstanceSnapshot.Name = fmt.Sprintf("isnap-sample-%v", rand.Int())
+			newInstanceSnapshot.Spec.ContainerFormat = crownlabsv1alpha2.ContainerSnapshotArchive
+			checkIsnapSuccessfulCreation(ctx, newInstanceSnapshot, WorkingNamespace, timeout, interval)
+
+			By("Checking the job, and that the instance is still running")
+			jobLookupKey := types.NamespacedName{Name: newInstanceSnapshot.Name, Namespace: WorkingNamespace}
+			snapjob := &batch.Job{}
+			Expect(k8sClient.Get(ctx, jobLookupKey, snapjob)).Should(Succeed())
+			Expect(snapjob.Spec.Template.Spec.InitContainers[0].Args).Should(ContainElements("--mode", "archive"))
+			Expect(snapjob.Spec.Template.Spec.Affinity).ShouldNot(BeNil())
+			Expect(k8sClient.Get(ctx, instanceLookupKey, currentInstance)).Should(Succeed())
+			Expect(currentInstance.Spec.Running).Should(BeTrue())
+		})
+	})
+
+	Context("Exporting a snapshot to a backend different from the registry", func() {
+		It("Should upload the exported disk to the S3 bucket", func() {
+			newInstanceSnapshot := instanceSnapshot.DeepCopy()
+			newInstanceSnapshot.Name = fmt.Sprintf("isnap-sample-%v", rand.Int())
+			newInstanceSnapshot.Spec.Backend = crownlabsv1alpha2.SnapshotBackendS3
+			checkIsnapSuccessfulCreation(ctx, newInstanceSnapshot, WorkingNamespace, timeout, interval)
+
+			By("Checking the containers of the job")
+			jobLookupKey := types.NamespacedName{Name: newInstanceSnapshot.Name, Namespace: WorkingNamespace}
+			snapjob := &batch.Job{}
+			Expect(k8sClient.Get(ctx, jobLookupKey, snapjob)).Should(Succeed())
+			Expect(snapjob.Spec.Template.Spec.Containers).Should(HaveLen(1))
+			Expect(snapjob.Spec.Template.Spec.Containers[0].Image).Should(Equal("aws-cli"))
+			Expect(snapjob.Spec.Template.Spec.Containers[0].Args).Should(ContainElements("cp", "/workspace/vm-snapshot.qcow2"))
+
+			By("Checking the location of the snapshot")
+			isnapLookupKey := types.NamespacedName{Name: newInstanceSnapshot.Name, Namespace: WorkingNamespace}
+			Expect(k8sClient.Get(ctx, isnapLookupKey, newInstanceSnapshot)).Should(Succeed())
+			Expect(newInstanceSnapshot.Status.Backend).Should(Equal(crownlabsv1alpha2.SnapshotBackendS3))
+			Expect(newInstanceSnapshot.Status.Location).Should(HavePrefix("s3://snapshots/testtenant/test-image/"))
+			Expect(newInstanceSnapshot.Status.Image).Should(BeEmpty())
+		})
+
+		It("Should clone the volume of the instance into a new PVC", func() {
+			By("Creating the volume of the instance")
+			volume := &v1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{Name: InstanceName, Namespace: WorkingNamespace},
+				Spec: v1.PersistentVolumeClaimSpec{
+					AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+					Resources:   v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceStorage: resource.MustParse("10Gi")}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, volume)).Should(Succeed())
+
+			newInstanceSnapshot := instanceSnapshot.DeepCopy()
+			newInstanceSnapshot.Name = fmt.Sprintf("isnap-sample-%v", rand.Int())
+			newInstanceSnapshot.Spec.Backend = crownlabsv1alpha2.SnapshotBackendPVC
+			checkIsnapSuccessfulCreation(ctx, newInstanceSnapshot, WorkingNamespace, timeout, interval)
+
+			By("Checking the destination PVC")
+			clone := &v1.PersistentVolumeClaim{}
+			cloneLookupKey := types.NamespacedName{Name: newInstanceSnapshot.Name + "-export", Namespace: WorkingNamespace}
+			Expect(k8sClient.Get(ctx, cloneLookupKey, clone)).Should(Succeed())
+			Expect(clone.Spec.Resources.Requests.Storage().Equal(resource.MustParse("10Gi"))).Should(BeTrue())
+			Expect(clone.OwnerReferences).Should(HaveLen(1))
+
+			By("Checking the containers of the job")
+			jobLookupKey := types.NamespacedName{Name: newInstanceSnapshot.Name, Namespace: WorkingNamespace}
+			snapjob := &batch.Job{}
+			Expect(k8sClient.Get(ctx, jobLookupKey, snapjob)).Should(Succeed())
+			Expect(snapjob.Spec.Template.Spec.InitContainers).Should(BeEmpty())
+			Expect(snapjob.Spec.Template.Spec.Containers).Should(HaveLen(1))
+			Expect(snapjob.Spec.Template.Spec.Containers[0].Args).Should(ContainElements("--mode", "clone"))
+
+			By("Deleting the volume of the instance")
+			Expect(k8sClient.Delete(ctx, volume)).Should(Succeed())
+		})
+
+		It("Should fail: incremental snapshots are not supported by S3", func() {
+			newInstanceSnapshot := instanceSnapshot.DeepCopy()
+			newInstanceSnapshot.Name = fmt.Sprintf("isnap-sample-%v", rand.Int())
+			newInstanceSnapshot.Spec.Backend = crownlabsv1alpha2.SnapshotBackendS3
+			newInstanceSnapshot.Spec.Mode = crownlabsv1alpha2.SnapshotModeIncremental
 			checkIsnapCreationFailure(ctx, newInstanceSnapshot, WorkingNamespace, timeout, interval)
 		})
+	})
 
+	Context("Testing incorrect environment configurations", func() {
 		It("Should fail: vm is not persistent", func() {
 			By("Getting current Template")
 			currentTemplate := &crownlabsv1alpha2.Template{}
@@ -224,7 +516,7 @@ var _ = Describe("InstancesnapshotController", func() {
 			checkIsnapCreationFailure(ctx, newInstanceSnapshot, WorkingNamespace, timeout, interval)
 		})
 
-		It("Should fail: environment is a container", func() {
+		It("Should fail: environment is a container without persistent drive", func() {
 			By("Getting current Template")
 			currentTemplate := &crownlabsv1alpha2.Template{}
 			templateLookupKey := types.NamespacedName{Name: TemplateName, Namespace: WorkingNamespace}
@@ -239,6 +531,23 @@ var _ = Describe("InstancesnapshotController", func() {
 			checkIsnapCreationFailure(ctx, newInstanceSnapshot, WorkingNamespace, timeout, interval)
 		})
 
+		It("Should fail: incremental snapshots are not supported by containers", func() {
+			By("Getting current Template")
+			currentTemplate := &crownlabsv1alpha2.Template{}
+			templateLookupKey := types.NamespacedName{Name: TemplateName, Namespace: WorkingNamespace}
+			Expect(k8sClient.Get(ctx, templateLookupKey, currentTemplate)).Should(Succeed())
+
+			By("Setting environment as Container with a persistent drive")
+			currentTemplate.Spec.EnvironmentList[0].EnvironmentType = crownlabsv1alpha2.ClassContainer
+			currentTemplate.Spec.EnvironmentList[0].Resources.Disk = resource.MustParse("1Gi")
+			Expect(k8sClient.Update(ctx, currentTemplate)).Should(Succeed())
+
+			newInstanceSnapshot := instanceSnapshot.DeepCopy()
+			newInstanceSnapshot.Name = fmt.Sprintf("isnap-sample-%v", rand.Int())
+			newInstanceSnapshot.Spec.Mode = crownlabsv1alpha2.SnapshotModeIncremental
+			checkIsnapCreationFailure(ctx, newInstanceSnapshot, WorkingNamespace, timeout, interval)
+		})
+
 		It("Should fail: template does not exist", func() {
 			By("Getting current instance")
 			currentInstance := &crownlabsv1alpha2.Instance{}
@@ -274,12 +583,19 @@ var _ = Describe("InstancesnapshotController", func() {
 			snapjob := &batch.Job{}
 			Expect(k8sClient.Get(ctx, jobLookupKey, snapjob)).Should(Succeed())
 			snapjob.Status.Conditions = []batch.JobCondition{
-				{Type: batch.JobFailed, Status: v1.ConditionTrue},
+				{Type: batch.JobFailed, Status: v1.ConditionTrue, Reason: "BackoffLimitExceeded", Message: "Job has reached the specified backoff limit"},
 			}
 			Expect(k8sClient.Status().Update(ctx, snapjob)).Should(Succeed())
 
 			By("Checking if the InstanceSnapshot status is Failed")
 			checkIsnapStatus(ctx, newInstanceSnapshot.Name, WorkingNamespace, crownlabsv1alpha2.Failed, timeout, interval)
+
+			By("Checking that the failure reason is reported in the InstanceSnapshot status")
+			isnapLookupKey := types.NamespacedName{Name: newInstanceSnapshot.Name, Namespace: WorkingNamespace}
+			Expect(k8sClient.Get(ctx, isnapLookupKey, newInstanceSnapshot)).Should(Succeed())
+			Expect(newInstanceSnapshot.Status.FailureReason).Should(ContainSubstring("BackoffLimitExceeded"))
+			Expect(newInstanceSnapshot.Status.CompletionTime).ShouldNot(BeNil())
+			Expect(meta.IsStatusConditionFalse(newInstanceSnapshot.Status.Conditions, string(crownlabsv1alpha2.InstanceSnapshotImagePushed))).Should(BeTrue())
 		})
 	})
 })
@@ -330,4 +646,9 @@ func checkIsnapCreationFailure(ctx context.Context, isnap *crownlabsv1alpha2.Ins
 
 	By("Checking that the InstanceSnapshot failed")
 	checkIsnapStatus(ctx, isnap.Name, workingNamespace, crownlabsv1alpha2.Failed, timeout, interval)
+
+	By("Checking that the reason of the failure is reported")
+	Expect(k8sClient.Get(ctx, instanceSnapshotLookupKey, createdInstanceSnapshot)).Should(Succeed())
+	Expect(createdInstanceSnapshot.Status.FailureReason).ShouldNot(BeEmpty())
+	Expect(meta.IsStatusConditionFalse(createdInstanceSnapshot.Status.Conditions, string(crownlabsv1alpha2.InstanceSnapshotValidated))).Should(BeTrue())
 }
@@ -70,24 +70,29 @@ func (r *InstanceSnapshotReconciler) ValidateRequest(ctx context.Context, isnap
 	}
 
 	// Retrieve the environment from the template.
-	var env *crownlabsv1alpha2.Environment
-
-	if isnap.Spec.Environment.Name != "" {
-		for i := range template.Spec.EnvironmentList {
-			if template.Spec.EnvironmentList[i].Name == isnap.Spec.Environment.Name {
-				env = &template.Spec.EnvironmentList[i]
-				break
-			}
-		}
+	env, err := GetSnapshotEnvironment(isnap, template)
+	if err != nil {
+		return false, err
+	}
+
+	// Check if the snapshot can be exported to the selected backend.
+	if err := r.snapshotExporter(r.snapshotBackend(isnap)).Validate(isnap, env); err != nil {
+		return false, err
+	}
 
-		// Check if the specified environment was found.
-		if env == nil {
-			return false, fmt.Errorf("environment %s not found in template %s. It is not possible to complete the InstanceSnapshot %s",
-				isnap.Spec.Environment.Name, template.Name, isnap.Name)
+	// Check if the environment is a container with a persistent drive.
+	if env.EnvironmentType == crownlabsv1alpha2.ClassContainer {
+		if env.Resources.Disk.IsZero() {
+			return false, fmt.Errorf("environment %s is a container without persistent drive. It is not possible to complete the InstanceSnapshot %s",
+				env.Name, isnap.Name)
 		}
-	} else {
-		// If the environment is not explicitly declared, take the first one.
-		env = &template.Spec.EnvironmentList[0]
+		// Only the disks of the VMs can be exported as overlays on top of the environment image.
+		if isnap.Spec.Mode == crownlabsv1alpha2.SnapshotModeIncremental {
+			return false, fmt.Errorf("environment %s is a container, which does not support incremental snapshots. It is not possible to complete the InstanceSnapshot %s",
+				env.Name, isnap.Name)
+		}
+		// The drive can be read while the container is running, hence no further checks are required.
+		return false, nil
 	}
 
 	// Check if the environment is a persistent VM.
@@ -96,14 +101,50 @@ func (r *InstanceSnapshotReconciler) ValidateRequest(ctx context.Context, isnap
 			env.Name, isnap.Name)
 	}
 
-	// Check if the VM is running.
-	if instance.Spec.Running {
+	// Check if the VM is running, and it cannot be snapshotted live.
+	if instance.Spec.Running && (r.LiveSnapshots == "" || r.LiveSnapshots == LiveSnapshotDisabled) {
 		return false, fmt.Errorf("the vm is running. It is not possible to complete the InstanceSnapshot %s", isnap.Name)
 	}
 
 	return false, nil
 }
 
+// GetSnapshotEnvironment returns the environment of the template to be snapshotted, according to the InstanceSnapshot request.
+func GetSnapshotEnvironment(isnap *crownlabsv1alpha2.InstanceSnapshot, template *crownlabsv1alpha2.Template) (*crownlabsv1alpha2.Environment, error) {
+	if isnap.Spec.Environment.Name == "" {
+		// If the environment is not explicitly declared, take the first one.
+		if len(template.Spec.EnvironmentList) == 0 {
+			return nil, fmt.Errorf("template %s has no environments. It is not possible to complete the InstanceSnapshot %s",
+				template.Name, isnap.Name)
+		}
+		return &template.Spec.EnvironmentList[0], nil
+	}
+
+	for i := range template.Spec.EnvironmentList {
+		if template.Spec.EnvironmentList[i].Name == isnap.Spec.Environment.Name {
+			return &template.Spec.EnvironmentList[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("environment %s not found in template %s. It is not possible to complete the InstanceSnapshot %s",
+		isnap.Spec.Environment.Name, template.Name, isnap.Name)
+}
+
+// getInstanceEnvironment retrieves the template of the given instance, and returns the environment to be snapshotted.
+func (r *InstanceSnapshotReconciler) getInstanceEnvironment(ctx context.Context, isnap *crownlabsv1alpha2.InstanceSnapshot,
+	instance *crownlabsv1alpha2.Instance) (*crownlabsv1alpha2.Environment, error) {
+	templateName := types.NamespacedName{
+		Namespace: instance.Spec.Template.Namespace,
+		Name:      instance.Spec.Template.Name,
+	}
+	template := &crownlabsv1alpha2.Template{}
+	if err := r.Get(ctx, templateName, template); err != nil {
+		return nil, fmt.Errorf("error in retrieving the template for InstanceSnapshot %s -> %w", isnap.Name, err)
+	}
+
+	return GetSnapshotEnvironment(isnap, template)
+}
+
 // GetJobStatus sets a Job and returns its status.
 func (r *InstanceSnapshotReconciler) GetJobStatus(job *batch.Job) (bool, batch.JobConditionType) {
 	for _, c := range job.Status.Conditions {
@@ -117,7 +158,8 @@ func (r *InstanceSnapshotReconciler) GetJobStatus(job *batch.Job) (bool, batch.J
 	return false, ""
 }
 
-// CreateSnapshottingJobDefinition generates the job to be created.
+// CreateSnapshottingJobDefinition generates the job to be created, configured according to the backend
+// the snapshot is exported to, and records in the status of the InstanceSnapshot its location.
 func (r *InstanceSnapshotReconciler) CreateSnapshottingJobDefinition(ctx context.Context, isnap *crownlabsv1alpha2.InstanceSnapshot) (batch.Job, error) {
 	// Get the tenant name in order to set it as directory of the image
 	instanceName := types.NamespacedName{
@@ -130,18 +172,25 @@ func (r *InstanceSnapshotReconciler) CreateSnapshottingJobDefinition(ctx context
 		return batch.Job{}, fmt.Errorf("error in retrieving the instance for InstanceSnapshot %s -> %w", isnap.Name, err)
 	}
 
+	env, err := r.getInstanceEnvironment(ctx, isnap, instance)
+	if err != nil {
+		return batch.Job{}, err
+	}
+
 	var backoff int32 = 2
-	imagetag := fmt.Sprint(time.Now().Format("20060102t150405"))
 	// Volume name does not accept dots, replace them with dashes
 	volumename := strings.ReplaceAll(isnap.Spec.Instance.Name, ".", "-")
-	imagedir := utils.ParseDockerDirectory(instance.Spec.Tenant.Name)
 
 	// Define volumes.
 
-	// Define VM VolumeSource.
+	// Define VM VolumeSource, restored from the volume snapshot in case the instance was running.
+	claimname := volumename
+	if isnap.Status.VirtualMachineSnapshot != "" {
+		claimname = liveClaimName(isnap)
+	}
 	vmvolume := corev1.VolumeSource{
 		PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
-			ClaimName: volumename,
+			ClaimName: claimname,
 		},
 	}
 
@@ -150,64 +199,6 @@ func (r *InstanceSnapshotReconciler) CreateSnapshottingJobDefinition(ctx context
 		EmptyDir: &corev1.EmptyDirVolumeSource{},
 	}
 
-	// Define secret VolumeSource.
-	secretvol := corev1.VolumeSource{
-		Secret: &corev1.SecretVolumeSource{
-			SecretName: r.RegistrySecretName,
-			Items: []corev1.KeyToPath{
-				{
-					Key:  ".dockerconfigjson",
-					Path: "config.json",
-				},
-			},
-		},
-	}
-
-	volumes := []corev1.Volume{
-		{
-			Name:         volumename,
-			VolumeSource: vmvolume,
-		},
-		{
-			Name:         "tmp-vol",
-			VolumeSource: tmpvol,
-		},
-		{
-			Name:         "kaniko-secret",
-			VolumeSource: secretvol,
-		},
-	}
-
-	// Define containers.
-
-	// Define Docker pusher container.
-	pushcontainer := corev1.Container{
-		Name:  "docker-pusher",
-		Image: r.ContainersSnapshot.ContainerKaniko,
-		Args: []string{"--dockerfile=/workspace/Dockerfile",
-			fmt.Sprintf("--destination=%s/%s/%s:%s", r.VMRegistry, imagedir, isnap.Spec.ImageName, imagetag)},
-		VolumeMounts: []corev1.VolumeMount{
-			{
-				Name:      "tmp-vol",
-				MountPath: "/workspace",
-			},
-			{
-				Name:      "kaniko-secret",
-				MountPath: "/kaniko/.docker/",
-			},
-		},
-		Resources: corev1.ResourceRequirements{
-			Requests: corev1.ResourceList{
-				"cpu":    resource.MustParse("1"),
-				"memory": resource.MustParse("8Gi"),
-			},
-			Limits: corev1.ResourceList{
-				"cpu":    resource.MustParse("1"),
-				"memory": resource.MustParse("32Gi"),
-			},
-		},
-	}
-
 	// Define image exporter container.
 	exportcontainer := corev1.Container{
 		Name:  "img-generator",
@@ -234,6 +225,51 @@ func (r *InstanceSnapshotReconciler) CreateSnapshottingJobDefinition(ctx context
 		},
 	}
 
+	var affinity *corev1.Affinity
+	if env.EnvironmentType == crownlabsv1alpha2.ClassContainer {
+		// The drive is mounted by the running container, hence the job shall be executed on the same node.
+		exportcontainer.VolumeMounts[0].ReadOnly = true
+		affinity = &corev1.Affinity{PodAffinity: &corev1.PodAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{{
+				LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"name": volumename}},
+				TopologyKey:   corev1.LabelHostname,
+			}},
+		}}
+	}
+
+	// Configure the job to export the snapshot to the selected backend.
+	job := &snapshotJob{
+		Environment: env,
+		Directory:   utils.ParseDockerDirectory(instance.Spec.Tenant.Name),
+		Tag:         fmt.Sprint(time.Now().Format("20060102t150405")),
+		Source:      claimname,
+		Exporter:    exportcontainer,
+		Volumes: []corev1.Volume{
+			{
+				Name:         volumename,
+				VolumeSource: vmvolume,
+			},
+			{
+				Name:         "tmp-vol",
+				VolumeSource: tmpvol,
+			},
+		},
+	}
+	isnap.Status.Backend = r.snapshotBackend(isnap)
+	if isnap.Status.Location, err = r.snapshotExporter(isnap.Status.Backend).Configure(ctx, isnap, job); err != nil {
+		return batch.Job{}, err
+	}
+
+	// In case of errors, the last lines of the logs are reported as failure reason in the status.
+	job.Exporter.TerminationMessagePolicy = corev1.TerminationMessageFallbackToLogsOnError
+	containers := []corev1.Container{job.Exporter}
+	var initcontainers []corev1.Container
+	if job.Pusher != nil {
+		// The volume is exported by the init container, and then stored in the backend.
+		job.Pusher.TerminationMessagePolicy = corev1.TerminationMessageFallbackToLogsOnError
+		containers, initcontainers = []corev1.Container{*job.Pusher}, containers
+	}
+
 	snapjob := batch.Job{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      isnap.Name,
@@ -243,14 +279,11 @@ func (r *InstanceSnapshotReconciler) CreateSnapshottingJobDefinition(ctx context
 			BackoffLimit: &backoff,
 			Template: corev1.PodTemplateSpec{
 				Spec: corev1.PodSpec{
-					Containers: []corev1.Container{
-						pushcontainer,
-					},
-					InitContainers: []corev1.Container{
-						exportcontainer,
-					},
-					Volumes:       volumes,
-					RestartPolicy: corev1.RestartPolicyOnFailure,
+					Containers:     containers,
+					InitContainers: initcontainers,
+					Volumes:        job.Volumes,
+					Affinity:       affinity,
+					RestartPolicy:  corev1.RestartPolicyOnFailure,
 				},
 			},
 		},
@@ -258,3 +291,56 @@ func (r *InstanceSnapshotReconciler) CreateSnapshottingJobDefinition(ctx context
 
 	return snapjob, nil
 }
+
+// configureIncrementalSnapshot adapts the containers of the snapshotting job to create an incremental snapshot:
+// the exporter generates a qcow2 overlay containing only the changes with respect to the disk of the base image,
+// and crane appends it as a new layer on top of the base image (which is not downloaded nor pushed again).
+func configureIncrementalSnapshot(exportcontainer, pushcontainer *corev1.Container, baseImage, destination, craneImage string) {
+	dockerConfig := corev1.EnvVar{Name: "DOCKER_CONFIG", Value: "/docker-config"}
+	secretMount := corev1.VolumeMount{Name: "kaniko-secret", MountPath: dockerConfig.Value, ReadOnly: true}
+
+	// The exporter needs to pull the disk of the base image, to compute the differences with respect to it.
+	exportcontainer.Command = []string{"/exporter.sh"}
+	exportcontainer.Args = []string{"--mode", "incremental", "--base-image", baseImage}
+	exportcontainer.Env = append(exportcontainer.Env, dockerConfig)
+	exportcontainer.VolumeMounts = append(exportcontainer.VolumeMounts, secretMount)
+
+	*pushcontainer = corev1.Container{
+		Name:  pushcontainer.Name,
+		Image: craneImage,
+		Args: []string{"append", "--base", baseImage,
+			"--new_layer", "/workspace/overlay.tar", "--new_tag", destination},
+		Env: []corev1.EnvVar{dockerConfig},
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      "tmp-vol",
+				MountPath: "/workspace",
+			},
+			secretMount,
+		},
+		// Differently from kaniko, crane streams the new layer without unpacking any filesystem.
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{
+				"cpu":    resource.MustParse("100m"),
+				"memory": resource.MustParse("128Mi"),
+			},
+			Limits: corev1.ResourceList{
+				"cpu":    resource.MustParse("1"),
+				"memory": resource.MustParse("512Mi"),
+			},
+		},
+	}
+}
+
+// configureContainerSnapshot adapts the exporter container of the snapshotting job to create the snapshot of a container
+// environment: the content of the persistent drive (mounted read-only) is either added to the image of the environment,
+// or packed in an archive, and the corresponding Dockerfile is generated to be built by kaniko.
+func configureContainerSnapshot(exportcontainer *corev1.Container, format crownlabsv1alpha2.ContainerSnapshotFormat, baseImage string) {
+	mode := "files"
+	if format == crownlabsv1alpha2.ContainerSnapshotArchive {
+		mode = "archive"
+	}
+
+	exportcontainer.Command = []string{"/exporter.sh"}
+	exportcontainer.Args = []string{"--mode", mode, "--base-image", baseImage}
+}
@@ -0,0 +1,275 @@
+package instancesnapshot_controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	batch "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	virtv1 "kubevirt.io/client-go/api/v1"
+	snapshotv1alpha1 "kubevirt.io/client-go/apis/snapshot/v1alpha1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrlUtil "sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	crownlabsv1alpha2 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha2"
+)
+
+// LiveSnapshotStrategy is the strategy adopted to create the snapshot of running instances.
+type LiveSnapshotStrategy string
+
+const (
+	// LiveSnapshotDisabled -> the creation of the snapshot of running instances is refused.
+	LiveSnapshotDisabled LiveSnapshotStrategy = "Disabled"
+	// LiveSnapshotStopAndRestart -> running instances are stopped, and restarted once the snapshot is created.
+	LiveSnapshotStopAndRestart LiveSnapshotStrategy = "StopAndRestart"
+	// LiveSnapshotVolumeSnapshot -> the snapshot is created from a KubeVirt VirtualMachineSnapshot
+	// (which leverages CSI volume snapshots and freezes the guest, if the agent is available),
+	// without interrupting the running instances.
+	LiveSnapshotVolumeSnapshot LiveSnapshotStrategy = "VolumeSnapshot"
+)
+
+// liveSnapshotPollInterval is the interval between the checks while waiting for the volume of a running instance.
+const liveSnapshotPollInterval = 5 * time.Second
+
+// ParseLiveSnapshotStrategy parses the given live snapshot strategy, returning an error if it is not valid.
+func ParseLiveSnapshotStrategy(strategy string) (LiveSnapshotStrategy, error) {
+	switch LiveSnapshotStrategy(strategy) {
+	case LiveSnapshotDisabled, LiveSnapshotStopAndRestart, LiveSnapshotVolumeSnapshot:
+		return LiveSnapshotStrategy(strategy), nil
+	default:
+		return "", fmt.Errorf("invalid live snapshot strategy %q", strategy)
+	}
+}
+
+// IsWaitingForVolume returns whether the InstanceSnapshot is waiting for the volume of the instance to be available.
+func IsWaitingForVolume(isnap *crownlabsv1alpha2.InstanceSnapshot) bool {
+	return isnap.Status.Phase == crownlabsv1alpha2.Stopping || isnap.Status.Phase == crownlabsv1alpha2.SnapshottingVolume
+}
+
+// PrepareSnapshotVolume ensures the volume to be exported is not in use by a running VM, either stopping the
+// instance or snapshotting its volume, according to the live snapshot strategy. It returns whether the volume is ready.
+func (r *InstanceSnapshotReconciler) PrepareSnapshotVolume(ctx context.Context, isnap *crownlabsv1alpha2.InstanceSnapshot) (bool, error) {
+	instance := &crownlabsv1alpha2.Instance{}
+	instanceName := types.NamespacedName{Namespace: isnap.Spec.Instance.Namespace, Name: isnap.Spec.Instance.Name}
+	if err := r.Get(ctx, instanceName, instance); err != nil {
+		return false, fmt.Errorf("error in retrieving the instance for InstanceSnapshot %s -> %w", isnap.Name, err)
+	}
+	// The resources associated with the instance are named after it, replacing the dots with dashes.
+	name := strings.ReplaceAll(instance.Name, ".", "-")
+
+	// The drive of container environments can be read while they are running.
+	env, err := r.getInstanceEnvironment(ctx, isnap, instance)
+	if err != nil {
+		return false, err
+	}
+	if env.EnvironmentType == crownlabsv1alpha2.ClassContainer {
+		return true, nil
+	}
+
+	switch {
+	case isnap.Status.VirtualMachineSnapshot != "":
+		return r.prepareLiveClaim(ctx, isnap, name)
+
+	case instance.Spec.Running && r.LiveSnapshots == LiveSnapshotVolumeSnapshot:
+		vmsnap := snapshotv1alpha1.VirtualMachineSnapshot{
+			ObjectMeta: metav1.ObjectMeta{Name: isnap.Name, Namespace: instance.Namespace},
+			Spec: snapshotv1alpha1.VirtualMachineSnapshotSpec{
+				Source: corev1.TypedLocalObjectReference{APIGroup: &virtv1.GroupVersion.Group, Kind: "VirtualMachine", Name: name},
+			},
+		}
+		if err := ctrl.SetControllerReference(isnap, &vmsnap, r.Scheme); err != nil {
+			return false, err
+		}
+		if err := r.Create(ctx, &vmsnap); err != nil && !errors.IsAlreadyExists(err) {
+			return false, fmt.Errorf("error when creating the VirtualMachineSnapshot for %s -> %w", isnap.Name, err)
+		}
+		klog.Infof("VirtualMachineSnapshot for InstanceSnapshot %s created", isnap.Name)
+		r.EventsRecorder.Event(isnap, "Normal", "VolumeSnapshotting", "The volume of the running instance is being snapshotted")
+
+		isnap.Status.VirtualMachineSnapshot = vmsnap.Name
+		return false, r.updateSnapshotPhase(ctx, isnap, crownlabsv1alpha2.SnapshottingVolume)
+
+	case instance.Spec.Running:
+		// The finalizer guarantees the instance is restarted even if the InstanceSnapshot is deleted before completing.
+		if !ctrlUtil.ContainsFinalizer(isnap, crownlabsv1alpha2.InstOperatorFinalizerName) {
+			ctrlUtil.AddFinalizer(isnap, crownlabsv1alpha2.InstOperatorFinalizerName)
+			if err := r.Update(ctx, isnap); err != nil {
+				return false, fmt.Errorf("error when adding the finalizer to InstanceSnapshot %s -> %w", isnap.Name, err)
+			}
+		}
+		patch := client.MergeFrom(instance.DeepCopy())
+		instance.Spec.Running = false
+		if err := r.Patch(ctx, instance, patch); err != nil {
+			return false, fmt.Errorf("error when stopping instance %s for InstanceSnapshot %s -> %w", instance.Name, isnap.Name, err)
+		}
+		klog.Infof("Instance %s/%s stopped to create InstanceSnapshot %s", instance.Namespace, instance.Name, isnap.Name)
+		r.EventsRecorder.Event(isnap, "Normal", "Stopping", "The running instance is being stopped, and it will be restarted once completed")
+
+		isnap.Status.InstanceStopped = true
+		return false, r.updateSnapshotPhase(ctx, isnap, crownlabsv1alpha2.Stopping)
+
+	case isnap.Status.InstanceStopped:
+		// Wait for the virtual machine to be actually stopped, releasing the volume.
+		err := r.Get(ctx, types.NamespacedName{Namespace: instance.Namespace, Name: name}, &virtv1.VirtualMachineInstance{})
+		if err == nil || !errors.IsNotFound(err) {
+			return false, client.IgnoreNotFound(err)
+		}
+		return true, nil
+
+	default:
+		return true, nil
+	}
+}
+
+// prepareLiveClaim creates the PersistentVolumeClaim to be exported from the VolumeSnapshot of the running instance.
+func (r *InstanceSnapshotReconciler) prepareLiveClaim(ctx context.Context, isnap *crownlabsv1alpha2.InstanceSnapshot, name string) (bool, error) {
+	vmsnap := &snapshotv1alpha1.VirtualMachineSnapshot{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: isnap.Spec.Instance.Namespace, Name: isnap.Status.VirtualMachineSnapshot}, vmsnap); err != nil {
+		return false, fmt.Errorf("error in retrieving the VirtualMachineSnapshot for InstanceSnapshot %s -> %w", isnap.Name, err)
+	}
+
+	if vmsnap.Status == nil || vmsnap.Status.ReadyToUse == nil || !*vmsnap.Status.ReadyToUse || vmsnap.Status.VirtualMachineSnapshotContentName == nil {
+		if vmsnap.Status != nil && vmsnap.Status.Error != nil && vmsnap.Status.Error.Message != nil {
+			klog.Warningf("VirtualMachineSnapshot for InstanceSnapshot %s not ready -> %s", isnap.Name, *vmsnap.Status.Error.Message)
+		}
+		return false, nil
+	}
+
+	content := &snapshotv1alpha1.VirtualMachineSnapshotContent{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: vmsnap.Namespace, Name: *vmsnap.Status.VirtualMachineSnapshotContentName}, content); err != nil {
+		return false, fmt.Errorf("error in retrieving the VirtualMachineSnapshotContent for InstanceSnapshot %s -> %w", isnap.Name, err)
+	}
+
+	var backup *snapshotv1alpha1.VolumeBackup
+	for i := range content.Spec.VolumeBackups {
+		if content.Spec.VolumeBackups[i].PersistentVolumeClaim.Name == name && content.Spec.VolumeBackups[i].VolumeSnapshotName != nil {
+			backup = &content.Spec.VolumeBackups[i]
+			break
+		}
+	}
+	if backup == nil {
+		return false, fmt.Errorf("the VirtualMachineSnapshot for InstanceSnapshot %s does not contain volume %s", isnap.Name, name)
+	}
+
+	// The claim is restored from the volume snapshot, with the same characteristics of the original one.
+	apiGroup := "snapshot.storage.k8s.io"
+	claim := corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: liveClaimName(isnap), Namespace: isnap.Namespace}}
+	claim.Spec = *backup.PersistentVolumeClaim.Spec.DeepCopy()
+	claim.Spec.VolumeName = ""
+	claim.Spec.DataSource = &corev1.TypedLocalObjectReference{APIGroup: &apiGroup, Kind: "VolumeSnapshot", Name: *backup.VolumeSnapshotName}
+	if err := ctrl.SetControllerReference(isnap, &claim, r.Scheme); err != nil {
+		return false, err
+	}
+	if err := r.Create(ctx, &claim); err != nil && !errors.IsAlreadyExists(err) {
+		return false, fmt.Errorf("error when creating the PersistentVolumeClaim for %s -> %w", isnap.Name, err)
+	}
+
+	return true, nil
+}
+
+// CleanupSnapshotVolume restarts the instance, or removes the volume snapshot, used to create the snapshot of a running instance.
+func (r *InstanceSnapshotReconciler) CleanupSnapshotVolume(ctx context.Context, isnap *crownlabsv1alpha2.InstanceSnapshot) error {
+	if isnap.Status.InstanceStopped {
+		instance := &crownlabsv1alpha2.Instance{}
+		instanceName := types.NamespacedName{Namespace: isnap.Spec.Instance.Namespace, Name: isnap.Spec.Instance.Name}
+		if err := r.Get(ctx, instanceName, instance); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("error in retrieving the instance for InstanceSnapshot %s -> %w", isnap.Name, err)
+		} else if err == nil {
+			patch := client.MergeFrom(instance.DeepCopy())
+			instance.Spec.Running = true
+			if err := r.Patch(ctx, instance, patch); err != nil {
+				return fmt.Errorf("error when restarting instance %s for InstanceSnapshot %s -> %w", instance.Name, isnap.Name, err)
+			}
+			klog.Infof("Instance %s/%s restarted after the creation of InstanceSnapshot %s", instance.Namespace, instance.Name, isnap.Name)
+		}
+	}
+
+	if isnap.Status.VirtualMachineSnapshot != "" {
+		// The volume snapshot is no longer necessary, once the image has been exported.
+		claim := corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: liveClaimName(isnap), Namespace: isnap.Namespace}}
+		if err := r.Delete(ctx, &claim); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("error when deleting the PersistentVolumeClaim for %s -> %w", isnap.Name, err)
+		}
+		vmsnap := snapshotv1alpha1.VirtualMachineSnapshot{ObjectMeta: metav1.ObjectMeta{Name: isnap.Status.VirtualMachineSnapshot, Namespace: isnap.Spec.Instance.Namespace}}
+		if err := r.Delete(ctx, &vmsnap); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("error when deleting the VirtualMachineSnapshot for %s -> %w", isnap.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// HandleSnapshotDeletion restarts the instance stopped for an InstanceSnapshot deleted before completing, once the
+// snapshotting job (if any) is gone, and then removes the finalizer. It returns whether the deletion can proceed.
+func (r *InstanceSnapshotReconciler) HandleSnapshotDeletion(ctx context.Context, isnap *crownlabsv1alpha2.InstanceSnapshot) (bool, error) {
+	if !ctrlUtil.ContainsFinalizer(isnap, crownlabsv1alpha2.InstOperatorFinalizerName) {
+		return true, nil
+	}
+
+	if isnap.Status.InstanceStopped && !isSnapshotTerminated(isnap) {
+		// The job is deleted first, since the instance cannot be restarted while its volume is being exported.
+		job := batch.Job{}
+		if err := r.Get(ctx, types.NamespacedName{Namespace: isnap.Namespace, Name: isnap.Name}, &job); client.IgnoreNotFound(err) != nil {
+			return false, fmt.Errorf("error in retrieving the job of InstanceSnapshot %s -> %w", isnap.Name, err)
+		} else if err == nil {
+			if err := r.Delete(ctx, &job, client.PropagationPolicy(metav1.DeletePropagationBackground)); client.IgnoreNotFound(err) != nil {
+				return false, fmt.Errorf("error when deleting the job of InstanceSnapshot %s -> %w", isnap.Name, err)
+			}
+			return false, nil
+		}
+
+		if err := r.CleanupSnapshotVolume(ctx, isnap); err != nil {
+			return false, err
+		}
+	}
+
+	ctrlUtil.RemoveFinalizer(isnap, crownlabsv1alpha2.InstOperatorFinalizerName)
+	if err := r.Update(ctx, isnap); err != nil {
+		return false, fmt.Errorf("error when removing the finalizer from InstanceSnapshot %s -> %w", isnap.Name, err)
+	}
+	return true, nil
+}
+
+// abortSnapshot marks the InstanceSnapshot as failed, setting the given condition to false with the specified reason,
+// and restarting the instance in case it has been stopped to create the snapshot.
+func (r *InstanceSnapshotReconciler) abortSnapshot(ctx context.Context, isnap *crownlabsv1alpha2.InstanceSnapshot,
+	condition crownlabsv1alpha2.InstanceSnapshotConditionType, reason string, cause error) error {
+	if err := r.CleanupSnapshotVolume(ctx, isnap); err != nil {
+		return err
+	}
+
+	isnap.Status.Phase = crownlabsv1alpha2.Failed
+	isnap.Status.QueuePosition = 0
+	isnap.Status.FailureReason = cause.Error()
+	setSnapshotCondition(isnap, condition, metav1.ConditionFalse, reason, cause.Error())
+	if err := r.Status().Update(ctx, isnap); err != nil {
+		return fmt.Errorf("error when updating status of InstanceSnapshot %s -> %w", isnap.Name, err)
+	}
+	return nil
+}
+
+// isSnapshotTerminated returns whether the creation of the InstanceSnapshot is over, either successfully or not.
+func isSnapshotTerminated(isnap *crownlabsv1alpha2.InstanceSnapshot) bool {
+	return isnap.Status.Phase == crownlabsv1alpha2.Completed || isnap.Status.Phase == crownlabsv1alpha2.Failed
+}
+
+// updateSnapshotPhase updates the phase of the InstanceSnapshot.
+func (r *InstanceSnapshotReconciler) updateSnapshotPhase(ctx context.Context, isnap *crownlabsv1alpha2.InstanceSnapshot, phase crownlabsv1alpha2.SnapshotStatus) error {
+	isnap.Status.Phase = phase
+	if err := r.Status().Update(ctx, isnap); err != nil {
+		return fmt.Errorf("error when updating status of InstanceSnapshot %s -> %w", isnap.Name, err)
+	}
+	return nil
+}
+
+// liveClaimName returns the name of the PersistentVolumeClaim restored from the volume snapshot of the running instance.
+func liveClaimName(isnap *crownlabsv1alpha2.InstanceSnapshot) string {
+	return isnap.Name + "-live"
+}
@@ -3,8 +3,11 @@ package instancesnapshot_controller
 import (
 	"context"
 	"fmt"
+	"time"
 
 	batch "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
 
 	crownlabsv1alpha2 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha2"
@@ -12,11 +15,13 @@ import (
 
 // CreateSnapshottingJob creates the job in charge of creating the snapshot.
 func (r *InstanceSnapshotReconciler) CreateSnapshottingJob(ctx context.Context, isnap *crownlabsv1alpha2.InstanceSnapshot) (bool, error) {
-	r.EventsRecorder.Event(isnap, "Normal", "Validating", "Start validation of the request")
+	if !IsWaitingForVolume(isnap) && !IsQueued(isnap) {
+		r.EventsRecorder.Event(isnap, "Normal", "Validating", "Start validation of the request")
 
-	isnap.Status.Phase = crownlabsv1alpha2.Pending
-	if err := r.Status().Update(ctx, isnap); err != nil {
-		return true, fmt.Errorf("error when updating status of InstanceSnapshot %s -> %w", isnap.Name, err)
+		isnap.Status.Phase = crownlabsv1alpha2.Pending
+		if err := r.Status().Update(ctx, isnap); err != nil {
+			return true, fmt.Errorf("error when updating status of InstanceSnapshot %s -> %w", isnap.Name, err)
+		}
 	}
 
 	if retry, err := r.ValidateRequest(ctx, isnap); err != nil {
@@ -26,19 +31,55 @@ func (r *InstanceSnapshotReconciler) CreateSnapshottingJob(ctx context.Context,
 			return true, err
 		}
 
-		// Set the status as failed
-		isnap.Status.Phase = crownlabsv1alpha2.Failed
-		if uerr := r.Status().Update(ctx, isnap); uerr != nil {
-			return true, fmt.Errorf("error when updating status of InstanceSnapshot %s -> %w", isnap.Name, uerr)
+		// Set the status as failed, restarting the instance if already stopped.
+		if uerr := r.abortSnapshot(ctx, isnap, crownlabsv1alpha2.InstanceSnapshotValidated, "ValidationFailed", err); uerr != nil {
+			return true, uerr
 		}
 
 		return false, err
 	}
+	// Record the owners of the snapshot, before changing its status.
+	if err := r.labelSnapshotOwners(ctx, isnap); err != nil {
+		return true, err
+	}
+	setSnapshotCondition(isnap, crownlabsv1alpha2.InstanceSnapshotValidated, metav1.ConditionTrue, "ValidationSucceeded", "The request is valid")
 
-	// Get the job to be created
+	// Wait for a free slot, in case the concurrency limits of the snapshotting jobs are reached.
+	if !IsWaitingForVolume(isnap) {
+		position, err := r.snapshotQueuePosition(ctx, isnap)
+		if err != nil {
+			return true, err
+		}
+		if position > 0 {
+			if !IsQueued(isnap) {
+				r.EventsRecorder.Event(isnap, "Normal", "Queued", "Waiting for a free slot to start the snapshotting job")
+			}
+			if position != isnap.Status.QueuePosition {
+				isnap.Status.QueuePosition = position
+				if err := r.Status().Update(ctx, isnap); err != nil {
+					return true, fmt.Errorf("error when updating status of InstanceSnapshot %s -> %w", isnap.Name, err)
+				}
+			}
+			return false, nil
+		}
+
+		isnap.Status.QueuePosition = 0
+		snapshotQueueWaitTimes.Observe(time.Since(isnap.CreationTimestamp.Time).Seconds())
+	}
+
+	// Make sure the volume is not in use by a running instance, otherwise wait for it.
+	if ready, err := r.PrepareSnapshotVolume(ctx, isnap); err != nil || !ready {
+		return true, err
+	}
+
+	// Get the job to be created. In case of errors, the snapshot is aborted rather than trying again,
+	// to avoid keeping the instance stopped for an indefinite amount of time.
 	snapjob, err1 := r.CreateSnapshottingJobDefinition(ctx, isnap)
 	if err1 != nil {
-		return true, err1
+		if uerr := r.abortSnapshot(ctx, isnap, crownlabsv1alpha2.InstanceSnapshotImagePushed, "JobDefinitionFailed", err1); uerr != nil {
+			return true, uerr
+		}
+		return false, err1
 	}
 
 	// Set the owner reference in order to delete the job when the InstanceSnapshot is deleted.
@@ -46,12 +87,15 @@ func (r *InstanceSnapshotReconciler) CreateSnapshottingJob(ctx context.Context,
 		return true, err
 	}
 
-	if err := r.Create(ctx, &snapjob); err != nil {
+	if err := r.Create(ctx, &snapjob); err != nil && !errors.IsAlreadyExists(err) {
 		// It was not possible to create the job
 		return true, fmt.Errorf("error when creating the job for %s -> %w", isnap.Name, err)
 	}
 
 	isnap.Status.Phase = crownlabsv1alpha2.Processing
+	isnap.Status.JobName = snapjob.Name
+	setSnapshotCondition(isnap, crownlabsv1alpha2.InstanceSnapshotImagePushed, metav1.ConditionFalse, "JobRunning",
+		fmt.Sprintf("Job %s is exporting the snapshot to %s", snapjob.Name, isnap.Status.Location))
 	if err := r.Status().Update(ctx, isnap); err != nil {
 		return true, fmt.Errorf("error when updating status of InstanceSnapshot %s -> %w", isnap.Name, err)
 	}
@@ -62,20 +106,39 @@ func (r *InstanceSnapshotReconciler) CreateSnapshottingJob(ctx context.Context,
 // HandleExistingJob checks the status of the existing job and updates the status of the InstanceSnapshot accordingly.
 func (r *InstanceSnapshotReconciler) HandleExistingJob(ctx context.Context, isnap *crownlabsv1alpha2.InstanceSnapshot, snapjob *batch.Job) (batch.JobConditionType, error) {
 	completed, jstatus := r.GetJobStatus(snapjob)
-	if completed {
-		if jstatus == batch.JobComplete {
-			// The job is completed and the image has been uploaded to the registry
-			isnap.Status.Phase = crownlabsv1alpha2.Completed
-			if err := r.Status().Update(ctx, isnap); err != nil {
-				return "", fmt.Errorf("error when updating status of InstanceSnapshot %s -> %w", isnap.Name, err)
-			}
-		} else {
-			// The creation of the snapshot failed since the job failed
-			isnap.Status.Phase = crownlabsv1alpha2.Failed
+	if completed && isnap.Status.Phase == crownlabsv1alpha2.Processing {
+		// Restore the running instance, if it has been stopped for the snapshot.
+		if err := r.CleanupSnapshotVolume(ctx, isnap); err != nil {
+			return "", err
+		}
+	}
+	if !completed {
+		// Report the start time of the job, once available.
+		if isnap.Status.StartTime == nil && snapjob.Status.StartTime != nil {
+			isnap.Status.StartTime = snapjob.Status.StartTime.DeepCopy()
 			if err := r.Status().Update(ctx, isnap); err != nil {
 				return "", fmt.Errorf("error when updating status of InstanceSnapshot %s -> %w", isnap.Name, err)
 			}
 		}
+		return jstatus, nil
+	}
+	if isnap.Status.CompletionTime == nil {
+		if err := r.recordJobOutcome(ctx, isnap, snapjob, jstatus); err != nil {
+			return "", err
+		}
+	}
+	if jstatus == batch.JobComplete {
+		// The job is completed and the image has been uploaded to the registry
+		isnap.Status.Phase = crownlabsv1alpha2.Completed
+		if err := r.Status().Update(ctx, isnap); err != nil {
+			return "", fmt.Errorf("error when updating status of InstanceSnapshot %s -> %w", isnap.Name, err)
+		}
+	} else {
+		// The creation of the snapshot failed since the job failed
+		isnap.Status.Phase = crownlabsv1alpha2.Failed
+		if err := r.Status().Update(ctx, isnap); err != nil {
+			return "", fmt.Errorf("error when updating status of InstanceSnapshot %s -> %w", isnap.Name, err)
+		}
 	}
 	return jstatus, nil
 }
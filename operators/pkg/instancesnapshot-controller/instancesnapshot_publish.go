@@ -0,0 +1,105 @@
+package instancesnapshot_controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	crownlabsv1alpha2 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha2"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/utils"
+)
+
+// errTemplateAlreadyExists is returned when the Template to be published was not generated by the same InstanceSnapshot.
+var errTemplateAlreadyExists = errors.New("a template not published by this snapshot already exists")
+
+// PublishTemplate generates the Template requested by the given completed InstanceSnapshot, in the namespace of the
+// workspace of the snapshotted instance. The Template is linked to the InstanceSnapshot through labels, since it
+// belongs to a different namespace. It returns an error and whether there's the need to try again.
+func (r *InstanceSnapshotReconciler) PublishTemplate(ctx context.Context, isnap *crownlabsv1alpha2.InstanceSnapshot) (bool, error) {
+	if isnap.Spec.PublishTemplate == nil {
+		return false, nil
+	}
+	if isnap.Status.Image == "" || isnap.Spec.ContainerFormat == crownlabsv1alpha2.ContainerSnapshotArchive {
+		return false, fmt.Errorf("the InstanceSnapshot %s did not push an image usable by a template", isnap.Name)
+	}
+
+	instance := &crownlabsv1alpha2.Instance{}
+	instanceName := types.NamespacedName{Namespace: isnap.Spec.Instance.Namespace, Name: isnap.Spec.Instance.Name}
+	if err := r.Get(ctx, instanceName, instance); err != nil {
+		return true, fmt.Errorf("error in retrieving the instance for InstanceSnapshot %s -> %w", isnap.Name, err)
+	}
+
+	source := &crownlabsv1alpha2.Template{}
+	sourceName := types.NamespacedName{Namespace: instance.Spec.Template.Namespace, Name: instance.Spec.Template.Name}
+	if err := r.Get(ctx, sourceName, source); err != nil {
+		return true, fmt.Errorf("error in retrieving the template for InstanceSnapshot %s -> %w", isnap.Name, err)
+	}
+
+	// Only the managers of the workspace are allowed to publish new templates. The tenant is derived from the namespace
+	// of the InstanceSnapshot (i.e. the one requesting the publication), since the one of the instance is set by the tenant.
+	workspace := source.Spec.WorkspaceRef.Name
+	tenant, err := utils.GetNamespaceTenant(ctx, r.Client, isnap.Namespace)
+	if err != nil {
+		return true, fmt.Errorf("error in retrieving the tenant for InstanceSnapshot %s -> %w", isnap.Name, err)
+	}
+	if tenant.Labels[crownlabsv1alpha1.WorkspaceLabelPrefix+workspace] != string(crownlabsv1alpha1.Manager) {
+		return false, fmt.Errorf("tenant %s is not a manager of workspace %s. It is not possible to publish the InstanceSnapshot %s",
+			tenant.Name, workspace, isnap.Name)
+	}
+
+	env, err := GetSnapshotEnvironment(isnap, source)
+	if err != nil {
+		return false, err
+	}
+
+	template := crownlabsv1alpha2.Template{ObjectMeta: metav1.ObjectMeta{Name: isnap.Spec.PublishTemplate.Name, Namespace: source.Namespace}}
+	op, err := ctrl.CreateOrUpdate(ctx, r.Client, &template, func() error {
+		// Prevent overwriting the templates not published by this InstanceSnapshot.
+		if template.ResourceVersion != "" && (template.Labels[crownlabsv1alpha2.InstanceSnapshotNameLabel] != isnap.Name ||
+			template.Labels[crownlabsv1alpha2.InstanceSnapshotNamespaceLabel] != isnap.Namespace) {
+			return fmt.Errorf("%w: %s/%s", errTemplateAlreadyExists, template.Namespace, template.Name)
+		}
+
+		if template.Labels == nil {
+			template.Labels = map[string]string{}
+		}
+		template.Labels[crownlabsv1alpha2.InstanceSnapshotNameLabel] = isnap.Name
+		template.Labels[crownlabsv1alpha2.InstanceSnapshotNamespaceLabel] = isnap.Namespace
+
+		// The spec is set only at creation, to preserve the subsequent changes performed by the managers.
+		if !template.CreationTimestamp.IsZero() {
+			return nil
+		}
+		published := *env.DeepCopy()
+		published.Image = isnap.Status.Image
+		published.IncrementalImage = isnap.Spec.Mode == crownlabsv1alpha2.SnapshotModeIncremental
+		template.Spec = crownlabsv1alpha2.TemplateSpec{
+			PrettyName:      isnap.Spec.PublishTemplate.PrettyName,
+			Description:     isnap.Spec.PublishTemplate.Description,
+			WorkspaceRef:    source.Spec.WorkspaceRef,
+			EnvironmentList: []crownlabsv1alpha2.Environment{published},
+			DeleteAfter:     source.Spec.DeleteAfter,
+			RolloutPolicy:   source.Spec.RolloutPolicy,
+		}
+		if template.Spec.PrettyName == "" {
+			template.Spec.PrettyName = template.Name
+		}
+		if template.Spec.Description == "" {
+			template.Spec.Description = fmt.Sprintf("Published from snapshot %s of %s", isnap.Name, source.Spec.PrettyName)
+		}
+		return nil
+	})
+	if err != nil {
+		// Trying again is pointless if the template belongs to someone else.
+		return !errors.Is(err, errTemplateAlreadyExists), fmt.Errorf("error when publishing the template for InstanceSnapshot %s -> %w", isnap.Name, err)
+	}
+
+	klog.Infof("Template %s/%s for InstanceSnapshot %s %s", template.Namespace, template.Name, isnap.Name, op)
+	return false, nil
+}
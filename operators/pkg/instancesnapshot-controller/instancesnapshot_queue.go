@@ -0,0 +1,94 @@
+package instancesnapshot_controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	crownlabsv1alpha2 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha2"
+)
+
+// snapshotQueuePollInterval is the interval between the checks while waiting for a free slot to start the job.
+const snapshotQueuePollInterval = 10 * time.Second
+
+// JobConcurrencyLimits are the limits to the number of snapshotting jobs running at the same time. Zero means unlimited.
+type JobConcurrencyLimits struct {
+	// Global is the maximum number of snapshotting jobs in the whole cluster.
+	Global int
+	// PerNamespace is the maximum number of snapshotting jobs in each namespace.
+	PerNamespace int
+}
+
+// Enabled returns whether at least one of the limits is set.
+func (l JobConcurrencyLimits) Enabled() bool {
+	return l.Global > 0 || l.PerNamespace > 0
+}
+
+// QueuePosition returns the position (starting from one) of the given InstanceSnapshot in the queue of the ones waiting
+// for a free slot, or zero if its job can be started, together with the overall number of queued InstanceSnapshots.
+// The snapshots in the Pending phase are served in creation order, considering the jobs of the earlier ones started,
+// if the limits allow; those in the Stopping, SnapshottingVolume and Processing phases are considered as running.
+func (l JobConcurrencyLimits) QueuePosition(snapshots []crownlabsv1alpha2.InstanceSnapshot,
+	isnap *crownlabsv1alpha2.InstanceSnapshot) (position, queued int32) {
+	running, runningPerNamespace := 0, map[string]int{}
+	pending := []*crownlabsv1alpha2.InstanceSnapshot{isnap}
+	for i := range snapshots {
+		snapshot := &snapshots[i]
+		if snapshot.UID == isnap.UID || !snapshot.DeletionTimestamp.IsZero() {
+			continue
+		}
+		switch snapshot.Status.Phase {
+		case crownlabsv1alpha2.Stopping, crownlabsv1alpha2.SnapshottingVolume, crownlabsv1alpha2.Processing:
+			running++
+			runningPerNamespace[snapshot.Namespace]++
+		case crownlabsv1alpha2.Pending:
+			pending = append(pending, snapshot)
+		}
+	}
+
+	sort.SliceStable(pending, func(i, j int) bool {
+		if !pending[i].CreationTimestamp.Equal(&pending[j].CreationTimestamp) {
+			return pending[i].CreationTimestamp.Before(&pending[j].CreationTimestamp)
+		}
+		if pending[i].Namespace != pending[j].Namespace {
+			return pending[i].Namespace < pending[j].Namespace
+		}
+		return pending[i].Name < pending[j].Name
+	})
+
+	for _, snapshot := range pending {
+		if (l.Global > 0 && running >= l.Global) || (l.PerNamespace > 0 && runningPerNamespace[snapshot.Namespace] >= l.PerNamespace) {
+			queued++
+			if snapshot.UID == isnap.UID {
+				position = queued
+			}
+			continue
+		}
+		running++
+		runningPerNamespace[snapshot.Namespace]++
+	}
+	return position, queued
+}
+
+// IsQueued returns whether the InstanceSnapshot is waiting for a free slot to start its job.
+func IsQueued(isnap *crownlabsv1alpha2.InstanceSnapshot) bool {
+	return isnap.Status.QueuePosition > 0
+}
+
+// snapshotQueuePosition returns the position of the given InstanceSnapshot in the queue of the ones waiting
+// for a free slot, according to the configured limits, or zero if its job can be started.
+func (r *InstanceSnapshotReconciler) snapshotQueuePosition(ctx context.Context, isnap *crownlabsv1alpha2.InstanceSnapshot) (int32, error) {
+	if !r.JobLimits.Enabled() {
+		return 0, nil
+	}
+
+	var snapshots crownlabsv1alpha2.InstanceSnapshotList
+	if err := r.List(ctx, &snapshots); err != nil {
+		return 0, fmt.Errorf("error when listing the InstanceSnapshots -> %w", err)
+	}
+
+	position, queued := r.JobLimits.QueuePosition(snapshots.Items, isnap)
+	snapshotQueueLength.Set(float64(queued))
+	return position, nil
+}
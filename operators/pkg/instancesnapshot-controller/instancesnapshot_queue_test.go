@@ -0,0 +1,79 @@
+package instancesnapshot_controller_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	crownlabsv1alpha2 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha2"
+	instancesnapshot_controller "github.com/netgroup-polito/CrownLabs/operators/pkg/instancesnapshot-controller"
+)
+
+var _ = Describe("JobConcurrencyLimits", func() {
+	var (
+		now       = time.Now()
+		snapshots []crownlabsv1alpha2.InstanceSnapshot
+	)
+
+	snapshot := func(namespace, name string, phase crownlabsv1alpha2.SnapshotStatus, age time.Duration) crownlabsv1alpha2.InstanceSnapshot {
+		return crownlabsv1alpha2.InstanceSnapshot{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name, Namespace: namespace, UID: types.UID(namespace + "/" + name),
+				CreationTimestamp: metav1.NewTime(now.Add(-age)),
+			},
+			Status: crownlabsv1alpha2.InstanceSnapshotStatus{Phase: phase},
+		}
+	}
+
+	BeforeEach(func() {
+		snapshots = []crownlabsv1alpha2.InstanceSnapshot{
+			snapshot("ns-1", "running", crownlabsv1alpha2.Processing, 10*time.Minute),
+			snapshot("ns-1", "first", crownlabsv1alpha2.Pending, 3*time.Minute),
+			snapshot("ns-2", "second", crownlabsv1alpha2.Pending, 2*time.Minute),
+			snapshot("ns-1", "third", crownlabsv1alpha2.Pending, time.Minute),
+			snapshot("ns-1", "completed", crownlabsv1alpha2.Completed, time.Hour),
+		}
+	})
+
+	It("Should not queue the snapshots if no limits are set", func() {
+		limits := instancesnapshot_controller.JobConcurrencyLimits{}
+		Expect(limits.Enabled()).Should(BeFalse())
+		position, queued := limits.QueuePosition(snapshots, &snapshots[3])
+		Expect(position).Should(BeZero())
+		Expect(queued).Should(BeZero())
+	})
+
+	It("Should queue the snapshots exceeding the global limit in creation order", func() {
+		limits := instancesnapshot_controller.JobConcurrencyLimits{Global: 2}
+		position, queued := limits.QueuePosition(snapshots, &snapshots[1])
+		Expect(position).Should(BeZero())
+		Expect(queued).Should(BeNumerically("==", 2))
+
+		position, _ = limits.QueuePosition(snapshots, &snapshots[2])
+		Expect(position).Should(BeNumerically("==", 1))
+		position, _ = limits.QueuePosition(snapshots, &snapshots[3])
+		Expect(position).Should(BeNumerically("==", 2))
+	})
+
+	It("Should not delay the snapshots of other namespaces because of the per-namespace limit", func() {
+		limits := instancesnapshot_controller.JobConcurrencyLimits{PerNamespace: 1}
+		position, queued := limits.QueuePosition(snapshots, &snapshots[2])
+		Expect(position).Should(BeZero())
+		Expect(queued).Should(BeNumerically("==", 2))
+
+		position, _ = limits.QueuePosition(snapshots, &snapshots[1])
+		Expect(position).Should(BeNumerically("==", 1))
+		position, _ = limits.QueuePosition(snapshots, &snapshots[3])
+		Expect(position).Should(BeNumerically("==", 2))
+	})
+
+	It("Should apply both the global and the per-namespace limits", func() {
+		limits := instancesnapshot_controller.JobConcurrencyLimits{Global: 3, PerNamespace: 2}
+		position, queued := limits.QueuePosition(snapshots, &snapshots[3])
+		Expect(position).Should(BeNumerically("==", 1))
+		Expect(queued).Should(BeNumerically("==", 1))
+	})
+})
@@ -0,0 +1,269 @@
+package instancesnapshot_controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	crownlabsv1alpha2 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha2"
+)
+
+const (
+	// snapshotTenantLabel is the label recording the tenant owning the snapshotted instance.
+	snapshotTenantLabel = "crownlabs.polito.it/tenant"
+	// snapshotWorkspaceLabel is the label recording the workspace of the snapshotted instance.
+	snapshotWorkspaceLabel = "crownlabs.polito.it/workspace"
+)
+
+// labelSnapshotOwners labels the InstanceSnapshot with the tenant and the workspace of the snapshotted instance,
+// to select the retention policy to be enforced even if the instance is deleted in the meanwhile.
+func (r *InstanceSnapshotReconciler) labelSnapshotOwners(ctx context.Context, isnap *crownlabsv1alpha2.InstanceSnapshot) error {
+	if _, ok := isnap.Labels[snapshotTenantLabel]; ok {
+		return nil
+	}
+
+	instance := &crownlabsv1alpha2.Instance{}
+	instanceName := types.NamespacedName{Namespace: isnap.Spec.Instance.Namespace, Name: isnap.Spec.Instance.Name}
+	if err := r.Get(ctx, instanceName, instance); err != nil {
+		return fmt.Errorf("error in retrieving the instance for InstanceSnapshot %s -> %w", isnap.Name, err)
+	}
+	template := &crownlabsv1alpha2.Template{}
+	templateName := types.NamespacedName{Namespace: instance.Spec.Template.Namespace, Name: instance.Spec.Template.Name}
+	if err := r.Get(ctx, templateName, template); err != nil {
+		return fmt.Errorf("error in retrieving the template for InstanceSnapshot %s -> %w", isnap.Name, err)
+	}
+
+	patch := client.MergeFrom(isnap.DeepCopy())
+	if isnap.Labels == nil {
+		isnap.Labels = map[string]string{}
+	}
+	isnap.Labels[snapshotTenantLabel] = instance.Spec.Tenant.Name
+	isnap.Labels[snapshotWorkspaceLabel] = template.Spec.WorkspaceRef.Name
+	if err := r.Patch(ctx, isnap, patch); err != nil {
+		return fmt.Errorf("error when labeling InstanceSnapshot %s -> %w", isnap.Name, err)
+	}
+	return nil
+}
+
+// snapshotRetentionPolicy returns the retention policy applying to the given InstanceSnapshot, that is the stricter
+// combination of the ones of the tenant and of the workspace. Nil is returned if none applies.
+func (r *InstanceSnapshotReconciler) snapshotRetentionPolicy(ctx context.Context,
+	isnap *crownlabsv1alpha2.InstanceSnapshot) (*crownlabsv1alpha1.SnapshotRetentionPolicy, error) {
+	var tenantPolicy, workspacePolicy *crownlabsv1alpha1.SnapshotRetentionPolicy
+	if name := isnap.Labels[snapshotTenantLabel]; name != "" {
+		tenant := &crownlabsv1alpha1.Tenant{}
+		if err := r.Get(ctx, types.NamespacedName{Name: name}, tenant); client.IgnoreNotFound(err) != nil {
+			return nil, fmt.Errorf("error in retrieving the tenant for InstanceSnapshot %s -> %w", isnap.Name, err)
+		} else if err == nil {
+			tenantPolicy = tenant.Spec.SnapshotRetention
+		}
+	}
+
+	if name := isnap.Labels[snapshotWorkspaceLabel]; name != "" {
+		workspace := &crownlabsv1alpha1.Workspace{}
+		if err := r.Get(ctx, types.NamespacedName{Name: name}, workspace); client.IgnoreNotFound(err) != nil {
+			return nil, fmt.Errorf("error in retrieving the workspace for InstanceSnapshot %s -> %w", isnap.Name, err)
+		} else if err == nil {
+			workspacePolicy = workspace.Spec.SnapshotRetention
+		}
+	}
+	return stricterRetentionPolicy(tenantPolicy, workspacePolicy), nil
+}
+
+// stricterRetentionPolicy combines the given retention policies, selecting the stricter of each of their limits.
+// Nil is returned if both policies are nil.
+func stricterRetentionPolicy(first, second *crownlabsv1alpha1.SnapshotRetentionPolicy) *crownlabsv1alpha1.SnapshotRetentionPolicy {
+	if first == nil {
+		return second
+	}
+	if second == nil {
+		return first
+	}
+
+	policy := first.DeepCopy()
+	if second.KeepLast != nil && (policy.KeepLast == nil || *second.KeepLast < *policy.KeepLast) {
+		policy.KeepLast = second.KeepLast
+	}
+	if second.MaxAge != nil && (policy.MaxAge == nil || second.MaxAge.Duration < policy.MaxAge.Duration) {
+		policy.MaxAge = second.MaxAge
+	}
+	return policy
+}
+
+// EnforceRetention deletes the completed InstanceSnapshots with the same image name of the given one (and the
+// corresponding exported snapshots in their backends) which are no longer retained according to the applicable policy.
+// Snapshots published as templates or referenced by instances are always retained. It returns after how long
+// the policy needs to be enforced again, since some of the snapshots will expire, or zero if not needed.
+func (r *InstanceSnapshotReconciler) EnforceRetention(ctx context.Context, isnap *crownlabsv1alpha2.InstanceSnapshot) (time.Duration, error) {
+	policy, err := r.snapshotRetentionPolicy(ctx, isnap)
+	if err != nil || policy == nil {
+		return 0, err
+	}
+
+	var snapshots crownlabsv1alpha2.InstanceSnapshotList
+	if err := r.List(ctx, &snapshots, client.InNamespace(isnap.Namespace)); err != nil {
+		return 0, fmt.Errorf("error when listing the InstanceSnapshots in namespace %s -> %w", isnap.Namespace, err)
+	}
+	referenced, err := r.referencedSnapshots(ctx, isnap.Namespace)
+	if err != nil {
+		return 0, err
+	}
+
+	var candidates []*crownlabsv1alpha2.InstanceSnapshot
+	for i := range snapshots.Items {
+		snapshot := &snapshots.Items[i]
+		if snapshot.Spec.ImageName != isnap.Spec.ImageName || snapshot.Status.Phase != crownlabsv1alpha2.Completed ||
+			snapshot.Spec.PublishTemplate != nil || referenced[snapshot.Name] || !snapshot.DeletionTimestamp.IsZero() ||
+			!r.snapshotExporter(r.snapshotBackend(snapshot)).Deletable() {
+			continue
+		}
+		candidates = append(candidates, snapshot)
+	}
+	// The most recent snapshots come first.
+	sort.Slice(candidates, func(i, j int) bool {
+		return snapshotCompletionTime(candidates[i]).After(snapshotCompletionTime(candidates[j]))
+	})
+
+	var requeue time.Duration
+	var expired []*crownlabsv1alpha2.InstanceSnapshot
+	for i, snapshot := range candidates {
+		isExpired := policy.KeepLast != nil && i >= int(*policy.KeepLast)
+		if policy.MaxAge != nil && !isExpired {
+			remaining := time.Until(snapshotCompletionTime(snapshot).Add(policy.MaxAge.Duration))
+			isExpired = remaining <= 0
+			if !isExpired && (requeue == 0 || remaining < requeue) {
+				requeue = remaining
+			}
+		}
+		if isExpired {
+			expired = append(expired, snapshot)
+		}
+	}
+
+	shared, err := r.sharedRegistryImages(ctx, expired)
+	if err != nil {
+		return 0, err
+	}
+	for _, snapshot := range expired {
+		if err := r.deleteSnapshot(ctx, snapshot, shared[snapshot.Name]); err != nil {
+			return 0, err
+		}
+		message := fmt.Sprintf("InstanceSnapshot %s and snapshot %s deleted by the retention policy", snapshot.Name, snapshot.Status.Location)
+		if shared[snapshot.Name] {
+			message = fmt.Sprintf("InstanceSnapshot %s deleted by the retention policy (image %s retained, since shared with other snapshots)",
+				snapshot.Name, snapshot.Status.Location)
+		}
+		r.EventsRecorder.Event(isnap, "Normal", "RetentionEnforced", message)
+	}
+	return requeue, nil
+}
+
+// sharedRegistryImages returns the names of the given expired InstanceSnapshots whose image resolves to the same digest
+// of the image of a retained InstanceSnapshot, in the same repository. Since the registry deletes the manifests by digest,
+// removing these images would delete the retained ones as well. The InstanceSnapshots are listed in all namespaces,
+// as they are not guaranteed to be in the same namespace of the repository they push to.
+func (r *InstanceSnapshotReconciler) sharedRegistryImages(ctx context.Context,
+	expired []*crownlabsv1alpha2.InstanceSnapshot) (map[string]bool, error) {
+	exporter := &registryExporter{r}
+	deleting := map[types.UID]bool{}
+	repositories := map[string]bool{}
+	for _, snapshot := range expired {
+		deleting[snapshot.UID] = true
+		if repository, _, err := parseImageReference(snapshot.Status.Image); err == nil {
+			repositories[repository] = true
+		}
+	}
+	if len(repositories) == 0 {
+		return nil, nil
+	}
+
+	var snapshots crownlabsv1alpha2.InstanceSnapshotList
+	if err := r.List(ctx, &snapshots); err != nil {
+		return nil, fmt.Errorf("error when listing the InstanceSnapshots -> %w", err)
+	}
+
+	// The images of the retained snapshots, identified by repository and digest.
+	retained := map[string]bool{}
+	for i := range snapshots.Items {
+		snapshot := &snapshots.Items[i]
+		repository, _, err := parseImageReference(snapshot.Status.Image)
+		if err != nil || !repositories[repository] || deleting[snapshot.UID] || !snapshot.DeletionTimestamp.IsZero() {
+			continue
+		}
+		digest, err := exporter.Digest(ctx, snapshot)
+		if err != nil {
+			return nil, err
+		}
+		if digest != "" {
+			retained[repository+"@"+digest] = true
+		}
+	}
+
+	shared := map[string]bool{}
+	for _, snapshot := range expired {
+		repository, _, err := parseImageReference(snapshot.Status.Image)
+		if err != nil {
+			continue
+		}
+		digest, err := exporter.Digest(ctx, snapshot)
+		if err != nil {
+			return nil, err
+		}
+		if digest != "" && retained[repository+"@"+digest] {
+			shared[snapshot.Name] = true
+		}
+	}
+	return shared, nil
+}
+
+// referencedSnapshots returns the names of the InstanceSnapshots in the given namespace the instances are restored from.
+// The instances are listed in all namespaces, since the managers of a workspace can clone the snapshots of other tenants.
+func (r *InstanceSnapshotReconciler) referencedSnapshots(ctx context.Context, namespace string) (map[string]bool, error) {
+	var instances crownlabsv1alpha2.InstanceList
+	if err := r.List(ctx, &instances); err != nil {
+		return nil, fmt.Errorf("error when listing the instances -> %w", err)
+	}
+
+	referenced := map[string]bool{}
+	for i := range instances.Items {
+		ref := instances.Items[i].Spec.SnapshotRef
+		if ref == nil {
+			continue
+		}
+		if ref.Namespace == namespace || (ref.Namespace == "" && instances.Items[i].Namespace == namespace) {
+			referenced[ref.Name] = true
+		}
+	}
+	return referenced, nil
+}
+
+// deleteSnapshot deletes the snapshot exported by the given InstanceSnapshot from its backend (unless it shall be kept,
+// since shared with other InstanceSnapshots), and then the InstanceSnapshot itself.
+func (r *InstanceSnapshotReconciler) deleteSnapshot(ctx context.Context, isnap *crownlabsv1alpha2.InstanceSnapshot, keepExported bool) error {
+	if !keepExported {
+		if err := r.snapshotExporter(r.snapshotBackend(isnap)).Delete(ctx, isnap); err != nil {
+			return err
+		}
+	}
+	if err := r.Delete(ctx, isnap); err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("error when deleting InstanceSnapshot %s -> %w", isnap.Name, err)
+	}
+
+	klog.Infof("InstanceSnapshot %s and snapshot %s deleted by the retention policy", isnap.Name, isnap.Status.Location)
+	return nil
+}
+
+// snapshotCompletionTime returns the time the given InstanceSnapshot completed, or its creation time if unknown.
+func snapshotCompletionTime(isnap *crownlabsv1alpha2.InstanceSnapshot) time.Time {
+	if isnap.Status.CompletionTime != nil {
+		return isnap.Status.CompletionTime.Time
+	}
+	return isnap.CreationTimestamp.Time
+}
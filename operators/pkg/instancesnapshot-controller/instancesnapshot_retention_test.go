@@ -0,0 +1,96 @@
+package instancesnapshot_controller_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	crownlabsv1alpha2 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha2"
+	instancesnapshot_controller "github.com/netgroup-polito/CrownLabs/operators/pkg/instancesnapshot-controller"
+)
+
+var _ = Describe("Retention of the snapshots sharing the same image", func() {
+	const (
+		namespace = "tenant-john-doe"
+		oldImage  = "my-registry/johndoe/image:20210101t000000"
+		newImage  = "my-registry/johndoe/image:20210102t000000"
+	)
+
+	var (
+		ctx        context.Context
+		images     *fakeRegistry
+		reconciler *instancesnapshot_controller.InstanceSnapshotReconciler
+		snapshots  []*crownlabsv1alpha2.InstanceSnapshot
+	)
+
+	snapshot := func(name, image string, age time.Duration) *crownlabsv1alpha2.InstanceSnapshot {
+		return &crownlabsv1alpha2.InstanceSnapshot{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name, Namespace: namespace, UID: types.UID(name),
+				Labels: map[string]string{"crownlabs.polito.it/workspace": "retention-ws"},
+			},
+			Spec: crownlabsv1alpha2.InstanceSnapshotSpec{ImageName: "image"},
+			Status: crownlabsv1alpha2.InstanceSnapshotStatus{
+				Phase: crownlabsv1alpha2.Completed, Image: image, Location: image,
+				CompletionTime: &metav1.Time{Time: time.Now().Add(-age)},
+			},
+		}
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		images = newFakeRegistry()
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(crownlabsv1alpha1.AddToScheme(scheme)).To(Succeed())
+		Expect(crownlabsv1alpha2.AddToScheme(scheme)).To(Succeed())
+
+		snapshots = []*crownlabsv1alpha2.InstanceSnapshot{snapshot("old", oldImage, time.Hour), snapshot("new", newImage, time.Minute)}
+		workspace := &crownlabsv1alpha1.Workspace{
+			ObjectMeta: metav1.ObjectMeta{Name: "retention-ws"},
+			Spec:       crownlabsv1alpha1.WorkspaceSpec{SnapshotRetention: &crownlabsv1alpha1.SnapshotRetentionPolicy{KeepLast: pointer.Int32Ptr(1)}},
+		}
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(workspace, snapshots[0], snapshots[1]).Build()
+
+		reconciler = &instancesnapshot_controller.InstanceSnapshotReconciler{
+			Client:             c,
+			APIReader:          c,
+			Scheme:             scheme,
+			EventsRecorder:     record.NewFakeRecorder(10),
+			VMRegistry:         "my-registry",
+			RegistrySecretName: "registry-credentials",
+			Registry:           instancesnapshot_controller.NewRegistryClient(images.URL),
+		}
+	})
+
+	AfterEach(func() {
+		images.Close()
+	})
+
+	It("Should delete the image of the expired snapshot", func() {
+		Expect(reconciler.EnforceRetention(ctx, snapshots[1])).Should(BeZero())
+		Expect(reconciler.Get(ctx, client.ObjectKeyFromObject(snapshots[0]), &crownlabsv1alpha2.InstanceSnapshot{})).ShouldNot(Succeed())
+		Expect(images.Deleted()).Should(ConsistOf("johndoe/image:20210101t000000"))
+	})
+
+	It("Should not delete the image if it resolves to the same digest of a retained snapshot", func() {
+		images.SetDigest("johndoe/image:20210101t000000", "sha256:0123")
+		images.SetDigest("johndoe/image:20210102t000000", "sha256:0123")
+
+		Expect(reconciler.EnforceRetention(ctx, snapshots[1])).Should(BeZero())
+		Expect(reconciler.Get(ctx, client.ObjectKeyFromObject(snapshots[0]), &crownlabsv1alpha2.InstanceSnapshot{})).ShouldNot(Succeed())
+		Expect(reconciler.Get(ctx, client.ObjectKeyFromObject(snapshots[1]), &crownlabsv1alpha2.InstanceSnapshot{})).Should(Succeed())
+		Expect(images.Deleted()).Should(BeEmpty())
+	})
+})
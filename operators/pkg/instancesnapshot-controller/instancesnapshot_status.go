@@ -0,0 +1,125 @@
+package instancesnapshot_controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	batch "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	crownlabsv1alpha2 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha2"
+)
+
+// digestPrefix is the prefix of the digests written by kaniko in the termination message of the pusher container.
+const digestPrefix = "sha256:"
+
+// setSnapshotCondition sets the given condition in the status of the InstanceSnapshot, without updating it.
+func setSnapshotCondition(isnap *crownlabsv1alpha2.InstanceSnapshot, ctype crownlabsv1alpha2.InstanceSnapshotConditionType,
+	status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&isnap.Status.Conditions, metav1.Condition{
+		Type:               string(ctype),
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: isnap.Generation,
+	})
+}
+
+// updateSnapshotCondition sets the given condition and updates the status of the InstanceSnapshot, in case it changed.
+func (r *InstanceSnapshotReconciler) updateSnapshotCondition(ctx context.Context, isnap *crownlabsv1alpha2.InstanceSnapshot,
+	ctype crownlabsv1alpha2.InstanceSnapshotConditionType, status metav1.ConditionStatus, reason, message string) error {
+	current := meta.FindStatusCondition(isnap.Status.Conditions, string(ctype))
+	if current != nil && current.Status == status && current.Reason == reason && current.Message == message {
+		return nil
+	}
+
+	setSnapshotCondition(isnap, ctype, status, reason, message)
+	if err := r.Status().Update(ctx, isnap); err != nil {
+		return fmt.Errorf("error when updating status of InstanceSnapshot %s -> %w", isnap.Name, err)
+	}
+	return nil
+}
+
+// recordJobOutcome records in the status of the InstanceSnapshot the outcome of the completed job, including
+// the digest of the pushed image or the reason of the failure, as reported by the pods of the job.
+func (r *InstanceSnapshotReconciler) recordJobOutcome(ctx context.Context, isnap *crownlabsv1alpha2.InstanceSnapshot,
+	snapjob *batch.Job, jstatus batch.JobConditionType) error {
+	isnap.Status.JobName = snapjob.Name
+	if snapjob.Status.StartTime != nil {
+		isnap.Status.StartTime = snapjob.Status.StartTime.DeepCopy()
+	}
+
+	// The completion time is set by the job controller only in case of success.
+	var condition batch.JobCondition
+	for i := range snapjob.Status.Conditions {
+		if snapjob.Status.Conditions[i].Type == jstatus {
+			condition = snapjob.Status.Conditions[i]
+		}
+	}
+	isnap.Status.CompletionTime = snapjob.Status.CompletionTime.DeepCopy()
+	if isnap.Status.CompletionTime == nil {
+		now := metav1.Now()
+		isnap.Status.CompletionTime = &now
+		if !condition.LastTransitionTime.IsZero() {
+			isnap.Status.CompletionTime = condition.LastTransitionTime.DeepCopy()
+		}
+	}
+
+	// The pods of the job are not cached by the manager, hence they are retrieved directly from the API server.
+	var pods corev1.PodList
+	if err := r.APIReader.List(ctx, &pods, client.InNamespace(snapjob.Namespace), client.MatchingLabels{"job-name": snapjob.Name}); err != nil {
+		return fmt.Errorf("error when retrieving the pods of the job for InstanceSnapshot %s -> %w", isnap.Name, err)
+	}
+
+	if jstatus == batch.JobComplete {
+		isnap.Status.ImageDigest = imageDigestFromPods(pods.Items)
+		setSnapshotCondition(isnap, crownlabsv1alpha2.InstanceSnapshotImagePushed, metav1.ConditionTrue, "JobCompleted",
+			fmt.Sprintf("Snapshot exported to %s", isnap.Status.Location))
+		return nil
+	}
+
+	isnap.Status.FailureReason = failureReasonFromPods(pods.Items)
+	if isnap.Status.FailureReason == "" {
+		isnap.Status.FailureReason = fmt.Sprintf("%s: %s", condition.Reason, condition.Message)
+	}
+	setSnapshotCondition(isnap, crownlabsv1alpha2.InstanceSnapshotImagePushed, metav1.ConditionFalse, "JobFailed", isnap.Status.FailureReason)
+	return nil
+}
+
+// imageDigestFromPods returns the digest of the pushed image, as written in the termination message of the pusher container.
+func imageDigestFromPods(pods []corev1.Pod) string {
+	for i := range pods {
+		for j := range pods[i].Status.ContainerStatuses {
+			terminated := pods[i].Status.ContainerStatuses[j].State.Terminated
+			if terminated != nil && terminated.ExitCode == 0 && strings.HasPrefix(strings.TrimSpace(terminated.Message), digestPrefix) {
+				return strings.TrimSpace(terminated.Message)
+			}
+		}
+	}
+	return ""
+}
+
+// failureReasonFromPods returns the reason of the failure of the most recent container terminated with an error.
+func failureReasonFromPods(pods []corev1.Pod) string {
+	var reason string
+	var latest metav1.Time
+	for i := range pods {
+		statuses := append(append([]corev1.ContainerStatus{}, pods[i].Status.InitContainerStatuses...), pods[i].Status.ContainerStatuses...)
+		for j := range statuses {
+			terminated := statuses[j].State.Terminated
+			if terminated == nil || terminated.ExitCode == 0 || terminated.FinishedAt.Before(&latest) {
+				continue
+			}
+			latest = terminated.FinishedAt
+			reason = fmt.Sprintf("container %s terminated with exit code %d (%s)", statuses[j].Name, terminated.ExitCode, terminated.Reason)
+			if message := strings.TrimSpace(terminated.Message); message != "" {
+				reason = fmt.Sprintf("%s: %s", reason, message)
+			}
+		}
+	}
+	return reason
+}
@@ -0,0 +1,23 @@
+package instancesnapshot_controller
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	snapshotQueueLength = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "instancesnapshot_queue_length",
+		Help: "The number of InstanceSnapshots waiting for a free slot to start their job, as of the last check",
+	})
+	snapshotQueueWaitTimes = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "instancesnapshot_queue_wait_time_seconds",
+		Help:    "The time elapsed between the creation of the InstanceSnapshots and the start of the preparation of their job",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 14),
+	})
+)
+
+func init() {
+	// Register custom metrics with the global prometheus registry
+	metrics.Registry.MustRegister(snapshotQueueLength, snapshotQueueWaitTimes)
+}
@@ -0,0 +1,226 @@
+package instancesnapshot_controller
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// manifestMediaTypes are the media types of the manifests accepted when resolving the digest of an image.
+var manifestMediaTypes = []string{
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.oci.image.index.v1+json",
+}
+
+// challengeParamsRegex matches the parameters of the WWW-Authenticate header returned by the registry.
+var challengeParamsRegex = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// RegistryCredentials are the credentials used to authenticate to the docker registry.
+type RegistryCredentials struct {
+	Username string
+	Password string
+}
+
+// RegistryClient interacts with the API of the docker registry the snapshots are pushed to.
+type RegistryClient struct {
+	// URL is the base URL of the registry API (e.g. https://registry.example.com).
+	URL string
+	// HTTPClient is the client used to perform the requests to the registry.
+	HTTPClient *http.Client
+}
+
+// NewRegistryClient returns a new RegistryClient for the registry exposing its API at the given URL.
+func NewRegistryClient(registryURL string) *RegistryClient {
+	return &RegistryClient{URL: strings.TrimSuffix(registryURL, "/"), HTTPClient: http.DefaultClient}
+}
+
+// ImageDigest resolves the digest of the manifest of the given image (i.e. <registry>/<repository>:<tag>).
+// An empty digest is returned if the image does not exist.
+func (rc *RegistryClient) ImageDigest(ctx context.Context, image string, creds *RegistryCredentials) (string, error) {
+	repository, tag, err := parseImageReference(image)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := rc.do(ctx, http.MethodHead, repository, "manifests/"+tag, creds)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return "", nil
+	case resp.StatusCode != http.StatusOK:
+		return "", fmt.Errorf("unexpected status %d when retrieving the manifest of image %s", resp.StatusCode, image)
+	}
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		return "", fmt.Errorf("the registry did not return the digest of image %s", image)
+	}
+	return digest, nil
+}
+
+// DeleteImage deletes from the registry the manifest of the given image (i.e. <registry>/<repository>:<tag>).
+// In case the digest is not known, it is resolved from the tag. Images already deleted are ignored.
+// Since manifests are deleted by digest, all the tags referencing the same manifest are deleted as well.
+func (rc *RegistryClient) DeleteImage(ctx context.Context, image, digest string, creds *RegistryCredentials) error {
+	repository, _, err := parseImageReference(image)
+	if err != nil {
+		return err
+	}
+
+	if digest == "" {
+		if digest, err = rc.ImageDigest(ctx, image, creds); err != nil || digest == "" {
+			return err
+		}
+	}
+
+	// Manifests can be deleted only referencing them by digest.
+	resp, err := rc.do(ctx, http.MethodDelete, repository, "manifests/"+digest, creds)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("unexpected status %d when deleting image %s", resp.StatusCode, image)
+	}
+	return nil
+}
+
+// do performs a request to the registry API, authenticating with a bearer token in case the registry requires it.
+func (rc *RegistryClient) do(ctx context.Context, method, repository, path string, creds *RegistryCredentials) (*http.Response, error) {
+	newRequest := func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, method, fmt.Sprintf("%s/v2/%s/%s", rc.URL, repository, path), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", strings.Join(manifestMediaTypes, ","))
+		if creds != nil {
+			req.SetBasicAuth(creds.Username, creds.Password)
+		}
+		return req, nil
+	}
+
+	req, err := newRequest()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := rc.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error when contacting the registry -> %w", err)
+	}
+
+	challenge := resp.Header.Get("WWW-Authenticate")
+	if resp.StatusCode != http.StatusUnauthorized || !strings.HasPrefix(strings.ToLower(challenge), "bearer ") {
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	token, err := rc.token(ctx, challenge, creds)
+	if err != nil {
+		return nil, err
+	}
+	if req, err = newRequest(); err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if resp, err = rc.HTTPClient.Do(req); err != nil {
+		return nil, fmt.Errorf("error when contacting the registry -> %w", err)
+	}
+	return resp, nil
+}
+
+// token retrieves a bearer token from the authorization server specified by the challenge of the registry.
+func (rc *RegistryClient) token(ctx context.Context, challenge string, creds *RegistryCredentials) (string, error) {
+	params := map[string]string{}
+	for _, match := range challengeParamsRegex.FindAllStringSubmatch(challenge, -1) {
+		params[match[1]] = match[2]
+	}
+	if params["realm"] == "" {
+		return "", fmt.Errorf("invalid authentication challenge returned by the registry: %s", challenge)
+	}
+
+	query := url.Values{}
+	for _, key := range []string{"service", "scope"} {
+		if params[key] != "" {
+			query.Set(key, params[key])
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, params["realm"]+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	if creds != nil {
+		req.SetBasicAuth(creds.Username, creds.Password)
+	}
+
+	resp, err := rc.HTTPClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error when retrieving the registry token -> %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %d when retrieving the registry token", resp.StatusCode)
+	}
+
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("error when decoding the registry token -> %w", err)
+	}
+	if body.Token == "" {
+		body.Token = body.AccessToken
+	}
+	return body.Token, nil
+}
+
+// parseImageReference splits the given image reference (i.e. <registry>/<repository>:<tag>) into repository and tag.
+func parseImageReference(image string) (repository, tag string, err error) {
+	slash := strings.Index(image, "/")
+	colon := strings.LastIndex(image, ":")
+	if slash < 0 || colon < slash {
+		return "", "", fmt.Errorf("invalid image reference %s", image)
+	}
+	return image[slash+1 : colon], image[colon+1:], nil
+}
+
+// registryCredentialsFromDockerConfig extracts the credentials for the given registry from a dockerconfigjson file.
+func registryCredentialsFromDockerConfig(config []byte, registry string) (*RegistryCredentials, error) {
+	var parsed struct {
+		Auths map[string]struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+			Auth     string `json:"auth"`
+		} `json:"auths"`
+	}
+	if err := json.Unmarshal(config, &parsed); err != nil {
+		return nil, fmt.Errorf("error when decoding the docker config -> %w", err)
+	}
+
+	for server, auth := range parsed.Auths {
+		// The server may be specified either as hostname or as URL.
+		if server != registry && !strings.HasPrefix(strings.TrimPrefix(strings.TrimPrefix(server, "https://"), "http://"), registry) {
+			continue
+		}
+		if auth.Username != "" {
+			return &RegistryCredentials{Username: auth.Username, Password: auth.Password}, nil
+		}
+		decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+		if err != nil {
+			return nil, fmt.Errorf("error when decoding the credentials of registry %s -> %w", registry, err)
+		}
+		if parts := strings.SplitN(string(decoded), ":", 2); len(parts) == 2 {
+			return &RegistryCredentials{Username: parts[0], Password: parts[1]}, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,54 @@
+package instancesnapshot_controller_test
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	instancesnapshot_controller "github.com/netgroup-polito/CrownLabs/operators/pkg/instancesnapshot-controller"
+)
+
+var _ = Describe("RegistryClient", func() {
+	var (
+		fake *fakeRegistry
+		rc   *instancesnapshot_controller.RegistryClient
+		ctx  context.Context
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		fake = newFakeRegistry()
+		rc = instancesnapshot_controller.NewRegistryClient(fake.URL)
+	})
+
+	AfterEach(func() {
+		fake.Close()
+	})
+
+	It("Should delete the image resolving the digest from the tag", func() {
+		Expect(rc.DeleteImage(ctx, "my-registry/tenant/image:20210101t000000", "", nil)).Should(Succeed())
+		Expect(fake.Deleted()).Should(ConsistOf("tenant/image:20210101t000000"))
+	})
+
+	It("Should authenticate with the token obtained from the registry", func() {
+		fake.Token = "secret-token"
+		creds := &instancesnapshot_controller.RegistryCredentials{Username: "user", Password: "password"}
+		Expect(rc.DeleteImage(ctx, "my-registry/tenant/image:20210101t000000", "", creds)).Should(Succeed())
+		Expect(fake.Deleted()).Should(ConsistOf("tenant/image:20210101t000000"))
+	})
+
+	It("Should ignore the images already deleted", func() {
+		Expect(rc.DeleteImage(ctx, "my-registry/tenant/image:20210101t000000", "sha256:0123", nil)).Should(Succeed())
+		Expect(fake.Deleted()).Should(BeEmpty())
+	})
+
+	It("Should resolve the digest of the image from the tag", func() {
+		fake.SetDigest("tenant/image:20210101t000000", "sha256:0123")
+		Expect(rc.ImageDigest(ctx, "my-registry/tenant/image:20210101t000000", nil)).Should(Equal("sha256:0123"))
+	})
+
+	It("Should fail with an invalid image reference", func() {
+		Expect(rc.DeleteImage(ctx, "image", "", nil)).ShouldNot(Succeed())
+	})
+})
@@ -0,0 +1,125 @@
+package instancesnapshot_controller
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// s3AccessKeyIDKey is the key of the secret containing the access key ID of the object storage.
+	s3AccessKeyIDKey = "AWS_ACCESS_KEY_ID"
+	// s3SecretAccessKeyKey is the key of the secret containing the secret access key of the object storage.
+	s3SecretAccessKeyKey = "AWS_SECRET_ACCESS_KEY"
+	// s3DefaultRegion is the region used to sign the requests, if not specified.
+	s3DefaultRegion = "us-east-1"
+	// s3EmptyPayloadHash is the SHA256 hash of an empty request body.
+	s3EmptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// S3Credentials are the credentials used to authenticate to the object storage.
+type S3Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+}
+
+// S3Client interacts with the API of the S3-compatible object storage the snapshots are uploaded to.
+// Requests are performed in path-style (i.e. <endpoint>/<bucket>/<key>), and signed with AWS Signature Version 4.
+type S3Client struct {
+	// Endpoint is the base URL of the object storage (e.g. https://minio.example.com).
+	Endpoint string
+	// Region is the region the requests are signed for.
+	Region string
+	// HTTPClient is the client used to perform the requests to the object storage.
+	HTTPClient *http.Client
+}
+
+// NewS3Client returns a new S3Client for the given endpoint and region. If the endpoint
+// is not specified, the AWS endpoint of the region is used.
+func NewS3Client(endpoint, region string) *S3Client {
+	if region == "" {
+		region = s3DefaultRegion
+	}
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+	}
+	return &S3Client{Endpoint: strings.TrimSuffix(endpoint, "/"), Region: region, HTTPClient: http.DefaultClient}
+}
+
+// DeleteObject deletes the given object from the bucket. Objects already deleted are ignored.
+func (sc *S3Client) DeleteObject(ctx context.Context, bucket, key string, creds S3Credentials) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, fmt.Sprintf("%s/%s/%s", sc.Endpoint, bucket, s3EscapePath(key)), nil)
+	if err != nil {
+		return err
+	}
+	sc.sign(req, creds, time.Now().UTC())
+
+	resp, err := sc.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error when contacting the object storage -> %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("unexpected status %d when deleting object %s from bucket %s", resp.StatusCode, key, bucket)
+	}
+	return nil
+}
+
+// sign adds to the given request (without body) the headers required by AWS Signature Version 4.
+func (sc *S3Client) sign(req *http.Request, creds S3Credentials, now time.Time) {
+	date := now.Format("20060102")
+	timestamp := now.Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", timestamp)
+	req.Header.Set("X-Amz-Content-Sha256", s3EmptyPayloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + s3EmptyPayloadHash,
+		"x-amz-date:" + timestamp,
+		"",
+		signedHeaders,
+		s3EmptyPayloadHash,
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, sc.Region)
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", timestamp, scope, hex.EncodeToString(canonicalHash[:])}, "\n")
+
+	key := []byte("AWS4" + creds.SecretAccessKey)
+	for _, part := range []string{date, sc.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of the given data.
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3EscapePath encodes the given object key as required by S3, i.e. escaping all characters but the unreserved ones and the slashes.
+func s3EscapePath(key string) string {
+	var escaped strings.Builder
+	for _, c := range []byte(key) {
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') || strings.IndexByte("-_.~/", c) >= 0 {
+			escaped.WriteByte(c)
+		} else {
+			fmt.Fprintf(&escaped, "%%%02X", c)
+		}
+	}
+	return escaped.String()
+}
@@ -0,0 +1,47 @@
+package instancesnapshot_controller_test
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	instancesnapshot_controller "github.com/netgroup-polito/CrownLabs/operators/pkg/instancesnapshot-controller"
+)
+
+var _ = Describe("S3Client", func() {
+	var (
+		fake  *fakeS3
+		sc    *instancesnapshot_controller.S3Client
+		creds instancesnapshot_controller.S3Credentials
+		ctx   context.Context
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		fake = newFakeS3("snapshots/tenant/image/20210101t000000.qcow2")
+		fake.AccessKeyID = "access-key"
+		sc = instancesnapshot_controller.NewS3Client(fake.URL, "")
+		creds = instancesnapshot_controller.S3Credentials{AccessKeyID: "access-key", SecretAccessKey: "secret-key"}
+	})
+
+	AfterEach(func() {
+		fake.Close()
+	})
+
+	It("Should delete the object from the bucket", func() {
+		Expect(sc.DeleteObject(ctx, "snapshots", "tenant/image/20210101t000000.qcow2", creds)).Should(Succeed())
+		Expect(fake.Deleted()).Should(ConsistOf("snapshots/tenant/image/20210101t000000.qcow2"))
+	})
+
+	It("Should ignore the objects already deleted", func() {
+		Expect(sc.DeleteObject(ctx, "snapshots", "tenant/image/missing.qcow2", creds)).Should(Succeed())
+		Expect(fake.Deleted()).Should(BeEmpty())
+	})
+
+	It("Should fail with invalid credentials", func() {
+		creds.AccessKeyID = "invalid"
+		Expect(sc.DeleteObject(ctx, "snapshots", "tenant/image/20210101t000000.qcow2", creds)).ShouldNot(Succeed())
+		Expect(fake.Deleted()).Should(BeEmpty())
+	})
+})
@@ -0,0 +1,136 @@
+// Package selectors groups the functionalities to restrict the set of objects reconciled by the
+// CrownLabs operators by means of label selectors, either on the objects themselves or on their namespace.
+package selectors
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// ParseLabelSelector converts a string into the corresponding label selector. The string follows the
+// syntax of kubectl (e.g. key1=value1,key2 in (value2,value3),!key4), while requirements can also be
+// separated with a & for backward compatibility (e.g. key1=value1&key2=value2).
+func ParseLabelSelector(raw string) (*metav1.LabelSelector, error) {
+	return metav1.ParseToLabelSelector(strings.ReplaceAll(raw, "&", ","))
+}
+
+// ObjectPredicate returns a predicate which discards the events of the objects whose labels do not satisfy the given selector.
+func ObjectPredicate(labelSelector *metav1.LabelSelector) (predicate.Predicate, error) {
+	return predicate.LabelSelectorPredicate(*labelSelector)
+}
+
+// NamespaceSelector filters the events of namespaced objects depending on the labels of the namespace they belong to.
+type NamespaceSelector struct {
+	reader   client.Reader
+	selector labels.Selector
+}
+
+// NewNamespaceSelector returns a new NamespaceSelector matching the namespaces which satisfy the given label selector.
+// The reader is expected to be backed by the manager cache (i.e. mgr.GetClient()), so that namespaces are retrieved
+// from the local informer rather than querying the API server for every event.
+func NewNamespaceSelector(reader client.Reader, labelSelector *metav1.LabelSelector) (*NamespaceSelector, error) {
+	selector, err := metav1.LabelSelectorAsSelector(labelSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid namespace selector -> %w", err)
+	}
+	return &NamespaceSelector{reader: reader, selector: selector}, nil
+}
+
+// Matches returns whether the namespace with the given name satisfies the selector. It is meant to be invoked also
+// by the Reconcile functions, since the predicate lets the events through in case the namespace cannot be retrieved:
+// in that case, the error is returned to retry later.
+func (ns *NamespaceSelector) Matches(ctx context.Context, namespace string) (bool, error) {
+	var namespaceObj corev1.Namespace
+	if err := ns.reader.Get(ctx, types.NamespacedName{Name: namespace}, &namespaceObj); err != nil {
+		return false, fmt.Errorf("error when retrieving namespace %s -> %w", namespace, err)
+	}
+	return ns.selector.Matches(labels.Set(namespaceObj.Labels)), nil
+}
+
+// Predicate returns a predicate which discards the events of the objects belonging to namespaces not satisfying the selector.
+// The events are let through in case the namespace cannot be retrieved (e.g. it has just been created and the cache is not
+// yet synchronized), not to drop them permanently: the check is then repeated by the Reconcile function (see Matches).
+func (ns *NamespaceSelector) Predicate() predicate.Predicate {
+	return predicate.NewPredicateFuncs(func(object client.Object) bool {
+		match, err := ns.Matches(context.Background(), object.GetNamespace())
+		if err != nil {
+			klog.Warningf("Failed to check the labels of namespace %s, deferring the check -> %s", object.GetNamespace(), err)
+			return true
+		}
+		return match
+	})
+}
+
+// Watch configures the given builder to watch the namespaces, and to enqueue the objects of the kind
+// returned by newList whenever the namespace they belong to is created or its labels change, and satisfies
+// the selector. This allows reconciling the objects which were previously filtered out by the predicate.
+func (ns *NamespaceSelector) Watch(blder *builder.Builder, newList func() client.ObjectList) *builder.Builder {
+	return blder.Watches(&source.Kind{Type: &corev1.Namespace{}},
+		handler.EnqueueRequestsFromMapFunc(ns.mapNamespaceObjects(newList)),
+		builder.WithPredicates(ns.labelsChangedPredicate()))
+}
+
+// labelsChangedPredicate returns a predicate which accepts only the namespace creations and the updates
+// modifying the labels, and such that the new ones satisfy the selector.
+func (ns *NamespaceSelector) labelsChangedPredicate() predicate.Predicate {
+	return predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			return ns.selector.Matches(labels.Set(e.Object.GetLabels()))
+		},
+		DeleteFunc:  func(event.DeleteEvent) bool { return false },
+		GenericFunc: func(event.GenericEvent) bool { return false },
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			if labels.Equals(e.ObjectOld.GetLabels(), e.ObjectNew.GetLabels()) {
+				return false
+			}
+			return ns.selector.Matches(labels.Set(e.ObjectNew.GetLabels()))
+		},
+	}
+}
+
+// mapNamespaceObjects returns a handler.MapFunc which generates a request for each object of
+// the kind returned by newList belonging to the given namespace.
+func (ns *NamespaceSelector) mapNamespaceObjects(newList func() client.ObjectList) handler.MapFunc {
+	return func(namespace client.Object) []reconcile.Request {
+		list := newList()
+		if err := ns.reader.List(context.Background(), list, client.InNamespace(namespace.GetName())); err != nil {
+			klog.Errorf("Failed to list the objects in namespace %s -> %s", namespace.GetName(), err)
+			return nil
+		}
+
+		var requests []reconcile.Request
+		err := meta.EachListItem(list, func(item runtime.Object) error {
+			accessor, err := meta.Accessor(item)
+			if err != nil {
+				return err
+			}
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: accessor.GetNamespace(), Name: accessor.GetName()},
+			})
+			return nil
+		})
+		if err != nil {
+			klog.Errorf("Failed to enqueue the objects in namespace %s -> %s", namespace.GetName(), err)
+			return nil
+		}
+
+		klog.Infof("Namespace %s matches the selector, enqueued %d objects", namespace.GetName(), len(requests))
+		return requests
+	}
+}
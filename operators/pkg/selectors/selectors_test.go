@@ -0,0 +1,107 @@
+package selectors
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func forgeNamespace(name string, labels map[string]string) *corev1.Namespace {
+	return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+}
+
+func forgeConfigMap(name, namespace string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+}
+
+func TestParseLabelSelector(t *testing.T) {
+	ls, err := ParseLabelSelector("production=true&crownlabs.polito.it/type in (tenant,workspace)")
+	assert.Nil(t, err)
+	assert.Equal(t, ls.MatchLabels, map[string]string{"production": "true"})
+	assert.Equal(t, len(ls.MatchExpressions), 1)
+	assert.Equal(t, ls.MatchExpressions[0].Key, "crownlabs.polito.it/type")
+	assert.Equal(t, ls.MatchExpressions[0].Operator, metav1.LabelSelectorOpIn)
+	assert.ElementsMatch(t, ls.MatchExpressions[0].Values, []string{"tenant", "workspace"})
+
+	_, err = ParseLabelSelector("production==true==false")
+	assert.NotNil(t, err)
+}
+
+func TestNamespaceSelectorMatches(t *testing.T) {
+	reader := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(
+		forgeNamespace("production", map[string]string{"production": "true", "tier": "gold"}),
+		forgeNamespace("staging", map[string]string{"production": "true", "tier": "bronze"}),
+		forgeNamespace("development", map[string]string{"production": "false"}),
+	).Build()
+
+	nsSelector, err := NewNamespaceSelector(reader, &metav1.LabelSelector{
+		MatchLabels: map[string]string{"production": "true"},
+		MatchExpressions: []metav1.LabelSelectorRequirement{
+			{Key: "tier", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"bronze"}},
+		},
+	})
+	assert.Nil(t, err)
+
+	match, err := nsSelector.Matches(context.Background(), "production")
+	assert.Nil(t, err)
+	assert.True(t, match)
+
+	match, err = nsSelector.Matches(context.Background(), "staging")
+	assert.Nil(t, err)
+	assert.False(t, match)
+
+	match, err = nsSelector.Matches(context.Background(), "development")
+	assert.Nil(t, err)
+	assert.False(t, match)
+
+	_, err = nsSelector.Matches(context.Background(), "not-existing")
+	assert.NotNil(t, err)
+
+	pred := nsSelector.Predicate()
+	assert.True(t, pred.Create(event.CreateEvent{Object: forgeConfigMap("config", "production")}))
+	assert.False(t, pred.Create(event.CreateEvent{Object: forgeConfigMap("config", "staging")}))
+	// The events are let through if the namespace cannot be retrieved, and the check is deferred to the reconciliation.
+	assert.True(t, pred.Create(event.CreateEvent{Object: forgeConfigMap("config", "not-existing")}))
+}
+
+func TestNamespaceSelectorInvalid(t *testing.T) {
+	_, err := NewNamespaceSelector(fake.NewClientBuilder().Build(), &metav1.LabelSelector{
+		MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "tier", Operator: "invalid"}},
+	})
+	assert.NotNil(t, err)
+}
+
+func TestNamespaceSelectorRequeue(t *testing.T) {
+	reader := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(
+		forgeNamespace("production", map[string]string{"production": "true"}),
+		forgeConfigMap("first", "production"),
+		forgeConfigMap("second", "production"),
+		forgeConfigMap("other", "development"),
+	).Build()
+
+	nsSelector, err := NewNamespaceSelector(reader, &metav1.LabelSelector{MatchLabels: map[string]string{"production": "true"}})
+	assert.Nil(t, err)
+
+	pred := nsSelector.labelsChangedPredicate()
+	oldNs := forgeNamespace("production", map[string]string{"production": "false"})
+	newNs := forgeNamespace("production", map[string]string{"production": "true"})
+	assert.True(t, pred.Update(event.UpdateEvent{ObjectOld: oldNs, ObjectNew: newNs}))
+	assert.False(t, pred.Update(event.UpdateEvent{ObjectOld: newNs, ObjectNew: oldNs}))
+	assert.False(t, pred.Update(event.UpdateEvent{ObjectOld: newNs, ObjectNew: newNs.DeepCopy()}))
+	assert.True(t, pred.Create(event.CreateEvent{Object: newNs}))
+	assert.False(t, pred.Create(event.CreateEvent{Object: oldNs}))
+
+	requests := nsSelector.mapNamespaceObjects(func() client.ObjectList { return &corev1.ConfigMapList{} })(newNs)
+	assert.Equal(t, len(requests), 2)
+	for _, request := range requests {
+		assert.Equal(t, request.Namespace, "production")
+		assert.Contains(t, []string{"first", "second"}, request.Name)
+	}
+}
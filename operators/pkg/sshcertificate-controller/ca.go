@@ -0,0 +1,85 @@
+package sshcertificate_controller
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// CertificateAuthority issues the SSH user certificates of the tenants.
+type CertificateAuthority struct {
+	signer ssh.Signer
+}
+
+// NewCertificateAuthority returns a new CertificateAuthority signing the certificates with the given signer.
+func NewCertificateAuthority(signer ssh.Signer) *CertificateAuthority {
+	// Certificates signed with SHA1 (i.e. the default for RSA keys) are rejected by recent versions of sshd.
+	if algorithmSigner, ok := signer.(ssh.AlgorithmSigner); ok && signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+		signer = &rsaSHA512Signer{AlgorithmSigner: algorithmSigner}
+	}
+	return &CertificateAuthority{signer: signer}
+}
+
+// LoadCertificateAuthority reads the (unencrypted) private key of the certificate authority from the given
+// file, either in PEM or OpenSSH format, and returns the corresponding CertificateAuthority.
+func LoadCertificateAuthority(path string) (*CertificateAuthority, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error when reading the private key of the certificate authority -> %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("error when parsing the private key of the certificate authority -> %w", err)
+	}
+	return NewCertificateAuthority(signer), nil
+}
+
+// PublicKey returns the public key of the certificate authority, in the authorized_keys format.
+func (ca *CertificateAuthority) PublicKey() string {
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(ca.signer.PublicKey())))
+}
+
+// Sign issues a new user certificate for the given public key, valid for the given principals in the given time interval.
+func (ca *CertificateAuthority) Sign(key ssh.PublicKey, keyID string, principals []string, validAfter, validBefore time.Time) (*ssh.Certificate, error) {
+	var serial [8]byte
+	if _, err := io.ReadFull(rand.Reader, serial[:]); err != nil {
+		return nil, fmt.Errorf("error when generating the serial of the certificate -> %w", err)
+	}
+
+	cert := &ssh.Certificate{
+		Key:             key,
+		Serial:          binary.BigEndian.Uint64(serial[:]),
+		CertType:        ssh.UserCert,
+		KeyId:           keyID,
+		ValidPrincipals: principals,
+		ValidAfter:      uint64(validAfter.Unix()),
+		ValidBefore:     uint64(validBefore.Unix()),
+		Permissions: ssh.Permissions{
+			Extensions: map[string]string{
+				"permit-pty":             "",
+				"permit-port-forwarding": "",
+				"permit-user-rc":         "",
+			},
+		},
+	}
+	if err := cert.SignCert(rand.Reader, ca.signer); err != nil {
+		return nil, fmt.Errorf("error when signing the certificate -> %w", err)
+	}
+	return cert, nil
+}
+
+// rsaSHA512Signer is a signer for RSA keys using the rsa-sha2-512 signature algorithm.
+type rsaSHA512Signer struct {
+	ssh.AlgorithmSigner
+}
+
+// Sign signs the data with the rsa-sha2-512 algorithm.
+func (s *rsaSHA512Signer) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	return s.SignWithAlgorithm(rand, data, ssh.SigAlgoRSASHA2512)
+}
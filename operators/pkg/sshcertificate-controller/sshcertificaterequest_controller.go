@@ -0,0 +1,243 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package sshcertificate_controller groups the functionalities related to the issuing of the SSH certificates of the tenants.
+package sshcertificate_controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	crownlabsv1alpha2 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha2"
+	instance_creation "github.com/netgroup-polito/CrownLabs/operators/pkg/instance-creation"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/selectors"
+)
+
+// clockSkewTolerance is the amount of time the certificates are backdated, to tolerate clock differences with the VMs.
+const clockSkewTolerance = 5 * time.Minute
+
+// SSHCertificateRequestReconciler reconciles a SSHCertificateRequest object.
+type SSHCertificateRequestReconciler struct {
+	client.Client
+	Scheme             *runtime.Scheme
+	EventsRecorder     record.EventRecorder
+	NamespaceWhitelist metav1.LabelSelector
+	// CA is the certificate authority issuing the certificates.
+	CA *CertificateAuthority
+	// DefaultValidity is the validity of the certificates, if not specified in the request.
+	DefaultValidity time.Duration
+	// MaxValidity is the maximum validity of the certificates.
+	MaxValidity time.Duration
+
+	// This function, if configured, is deferred at the beginning of the Reconcile.
+	// Specifically, it is meant to be set to GinkgoRecover during the tests,
+	// in order to lead to a controlled failure in case the Reconcile panics.
+	ReconcileDeferHook func()
+
+	// nsSelector is derived from NamespaceWhitelist by SetupWithManager, and checked again by Reconcile.
+	nsSelector *selectors.NamespaceSelector
+}
+
+// Reconcile issues the certificate requested by the SSHCertificateRequest, and marks it as expired once no longer valid.
+func (r *SSHCertificateRequestReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	if r.ReconcileDeferHook != nil {
+		defer r.ReconcileDeferHook()
+	}
+
+	sshcr := &crownlabsv1alpha2.SSHCertificateRequest{}
+	if err := r.Get(ctx, req.NamespacedName, sshcr); client.IgnoreNotFound(err) != nil {
+		klog.Errorf("Error when getting SSHCertificateRequest %s before starting reconcile -> %s", req.NamespacedName, err)
+		return ctrl.Result{}, err
+	} else if err != nil {
+		klog.Infof("SSHCertificateRequest %s already deleted", req.NamespacedName)
+		return ctrl.Result{}, nil
+	}
+
+	// Check the selector label, in order to know whether to perform or not reconciliation.
+	if proceed, err := r.nsSelector.Matches(ctx, sshcr.Namespace); !proceed {
+		// If there was an error while checking, show the error and try again.
+		if err != nil {
+			klog.Error(err)
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	switch sshcr.Status.Phase {
+	case crownlabsv1alpha2.SSHCertificateFailed, crownlabsv1alpha2.SSHCertificateExpired:
+		return ctrl.Result{}, nil
+	case crownlabsv1alpha2.SSHCertificateIssued:
+		return r.checkExpiration(ctx, sshcr)
+	}
+
+	klog.Infof("Issuing the certificate requested by SSHCertificateRequest %s", req.NamespacedName)
+	if retry, err := r.IssueCertificate(ctx, sshcr); err != nil {
+		klog.Error(err)
+		if retry {
+			return ctrl.Result{}, err
+		}
+
+		// The request is not valid, hence it is marked as failed.
+		r.EventsRecorder.Event(sshcr, "Warning", "ValidationError", err.Error())
+		sshcr.Status.Phase = crownlabsv1alpha2.SSHCertificateFailed
+		sshcr.Status.FailureReason = err.Error()
+		if err1 := r.Status().Update(ctx, sshcr); err1 != nil {
+			klog.Errorf("Error when updating the status of SSHCertificateRequest %s -> %s", req.NamespacedName, err1)
+			return ctrl.Result{}, err1
+		}
+		return ctrl.Result{}, nil
+	}
+
+	r.EventsRecorder.Event(sshcr, "Normal", "Issued", fmt.Sprintf("Certificate %s issued, valid until %s",
+		sshcr.Status.KeyID, sshcr.Status.ValidBefore.Format(time.RFC3339)))
+	return ctrl.Result{RequeueAfter: time.Until(sshcr.Status.ValidBefore.Time)}, nil
+}
+
+// IssueCertificate validates the SSHCertificateRequest, issues the certificate and stores it in the status.
+// In case of errors, it returns whether the issuing should be retried, or the request is not valid.
+func (r *SSHCertificateRequestReconciler) IssueCertificate(ctx context.Context, sshcr *crownlabsv1alpha2.SSHCertificateRequest) (retry bool, err error) {
+	tenant := crownlabsv1alpha1.Tenant{}
+	if err := r.Get(ctx, types.NamespacedName{Name: sshcr.Spec.Tenant.Name}, &tenant); client.IgnoreNotFound(err) != nil {
+		return true, fmt.Errorf("error when retrieving the tenant %s -> %w", sshcr.Spec.Tenant.Name, err)
+	} else if err != nil {
+		return false, fmt.Errorf("the tenant %s does not exist", sshcr.Spec.Tenant.Name)
+	}
+
+	// The request shall be created in the personal namespace of the tenant, which is accessible only by the tenant itself.
+	if tenant.Status.PersonalNamespace.Name != sshcr.Namespace {
+		return false, fmt.Errorf("the certificate for tenant %s can only be requested in its personal namespace", tenant.Name)
+	}
+
+	key, err := ParsePublicKey(sshcr.Spec.PublicKey)
+	if err != nil {
+		return false, err
+	}
+
+	validity := r.DefaultValidity
+	if sshcr.Spec.Validity != nil {
+		validity = sshcr.Spec.Validity.Duration
+	}
+	if validity <= 0 {
+		return false, fmt.Errorf("invalid validity %s: it shall be positive", validity)
+	}
+	if r.MaxValidity > 0 && validity > r.MaxValidity {
+		validity = r.MaxValidity
+	}
+
+	now := time.Now()
+	validAfter, validBefore := now.Add(-clockSkewTolerance), now.Add(validity)
+	keyID := fmt.Sprintf("%s@%s/%s", tenant.Name, sshcr.Namespace, sshcr.Name)
+	principals := TenantPrincipals(&tenant)
+
+	cert, err := r.CA.Sign(key, keyID, principals, validAfter, validBefore)
+	if err != nil {
+		return true, err
+	}
+
+	sshcr.Status.Phase = crownlabsv1alpha2.SSHCertificateIssued
+	sshcr.Status.Certificate = strings.TrimSpace(string(ssh.MarshalAuthorizedKey(cert))) + " " + keyID
+	sshcr.Status.KeyID = keyID
+	sshcr.Status.Principals = principals
+	sshcr.Status.ValidAfter = &metav1.Time{Time: time.Unix(int64(cert.ValidAfter), 0)}
+	sshcr.Status.ValidBefore = &metav1.Time{Time: time.Unix(int64(cert.ValidBefore), 0)}
+	sshcr.Status.FailureReason = ""
+	if err := r.Status().Update(ctx, sshcr); err != nil {
+		return true, fmt.Errorf("error when updating the status of SSHCertificateRequest %s/%s -> %w", sshcr.Namespace, sshcr.Name, err)
+	}
+	return false, nil
+}
+
+// checkExpiration marks the SSHCertificateRequest as expired once the certificate is no longer valid.
+func (r *SSHCertificateRequestReconciler) checkExpiration(ctx context.Context, sshcr *crownlabsv1alpha2.SSHCertificateRequest) (ctrl.Result, error) {
+	if sshcr.Status.ValidBefore != nil {
+		if remaining := time.Until(sshcr.Status.ValidBefore.Time); remaining > 0 {
+			return ctrl.Result{RequeueAfter: remaining}, nil
+		}
+	}
+
+	sshcr.Status.Phase = crownlabsv1alpha2.SSHCertificateExpired
+	if err := r.Status().Update(ctx, sshcr); err != nil {
+		klog.Errorf("Error when updating the status of SSHCertificateRequest %s/%s -> %s", sshcr.Namespace, sshcr.Name, err)
+		return ctrl.Result{}, err
+	}
+	r.EventsRecorder.Event(sshcr, "Normal", "Expired", fmt.Sprintf("Certificate %s expired", sshcr.Status.KeyID))
+	return ctrl.Result{}, nil
+}
+
+// ParsePublicKey parses a public key in the authorized_keys format, rejecting certificates.
+func ParsePublicKey(publicKey string) (ssh.PublicKey, error) {
+	if strings.ContainsAny(publicKey, "\r\n") {
+		return nil, errors.New("invalid public key: multiple lines are not allowed")
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(publicKey))
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	if _, ok := key.(*ssh.Certificate); ok {
+		return nil, errors.New("invalid public key: certificates are not allowed")
+	}
+	return key, nil
+}
+
+// TenantPrincipals returns the principals of the certificates issued to the given tenant, i.e. the one
+// of the tenant itself, and the ones of the workspaces the tenant is manager of (sorted by name).
+func TenantPrincipals(tenant *crownlabsv1alpha1.Tenant) []string {
+	var workspaces []string
+	for i := range tenant.Spec.Workspaces {
+		if tenant.Spec.Workspaces[i].Role == crownlabsv1alpha1.Manager {
+			workspaces = append(workspaces, tenant.Spec.Workspaces[i].WorkspaceRef.Name)
+		}
+	}
+	sort.Strings(workspaces)
+
+	principals := []string{instance_creation.TenantPrincipal(tenant.Name)}
+	for _, workspace := range workspaces {
+		principals = append(principals, instance_creation.WorkspaceManagerPrincipal(workspace))
+	}
+	return principals
+}
+
+// SetupWithManager registers a new controller for SSHCertificateRequest resources.
+func (r *SSHCertificateRequestReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	// Only the requests in the namespaces satisfying the whitelist are reconciled.
+	nsSelector, err := selectors.NewNamespaceSelector(mgr.GetClient(), &r.NamespaceWhitelist)
+	if err != nil {
+		return err
+	}
+	r.nsSelector = nsSelector
+
+	blder := ctrl.NewControllerManagedBy(mgr).
+		// The generation changed predicate allow to avoid updates on the status changes of the SSHCertificateRequest
+		For(&crownlabsv1alpha2.SSHCertificateRequest{}, builder.WithPredicates(predicate.GenerationChangedPredicate{}, nsSelector.Predicate()))
+	return nsSelector.Watch(blder, func() client.ObjectList { return &crownlabsv1alpha2.SSHCertificateRequestList{} }).
+		Complete(r)
+}
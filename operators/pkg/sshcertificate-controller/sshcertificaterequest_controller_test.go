@@ -0,0 +1,174 @@
+package sshcertificate_controller_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"golang.org/x/crypto/ssh"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	crownlabsv1alpha2 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha2"
+)
+
+var _ = Describe("SSHCertificateRequest controller", func() {
+	const (
+		TenantName        = "s12345"
+		PersonalNamespace = "tenant-s12345"
+		OtherNamespace    = "tenant-other"
+
+		timeout  = time.Second * 10
+		interval = time.Millisecond * 250
+	)
+
+	var (
+		ctx       = context.Background()
+		publicKey string
+	)
+
+	// newRequest returns a new SSHCertificateRequest for the tenant, in the given namespace.
+	newRequest := func(namespace string, publicKey string, validity *metav1.Duration) *crownlabsv1alpha2.SSHCertificateRequest {
+		return &crownlabsv1alpha2.SSHCertificateRequest{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      fmt.Sprintf("sshcr-%d", time.Now().UnixNano()),
+				Namespace: namespace,
+			},
+			Spec: crownlabsv1alpha2.SSHCertificateRequestSpec{
+				Tenant:    crownlabsv1alpha2.GenericRef{Name: TenantName},
+				PublicKey: publicKey,
+				Validity:  validity,
+			},
+		}
+	}
+
+	// waitForPhase waits for the SSHCertificateRequest to reach the given phase, and returns it.
+	waitForPhase := func(sshcr *crownlabsv1alpha2.SSHCertificateRequest, phase crownlabsv1alpha2.SSHCertificateRequestPhase) *crownlabsv1alpha2.SSHCertificateRequest {
+		updated := &crownlabsv1alpha2.SSHCertificateRequest{}
+		Eventually(func() crownlabsv1alpha2.SSHCertificateRequestPhase {
+			if err := k8sClient.Get(ctx, types.NamespacedName{Name: sshcr.Name, Namespace: sshcr.Namespace}, updated); err != nil {
+				return ""
+			}
+			return updated.Status.Phase
+		}, timeout, interval).Should(Equal(phase))
+		return updated
+	}
+
+	// parseCertificate parses the certificate issued in the status of the SSHCertificateRequest.
+	parseCertificate := func(sshcr *crownlabsv1alpha2.SSHCertificateRequest) *ssh.Certificate {
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(sshcr.Status.Certificate))
+		Expect(err).ToNot(HaveOccurred())
+		cert, ok := key.(*ssh.Certificate)
+		Expect(ok).To(BeTrue())
+		return cert
+	}
+
+	BeforeEach(func() {
+		pub, _, err := ed25519.GenerateKey(rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+		sshPub, err := ssh.NewPublicKey(pub)
+		Expect(err).ToNot(HaveOccurred())
+		publicKey = strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPub))) + " user@laptop"
+
+		for _, name := range []string{PersonalNamespace, OtherNamespace} {
+			ns := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"test-suite": "true"}}}
+			if err := k8sClient.Get(ctx, types.NamespacedName{Name: name}, &v1.Namespace{}); err != nil {
+				Expect(k8sClient.Create(ctx, ns)).Should(Succeed())
+			}
+		}
+
+		tenant := &crownlabsv1alpha1.Tenant{}
+		if err := k8sClient.Get(ctx, types.NamespacedName{Name: TenantName}, tenant); err != nil {
+			tenant = &crownlabsv1alpha1.Tenant{
+				ObjectMeta: metav1.ObjectMeta{Name: TenantName},
+				Spec: crownlabsv1alpha1.TenantSpec{
+					FirstName: "Mario",
+					LastName:  "Rossi",
+					Email:     "mario.rossi@fakemail.com",
+					Workspaces: []crownlabsv1alpha1.TenantWorkspaceEntry{
+						{WorkspaceRef: crownlabsv1alpha1.GenericRef{Name: "ws-user"}, Role: crownlabsv1alpha1.User},
+						{WorkspaceRef: crownlabsv1alpha1.GenericRef{Name: "ws-manager"}, Role: crownlabsv1alpha1.Manager},
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, tenant)).Should(Succeed())
+
+			tenant.Status.PersonalNamespace = crownlabsv1alpha1.NameCreated{Name: PersonalNamespace, Created: true}
+			Expect(k8sClient.Status().Update(ctx, tenant)).Should(Succeed())
+		}
+	})
+
+	Context("Requesting a certificate in the personal namespace of the tenant", func() {
+		It("Should issue a certificate signed by the certificate authority", func() {
+			sshcr := newRequest(PersonalNamespace, publicKey, nil)
+			Expect(k8sClient.Create(ctx, sshcr)).Should(Succeed())
+			issued := waitForPhase(sshcr, crownlabsv1alpha2.SSHCertificateIssued)
+
+			By("Checking the principals of the certificate")
+			expectedPrincipals := []string{"tenant-" + TenantName, "manager-ws-manager"}
+			Expect(issued.Status.Principals).To(Equal(expectedPrincipals))
+
+			cert := parseCertificate(issued)
+			Expect(cert.CertType).To(BeEquivalentTo(ssh.UserCert))
+			Expect(cert.ValidPrincipals).To(Equal(expectedPrincipals))
+			Expect(cert.KeyId).To(Equal(issued.Status.KeyID))
+
+			By("Checking the certified key")
+			requested, _, _, _, err := ssh.ParseAuthorizedKey([]byte(publicKey))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cert.Key.Marshal()).To(Equal(requested.Marshal()))
+
+			By("Checking the certificate is accepted by a host trusting the certificate authority")
+			checker := ssh.CertChecker{IsUserAuthority: func(auth ssh.PublicKey) bool {
+				return string(auth.Marshal()) == string(caPublicKey.Marshal())
+			}}
+			Expect(checker.CheckCert("tenant-"+TenantName, cert)).To(Succeed())
+			Expect(checker.CheckCert("tenant-other", cert)).ToNot(Succeed())
+
+			By("Checking the validity of the certificate")
+			Expect(issued.Status.ValidBefore.Sub(time.Now())).To(BeNumerically("~", defaultValidity, time.Minute))
+		})
+
+		It("Should limit the validity of the certificate to the maximum one", func() {
+			sshcr := newRequest(PersonalNamespace, publicKey, &metav1.Duration{Duration: 10 * maxValidity})
+			Expect(k8sClient.Create(ctx, sshcr)).Should(Succeed())
+			issued := waitForPhase(sshcr, crownlabsv1alpha2.SSHCertificateIssued)
+
+			Expect(issued.Status.ValidBefore.Sub(time.Now())).To(BeNumerically("~", maxValidity, time.Minute))
+		})
+
+		It("Should mark the request as expired once the certificate is no longer valid", func() {
+			sshcr := newRequest(PersonalNamespace, publicKey, &metav1.Duration{Duration: 2 * time.Second})
+			Expect(k8sClient.Create(ctx, sshcr)).Should(Succeed())
+			waitForPhase(sshcr, crownlabsv1alpha2.SSHCertificateIssued)
+			waitForPhase(sshcr, crownlabsv1alpha2.SSHCertificateExpired)
+		})
+	})
+
+	Context("Requesting an invalid certificate", func() {
+		It("Should reject the request in a namespace different from the personal one", func() {
+			sshcr := newRequest(OtherNamespace, publicKey, nil)
+			Expect(k8sClient.Create(ctx, sshcr)).Should(Succeed())
+			failed := waitForPhase(sshcr, crownlabsv1alpha2.SSHCertificateFailed)
+
+			Expect(failed.Status.Certificate).To(BeEmpty())
+			Expect(failed.Status.FailureReason).To(ContainSubstring("personal namespace"))
+		})
+
+		It("Should reject a malformed public key", func() {
+			sshcr := newRequest(PersonalNamespace, "ssh-ed25519 invalid-key", nil)
+			Expect(k8sClient.Create(ctx, sshcr)).Should(Succeed())
+			failed := waitForPhase(sshcr, crownlabsv1alpha2.SSHCertificateFailed)
+
+			Expect(failed.Status.Certificate).To(BeEmpty())
+			Expect(failed.Status.FailureReason).To(ContainSubstring("invalid public key"))
+		})
+	})
+})
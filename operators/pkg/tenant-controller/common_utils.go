@@ -5,9 +5,7 @@ import (
 	"fmt"
 	"math/big"
 
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/klog/v2"
-	"sigs.k8s.io/controller-runtime/pkg/predicate"
 )
 
 func randomRange(min, max int) (*int, error) {
@@ -41,18 +39,3 @@ func generateToken() (*string, error) {
 	token := fmt.Sprintf("%x", b)
 	return &token, nil
 }
-
-func labelSelectorPredicate(targetLabelKey, targetLabelValue string) predicate.Predicate {
-	labelSelector := metav1.LabelSelector{
-		MatchLabels: map[string]string{
-			targetLabelKey: targetLabelValue,
-		},
-	}
-
-	lsPred, err := predicate.LabelSelectorPredicate(labelSelector)
-	if err != nil {
-		klog.Fatalf("Failed to construct the label selector predicate: %v", err)
-	}
-
-	return lsPred
-}
@@ -12,9 +12,21 @@ var (
 	},
 		[]string{"controller", "reason"},
 	)
+	tnOpOrphanResources = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "tenant_operator_orphan_resources",
+		Help: "The number of orphan resources in the external services detected during the last collection",
+	},
+		[]string{"service", "kind"},
+	)
+	tnOpOrphanDeletions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tenant_operator_orphan_deletions",
+		Help: "The number of deletions of orphan resources in the external services performed by the tenant operator",
+	},
+		[]string{"service", "kind", "outcome"},
+	)
 )
 
 func init() {
 	// Register custom metrics with the global prometheus registry
-	metrics.Registry.MustRegister(tnOpinternalErrors)
+	metrics.Registry.MustRegister(tnOpinternalErrors, tnOpOrphanResources, tnOpOrphanDeletions)
 }
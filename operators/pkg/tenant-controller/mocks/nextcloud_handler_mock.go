@@ -2,6 +2,10 @@ package mocks
 
 // NcHandlerMock mocks NcHandler for testing nextcloud features.
 type NcHandlerMock struct {
+	// The usernames returned by ListUsers.
+	Users []string
+	// The usernames DeleteUser has been invoked with.
+	DeletedUsers []string
 }
 
 // GetUser mocks GetUser by implementing only one case, the user already exists, with displayname and there are no errors.
@@ -16,5 +20,11 @@ func (mNcA *NcHandlerMock) CreateUser(ncUsername, ncPsw, displayname string) err
 // UpdateUserData mocks UpdateUserData by implementing only one case, the creation is successful.
 func (mNcA *NcHandlerMock) UpdateUserData(username, param, value string) error { return nil }
 
-// DeleteUser mocks DeleteUser by implementing only one case, the creation is successful.
-func (mNcA *NcHandlerMock) DeleteUser(username string) error { return nil }
+// DeleteUser mocks DeleteUser by recording the deleted username, the deletion is successful.
+func (mNcA *NcHandlerMock) DeleteUser(username string) error {
+	mNcA.DeletedUsers = append(mNcA.DeletedUsers, username)
+	return nil
+}
+
+// ListUsers mocks ListUsers by returning the usernames configured in the mock.
+func (mNcA *NcHandlerMock) ListUsers(search string) ([]string, error) { return mNcA.Users, nil }
@@ -15,6 +15,7 @@ type NcHandler interface {
 	CreateUser(ncUsername, ncPsw, displayname string) error
 	UpdateUserData(username, param, value string) error
 	DeleteUser(username string) error
+	ListUsers(search string) ([]string, error)
 }
 
 // NcActor holds the info and methods to interact with nextcloud.
@@ -136,6 +137,48 @@ func (ncA *NcActor) DeleteUser(username string) error {
 	return nil
 }
 
+// ListUsers returns the usernames of the nextcloud users matching the given search string.
+func (ncA *NcActor) ListUsers(search string) ([]string, error) {
+	usersURL := ncA.buildOCSEndpoint("/users")
+	res, err := ncA.Client.R().SetBasicAuth(ncA.TnOpUser, ncA.TnOpPsw).SetHeaders(ncHeaders).SetQueryParam("search", search).Get(usersURL)
+	if err != nil {
+		klog.Errorf("Error during GET request when listing users in nextcloud -> %s", err)
+		return nil, err
+	}
+
+	statusCode, message, err := parseOCSResponseMeta(res.Body())
+	if err != nil {
+		klog.Errorf("Error when parsing meta of nextcloud response of GET request to list users -> %s", err)
+		return nil, err
+	}
+	if *statusCode != 100 {
+		klog.Errorf("Error when listing nextcloud users -> statusCode: %d, message: %s", *statusCode, *message)
+		return nil, errors.New(*message)
+	}
+
+	ocsJSON, err := extractOCSResponse(res.Body())
+	if err != nil {
+		return nil, err
+	}
+	dataJSON, ok := ocsJSON["data"].(map[string]interface{})
+	if !ok {
+		klog.Errorf("Error when parsing data of nextcloud response of GET request to list users -> unexpected format")
+		return nil, errors.New("unexpected format of the nextcloud users list")
+	}
+	usersJSON, ok := dataJSON["users"].([]interface{})
+	if !ok {
+		klog.Errorf("Error when parsing users of nextcloud response of GET request to list users -> unexpected format")
+		return nil, errors.New("unexpected format of the nextcloud users list")
+	}
+	users := make([]string, 0, len(usersJSON))
+	for _, user := range usersJSON {
+		if username, ok := user.(string); ok {
+			users = append(users, username)
+		}
+	}
+	return users, nil
+}
+
 func parseOCSResponseMeta(respBody []byte) (parsedStatusCode *int, parsedMessage *string, err error) {
 	ocsJSON, err := extractOCSResponse(respBody)
 	if err != nil {
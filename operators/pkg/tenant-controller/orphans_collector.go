@@ -0,0 +1,266 @@
+package tenant_controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	gocloak "github.com/Nerzal/gocloak/v7"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+)
+
+// OrphansPolicy is an enumeration of the different behaviors of the OrphansCollector
+// when it detects external resources no longer associated with any Tenant or Workspace.
+type OrphansPolicy string
+
+const (
+	// OrphansPolicyDisabled -> the orphans collector is not started.
+	OrphansPolicyDisabled OrphansPolicy = "disabled"
+	// OrphansPolicyReport -> the orphan resources are only reported (logs and metrics).
+	OrphansPolicyReport OrphansPolicy = "report"
+	// OrphansPolicyDelete -> the orphan resources are reported and deleted.
+	OrphansPolicyDelete OrphansPolicy = "delete"
+)
+
+// ncUsernamePrefix is the prefix of the nextcloud users created by the tenant operator.
+const ncUsernamePrefix = "keycloak-"
+
+// kcPageSize is the maximum number of users retrieved from keycloak with a single request.
+const kcPageSize = 100
+
+// ParseOrphansPolicy converts a string into the corresponding OrphansPolicy.
+func ParseOrphansPolicy(policy string) (OrphansPolicy, error) {
+	switch OrphansPolicy(policy) {
+	case OrphansPolicyDisabled, OrphansPolicyReport, OrphansPolicyDelete:
+		return OrphansPolicy(policy), nil
+	default:
+		return "", fmt.Errorf("invalid orphans policy %s", policy)
+	}
+}
+
+// OrphansCollector periodically looks for the keycloak users, the keycloak workspace roles and
+// the nextcloud users which are no longer associated with an existing Tenant or Workspace
+// (e.g. because the corresponding object has been force-deleted without running the finalizer),
+// and reports or deletes them according to the configured policy.
+type OrphansCollector struct {
+	// The reader used to retrieve Tenants and Workspaces. It should not be backed by a cache,
+	// to prevent recently created resources from being considered as orphans.
+	Reader   client.Reader
+	KcA      *KcActor
+	NcA      NcHandler
+	Policy   OrphansPolicy
+	Interval time.Duration
+}
+
+// orphanResources groups the external resources detected as orphans.
+type orphanResources struct {
+	// keycloak users, map from ID to username
+	kcUsers map[string]string
+	kcRoles []string
+	ncUsers []string
+}
+
+// Start implements the manager.Runnable interface, and performs a collection every Interval until the context is canceled.
+func (oc *OrphansCollector) Start(ctx context.Context) error {
+	klog.Infof("Starting orphans collector with policy %s, every %s", oc.Policy, oc.Interval)
+	ticker := time.NewTicker(oc.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := oc.Collect(ctx); err != nil {
+			klog.Errorf("Error when collecting orphan resources -> %s", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			klog.Info("Stopping orphans collector")
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// NeedLeaderElection implements the manager.LeaderElectionRunnable interface, to prevent concurrent collections.
+func (oc *OrphansCollector) NeedLeaderElection() bool {
+	return true
+}
+
+// Collect performs a single detection of the orphan resources, and deletes them if the policy requires it.
+func (oc *OrphansCollector) Collect(ctx context.Context) error {
+	// The external resources are retrieved before the cluster ones: since the tenant operator creates the
+	// former only after the latter exist, this prevents resources being created from being considered as orphans.
+	roles, err := oc.listKcWorkspaceRoles(ctx)
+	if err != nil {
+		tnOpinternalErrors.WithLabelValues("orphans-collector", "keycloak").Inc()
+		return err
+	}
+	roleUsers, err := oc.listKcRolesUsers(ctx, roles)
+	if err != nil {
+		tnOpinternalErrors.WithLabelValues("orphans-collector", "keycloak").Inc()
+		return err
+	}
+	ncUsers, err := oc.NcA.ListUsers(ncUsernamePrefix)
+	if err != nil {
+		tnOpinternalErrors.WithLabelValues("orphans-collector", "nextcloud").Inc()
+		return err
+	}
+
+	var tenants crownlabsv1alpha1.TenantList
+	if err = oc.Reader.List(ctx, &tenants); err != nil {
+		klog.Errorf("Error when listing tenants for orphans collection -> %s", err)
+		tnOpinternalErrors.WithLabelValues("orphans-collector", "cluster-resources").Inc()
+		return err
+	}
+	var workspaces crownlabsv1alpha1.WorkspaceList
+	if err = oc.Reader.List(ctx, &workspaces); err != nil {
+		klog.Errorf("Error when listing workspaces for orphans collection -> %s", err)
+		tnOpinternalErrors.WithLabelValues("orphans-collector", "cluster-resources").Inc()
+		return err
+	}
+
+	orphans := findOrphans(&tenants, &workspaces, roles, roleUsers, ncUsers)
+	tnOpOrphanResources.WithLabelValues("keycloak", "user").Set(float64(len(orphans.kcUsers)))
+	tnOpOrphanResources.WithLabelValues("keycloak", "role").Set(float64(len(orphans.kcRoles)))
+	tnOpOrphanResources.WithLabelValues("nextcloud", "user").Set(float64(len(orphans.ncUsers)))
+	klog.Infof("Orphans collection completed: %d keycloak users, %d keycloak roles, %d nextcloud users detected",
+		len(orphans.kcUsers), len(orphans.kcRoles), len(orphans.ncUsers))
+
+	return oc.handleOrphans(ctx, &orphans)
+}
+
+// handleOrphans reports and possibly deletes the given orphan resources using a fail-fast:false strategy.
+func (oc *OrphansCollector) handleOrphans(ctx context.Context, orphans *orphanResources) error {
+	var retErr error
+	for userID, username := range orphans.kcUsers {
+		if oc.Policy != OrphansPolicyDelete {
+			auditOrphan("detected", "keycloak", "user", username)
+			continue
+		}
+		if err := oc.KcA.Client.DeleteUser(ctx, oc.KcA.GetAccessToken(), oc.KcA.TargetRealm, userID); err != nil {
+			klog.Errorf("Error when deleting orphan keycloak user %s -> %s", username, err)
+			auditOrphan("deletion-failed", "keycloak", "user", username)
+			tnOpOrphanDeletions.WithLabelValues("keycloak", "user", "failed").Inc()
+			retErr = err
+			continue
+		}
+		auditOrphan("deleted", "keycloak", "user", username)
+		tnOpOrphanDeletions.WithLabelValues("keycloak", "user", "succeeded").Inc()
+	}
+
+	for _, role := range orphans.kcRoles {
+		if oc.Policy != OrphansPolicyDelete {
+			auditOrphan("detected", "keycloak", "role", role)
+			continue
+		}
+		if err := oc.KcA.deleteKcRoles(ctx, map[string]string{role: ""}); err != nil {
+			auditOrphan("deletion-failed", "keycloak", "role", role)
+			tnOpOrphanDeletions.WithLabelValues("keycloak", "role", "failed").Inc()
+			retErr = err
+			continue
+		}
+		auditOrphan("deleted", "keycloak", "role", role)
+		tnOpOrphanDeletions.WithLabelValues("keycloak", "role", "succeeded").Inc()
+	}
+
+	for _, ncUsername := range orphans.ncUsers {
+		if oc.Policy != OrphansPolicyDelete {
+			auditOrphan("detected", "nextcloud", "user", ncUsername)
+			continue
+		}
+		if err := oc.NcA.DeleteUser(ncUsername); err != nil {
+			klog.Errorf("Error when deleting orphan nextcloud user %s -> %s", ncUsername, err)
+			auditOrphan("deletion-failed", "nextcloud", "user", ncUsername)
+			tnOpOrphanDeletions.WithLabelValues("nextcloud", "user", "failed").Inc()
+			retErr = err
+			continue
+		}
+		auditOrphan("deleted", "nextcloud", "user", ncUsername)
+		tnOpOrphanDeletions.WithLabelValues("nextcloud", "user", "succeeded").Inc()
+	}
+	return retErr
+}
+
+// listKcWorkspaceRoles returns the names of the keycloak client roles associated with workspaces.
+func (oc *OrphansCollector) listKcWorkspaceRoles(ctx context.Context) ([]string, error) {
+	roles, err := oc.KcA.Client.GetClientRoles(ctx, oc.KcA.GetAccessToken(), oc.KcA.TargetRealm, oc.KcA.TargetClientID)
+	if err != nil {
+		klog.Errorf("Error when listing keycloak client roles -> %s", err)
+		return nil, err
+	}
+
+	var wsRoles []string
+	for _, role := range roles {
+		if role.Name != nil && strings.HasPrefix(*role.Name, "workspace-") {
+			wsRoles = append(wsRoles, *role.Name)
+		}
+	}
+	return wsRoles, nil
+}
+
+// listKcRolesUsers returns the keycloak users (map from ID to username) having at least one of the given roles.
+// Only users associated with workspace roles are considered, since the realm may contain users not managed by the operator.
+func (oc *OrphansCollector) listKcRolesUsers(ctx context.Context, roles []string) (map[string]string, error) {
+	users := make(map[string]string)
+	for _, role := range roles {
+		for first := 0; ; first += kcPageSize {
+			firstIdx, maxCount := first, kcPageSize
+			page, err := oc.KcA.Client.GetUsersByClientRoleName(ctx, oc.KcA.GetAccessToken(), oc.KcA.TargetRealm, oc.KcA.TargetClientID,
+				role, gocloak.GetUsersByRoleParams{First: &firstIdx, Max: &maxCount})
+			if err != nil {
+				klog.Errorf("Error when listing keycloak users with role %s -> %s", role, err)
+				return nil, err
+			}
+			for _, user := range page {
+				if user.ID != nil && user.Username != nil {
+					users[*user.ID] = *user.Username
+				}
+			}
+			if len(page) < kcPageSize {
+				break
+			}
+		}
+	}
+	return users, nil
+}
+
+// findOrphans compares the external resources with the existing Tenants and Workspaces, returning the orphan ones.
+func findOrphans(tenants *crownlabsv1alpha1.TenantList, workspaces *crownlabsv1alpha1.WorkspaceList,
+	kcRoles []string, kcUsers map[string]string, ncUsers []string) orphanResources {
+	tenantNames := make(map[string]bool, len(tenants.Items))
+	for i := range tenants.Items {
+		tenantNames[tenants.Items[i].Name] = true
+	}
+	wsRoles := make(map[string]bool, 2*len(workspaces.Items))
+	for i := range workspaces.Items {
+		for role := range genWsKcRolesData(workspaces.Items[i].Name, "") {
+			wsRoles[role] = true
+		}
+	}
+
+	orphans := orphanResources{kcUsers: make(map[string]string)}
+	for userID, username := range kcUsers {
+		if !tenantNames[username] {
+			orphans.kcUsers[userID] = username
+		}
+	}
+	for _, role := range kcRoles {
+		if !wsRoles[role] {
+			orphans.kcRoles = append(orphans.kcRoles, role)
+		}
+	}
+	for _, ncUsername := range ncUsers {
+		if strings.HasPrefix(ncUsername, ncUsernamePrefix) && !tenantNames[strings.TrimPrefix(ncUsername, ncUsernamePrefix)] {
+			orphans.ncUsers = append(orphans.ncUsers, ncUsername)
+		}
+	}
+	return orphans
+}
+
+// auditOrphan records an action performed by the orphans collector in the audit log.
+func auditOrphan(action, service, kind, name string) {
+	klog.Infof("[orphans-collector audit] action=%s service=%s kind=%s name=%s", action, service, kind, name)
+}
@@ -0,0 +1,146 @@
+package tenant_controller
+
+import (
+	"context"
+	"time"
+
+	gocloak "github.com/Nerzal/gocloak/v7"
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/tenant-controller/mocks"
+)
+
+var _ = Describe("Orphans collector", func() {
+	var (
+		mockCtrl  *gomock.Controller
+		mGcClient *mocks.MockGoCloak
+		gcKcA     *KcActor
+		gcNcA     *mocks.NcHandlerMock
+		collector *OrphansCollector
+
+		wsName            = "gcws"
+		tnName            = "gctenant"
+		orphanTnName      = "gcorphan"
+		orphanUserID      = "orphanUserID"
+		existingUserID    = "existingUserID"
+		wsUserRole        = "workspace-gcws:user"
+		orphanRole        = "workspace-gcorphanws:user"
+		unrelatedRole     = "unrelated-role"
+		wsUserRoleName    = wsUserRole
+		orphanRoleName    = orphanRole
+		unrelatedRoleName = unrelatedRole
+	)
+
+	const (
+		timeout  = time.Second * 10
+		interval = time.Millisecond * 250
+	)
+
+	BeforeEach(func() {
+		ctx := context.Background()
+
+		mockCtrl = gomock.NewController(GinkgoT())
+		mGcClient = mocks.NewMockGoCloak(mockCtrl)
+		gcKcA = &KcActor{
+			Client:         mGcClient,
+			token:          mToken,
+			TargetRealm:    kcTargetRealm,
+			TargetClientID: kcTargetClientID,
+		}
+		gcNcA = &mocks.NcHandlerMock{Users: []string{genNcUsername(tnName), genNcUsername(orphanTnName)}}
+		collector = &OrphansCollector{
+			Reader:   k8sClient,
+			KcA:      gcKcA,
+			NcA:      gcNcA,
+			Interval: time.Hour,
+		}
+
+		mGcClient.EXPECT().GetClientRoles(
+			gomock.Any(),
+			gomock.Eq(kcAccessToken),
+			gomock.Eq(kcTargetRealm),
+			gomock.Eq(kcTargetClientID),
+		).Return([]*gocloak.Role{{Name: &wsUserRoleName}, {Name: &orphanRoleName}, {Name: &unrelatedRoleName}}, nil).AnyTimes()
+
+		mGcClient.EXPECT().GetUsersByClientRoleName(
+			gomock.Any(),
+			gomock.Eq(kcAccessToken),
+			gomock.Eq(kcTargetRealm),
+			gomock.Eq(kcTargetClientID),
+			gomock.Eq(wsUserRole),
+			gomock.Any(),
+		).Return([]*gocloak.User{{ID: &existingUserID, Username: &tnName}, {ID: &orphanUserID, Username: &orphanTnName}}, nil).AnyTimes()
+
+		mGcClient.EXPECT().GetUsersByClientRoleName(
+			gomock.Any(),
+			gomock.Eq(kcAccessToken),
+			gomock.Eq(kcTargetRealm),
+			gomock.Eq(kcTargetClientID),
+			gomock.Eq(orphanRole),
+			gomock.Any(),
+		).Return([]*gocloak.User{{ID: &orphanUserID, Username: &orphanTnName}}, nil).AnyTimes()
+
+		By("By creating the workspace and the tenant which are not orphans")
+		ws := &crownlabsv1alpha1.Workspace{
+			ObjectMeta: metav1.ObjectMeta{Name: wsName},
+			Spec:       crownlabsv1alpha1.WorkspaceSpec{PrettyName: wsName},
+		}
+		Expect(k8sClient.Create(ctx, ws)).Should(Succeed())
+		tn := &crownlabsv1alpha1.Tenant{
+			ObjectMeta: metav1.ObjectMeta{Name: tnName},
+			Spec: crownlabsv1alpha1.TenantSpec{
+				FirstName: "gc",
+				LastName:  "tenant",
+				Email:     "gc.tenant@email.com",
+			},
+		}
+		Expect(k8sClient.Create(ctx, tn)).Should(Succeed())
+
+		doesEventuallyExists(ctx, types.NamespacedName{Name: wsName}, &crownlabsv1alpha1.Workspace{}, BeTrue(), timeout, interval)
+		doesEventuallyExists(ctx, types.NamespacedName{Name: tnName}, &crownlabsv1alpha1.Tenant{}, BeTrue(), timeout, interval)
+	})
+
+	AfterEach(func() {
+		ctx := context.Background()
+		Expect(k8sClient.Delete(ctx, &crownlabsv1alpha1.Workspace{ObjectMeta: metav1.ObjectMeta{Name: wsName}})).Should(Succeed())
+		Expect(k8sClient.Delete(ctx, &crownlabsv1alpha1.Tenant{ObjectMeta: metav1.ObjectMeta{Name: tnName}})).Should(Succeed())
+		mockCtrl.Finish()
+	})
+
+	It("Should only report the orphan resources when the policy is report", func() {
+		collector.Policy = OrphansPolicyReport
+
+		mGcClient.EXPECT().DeleteUser(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+		mGcClient.EXPECT().DeleteClientRole(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+		Expect(collector.Collect(context.Background())).Should(Succeed())
+		Expect(gcNcA.DeletedUsers).Should(BeEmpty())
+	})
+
+	It("Should delete only the orphan resources when the policy is delete", func() {
+		collector.Policy = OrphansPolicyDelete
+
+		mGcClient.EXPECT().DeleteUser(
+			gomock.Any(),
+			gomock.Eq(kcAccessToken),
+			gomock.Eq(kcTargetRealm),
+			gomock.Eq(orphanUserID),
+		).Return(nil).Times(1)
+
+		mGcClient.EXPECT().DeleteClientRole(
+			gomock.Any(),
+			gomock.Eq(kcAccessToken),
+			gomock.Eq(kcTargetRealm),
+			gomock.Eq(kcTargetClientID),
+			gomock.Eq(orphanRole),
+		).Return(nil).Times(1)
+
+		Expect(collector.Collect(context.Background())).Should(Succeed())
+		Expect(gcNcA.DeletedUsers).Should(ConsistOf(genNcUsername(orphanTnName)))
+	})
+})
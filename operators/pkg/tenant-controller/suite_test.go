@@ -26,6 +26,7 @@ import (
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	gomegaTypes "github.com/onsi/gomega/types"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
@@ -100,23 +101,21 @@ var _ = BeforeSuite(func(done Done) {
 	Expect(err).ToNot(HaveOccurred())
 
 	err = (&WorkspaceReconciler{
-		Client:             k8sManager.GetClient(),
-		Scheme:             k8sManager.GetScheme(),
-		KcA:                &kcA,
-		TargetLabelKey:     targetLabelKey,
-		TargetLabelValue:   targetLabelValue,
-		ReconcileDeferHook: GinkgoRecover,
+		Client:              k8sManager.GetClient(),
+		Scheme:              k8sManager.GetScheme(),
+		KcA:                 &kcA,
+		TargetLabelSelector: metav1.LabelSelector{MatchLabels: map[string]string{targetLabelKey: targetLabelValue}},
+		ReconcileDeferHook:  GinkgoRecover,
 	}).SetupWithManager(k8sManager)
 	Expect(err).ToNot(HaveOccurred())
 
 	err = (&TenantReconciler{
-		Client:             k8sManager.GetClient(),
-		Scheme:             k8sManager.GetScheme(),
-		KcA:                &kcA,
-		NcA:                mNcA,
-		TargetLabelKey:     targetLabelKey,
-		TargetLabelValue:   targetLabelValue,
-		ReconcileDeferHook: GinkgoRecover,
+		Client:              k8sManager.GetClient(),
+		Scheme:              k8sManager.GetScheme(),
+		KcA:                 &kcA,
+		NcA:                 mNcA,
+		TargetLabelSelector: metav1.LabelSelector{MatchLabels: map[string]string{targetLabelKey: targetLabelValue}},
+		ReconcileDeferHook:  GinkgoRecover,
 	}).SetupWithManager(k8sManager)
 	Expect(err).ToNot(HaveOccurred())
 
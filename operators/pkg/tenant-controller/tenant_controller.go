@@ -25,31 +25,38 @@ import (
 	rbacv1 "k8s.io/api/rbac/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/klog/v2"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	ctrlUtil "sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 
 	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/selectors"
 )
 
 // TenantReconciler reconciles a Tenant object.
 type TenantReconciler struct {
 	client.Client
-	Scheme           *runtime.Scheme
-	KcA              *KcActor
-	NcA              NcHandler
-	TargetLabelKey   string
-	TargetLabelValue string
-	Concurrency      int
+	Scheme      *runtime.Scheme
+	KcA         *KcActor
+	NcA         NcHandler
+	Concurrency int
+	// TargetLabelSelector selects the tenants to be reconciled. Its MatchLabels are also
+	// applied to the managed resources (e.g. to let other operators select the namespaces).
+	TargetLabelSelector metav1.LabelSelector
 
 	// This function, if configured, is deferred at the beginning of the Reconcile.
 	// Specifically, it is meant to be set to GinkgoRecover during the tests,
 	// in order to lead to a controlled failure in case the Reconcile panics.
 	ReconcileDeferHook func()
+
+	// targetSelector is built from TargetLabelSelector when setting up the controller.
+	targetSelector labels.Selector
 }
 
 // Reconcile reconciles the state of a tenant resource.
@@ -67,7 +74,7 @@ func (r *TenantReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctr
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
-	if tn.Labels[r.TargetLabelKey] != r.TargetLabelValue {
+	if !r.targetSelector.Matches(labels.Set(tn.Labels)) {
 		// if entered here it means that is in the reconcile
 		// which has been requed after
 		// the last successful one with the old target label
@@ -223,9 +230,18 @@ func (r *TenantReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctr
 
 // SetupWithManager registers a new controller for Tenant resources.
 func (r *TenantReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	// Only the tenants satisfying the target selector are reconciled. The events of the owned resources
+	// are not filtered, since they might not satisfy it: the owner is checked again by the Reconcile function.
+	lsPred, err := selectors.ObjectPredicate(&r.TargetLabelSelector)
+	if err != nil {
+		return err
+	}
+	if r.targetSelector, err = metav1.LabelSelectorAsSelector(&r.TargetLabelSelector); err != nil {
+		return err
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&crownlabsv1alpha1.Tenant{}).
-		WithEventFilter(labelSelectorPredicate(r.TargetLabelKey, r.TargetLabelValue)).
+		For(&crownlabsv1alpha1.Tenant{}, builder.WithPredicates(lsPred)).
 		// owns the secret related to the nextcloud credentials, to allow new password generation in case tenant has a problem with nextcloud
 		Owns(&v1.Secret{}).
 		Owns(&v1.Namespace{}).
@@ -575,11 +591,13 @@ func cleanWorkspaceLabels(labels map[string]string) {
 	}
 }
 
-func (r *TenantReconciler) updateTnResourceCommonLabels(labels map[string]string) map[string]string {
-	if labels == nil {
-		labels = make(map[string]string, 1)
+func (r *TenantReconciler) updateTnResourceCommonLabels(resourceLabels map[string]string) map[string]string {
+	if resourceLabels == nil {
+		resourceLabels = make(map[string]string, 1)
+	}
+	for key, value := range r.TargetLabelSelector.MatchLabels {
+		resourceLabels[key] = value
 	}
-	labels[r.TargetLabelKey] = r.TargetLabelValue
-	labels["crownlabs.polito.it/managed-by"] = "tenant"
-	return labels
+	resourceLabels["crownlabs.polito.it/managed-by"] = "tenant"
+	return resourceLabels
 }
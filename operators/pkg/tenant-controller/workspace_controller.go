@@ -20,27 +20,33 @@ import (
 	v1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/klog/v2"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	ctrlUtil "sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 
 	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/selectors"
 )
 
 // WorkspaceReconciler reconciles a Workspace object.
 type WorkspaceReconciler struct {
 	client.Client
-	Scheme           *runtime.Scheme
-	KcA              *KcActor
-	TargetLabelKey   string
-	TargetLabelValue string
+	Scheme *runtime.Scheme
+	KcA    *KcActor
+	// TargetLabelSelector selects the workspaces to be reconciled, and its MatchLabels are applied to the managed resources.
+	TargetLabelSelector metav1.LabelSelector
 
 	// This function, if configured, is deferred at the beginning of the Reconcile.
 	// Specifically, it is meant to be set to GinkgoRecover during the tests,
 	// in order to lead to a controlled failure in case the Reconcile panics.
 	ReconcileDeferHook func()
+
+	// targetSelector is the parsed form of TargetLabelSelector, set by SetupWithManager.
+	targetSelector labels.Selector
 }
 
 // Reconcile reconciles the state of a workspace resource.
@@ -59,7 +65,7 @@ func (r *WorkspaceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
-	if ws.Labels[r.TargetLabelKey] != r.TargetLabelValue {
+	if !r.targetSelector.Matches(labels.Set(ws.Labels)) {
 		// if entered here it means that is in the reconcile
 		// which has been requed after
 		// the last successful one with the old target label
@@ -172,9 +178,18 @@ func (r *WorkspaceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 
 // SetupWithManager registers a new controller for Workspace resources.
 func (r *WorkspaceReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	// Only the workspaces satisfying the target selector are reconciled, while the events of
+	// the owned resources are always let through, and the owner is checked by the Reconcile function.
+	lsPred, err := selectors.ObjectPredicate(&r.TargetLabelSelector)
+	if err != nil {
+		return err
+	}
+	if r.targetSelector, err = metav1.LabelSelectorAsSelector(&r.TargetLabelSelector); err != nil {
+		return err
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
-		WithEventFilter(labelSelectorPredicate(r.TargetLabelKey, r.TargetLabelValue)).
-		For(&crownlabsv1alpha1.Workspace{}).
+		For(&crownlabsv1alpha1.Workspace{}, builder.WithPredicates(lsPred)).
 		Owns(&v1.Namespace{}).
 		Owns(&rbacv1.ClusterRoleBinding{}).
 		Owns(&rbacv1.RoleBinding{}).
@@ -312,13 +327,15 @@ func genWsKcRoleName(wsName string, role crownlabsv1alpha1.WorkspaceUserRole) st
 	return fmt.Sprintf("workspace-%s:%s", wsName, role)
 }
 
-func (r *WorkspaceReconciler) updateWsResourceCommonLabels(labels map[string]string) map[string]string {
-	if labels == nil {
-		labels = make(map[string]string, 1)
+func (r *WorkspaceReconciler) updateWsResourceCommonLabels(resourceLabels map[string]string) map[string]string {
+	if resourceLabels == nil {
+		resourceLabels = make(map[string]string, 1)
+	}
+	for key, value := range r.TargetLabelSelector.MatchLabels {
+		resourceLabels[key] = value
 	}
-	labels[r.TargetLabelKey] = r.TargetLabelValue
-	labels["crownlabs.polito.it/managed-by"] = "workspace"
+	resourceLabels["crownlabs.polito.it/managed-by"] = "workspace"
 
 	// don't know why the initialization of the map doesn't work, so need to return a new one
-	return labels
+	return resourceLabels
 }
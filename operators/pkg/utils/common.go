@@ -18,15 +18,10 @@ limitations under the License.
 package utils
 
 import (
-	"context"
-	"fmt"
 	"regexp"
 	"strings"
 
 	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/types"
-	"k8s.io/klog/v2"
-	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 // ParseDockerDirectory returns a valid Docker image directory.
@@ -45,25 +40,21 @@ func CheckLabels(ns *corev1.Namespace, matchLabels map[string]string) bool {
 	return true
 }
 
-// CheckSelectorLabel checks if the given namespace belongs to the whitelisted namespaces where to perform reconciliation.
-func CheckSelectorLabel(ctx context.Context, k8sClient client.Client, namespaceName string, matchLabels map[string]string) (bool, error) {
-	ns := corev1.Namespace{}
-	namespaceLookupKey := types.NamespacedName{
-		Name:      namespaceName,
-		Namespace: "",
+// ConfigureImgExporterCache configures the img-exporter container to cache the disks of the base images in the
+// given directory of the host, shared by the jobs executed on the same node, and returns the volume to be added
+// to the pod. The container is left unchanged, and a nil volume is returned, if the directory is empty.
+func ConfigureImgExporterCache(exporter *corev1.Container, hostPath string) *corev1.Volume {
+	if hostPath == "" {
+		return nil
 	}
 
-	// It performs reconciliation only if the InstanceSnapshot belongs to whitelisted namespaces
-	// by checking the existence of keys in the namespace of the InstanceSnapshot.
-	if err := k8sClient.Get(ctx, namespaceLookupKey, &ns); err == nil {
-		if !CheckLabels(&ns, matchLabels) {
-			klog.Infof("Namespace %s does not meet the selector labels", namespaceName)
-			return false, nil
-		}
-	} else {
-		return false, fmt.Errorf("error when retrieving the InstanceSnapshot namespace -> %w", err)
+	hostPathType := corev1.HostPathDirectoryOrCreate
+	exporter.Args = append(exporter.Args, "--cache-dir", "/img-cache")
+	exporter.VolumeMounts = append(exporter.VolumeMounts, corev1.VolumeMount{Name: "img-cache", MountPath: "/img-cache"})
+	return &corev1.Volume{
+		Name: "img-cache",
+		VolumeSource: corev1.VolumeSource{
+			HostPath: &corev1.HostPathVolumeSource{Path: hostPath, Type: &hostPathType},
+		},
 	}
-
-	klog.Info("Namespace " + namespaceName + " met the selector labels")
-	return true, nil
 }
@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+)
+
+// GetNamespaceTenant returns the Tenant owning the given namespace (i.e. its personal namespace, created by the tenant
+// operator). Differently from the tenant referenced by the resources in that namespace, the owner of the namespace
+// cannot be tampered with by the tenants, hence it is suitable to perform authorization checks.
+func GetNamespaceTenant(ctx context.Context, c client.Reader, namespace string) (*crownlabsv1alpha1.Tenant, error) {
+	var ns corev1.Namespace
+	if err := c.Get(ctx, types.NamespacedName{Name: namespace}, &ns); err != nil {
+		return nil, fmt.Errorf("error in retrieving namespace %s -> %w", namespace, err)
+	}
+
+	owner := metav1.GetControllerOf(&ns)
+	if owner == nil || owner.Kind != "Tenant" || owner.APIVersion != crownlabsv1alpha1.GroupVersion.String() {
+		return nil, fmt.Errorf("namespace %s is not owned by any tenant", namespace)
+	}
+
+	var tenant crownlabsv1alpha1.Tenant
+	if err := c.Get(ctx, types.NamespacedName{Name: owner.Name}, &tenant); err != nil {
+		return nil, fmt.Errorf("error in retrieving the tenant %s owning namespace %s -> %w", owner.Name, namespace, err)
+	}
+	if tenant.UID != owner.UID {
+		return nil, fmt.Errorf("namespace %s is not owned by the current tenant %s", namespace, owner.Name)
+	}
+	return &tenant, nil
+}
+
+// TenantNamespaceName returns the name of the personal namespace of the given Tenant, created by the tenant operator.
+func TenantNamespaceName(tenantName string) string {
+	return fmt.Sprintf("tenant-%s", strings.ReplaceAll(tenantName, ".", "-"))
+}
+
+// WorkspaceNamespaceName returns the name of the namespace of the given Workspace, created by the tenant operator.
+func WorkspaceNamespaceName(wsName string) string {
+	return fmt.Sprintf("workspace-%s", wsName)
+}
+
+// NamespaceWorkspaceName returns the name of the Workspace the given namespace belongs to, or an empty string
+// in case it is not the namespace of any Workspace (i.e. it does not match the ones created by the tenant operator).
+func NamespaceWorkspaceName(namespace string) string {
+	if !strings.HasPrefix(namespace, WorkspaceNamespaceName("")) {
+		return ""
+	}
+	return strings.TrimPrefix(namespace, WorkspaceNamespaceName(""))
+}
@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+)
+
+func TestGetNamespaceTenant(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	assert.Nil(t, clientgoscheme.AddToScheme(scheme))
+	assert.Nil(t, crownlabsv1alpha1.AddToScheme(scheme))
+
+	controller := true
+	tenant := crownlabsv1alpha1.Tenant{ObjectMeta: metav1.ObjectMeta{Name: "john.doe", UID: "tenant-uid"}}
+	owned := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "tenant-john-doe", OwnerReferences: []metav1.OwnerReference{{
+		APIVersion: crownlabsv1alpha1.GroupVersion.String(), Kind: "Tenant", Name: tenant.Name, UID: tenant.UID, Controller: &controller,
+	}}}}
+	stale := *owned.DeepCopy()
+	stale.Name = "tenant-stale"
+	stale.OwnerReferences[0].UID = "previous-uid"
+	workspace := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "workspace-netlab"}}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&tenant, &owned, &stale, &workspace).Build()
+
+	owner, err := GetNamespaceTenant(ctx, c, owned.Name)
+	assert.Nil(t, err)
+	assert.Equal(t, tenant.Name, owner.Name)
+
+	_, err = GetNamespaceTenant(ctx, c, workspace.Name)
+	assert.NotNil(t, err, "Namespaces not owned by a tenant should be refused")
+	_, err = GetNamespaceTenant(ctx, c, stale.Name)
+	assert.NotNil(t, err, "Namespaces owned by a previous tenant with the same name should be refused")
+	_, err = GetNamespaceTenant(ctx, c, "missing")
+	assert.NotNil(t, err)
+}
+
+func TestNamespaceWorkspaceName(t *testing.T) {
+	assert.Equal(t, "netlab", NamespaceWorkspaceName(WorkspaceNamespaceName("netlab")))
+	assert.Empty(t, NamespaceWorkspaceName(TenantNamespaceName("john.doe")))
+	assert.Empty(t, NamespaceWorkspaceName("netlab"))
+}
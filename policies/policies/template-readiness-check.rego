@@ -0,0 +1,20 @@
+package crownlabs_template_readiness_check
+
+vm_environment_type := "VirtualMachine"
+
+# The set of VM environments of the template specifying a check executing a command,
+# which is supported only in case of containers
+vm_exec_environments[name] {
+	environment := input.review.object.spec.environmentList[_]
+	environment.environmentType == vm_environment_type
+	environment.readinessCheck.exec
+	name := environment.name
+}
+
+# This violation is triggered if a VM environment specifies an exec readiness check
+violation[{"msg": msg, "details": details}] {
+	environment_name := vm_exec_environments[_]
+
+	msg := sprintf("Environment %v is a VM, which does not support exec readiness checks", [environment_name])
+	details := {"environment": environment_name}
+}
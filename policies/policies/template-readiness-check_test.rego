@@ -0,0 +1,44 @@
+package crownlabs_template_readiness_check
+
+test_no_readiness_check {
+	input := {"review": input_review([{"name": "vm", "environmentType": "VirtualMachine"}])}
+	results := violation with input as input
+	count(results) == 0
+}
+
+test_vm_supported_checks {
+	input := {"review": input_review([
+		{"name": "vm-http", "environmentType": "VirtualMachine", "readinessCheck": {"httpGet": {"port": 8080}}},
+		{"name": "vm-tcp", "environmentType": "VirtualMachine", "readinessCheck": {"tcpSocket": {"port": 22}}},
+		{"name": "vm-agent", "environmentType": "VirtualMachine", "readinessCheck": {"guestAgent": true}},
+	])}
+	results := violation with input as input
+	count(results) == 0
+}
+
+test_container_exec_check {
+	input := {"review": input_review([exec_environment("container", "Container")])}
+	results := violation with input as input
+	count(results) == 0
+}
+
+test_vm_exec_check {
+	input := {"review": input_review([exec_environment("container", "Container"), exec_environment("vm", "VirtualMachine")])}
+	results := violation with input as input
+	count(results) == 1
+	results[_].details.environment == "vm"
+}
+
+exec_environment(name, environment_type) = output {
+	output = {"name": name, "environmentType": environment_type, "readinessCheck": {"exec": {"command": ["cat", "/tmp/ready"]}}}
+}
+
+input_review(environments) = output {
+	output = {"object": {
+		"metadata": {
+			"name": "template-name",
+			"namespace": "workspace-networking",
+		},
+		"spec": {"environmentList": environments},
+	}}
+}
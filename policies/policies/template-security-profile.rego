@@ -0,0 +1,45 @@
+package crownlabs_template_security_profile
+
+profile_api_version := "crownlabs.polito.it/v1alpha2"
+
+ws_namespace_prefix := "workspace-"
+
+# Return the name of the workspace the template belongs to, derived from its namespace (since the
+# workspace reference in the spec is set by the author of the template), otherwise ""
+get_workspace_name(review) = name {
+	startswith(review.object.metadata.namespace, ws_namespace_prefix)
+	name := trim_prefix(review.object.metadata.namespace, ws_namespace_prefix)
+} else = "" {
+	true
+}
+
+# The set of security profiles selected by the environments of the template
+selected_profiles[profile] {
+	profile := input.review.object.spec.environmentList[_].securityProfile
+	profile != ""
+}
+
+# Check whether the security profile can be used by the templates of the given workspace
+profile_allowed(profile, workspace) {
+	profile.spec.allowedWorkspaces[_] == workspace
+}
+
+# This violation is triggered if a selected security profile does not exist
+violation[{"msg": msg, "details": details}] {
+	profile_name := selected_profiles[_]
+
+	not data.inventory.cluster[profile_api_version].SecurityProfile[profile_name]
+	msg := sprintf("SecurityProfile %v not found", [profile_name])
+	details := {"security_profile": profile_name}
+}
+
+# This violation is triggered if a selected security profile is not allowed for the workspace of the template
+violation[{"msg": msg, "details": details}] {
+	profile_name := selected_profiles[_]
+	workspace := get_workspace_name(input.review)
+
+	profile := data.inventory.cluster[profile_api_version].SecurityProfile[profile_name]
+	not profile_allowed(profile, workspace)
+	msg := sprintf("SecurityProfile %v not allowed for workspace %v", [profile_name, workspace])
+	details := {"security_profile": profile_name, "workspace": workspace}
+}
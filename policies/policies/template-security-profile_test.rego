@@ -0,0 +1,105 @@
+package crownlabs_template_security_profile
+
+test_no_security_profile {
+	input := {"review": input_review("networking", [""])}
+	results := violation with input as input with data.inventory as data_inventory
+	count(results) == 0
+}
+
+test_security_profile_not_exists {
+	input := {"review": input_review("networking", ["not-existing"])}
+	results := violation with input as input with data.inventory as data_inventory
+	count(results) > 0
+}
+
+test_security_profile_no_profiles {
+	input := {"review": input_review("networking", ["net-admin"])}
+	results := violation with input as input with data.inventory as data_inventory_empty
+	count(results) > 0
+}
+
+test_security_profile_allowed {
+	input1 := {"review": input_review("networking", ["net-admin"])}
+	input2 := {"review": input_review("security", ["net-admin", "root"])}
+
+	results1 := violation with input as input1 with data.inventory as data_inventory
+	results2 := violation with input as input2 with data.inventory as data_inventory
+
+	count(results1) == 0
+	count(results2) == 0
+}
+
+test_security_profile_not_allowed {
+	input1 := {"review": input_review("databases", ["net-admin"])}
+	input2 := {"review": input_review("networking", ["net-admin", "root"])}
+
+	results1 := violation with input as input1 with data.inventory as data_inventory
+	results2 := violation with input as input2 with data.inventory as data_inventory
+
+	count(results1) > 0
+	count(results2) > 0
+}
+
+test_security_profile_workspace_from_namespace {
+	input := {"review": input_review_namespace("tenant-networking", "networking", ["net-admin"])}
+	results := violation with input as input with data.inventory as data_inventory
+	count(results) > 0
+}
+
+test_security_profile_workspace_ref_ignored {
+	input := {"review": input_review_namespace("workspace-databases", "networking", ["net-admin"])}
+	results := violation with input as input with data.inventory as data_inventory
+	count(results) > 0
+}
+
+test_security_profile_without_allowed_workspaces {
+	input := {"review": input_review("networking", ["nobody"])}
+	results := violation with input as input with data.inventory as data_inventory
+	count(results) > 0
+}
+
+input_review(workspace, profiles) = output {
+	output = input_review_namespace(concat("", ["workspace-", workspace]), workspace, profiles)
+}
+
+input_review_namespace(namespace, workspace, profiles) = output {
+	output = {"object": {
+		"metadata": {
+			"name": "template-name",
+			"namespace": namespace,
+		},
+		"spec": {
+			"workspace.crownlabs.polito.it/WorkspaceRef": {"name": workspace},
+			"environmentList": [environment | environment := {"name": "env", "environmentType": "Container", "securityProfile": profiles[_]}],
+		},
+	}}
+}
+
+data_inventory = {"cluster": {"crownlabs.polito.it/v1alpha2": {"SecurityProfile": {
+	"net-admin": {
+		"apiVersion": "crownlabs.polito.it/v1alpha2",
+		"kind": "SecurityProfile",
+		"metadata": {"name": "net-admin"},
+		"spec": {
+			"allowedWorkspaces": ["networking", "security"],
+			"capabilities": ["NET_ADMIN", "NET_RAW"],
+		},
+	},
+	"root": {
+		"apiVersion": "crownlabs.polito.it/v1alpha2",
+		"kind": "SecurityProfile",
+		"metadata": {"name": "root"},
+		"spec": {
+			"allowedWorkspaces": ["security"],
+			"runAsUser": 0,
+		},
+	},
+	"nobody": {
+		"apiVersion": "crownlabs.polito.it/v1alpha2",
+		"kind": "SecurityProfile",
+		"metadata": {"name": "nobody"},
+		"spec": {"runAsUser": 65534},
+	},
+}}}}
+
+data_inventory_empty = {"cluster": {"crownlabs.polito.it/v1alpha2": {"SecurityProfile": {}}}}